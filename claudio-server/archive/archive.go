@@ -0,0 +1,338 @@
+// Package archive reads and writes room archives, the zip files that carry a
+// room from one claudio server to another (rooms.exportArchive and
+// rooms.importArchive).
+//
+// An archive holds:
+//
+//	manifest.json     the Manifest: room settings, participants, attachment list
+//	messages.jsonl    one Message per line, oldest first
+//	attachments/<id>  each attachment's content
+//
+// IDs in an archive are the exporting server's. The importing server gives
+// the room, messages and attachments new ones.
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	Format  = "claudio.room"
+	Version = 1
+)
+
+const (
+	manifestName    = "manifest.json"
+	messagesName    = "messages.jsonl"
+	attachmentDir   = "attachments/"
+	maxManifestSize = 16 << 20
+	maxMessageSize  = 4 << 20 // one line of messages.jsonl
+)
+
+var (
+	// ErrNotArchive is returned by NewReader for a zip that isn't a room archive.
+	ErrNotArchive = errors.New("archive: not a claudio room archive")
+	// ErrTooLarge is returned when an archive goes over the Reader's Limits.
+	ErrTooLarge = errors.New("archive: over the size limits")
+)
+
+// Limits bound what a Reader will inflate, so an archive that's small on
+// the wire can't expand without end. Sizes are checked against the zip's
+// headers when the archive is opened and counted again while reading, as
+// the headers can lie. A zero field means no limit.
+type Limits struct {
+	TotalBytes      int64 // all entries together, uncompressed
+	AttachmentBytes int64 // any one attachment
+	Attachments     int
+	Messages        int
+}
+
+// Manifest describes an archive's room and lists its attachments.
+type Manifest struct {
+	Format       string        `json:"format"`
+	Version      int           `json:"version"`
+	ExportedAt   time.Time     `json:"exportedAt"`
+	Source       string        `json:"source,omitempty"` // exporting server's external URL
+	Room         Room          `json:"room"`
+	Participants []Participant `json:"participants"`
+	Messages     int           `json:"messages"`
+	Attachments  []Attachment  `json:"attachments"`
+}
+
+// Room is a room's settings.
+type Room struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	Emoji                string    `json:"emoji"`
+	Description          string    `json:"description"`
+	Topic                string    `json:"topic"`
+	Visibility           string    `json:"visibility"`
+	SlowModeSeconds      int       `json:"slowModeSeconds"`
+	RequireApproval      bool      `json:"requireApproval"`
+	AgentChains          bool      `json:"agentChains"`
+	ContentFilter        string    `json:"contentFilter"`
+	MessageRetentionDays int       `json:"messageRetentionDays"`
+	AvatarID             string    `json:"avatarId,omitempty"` // one of the attachments
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+// Participant is a room member at export time. Agents are listed without
+// their credentials.
+type Participant struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Emoji       string `json:"emoji"`
+	Role        string `json:"role"`
+	Agent       bool   `json:"agent,omitempty"`
+}
+
+// Message is one message of the room's history.
+type Message struct {
+	ID                string          `json:"id"`
+	SenderUserID      string          `json:"senderUserId,omitempty"`
+	SenderAgentID     string          `json:"senderAgentId,omitempty"`
+	SenderDisplayName string          `json:"senderDisplayName"`
+	SenderEmoji       string          `json:"senderEmoji"`
+	Content           string          `json:"content"`
+	Mentions          json.RawMessage `json:"mentions,omitempty"`
+	ReplyTo           string          `json:"replyTo,omitempty"`
+	Kind              string          `json:"kind"`
+	Blocks            json.RawMessage `json:"blocks,omitempty"`
+	Attachments       []string        `json:"attachments,omitempty"`
+	CreatedAt         time.Time       `json:"createdAt"`
+}
+
+// Attachment is a file sent in a message, or the room's avatar.
+type Attachment struct {
+	ID          string    `json:"id"`
+	MessageID   string    `json:"messageId,omitempty"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// validID reports whether id can name a file under attachments/.
+func validID(id string) bool {
+	return id != "" && len(id) <= 64 && !strings.ContainsAny(id, `/\.`)
+}
+
+// Writer writes an archive. All messages go in before the attachments;
+// Close writes the manifest.
+type Writer struct {
+	zw          *zip.Writer
+	messages    *json.Encoder
+	count       int
+	attachments []Attachment
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// AddMessage appends m to the history.
+func (w *Writer) AddMessage(m Message) error {
+	if len(w.attachments) > 0 {
+		return errors.New("archive: message added after attachments")
+	}
+	if w.messages == nil {
+		f, err := w.zw.Create(messagesName)
+		if err != nil {
+			return err
+		}
+		w.messages = json.NewEncoder(f)
+	}
+	w.count++
+	return w.messages.Encode(m)
+}
+
+// AddAttachment stores a's content, read from content.
+func (w *Writer) AddAttachment(a Attachment, content io.Reader) error {
+	if !validID(a.ID) {
+		return fmt.Errorf("archive: bad attachment ID %q", a.ID)
+	}
+	f, err := w.zw.Create(attachmentDir + a.ID)
+	if err != nil {
+		return err
+	}
+	if a.Size, err = io.Copy(f, content); err != nil {
+		return fmt.Errorf("archive: attachment %s: %w", a.ID, err)
+	}
+	w.attachments = append(w.attachments, a)
+	return nil
+}
+
+// Close writes m as the manifest, with the format, message count and
+// attachments filled in, and finishes the zip. It doesn't close the
+// underlying writer.
+func (w *Writer) Close(m Manifest) error {
+	m.Format, m.Version = Format, Version
+	m.Messages = w.count
+	m.Attachments = w.attachments
+	f, err := w.zw.Create(manifestName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// Reader reads an archive.
+type Reader struct {
+	Manifest Manifest
+	files    map[string]*zip.File
+	limits   Limits
+	left     int64 // of limits.TotalBytes, across every entry read
+}
+
+// NewReader opens the archive in r and checks its manifest against limits.
+func NewReader(r io.ReaderAt, size int64, limits Limits) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrNotArchive
+	}
+	if limits.TotalBytes <= 0 {
+		limits.TotalBytes = math.MaxInt64
+	}
+	if limits.AttachmentBytes <= 0 {
+		limits.AttachmentBytes = math.MaxInt64
+	}
+	ar := &Reader{files: make(map[string]*zip.File, len(zr.File)), limits: limits, left: limits.TotalBytes}
+	var total uint64
+	for _, f := range zr.File {
+		ar.files[f.Name] = f
+		if total += f.UncompressedSize64; total > uint64(limits.TotalBytes) {
+			return nil, ErrTooLarge
+		}
+	}
+
+	mf, ok := ar.files[manifestName]
+	if !ok {
+		return nil, ErrNotArchive
+	}
+	rc, err := ar.open(mf, limits.TotalBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(io.LimitReader(rc, maxManifestSize)).Decode(&ar.Manifest); err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("archive: bad manifest: %w", err)
+	}
+	m := &ar.Manifest
+	if m.Format != Format {
+		return nil, ErrNotArchive
+	}
+	if m.Version < 1 || m.Version > Version {
+		return nil, fmt.Errorf("archive: version %d is not supported", m.Version)
+	}
+	if limits.Messages > 0 && m.Messages > limits.Messages {
+		return nil, ErrTooLarge
+	}
+	if limits.Attachments > 0 && len(m.Attachments) > limits.Attachments {
+		return nil, ErrTooLarge
+	}
+	seen := make(map[string]bool, len(m.Attachments))
+	for _, a := range m.Attachments {
+		f := ar.files[attachmentDir+a.ID]
+		if !validID(a.ID) || f == nil {
+			return nil, fmt.Errorf("archive: attachment %q is missing", a.ID)
+		}
+		if seen[a.ID] {
+			return nil, fmt.Errorf("archive: attachment %q is listed twice", a.ID)
+		}
+		seen[a.ID] = true
+		if f.UncompressedSize64 > uint64(limits.AttachmentBytes) {
+			return nil, ErrTooLarge
+		}
+	}
+	return ar, nil
+}
+
+// open opens f for reading, failing with ErrTooLarge once it inflates past
+// max or the archive's total budget.
+func (r *Reader) open(f *zip.File, max int64) (io.ReadCloser, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("archive: %w", err)
+	}
+	return &budgetReader{ReadCloser: rc, r: r, left: max}, nil
+}
+
+// budgetReader counts what it reads against its own limit and its Reader's
+// total.
+type budgetReader struct {
+	io.ReadCloser
+	r    *Reader
+	left int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	b.r.left -= int64(n)
+	if b.left < 0 || b.r.left < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// Messages calls fn with each message, oldest first, stopping at the first
+// error. It fails with ErrTooLarge past the Reader's message limit.
+func (r *Reader) Messages(fn func(Message) error) error {
+	f, ok := r.files[messagesName]
+	if !ok {
+		return nil
+	}
+	rc, err := r.open(f, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	lines := bufio.NewScanner(rc)
+	lines.Buffer(make([]byte, 0, 64<<10), maxMessageSize)
+	for n := 1; lines.Scan(); n++ {
+		if r.limits.Messages > 0 && n > r.limits.Messages {
+			return ErrTooLarge
+		}
+		var m Message
+		if err := json.Unmarshal(lines.Bytes(), &m); err != nil {
+			return fmt.Errorf("archive: bad message: %w", err)
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	if err := lines.Err(); err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		}
+		return fmt.Errorf("archive: %w", err)
+	}
+	return nil
+}
+
+// OpenAttachment opens the content of the attachment with id. Reads fail
+// with ErrTooLarge past the Reader's limits.
+func (r *Reader) OpenAttachment(id string) (io.ReadCloser, error) {
+	f, ok := r.files[attachmentDir+id]
+	if !ok || !validID(id) {
+		return nil, fmt.Errorf("archive: attachment %q is missing", id)
+	}
+	return r.open(f, r.limits.AttachmentBytes)
+}
@@ -0,0 +1,155 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, m := range []Message{
+		{ID: "m1", SenderDisplayName: "Alice", Content: "hi", Kind: "user", Attachments: []string{"a1"}, CreatedAt: created},
+		{ID: "m2", SenderDisplayName: "Bob", Content: "hello", Kind: "user", ReplyTo: "m1", CreatedAt: created.Add(time.Minute)},
+	} {
+		if err := w.AddMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.AddAttachment(Attachment{ID: "a1", MessageID: "m1", Filename: "notes.txt"}, strings.NewReader("file body")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddMessage(Message{ID: "m3"}); err == nil {
+		t.Error("message after attachments was accepted")
+	}
+	if err := w.Close(Manifest{Room: Room{Name: "Team"}, Participants: []Participant{{ID: "alice", Role: "owner"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := r.Manifest
+	if m.Format != Format || m.Version != Version || m.Room.Name != "Team" || m.Messages != 2 {
+		t.Errorf("manifest = %+v", m)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Size != int64(len("file body")) {
+		t.Errorf("attachments = %+v", m.Attachments)
+	}
+
+	var got []Message
+	if err := r.Messages(func(m Message) error { got = append(got, m); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].ReplyTo != "m1" || !got[0].CreatedAt.Equal(created) {
+		t.Errorf("messages = %+v", got)
+	}
+
+	rc, err := r.OpenAttachment("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if body, _ := io.ReadAll(rc); string(body) != "file body" {
+		t.Errorf("attachment = %q", body)
+	}
+}
+
+func TestNewReaderRejects(t *testing.T) {
+	zipWith := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, body := range files {
+			f, _ := zw.Create(name)
+			f.Write([]byte(body))
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	for name, data := range map[string][]byte{
+		"not a zip":       []byte("hello"),
+		"no manifest":     zipWith(map[string]string{"messages.jsonl": ""}),
+		"other format":    zipWith(map[string]string{"manifest.json": `{"format":"other","version":1}`}),
+		"future version":  zipWith(map[string]string{"manifest.json": `{"format":"claudio.room","version":99}`}),
+		"missing file":    zipWith(map[string]string{"manifest.json": `{"format":"claudio.room","version":1,"attachments":[{"id":"a1"}]}`}),
+		"path in file ID": zipWith(map[string]string{"manifest.json": `{"format":"claudio.room","version":1,"attachments":[{"id":"../x"}]}`, "attachments/../x": ""}),
+		"duplicate ID":    zipWith(map[string]string{"manifest.json": `{"format":"claudio.room","version":1,"attachments":[{"id":"a1"},{"id":"a1"}]}`, "attachments/a1": ""}),
+	} {
+		if _, err := NewReader(bytes.NewReader(data), int64(len(data)), Limits{}); err == nil {
+			t.Errorf("%s: accepted", name)
+		} else if name == "other format" && !errors.Is(err, ErrNotArchive) {
+			t.Errorf("%s: err = %v, want ErrNotArchive", name, err)
+		}
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := w.AddMessage(Message{ID: id, Content: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Compresses to almost nothing
+	if err := w.AddAttachment(Attachment{ID: "a1"}, bytes.NewReader(make([]byte, 1<<20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(Manifest{}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	open := func(l Limits) (*Reader, error) {
+		return NewReader(bytes.NewReader(data), int64(len(data)), l)
+	}
+
+	for name, l := range map[string]Limits{
+		"total":      {TotalBytes: 1 << 19},
+		"attachment": {AttachmentBytes: 1<<20 - 1},
+		"messages":   {Messages: 2},
+	} {
+		if _, err := open(l); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: err = %v, want ErrTooLarge", name, err)
+		}
+	}
+
+	r, err := open(Limits{TotalBytes: 2 << 20, AttachmentBytes: 1 << 20, Attachments: 1, Messages: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Messages(func(Message) error { return nil }); err != nil {
+		t.Errorf("messages: %v", err)
+	}
+	rc, err := r.OpenAttachment("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if n, err := io.Copy(io.Discard, rc); err != nil || n != 1<<20 {
+		t.Errorf("attachment: read %d, %v", n, err)
+	}
+
+	// A manifest that undercounts its messages is caught while reading them
+	r, _ = open(Limits{})
+	r.limits.Messages = 2
+	if err := r.Messages(func(Message) error { return nil }); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("messages over the limit: err = %v, want ErrTooLarge", err)
+	}
+
+	// The total budget is counted as entries are read, not just from headers
+	r, _ = open(Limits{})
+	r.left = 1 << 19
+	rc, _ = r.OpenAttachment("a1")
+	defer rc.Close()
+	if _, err := io.Copy(io.Discard, rc); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over the total budget: err = %v, want ErrTooLarge", err)
+	}
+}
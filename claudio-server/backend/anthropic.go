@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nicebartender/claudio-server/openclaw"
+)
+
+const (
+	// DefaultAnthropicModel is used when an Anthropic agent has no model set.
+	DefaultAnthropicModel = "claude-3-5-haiku-latest"
+	anthropicVersion      = "2023-06-01"
+	anthropicMaxTokens    = 1024
+)
+
+// Anthropic calls the Anthropic Messages API.
+type Anthropic struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+
+	client *http.Client
+}
+
+func (b *Anthropic) Stateful() bool { return false }
+
+func (b *Anthropic) Complete(ctx context.Context, req Request) (*Reply, error) {
+	// The Messages API takes the system prompt separately
+	var system []string
+	messages := []map[string]string{}
+	for _, m := range req.Messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		messages = append(messages, map[string]string{"role": m.Role, "content": m.Content})
+	}
+	payload := map[string]interface{}{
+		"model":      b.Model,
+		"max_tokens": anthropicMaxTokens,
+		"messages":   messages,
+	}
+	if len(system) > 0 {
+		payload["system"] = strings.Join(system, "\n\n")
+	}
+	body, _ := json.Marshal(payload)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", b.BaseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("x-api-key", b.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	respBody, err := doJSON(b.client, httpReq, "Anthropic")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Content []interface{} `json:"content"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("parse Anthropic response: %w", err)
+	}
+	text, blocks := openclaw.ParseContentBlocks(result.Content)
+	return &Reply{Text: text, Blocks: blocks}, nil
+}
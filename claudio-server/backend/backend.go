@@ -0,0 +1,152 @@
+// Package backend talks to the services that generate agent replies: an
+// OpenClaw server, or an LLM provider's HTTP API used directly.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nicebartender/claudio-server/openclaw"
+)
+
+// Kinds of backend an agent participant can use.
+const (
+	KindOpenClaw  = "openclaw"
+	KindOpenAI    = "openai"
+	KindAnthropic = "anthropic"
+)
+
+// Message is one turn of a conversation.
+type Message struct {
+	Role    string // system, user, or assistant
+	Content string
+}
+
+// Request asks a backend for the next assistant message.
+type Request struct {
+	// SessionKey identifies the conversation for backends that keep history
+	SessionKey string
+	Messages   []Message
+}
+
+// Reply is an agent's answer: its text plus any images and tool activity.
+type Reply struct {
+	Text   string
+	Blocks []openclaw.ContentBlock
+}
+
+// Backend produces agent replies.
+type Backend interface {
+	Complete(ctx context.Context, req Request) (*Reply, error)
+	// Stateful reports whether the backend remembers earlier turns of a
+	// session by itself. Stateless backends must be sent the history.
+	Stateful() bool
+}
+
+// Config selects and configures a backend.
+type Config struct {
+	Kind    string
+	BaseURL string // provider default if empty (not for OpenClaw)
+	Token   string // OpenClaw token or provider API key
+	Model   string // provider default if empty
+}
+
+// ValidKind reports whether kind names a known backend; "" means OpenClaw.
+func ValidKind(kind string) bool {
+	switch kind {
+	case "", KindOpenClaw, KindOpenAI, KindAnthropic:
+		return true
+	}
+	return false
+}
+
+// IsOpenClaw reports whether kind is served by an OpenClaw server.
+func IsOpenClaw(kind string) bool {
+	return kind == "" || kind == KindOpenClaw
+}
+
+// DefaultBaseURL returns the public API URL for a provider kind.
+func DefaultBaseURL(kind string) string {
+	switch kind {
+	case KindOpenAI:
+		return "https://api.openai.com"
+	case KindAnthropic:
+		return "https://api.anthropic.com"
+	}
+	return ""
+}
+
+// New returns the backend described by cfg.
+func New(cfg Config, client *http.Client) (Backend, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL(cfg.Kind)
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	switch cfg.Kind {
+	case "", KindOpenClaw:
+		if baseURL == "" {
+			return nil, fmt.Errorf("backend: OpenClaw URL is required")
+		}
+		return &OpenAI{BaseURL: HTTPURL(baseURL), APIKey: cfg.Token, Model: "default", client: client, stateful: true}, nil
+	case KindOpenAI:
+		model := cfg.Model
+		if model == "" {
+			model = DefaultOpenAIModel
+		}
+		return &OpenAI{BaseURL: baseURL, APIKey: cfg.Token, Model: model, client: client}, nil
+	case KindAnthropic:
+		model := cfg.Model
+		if model == "" {
+			model = DefaultAnthropicModel
+		}
+		return &Anthropic{BaseURL: baseURL, APIKey: cfg.Token, Model: model, client: client}, nil
+	}
+	return nil, fmt.Errorf("backend: unknown kind %q", cfg.Kind)
+}
+
+// HTTPURL converts a WebSocket or HTTP URL to an HTTP base URL.
+func HTTPURL(raw string) string {
+	u := raw
+	u = strings.Replace(u, "wss://", "https://", 1)
+	u = strings.Replace(u, "ws://", "http://", 1)
+	u = strings.TrimSuffix(u, "/")
+	return u
+}
+
+// StatusError is returned when a backend answers with a non-200 status.
+type StatusError struct {
+	Service string
+	Code    int
+	Body    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned %d", e.Service, e.Code)
+}
+
+// doJSON sends req and returns the body of a 200 response.
+func doJSON(client *http.Client, req *http.Request, service string) ([]byte, error) {
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Service: service, Code: resp.StatusCode, Body: string(body)}
+	}
+	return body, nil
+}
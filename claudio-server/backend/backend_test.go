@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nicebartender/claudio-server/openclaw"
+)
+
+func TestOpenAIComplete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("request %s auth=%q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var body struct {
+			Model    string              `json:"model"`
+			Messages []map[string]string `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Model != "gpt-test" || len(body.Messages) != 2 || body.Messages[0]["role"] != "system" {
+			t.Errorf("body = %+v", body)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"hello"}}]}`))
+	}))
+	defer srv.Close()
+
+	b, err := New(Config{Kind: KindOpenAI, BaseURL: srv.URL, Token: "key", Model: "gpt-test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Stateful() {
+		t.Error("OpenAI backend should be stateless")
+	}
+	reply, err := b.Complete(context.Background(), Request{Messages: []Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "hi"},
+	}})
+	if err != nil || reply.Text != "hello" {
+		t.Fatalf("reply = %+v, %v", reply, err)
+	}
+}
+
+func TestAnthropicComplete(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		var body struct {
+			System   string              `json:"system"`
+			Messages []map[string]string `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.System != "be brief" || len(body.Messages) != 1 {
+			t.Errorf("body = %+v", body)
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"hel"},{"type":"tool_use","id":"t1","name":"search","input":{"q":"x"}},{"type":"text","text":"lo"}]}`))
+	}))
+	defer srv.Close()
+
+	b, _ := New(Config{Kind: KindAnthropic, BaseURL: srv.URL, Token: "key"}, nil)
+	reply, err := b.Complete(context.Background(), Request{Messages: []Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "hi"},
+	}})
+	if err != nil || reply.Text != "hello" {
+		t.Fatalf("reply = %+v, %v", reply, err)
+	}
+	if len(reply.Blocks) != 1 || reply.Blocks[0].ToolName != "search" || string(reply.Blocks[0].Input) != `{"q":"x"}` {
+		t.Errorf("blocks = %+v", reply.Blocks)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	b, _ := New(Config{Kind: KindOpenClaw, BaseURL: srv.URL}, nil)
+	_, err := b.Complete(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != http.StatusUnauthorized || status.Error() != "OpenClaw returned 401" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{&StatusError{Code: 429}, ErrRateLimited, true},
+		{&StatusError{Code: 401}, ErrUnauthorized, false},
+		{&StatusError{Code: 502}, ErrServer, true},
+		{&StatusError{Code: 400}, ErrRejected, false},
+		{context.DeadlineExceeded, ErrTimeout, true},
+		{fmt.Errorf("auth: %w: INVALID_TOKEN: bad token", openclaw.ErrConnectRejected), ErrUnauthorized, false},
+		{errors.New("parse failed"), ErrUnknown, false},
+	}
+	for _, tt := range tests {
+		if code, retryable := Classify(tt.err); code != tt.code || retryable != tt.retryable {
+			t.Errorf("Classify(%v) = %s, %v; want %s, %v", tt.err, code, retryable, tt.code, tt.retryable)
+		}
+	}
+
+	// Nothing listens on port 1
+	b, _ := New(Config{Kind: KindOpenAI, BaseURL: "http://127.0.0.1:1"}, nil)
+	_, err := b.Complete(context.Background(), Request{})
+	if code, retryable := Classify(err); code != ErrUnreachable || !retryable {
+		t.Errorf("connection refused = %s, %v", code, retryable)
+	}
+}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/nicebartender/claudio-server/openclaw"
+)
+
+// Machine-readable causes of a failed agent call, sent to clients in
+// room.agent.error events.
+const (
+	ErrTimeout      = "timeout"
+	ErrUnreachable  = "unreachable"
+	ErrRateLimited  = "rate_limited"
+	ErrUnauthorized = "unauthorized"
+	ErrServer       = "server_error"
+	ErrRejected     = "rejected"
+	ErrUnknown      = "unknown"
+)
+
+// Classify maps an error from Complete to an error code and whether trying
+// again might succeed.
+func Classify(err error) (code string, retryable bool) {
+	var status *StatusError
+	if errors.As(err, &status) {
+		switch {
+		case status.Code == http.StatusTooManyRequests:
+			return ErrRateLimited, true
+		case status.Code == http.StatusUnauthorized || status.Code == http.StatusForbidden:
+			return ErrUnauthorized, false
+		case status.Code == http.StatusRequestTimeout || status.Code == http.StatusGatewayTimeout:
+			return ErrTimeout, true
+		case status.Code >= 500:
+			return ErrServer, true
+		default:
+			return ErrRejected, false
+		}
+	}
+
+	if errors.Is(err, openclaw.ErrConnectRejected) {
+		return ErrUnauthorized, false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout, true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout, true
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || errors.As(err, &opErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return ErrUnreachable, true
+	}
+	return ErrUnknown, false
+}
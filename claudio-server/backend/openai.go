@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nicebartender/claudio-server/openclaw"
+)
+
+// DefaultOpenAIModel is used when an OpenAI agent has no model set.
+const DefaultOpenAIModel = "gpt-4o-mini"
+
+// OpenAI calls an OpenAI-compatible chat completions API. OpenClaw serves
+// the same API and keeps the conversation per session key.
+type OpenAI struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+
+	client   *http.Client
+	stateful bool
+}
+
+func (b *OpenAI) Stateful() bool { return b.stateful }
+
+func (b *OpenAI) Complete(ctx context.Context, req Request) (*Reply, error) {
+	messages := make([]map[string]string, len(req.Messages))
+	for i, m := range req.Messages {
+		messages[i] = map[string]string{"role": m.Role, "content": m.Content}
+	}
+	payload := map[string]interface{}{
+		"model":    b.Model,
+		"messages": messages,
+	}
+	if b.stateful && req.SessionKey != "" {
+		payload["user"] = req.SessionKey
+	}
+	body, _ := json.Marshal(payload)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", b.BaseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if b.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+b.APIKey)
+	}
+
+	service := "OpenAI"
+	if b.stateful {
+		service = "OpenClaw"
+	}
+	respBody, err := doJSON(b.client, httpReq, service)
+	if err != nil {
+		return nil, err
+	}
+
+	// content is usually a string, but may be a list of parts
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content   interface{}   `json:"content"`
+				ToolCalls []interface{} `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("parse %s response: %w", service, err)
+	}
+	if len(result.Choices) == 0 {
+		return &Reply{}, nil
+	}
+
+	msg := result.Choices[0].Message
+	reply := &Reply{}
+	switch content := msg.Content.(type) {
+	case string:
+		reply.Text = content
+	case []interface{}:
+		reply.Text, reply.Blocks = openclaw.ParseContentBlocks(content)
+	}
+	for _, call := range msg.ToolCalls {
+		cm, ok := call.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// OpenAI nests the name and arguments under "function"
+		block := map[string]interface{}{"type": "tool_call", "id": cm["id"]}
+		if fn, ok := cm["function"].(map[string]interface{}); ok {
+			block["name"], block["arguments"] = fn["name"], fn["arguments"]
+		}
+		_, calls := openclaw.ParseContentBlocks([]interface{}{block})
+		reply.Blocks = append(reply.Blocks, calls...)
+	}
+	return reply, nil
+}
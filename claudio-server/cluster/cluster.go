@@ -0,0 +1,64 @@
+// Package cluster carries hub traffic between claudio-server instances over
+// Redis or NATS pub/sub, for running several of them behind a load
+// balancer. Only the publish/subscribe subset of each protocol is spoken.
+package cluster
+
+import (
+	"fmt"
+	mrand "math/rand/v2"
+	"net/url"
+	"time"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+)
+
+// Broker publishes to and receives from one pub/sub channel. It implements
+// ws.ClusterBroker.
+type Broker interface {
+	Publish(data []byte) error
+	// Subscribe calls handler with every message on the channel, including
+	// our own, until Close. The subscription survives reconnects.
+	Subscribe(handler func(data []byte)) error
+	Close() error
+}
+
+// Open connects to rawURL, redis://[:password@]host:port or
+// nats://[user:password@]host:port, and uses channel for all traffic.
+func Open(rawURL, channel string) (Broker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("cluster url: %w", err)
+	}
+	switch u.Scheme {
+	case "redis":
+		return openRedis(u, channel)
+	case "nats":
+		return openNATS(u, channel)
+	default:
+		return nil, fmt.Errorf("cluster url: unsupported scheme %q (want redis or nats)", u.Scheme)
+	}
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return u.Hostname() + ":" + defaultPort
+	}
+	return u.Host
+}
+
+// jitter spreads reconnects of many instances after a broker restart.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(mrand.Int64N(int64(half)+1))
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	if d *= 2; d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeServer listens for the broker under test and hands over each
+// connection it makes. Connections time out after 10s.
+func fakeServer(t *testing.T) (addr string, conns <-chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			ch <- conn
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+// accept waits for the broker's next connection, which after a drop comes
+// once the reconnect backoff is over.
+func accept(t *testing.T, conns <-chan net.Conn) net.Conn {
+	t.Helper()
+	select {
+	case conn := <-conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker didn't connect")
+		return nil
+	}
+}
+
+func received(t *testing.T, got <-chan []byte, want string) {
+	t.Helper()
+	select {
+	case data := <-got:
+		if string(data) != want {
+			t.Errorf("handler got %q, want %q", data, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("handler didn't get %q", want)
+	}
+}
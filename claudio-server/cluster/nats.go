@@ -0,0 +1,240 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsBroker speaks the NATS client protocol on a single connection, which
+// both publishes and receives.
+type natsBroker struct {
+	addr    string
+	user    string
+	pass    string
+	subject string
+
+	mu      sync.Mutex // guards conn and handler; serializes writes
+	conn    net.Conn
+	r       *bufio.Reader
+	handler func(data []byte)
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func openNATS(u *url.URL, subject string) (*natsBroker, error) {
+	b := &natsBroker{
+		addr:    hostPort(u, "4222"),
+		subject: subject,
+		closed:  make(chan struct{}),
+	}
+	if u.User != nil {
+		b.user = u.User.Username()
+		b.pass, _ = u.User.Password()
+	}
+	conn, r, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.conn, b.r = conn, r
+	go b.readLoop()
+	return b, nil
+}
+
+// dial connects and completes the handshake: the server's INFO, our
+// CONNECT, and a PING answered by PONG (or -ERR if CONNECT was refused).
+func (b *natsBroker) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, dialTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("nats dial %s: %w", b.addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+	fail := func(err error) (net.Conn, *bufio.Reader, error) {
+		conn.Close()
+		return nil, nil, fmt.Errorf("nats handshake: %w", err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fail(errors.New("expected INFO, got " + strings.TrimSpace(line)))
+	}
+	opts, _ := json.Marshal(map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "claudio-server",
+		"lang":     "go",
+		"user":     b.user,
+		"pass":     b.pass,
+	})
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", opts); err != nil {
+		return fail(err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fail(err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return conn, r, nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fail(errors.New(line))
+		}
+	}
+}
+
+func (b *natsBroker) Publish(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return errors.New("nats: not connected")
+	}
+	b.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	buf := make([]byte, 0, len(b.subject)+len(data)+32)
+	buf = append(buf, "PUB "+b.subject+" "+strconv.Itoa(len(data))+"\r\n"...)
+	buf = append(buf, data...)
+	buf = append(buf, "\r\n"...)
+	if _, err := b.conn.Write(buf); err != nil {
+		return fmt.Errorf("nats publish: %w", err)
+	}
+	return nil
+}
+
+func (b *natsBroker) Subscribe(handler func(data []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handler = handler
+	if b.conn == nil {
+		// readLoop subscribes when it reconnects
+		return nil
+	}
+	return b.sub()
+}
+
+// sub asks the server for the subject's messages. The caller holds b.mu.
+func (b *natsBroker) sub() error {
+	b.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := fmt.Fprintf(b.conn, "SUB %s 1\r\n", b.subject)
+	return err
+}
+
+// readLoop delivers messages and answers pings, reconnecting and
+// resubscribing when the connection drops.
+func (b *natsBroker) readLoop() {
+	backoff := minBackoff
+	for {
+		b.mu.Lock()
+		r := b.r
+		b.mu.Unlock()
+		err := b.read(r)
+
+		b.mu.Lock()
+		if b.conn != nil {
+			b.conn.Close()
+			b.conn, b.r = nil, nil
+		}
+		b.mu.Unlock()
+		for {
+			select {
+			case <-b.closed:
+				return
+			default:
+			}
+			slog.Warn("cluster: nats connection lost", "addr", b.addr, "retryIn", backoff, "err", err)
+			select {
+			case <-b.closed:
+				return
+			case <-time.After(jitter(backoff)):
+			}
+			var conn net.Conn
+			if conn, r, err = b.dial(); err != nil {
+				backoff = nextBackoff(backoff)
+				continue
+			}
+			b.mu.Lock()
+			b.conn, b.r = conn, r
+			if b.handler != nil {
+				err = b.sub()
+			}
+			b.mu.Unlock()
+			if err != nil {
+				b.mu.Lock()
+				b.conn.Close()
+				b.conn, b.r = nil, nil
+				b.mu.Unlock()
+				continue
+			}
+			slog.Info("cluster: nats reconnected", "addr", b.addr)
+			backoff = minBackoff
+			break
+		}
+	}
+}
+
+// read handles server messages until the connection fails.
+func (b *natsBroker) read(r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			n, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || n < 0 {
+				return errors.New("nats: malformed MSG")
+			}
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			b.mu.Lock()
+			handler := b.handler
+			b.mu.Unlock()
+			if handler != nil {
+				handler(payload[:n])
+			}
+		case line == "PING":
+			b.mu.Lock()
+			if b.conn != nil {
+				b.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+				_, err = io.WriteString(b.conn, "PONG\r\n")
+			}
+			b.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			slog.Warn("cluster: nats error", "err", line)
+		}
+	}
+}
+
+func (b *natsBroker) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn, b.r = nil, nil
+	}
+	return nil
+}
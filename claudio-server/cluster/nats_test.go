@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// natsConn is the fake server's side of a broker connection.
+type natsConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *natsConn) expect(want string) string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("reading %q: %v", want, err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, want) {
+		c.t.Fatalf("got %q, want %q", line, want)
+	}
+	return line
+}
+
+func (c *natsConn) send(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// handshake plays the server's part of natsBroker.dial and returns the
+// CONNECT line.
+func (c *natsConn) handshake() string {
+	c.t.Helper()
+	c.send("INFO {\"server_id\":\"fake\"}\r\n")
+	connect := c.expect("CONNECT ")
+	c.expect("PING")
+	c.send("PONG\r\n")
+	return connect
+}
+
+func acceptNATS(t *testing.T, conns <-chan net.Conn) *natsConn {
+	t.Helper()
+	conn := accept(t, conns)
+	return &natsConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func TestNATSBroker(t *testing.T) {
+	addr, conns := fakeServer(t)
+	opened := make(chan Broker, 1)
+	go func() {
+		b, err := Open("nats://claudio:secret@"+addr, "claudio.hub")
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- b
+	}()
+	srv := acceptNATS(t, conns)
+	if connect := srv.handshake(); !strings.Contains(connect, `"user":"claudio"`) || !strings.Contains(connect, `"pass":"secret"`) {
+		t.Errorf("CONNECT = %s, want the URL's credentials", connect)
+	}
+	b := <-opened
+	if b == nil {
+		t.FailNow()
+	}
+	defer b.Close()
+
+	got := make(chan []byte, 4)
+	if err := b.Subscribe(func(data []byte) { got <- data }); err != nil {
+		t.Fatal(err)
+	}
+	srv.expect("SUB claudio.hub 1")
+
+	if err := b.Publish([]byte("a\r\nb")); err != nil {
+		t.Fatal(err)
+	}
+	srv.expect("PUB claudio.hub 4")
+	srv.expect("a")
+	srv.expect("b")
+
+	srv.send("MSG claudio.hub 1 4\r\nc\r\nd\r\n")
+	received(t, got, "c\r\nd")
+	srv.send("PING\r\n")
+	srv.expect("PONG")
+	srv.send("MSG claudio.hub 1 _INBOX.x 2\r\nhi\r\n")
+	received(t, got, "hi")
+
+	// A dropped connection is redialed and the subscription renewed
+	srv.conn.Close()
+	srv = acceptNATS(t, conns)
+	srv.handshake()
+	srv.expect("SUB claudio.hub 1")
+	srv.send("MSG claudio.hub 1 5\r\nagain\r\n")
+	received(t, got, "again")
+}
+
+func TestNATSRefusedConnect(t *testing.T) {
+	addr, conns := fakeServer(t)
+	errc := make(chan error, 1)
+	go func() {
+		b, err := Open("nats://"+addr, "claudio.hub")
+		if err == nil {
+			b.Close()
+		}
+		errc <- err
+	}()
+	srv := acceptNATS(t, conns)
+	srv.send("INFO {}\r\n")
+	srv.expect("CONNECT ")
+	srv.expect("PING")
+	srv.send("-ERR 'Authorization Violation'\r\n")
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Open = %v, want the server's error", err)
+	}
+}
@@ -0,0 +1,238 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisBroker publishes on one connection and listens on another, as a
+// Redis connection in subscribe mode can't run other commands.
+type redisBroker struct {
+	addr     string
+	password string
+	channel  string
+
+	mu  sync.Mutex // guards pub
+	pub *redisConn
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func openRedis(u *url.URL, channel string) (*redisBroker, error) {
+	b := &redisBroker{
+		addr:    hostPort(u, "6379"),
+		channel: channel,
+		closed:  make(chan struct{}),
+	}
+	if u.User != nil {
+		b.password, _ = u.User.Password()
+		if b.password == "" {
+			b.password = u.User.Username()
+		}
+	}
+	// Fail at startup rather than on the first broadcast
+	pub, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.pub = pub
+	return b, nil
+}
+
+func (b *redisBroker) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis dial %s: %w", b.addr, err)
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if b.password != "" {
+		if _, err := c.do("AUTH", b.password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (b *redisBroker) Publish(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pub == nil {
+		pub, err := b.dial()
+		if err != nil {
+			return err
+		}
+		b.pub = pub
+	}
+	if _, err := b.pub.do("PUBLISH", b.channel, string(data)); err != nil {
+		// Redial on the next publish
+		b.pub.conn.Close()
+		b.pub = nil
+		return fmt.Errorf("redis publish: %w", err)
+	}
+	return nil
+}
+
+func (b *redisBroker) Subscribe(handler func(data []byte)) error {
+	go func() {
+		backoff := minBackoff
+		for {
+			err := b.listen(handler, func() { backoff = minBackoff })
+			select {
+			case <-b.closed:
+				return
+			default:
+			}
+			slog.Warn("cluster: redis subscription lost", "addr", b.addr, "retryIn", backoff, "err", err)
+			select {
+			case <-b.closed:
+				return
+			case <-time.After(jitter(backoff)):
+			}
+			backoff = nextBackoff(backoff)
+		}
+	}()
+	return nil
+}
+
+// listen subscribes on a fresh connection and delivers messages until the
+// connection fails or the broker is closed.
+func (b *redisBroker) listen(handler func(data []byte), subscribed func()) error {
+	c, err := b.dial()
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-b.closed:
+		case <-stop:
+		}
+		c.conn.Close()
+	}()
+
+	if err := c.write("SUBSCRIBE", b.channel); err != nil {
+		return err
+	}
+	for {
+		reply, err := readRESP(c.r)
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		switch kind, _ := parts[0].(string); kind {
+		case "subscribe":
+			slog.Info("cluster: subscribed", "redis", b.addr, "channel", b.channel)
+			subscribed()
+		case "message":
+			if data, ok := parts[2].(string); ok {
+				handler([]byte(data))
+			}
+		}
+	}
+}
+
+func (b *redisBroker) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pub != nil {
+		b.pub.conn.Close()
+		b.pub = nil
+	}
+	return nil
+}
+
+// do sends a command and reads its reply.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	return readRESP(c.r)
+}
+
+func (c *redisConn) write(args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// readRESP reads one RESP2 reply: simple strings and bulk strings come back
+// as string, integers as int64, arrays as []interface{}, nil as nil, and
+// error replies as an error.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, errors.New("redis: " + body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errors.New("redis: malformed bulk length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errors.New("redis: malformed array length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
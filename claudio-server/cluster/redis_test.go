@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRESP(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(
+		"+OK\r\n" +
+			":3\r\n" +
+			"$-1\r\n" +
+			"*3\r\n$7\r\nmessage\r\n$7\r\nclaudio\r\n$12\r\n{\"a\":\"b\r\nc\"}\r\n" +
+			"-ERR wrong password\r\n"))
+
+	want := []interface{}{
+		"OK",
+		int64(3),
+		nil,
+		[]interface{}{"message", "claudio", "{\"a\":\"b\r\nc\"}"},
+	}
+	for i, w := range want {
+		got, err := readRESP(r)
+		if err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("reply %d = %#v, want %#v", i, got, w)
+		}
+	}
+	if _, err := readRESP(r); err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Errorf("error reply = %v", err)
+	}
+}
+
+// redisServerConn is the fake server's side of a broker connection.
+type redisServerConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func acceptRedis(t *testing.T, conns <-chan net.Conn) *redisServerConn {
+	t.Helper()
+	conn := accept(t, conns)
+	return &redisServerConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// expect reads a command and checks its args.
+func (c *redisServerConn) expect(args ...string) {
+	c.t.Helper()
+	got, err := readRESP(c.r)
+	if err != nil {
+		c.t.Fatalf("reading %v: %v", args, err)
+	}
+	want := make([]interface{}, len(args))
+	for i, a := range args {
+		want[i] = a
+	}
+	if !reflect.DeepEqual(got, want) {
+		c.t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func (c *redisServerConn) send(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func TestRedisBroker(t *testing.T) {
+	addr, conns := fakeServer(t)
+	opened := make(chan Broker, 1)
+	go func() {
+		b, err := Open("redis://:secret@"+addr, "claudio")
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- b
+	}()
+	pub := acceptRedis(t, conns)
+	pub.expect("AUTH", "secret")
+	pub.send("+OK\r\n")
+	b := <-opened
+	if b == nil {
+		t.FailNow()
+	}
+	defer b.Close()
+
+	publish := func(data string) <-chan error {
+		errc := make(chan error, 1)
+		go func() { errc <- b.Publish([]byte(data)) }()
+		return errc
+	}
+	errc := publish("a\r\nb")
+	pub.expect("PUBLISH", "claudio", "a\r\nb")
+	pub.send(":1\r\n")
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed publish redials on the next one
+	errc = publish("lost")
+	pub.expect("PUBLISH", "claudio", "lost")
+	pub.send("-ERR oops\r\n")
+	if err := <-errc; err == nil {
+		t.Error("Publish succeeded after an error reply")
+	}
+	errc = publish("retried")
+	pub = acceptRedis(t, conns)
+	pub.expect("AUTH", "secret")
+	pub.send("+OK\r\n")
+	pub.expect("PUBLISH", "claudio", "retried")
+	pub.send(":1\r\n")
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan []byte, 4)
+	if err := b.Subscribe(func(data []byte) { got <- data }); err != nil {
+		t.Fatal(err)
+	}
+	sub := acceptRedis(t, conns)
+	sub.expect("AUTH", "secret")
+	sub.send("+OK\r\n")
+	sub.expect("SUBSCRIBE", "claudio")
+	sub.send("*3\r\n$9\r\nsubscribe\r\n$7\r\nclaudio\r\n:1\r\n")
+	sub.send("*3\r\n$7\r\nmessage\r\n$7\r\nclaudio\r\n$4\r\nc\r\nd\r\n")
+	received(t, got, "c\r\nd")
+
+	// A dropped subscription is renewed on a new connection
+	sub.conn.Close()
+	sub = acceptRedis(t, conns)
+	sub.expect("AUTH", "secret")
+	sub.send("+OK\r\n")
+	sub.expect("SUBSCRIBE", "claudio")
+	sub.send("*3\r\n$7\r\nmessage\r\n$7\r\nclaudio\r\n$5\r\nagain\r\n")
+	received(t, got, "again")
+}
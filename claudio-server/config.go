@@ -1,19 +1,166 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nicebartender/claudio-server/apns"
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/openclaw"
+	"github.com/nicebartender/claudio-server/rpc"
+	"github.com/nicebartender/claudio-server/ws"
 )
 
 type Config struct {
 	ListenAddr  string
 	DBPath      string
+	DatabaseURL string // postgres://... to use Postgres instead of the SQLite file at DBPath
+
+	DBQueryTimeout time.Duration // longest a query or transaction may run; 0 = no limit
+
+	// Online SQLite backups: admin.backup writes snapshots into BackupDir
+	// (unset disables it). BackupTo and RestoreFrom are one-off commands
+	// that back up to, or restore from, a file and exit; restore with the
+	// server stopped.
+	BackupDir   string
+	BackupTo    string
+	RestoreFrom string
+
+	// Database maintenance: a cron expression in server local time (empty
+	// disables it) for pruning expired invites, auth log entries older than
+	// AuthEventRetention (0 = keep) and messages past their room's
+	// retention, then vacuuming
+	MaintenanceSchedule string
+	AuthEventRetention  time.Duration
+
 	ExternalURL string
 	APNS        apns.Config
 	PushSecret  string
+	AdminSecret string // bearer token for server admin endpoints; unset disables them
 	LobbyAgent  LobbyAgentConfig
+
+	ResumeTokenTTL time.Duration // lifetime of connect resume tokens; 0 = don't issue them
+
+	// Connections must re-run the connect handshake this often (0 = never),
+	// and are warned with auth.expiring this long before
+	MaxSessionAge        time.Duration
+	SessionExpiryWarning time.Duration
+
+	ReplayBuffer   int // recent events kept per room for reconnects with lastSeq
+	LateJoinEvents int // of those, how many a client gets on joining a room
+
+	// Messages queued per connection behind a full send buffer, and what
+	// to do with connections that fill the queue (resync or disconnect)
+	SendQueueLimit int
+	SlowConsumer   string
+
+	// How long room.message events in busy rooms wait to share one frame,
+	// for clients that ask for batches (0 = off)
+	BatchInterval time.Duration
+
+	// How often connections without other traffic get a tick event
+	TickInterval time.Duration
+
+	// Cluster mode: instances behind one load balancer share broadcasts and
+	// presence over Redis (redis://) or NATS (nats://). They must also share
+	// the database. Unset runs a single instance.
+	ClusterURL     string
+	ClusterChannel string
+
+	// Token-bucket limits on RPC requests and sent messages (0/s = off)
+	ConnRPCLimit     ws.RateLimit
+	UserRPCLimit     ws.RateLimit
+	UserMessageLimit ws.RateLimit
+
+	// WebSocket upgrades per remote IP, against reconnect storms
+	IPConnectLimit ws.RateLimit
+
+	// Browser origins allowed to open WebSockets besides the server's own
+	// (wildcards allowed, see ws.OriginChecker). AllowAnyOrigin skips the
+	// check, for deployments where only native clients connect.
+	AllowedOrigins []string
+	AllowAnyOrigin bool
+
+	// Reverse proxies in front of the server, each appending to
+	// X-Forwarded-For. Client IPs, used for the auth log, IP bans and the
+	// per-IP connect throttle, are taken from that header, that many entries
+	// from the end. 0 ignores it, since clients can set it themselves.
+	TrustedProxies int
+
+	// permessage-deflate on client WebSockets and OpenClaw connections, and
+	// the deflate level (1 fastest, 9 smallest)
+	Compression      bool
+	CompressionLevel int
+
+	// Who may register new devices: open, secret (JoinSecret as auth.token)
+	// or invite (a server invite code as auth.token)
+	Registration string
+	JoinSecret   string
+
+	// Connects with an identity provider's JWT (auth.jwt) instead of a
+	// device key. Enabled by JWTSecret (HS256) or JWTPublicKeysPath (PEM
+	// file of RS256/ES256/EdDSA keys).
+	JWTIssuer         string
+	JWTAudience       string
+	JWTSecret         string
+	JWTPublicKeysPath string
+	JWTUserClaim      string
+	JWTNameClaim      string
+
+	// Server admins for admin.* RPCs: public keys (base64url) or device/user IDs
+	Admins []string
+
+	// Optional URL that is POSTed every new abuse report
+	ReportWebhookURL string
+
+	MediaDir       string // content-addressed attachment storage
+	MaxUploadBytes int64
+
+	// Longest the server waits to read a whole HTTP request, upload bodies
+	// included (0 = no limit)
+	ReadTimeout time.Duration
+
+	MaxMessageLength        int  // in characters; 0 = unlimited
+	LongMessageAsAttachment bool // convert over-long messages to a .txt attachment instead of rejecting
+
+	// Optional LibreTranslate-compatible endpoint for rooms.translateMessage
+	TranslateURL    string
+	TranslateAPIKey string
+
+	// Content filters rooms can opt into: a wordlist file (one word per line,
+	// "re:" for regexes) and/or a webhook, see rpc.MessageFilter
+	FilterWordlistPath string
+	FilterWebhookURL   string
+
+	TemplatesPath string // JSON file of server-wide room templates
+
+	MaxAgentChainDepth int // agent replies allowed after a human message in rooms with agent chains
+
+	// Per-agent per-room dispatch limits: at most one response per
+	// AgentMinInterval, and a pause after AgentBreakerLimit responses within
+	// AgentBreakerWindow (0 disables either).
+	AgentMinInterval   time.Duration
+	AgentBreakerLimit  int
+	AgentBreakerWindow time.Duration
+
+	// Per-agent dispatch queue, see rpc.Router.AgentConcurrency
+	AgentConcurrency   int
+	AgentQueueLimit    int
+	AgentQueueStrategy string // merge or drop
+	AgentMentionOrder  string // grouped or ordered
+
+	// OpenClaw request timeouts
+	OpenclawRequestTimeout time.Duration // one request/response round trip
+	OpenclawChatTimeout    time.Duration // a whole agent response
+	AgentProbeInterval     time.Duration // how often pooled agent connections are pinged; 0 = off
+	OpenclawIdleTimeout    time.Duration // close pooled connections unused this long; 0 = never
+	OpenclawMaxConns       int           // pooled connection cap; 0 = unlimited
 }
 
 type LobbyAgentConfig struct {
@@ -30,9 +177,55 @@ func LoadConfig() Config {
 
 	flag.StringVar(&cfg.ListenAddr, "addr", defaultAddr(), "Listen address")
 	flag.StringVar(&cfg.DBPath, "db", envOrDefault("CLAUDIO_DB", "claudio.db"), "SQLite database path")
+	flag.StringVar(&cfg.DatabaseURL, "database-url", envOrDefault("CLAUDIO_DATABASE_URL", ""), "Postgres URL; overrides -db")
+	flag.DurationVar(&cfg.DBQueryTimeout, "db-query-timeout", envDurationOrDefault("CLAUDIO_DB_QUERY_TIMEOUT", db.DefaultQueryTimeout), "Longest a database query or transaction may run (0 = no limit)")
+	flag.StringVar(&cfg.MaintenanceSchedule, "maintenance-schedule", envOrDefault("CLAUDIO_MAINTENANCE_SCHEDULE", "30 4 * * *"), "Cron expression for database maintenance (empty disables it)")
+	flag.DurationVar(&cfg.AuthEventRetention, "auth-event-retention", envDurationOrDefault("CLAUDIO_AUTH_EVENT_RETENTION", 90*24*time.Hour), "How long auth log entries are kept (0 = forever)")
+	flag.StringVar(&cfg.BackupDir, "backup-dir", envOrDefault("CLAUDIO_BACKUP_DIR", ""), "Directory admin.backup writes database snapshots to")
+	flag.StringVar(&cfg.BackupTo, "backup", "", "Snapshot the database to this file and exit")
+	flag.StringVar(&cfg.RestoreFrom, "restore", "", "Replace the database with this backup and exit (server must be stopped)")
 	flag.StringVar(&cfg.ExternalURL, "external-url", envOrDefault("CLAUDIO_EXTERNAL_URL", ""), "External URL advertised in join codes")
+	flag.StringVar(&cfg.MediaDir, "media-dir", envOrDefault("CLAUDIO_MEDIA_DIR", ""), "Attachment storage directory (default: media/ next to the database)")
+	flag.Int64Var(&cfg.MaxUploadBytes, "max-upload-bytes", envInt64OrDefault("CLAUDIO_MAX_UPLOAD_BYTES", 25<<20), "Maximum attachment size in bytes")
+	flag.DurationVar(&cfg.ReadTimeout, "read-timeout", envDurationOrDefault("CLAUDIO_READ_TIMEOUT", 10*time.Minute), "Longest an HTTP request, body included, may take to arrive (0 = no limit)")
+	flag.IntVar(&cfg.MaxMessageLength, "max-message-length", int(envInt64OrDefault("CLAUDIO_MAX_MESSAGE_LENGTH", 10000)), "Maximum message length in characters (0 = unlimited)")
+	flag.IntVar(&cfg.MaxAgentChainDepth, "agent-chain-depth", int(envInt64OrDefault("CLAUDIO_AGENT_CHAIN_DEPTH", openclaw.DefaultMaxChainDepth)), "Maximum agent-to-agent reply chain depth in rooms that allow agent chains")
+	flag.DurationVar(&cfg.AgentMinInterval, "agent-min-interval", envDurationOrDefault("CLAUDIO_AGENT_MIN_INTERVAL", openclaw.DefaultMinInterval), "Minimum time between an agent's responses in a room")
+	flag.IntVar(&cfg.AgentBreakerLimit, "agent-breaker-limit", int(envInt64OrDefault("CLAUDIO_AGENT_BREAKER_LIMIT", openclaw.DefaultBreakerLimit)), "Responses within the breaker window that pause an agent (0 = off)")
+	flag.DurationVar(&cfg.AgentBreakerWindow, "agent-breaker-window", envDurationOrDefault("CLAUDIO_AGENT_BREAKER_WINDOW", openclaw.DefaultBreakerWindow), "Window for the agent circuit breaker")
+	flag.IntVar(&cfg.AgentConcurrency, "agent-concurrency", int(envInt64OrDefault("CLAUDIO_AGENT_CONCURRENCY", rpc.DefaultAgentConcurrency)), "Responses each agent works on at once (0 = unlimited)")
+	flag.IntVar(&cfg.AgentQueueLimit, "agent-queue-limit", int(envInt64OrDefault("CLAUDIO_AGENT_QUEUE_LIMIT", rpc.DefaultAgentQueueLimit)), "Calls that may wait for a busy agent before new ones are dropped")
+	flag.StringVar(&cfg.AgentQueueStrategy, "agent-queue-strategy", envOrDefault("CLAUDIO_AGENT_QUEUE_STRATEGY", rpc.QueueMerge), "How a busy agent's queue handles more messages from the same room: merge or drop")
+	flag.StringVar(&cfg.AgentMentionOrder, "agent-mention-order", envOrDefault("CLAUDIO_AGENT_MENTION_ORDER", rpc.MentionGrouped), "How agents mentioned in one message reply: grouped (all at once) or ordered (one after another)")
+	flag.DurationVar(&cfg.OpenclawRequestTimeout, "openclaw-request-timeout", envDurationOrDefault("CLAUDIO_OPENCLAW_REQUEST_TIMEOUT", openclaw.DefaultTimeouts.Request), "Timeout for a single OpenClaw request")
+	flag.DurationVar(&cfg.OpenclawChatTimeout, "openclaw-chat-timeout", envDurationOrDefault("CLAUDIO_OPENCLAW_CHAT_TIMEOUT", openclaw.DefaultTimeouts.Chat), "Timeout for an agent to finish responding")
+	flag.DurationVar(&cfg.AgentProbeInterval, "agent-probe-interval", envDurationOrDefault("CLAUDIO_AGENT_PROBE_INTERVAL", time.Minute), "How often to ping agent OpenClaw connections (0 = off)")
+	flag.DurationVar(&cfg.OpenclawIdleTimeout, "openclaw-idle-timeout", envDurationOrDefault("CLAUDIO_OPENCLAW_IDLE_TIMEOUT", openclaw.DefaultIdleTimeout), "Close OpenClaw connections unused for this long (0 = never)")
+	flag.IntVar(&cfg.OpenclawMaxConns, "openclaw-max-conns", int(envInt64OrDefault("CLAUDIO_OPENCLAW_MAX_CONNS", openclaw.DefaultMaxConns)), "Maximum pooled OpenClaw connections (0 = unlimited)")
+	flag.DurationVar(&cfg.ResumeTokenTTL, "resume-token-ttl", envDurationOrDefault("CLAUDIO_RESUME_TOKEN_TTL", ws.DefaultResumeTokenTTL), "How long a client may reconnect with a resume token instead of signing (0 = off)")
+	flag.DurationVar(&cfg.MaxSessionAge, "max-session-age", envDurationOrDefault("CLAUDIO_MAX_SESSION_AGE", 0), "How long a connection stays signed in before it must authenticate again (0 = forever)")
+	flag.DurationVar(&cfg.SessionExpiryWarning, "session-expiry-warning", envDurationOrDefault("CLAUDIO_SESSION_EXPIRY_WARNING", ws.DefaultSessionExpiryWarning), "How long before the session expires clients get auth.expiring")
+	flag.IntVar(&cfg.SendQueueLimit, "send-queue-limit", int(envInt64OrDefault("CLAUDIO_SEND_QUEUE_LIMIT", ws.DefaultSendQueueLimit)), "Messages queued for a connection that isn't keeping up before the slow consumer policy applies")
+	flag.StringVar(&cfg.SlowConsumer, "slow-consumer", envOrDefault("CLAUDIO_SLOW_CONSUMER", ws.SlowConsumerResync), "What to do with connections that can't keep up: resync (send sync.required) or disconnect")
+	flag.DurationVar(&cfg.BatchInterval, "batch-interval", envDurationOrDefault("CLAUDIO_BATCH_INTERVAL", ws.DefaultBatchInterval), "How long messages in busy rooms wait to be sent together to clients that ask for batches (0 = off)")
+	flag.DurationVar(&cfg.TickInterval, "tick-interval", envDurationOrDefault("CLAUDIO_TICK_INTERVAL", ws.DefaultTickInterval), "How often idle WebSocket connections get a tick event")
+	flag.IntVar(&cfg.LateJoinEvents, "late-join-events", int(envInt64OrDefault("CLAUDIO_LATE_JOIN_EVENTS", ws.DefaultLateJoinEvents)), "Recent room events (from the last minute) a client gets on joining a room (0 = off)")
+	flag.IntVar(&cfg.ReplayBuffer, "replay-buffer", int(envInt64OrDefault("CLAUDIO_REPLAY_BUFFER", ws.DefaultReplayBuffer)), "Recent events kept per room for clients reconnecting with lastSeq (0 = off)")
+	rateLimitFlags(&cfg.ConnRPCLimit, "rpc-rate-conn", "CLAUDIO_RPC_RATE_CONN", ws.DefaultConnRPCLimit, "RPC requests per connection")
+	rateLimitFlags(&cfg.UserRPCLimit, "rpc-rate-user", "CLAUDIO_RPC_RATE_USER", ws.DefaultUserRPCLimit, "RPC requests per user")
+	rateLimitFlags(&cfg.UserMessageLimit, "message-rate-user", "CLAUDIO_MESSAGE_RATE_USER", ws.DefaultUserMessageLimit, "messages sent per user")
+	rateLimitFlags(&cfg.IPConnectLimit, "connect-rate-ip", "CLAUDIO_CONNECT_RATE_IP", ws.DefaultIPConnectLimit, "WebSocket connects per IP")
+	flag.StringVar(&cfg.Registration, "registration", envOrDefault("CLAUDIO_REGISTRATION", ws.RegistrationOpen), "Who may register new devices: open, secret or invite")
+	flag.StringVar(&cfg.TemplatesPath, "templates", envOrDefault("CLAUDIO_ROOM_TEMPLATES", ""), "JSON file of server-wide room templates")
+	flag.BoolVar(&cfg.Compression, "ws-compression", os.Getenv("CLAUDIO_WS_COMPRESSION") != "false", "Negotiate permessage-deflate on WebSocket connections")
+	flag.IntVar(&cfg.CompressionLevel, "ws-compression-level", int(envInt64OrDefault("CLAUDIO_WS_COMPRESSION_LEVEL", ws.DefaultCompressionLevel)), "Deflate level for compressed WebSocket connections (1-9)")
+	flag.BoolVar(&cfg.LongMessageAsAttachment, "long-message-attachments", os.Getenv("CLAUDIO_LONG_MESSAGE_ATTACHMENTS") == "true", "Convert over-long messages into text attachments")
 	flag.Parse()
 
+	if cfg.MediaDir == "" {
+		cfg.MediaDir = filepath.Join(filepath.Dir(cfg.DBPath), "media")
+	}
+
 	cfg.APNS = apns.Config{
 		KeyPath:   os.Getenv("CLAUDIO_APNS_KEY_PATH"),
 		KeyBase64: os.Getenv("CLAUDIO_APNS_KEY_BASE64"),
@@ -41,6 +234,25 @@ func LoadConfig() Config {
 		Sandbox:   os.Getenv("CLAUDIO_APNS_SANDBOX") == "true",
 	}
 	cfg.PushSecret = os.Getenv("CLAUDIO_PUSH_SECRET")
+	cfg.AdminSecret = os.Getenv("CLAUDIO_ADMIN_SECRET")
+	cfg.JoinSecret = os.Getenv("CLAUDIO_JOIN_SECRET")
+	cfg.ClusterURL = os.Getenv("CLAUDIO_CLUSTER_URL")
+	cfg.ClusterChannel = envOrDefault("CLAUDIO_CLUSTER_CHANNEL", "claudio")
+	cfg.JWTIssuer = os.Getenv("CLAUDIO_JWT_ISSUER")
+	cfg.JWTAudience = os.Getenv("CLAUDIO_JWT_AUDIENCE")
+	cfg.JWTSecret = os.Getenv("CLAUDIO_JWT_SECRET")
+	cfg.JWTPublicKeysPath = os.Getenv("CLAUDIO_JWT_PUBLIC_KEYS")
+	cfg.JWTUserClaim = envOrDefault("CLAUDIO_JWT_USER_CLAIM", "sub")
+	cfg.JWTNameClaim = envOrDefault("CLAUDIO_JWT_NAME_CLAIM", "name")
+	cfg.Admins = envList("CLAUDIO_ADMINS")
+	cfg.ReportWebhookURL = os.Getenv("CLAUDIO_REPORT_WEBHOOK_URL")
+	cfg.AllowedOrigins = envList("CLAUDIO_ALLOWED_ORIGINS")
+	cfg.AllowAnyOrigin = os.Getenv("CLAUDIO_ALLOW_ANY_ORIGIN") == "true"
+	cfg.TrustedProxies = trustedProxies(os.Getenv("CLAUDIO_TRUST_PROXY"))
+	cfg.TranslateURL = os.Getenv("CLAUDIO_TRANSLATE_URL")
+	cfg.TranslateAPIKey = os.Getenv("CLAUDIO_TRANSLATE_API_KEY")
+	cfg.FilterWordlistPath = os.Getenv("CLAUDIO_FILTER_WORDLIST")
+	cfg.FilterWebhookURL = os.Getenv("CLAUDIO_FILTER_WEBHOOK_URL")
 
 	cfg.LobbyAgent = LobbyAgentConfig{
 		OpenclawURL:     os.Getenv("LOBBY_AGENT_OPENCLAW_URL"),
@@ -54,6 +266,59 @@ func LoadConfig() Config {
 	return cfg
 }
 
+// loadRoomTemplates reads server-wide room templates from a JSON array.
+func loadRoomTemplates(path string) ([]db.RoomTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var templates []db.RoomTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, t := range templates {
+		if t.ID == "" {
+			return nil, fmt.Errorf("template %d: id is required", i)
+		}
+		if msg := rpc.ValidateTemplate(t); msg != "" {
+			return nil, fmt.Errorf("template %s: %s", t.ID, msg)
+		}
+	}
+	return templates, nil
+}
+
+// serverAdminIDs maps configured admins to the IDs the router checks. Public
+// keys become device IDs; anything else is taken as a device or user ID.
+func serverAdminIDs(admins []string) map[string]bool {
+	ids := make(map[string]bool, len(admins))
+	for _, admin := range admins {
+		if id, err := ws.DeviceIDForKey(admin); err == nil {
+			ids[id] = true
+		} else {
+			ids[admin] = true
+		}
+	}
+	return ids
+}
+
+// rateLimitFlags registers <name> (per second) and <name>-burst flags, with
+// matching <env> and <env>_BURST variables.
+func rateLimitFlags(l *ws.RateLimit, name, env string, def ws.RateLimit, what string) {
+	flag.Float64Var(&l.PerSecond, name, envFloatOrDefault(env, def.PerSecond), "Sustained "+what+" per second (0 = unlimited)")
+	flag.IntVar(&l.Burst, name+"-burst", int(envInt64OrDefault(env+"_BURST", int64(def.Burst))), "Burst of "+what+" allowed at once")
+}
+
+// envList splits a comma-separated variable, dropping empty entries.
+func envList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func envOrDefault(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -61,6 +326,43 @@ func envOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// trustedProxies reads CLAUDIO_TRUST_PROXY: "true" for one proxy, or how
+// many there are.
+func trustedProxies(v string) int {
+	if v == "true" {
+		return 1
+	}
+	n, _ := strconv.Atoi(v)
+	return max(n, 0)
+}
+
+func envInt64OrDefault(key string, fallback int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envFloatOrDefault(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func envDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
 func defaultAddr() string {
 	if v := os.Getenv("CLAUDIO_ADDR"); v != "" {
 		return v
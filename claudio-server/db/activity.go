@@ -0,0 +1,103 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+const activityTopSenders = 10
+
+// RoomActivity summarizes a room's messages over a date range.
+type RoomActivity struct {
+	Days           []DayActivity    `json:"days"`
+	TopSenders     []SenderActivity `json:"topSenders"`
+	AgentResponses []SenderActivity `json:"agentResponses"`
+}
+
+// DayActivity counts one UTC day's messages. System messages are excluded.
+type DayActivity struct {
+	Date          string `json:"date"` // YYYY-MM-DD
+	Messages      int    `json:"messages"`
+	AgentMessages int    `json:"agentMessages"`
+}
+
+// SenderActivity counts messages from one sender.
+type SenderActivity struct {
+	ID          string `json:"id"` // user ID, or agent ID for agents
+	DisplayName string `json:"displayName"`
+	IsAgent     bool   `json:"isAgent"`
+	Messages    int    `json:"messages"`
+}
+
+// GetRoomActivity aggregates messages sent in [from, to).
+func (d *DB) GetRoomActivity(roomID string, from, to time.Time) (*RoomActivity, error) {
+	activity := &RoomActivity{
+		Days:           []DayActivity{},
+		TopSenders:     []SenderActivity{},
+		AgentResponses: []SenderActivity{},
+	}
+	from, to = from.UTC(), to.UTC()
+
+	rows, err := d.Query(`
+		SELECT `+d.dialect.utcDay("created_at")+` AS day, COUNT(*), SUM(CASE WHEN kind = 'agent' THEN 1 ELSE 0 END)
+		FROM messages
+		WHERE room_id = ? AND created_at >= ? AND created_at < ? AND kind != 'system'
+		GROUP BY day ORDER BY day
+	`, roomID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("activity by day: %w", err)
+	}
+	for rows.Next() {
+		var day DayActivity
+		if err := rows.Scan(&day.Date, &day.Messages, &day.AgentMessages); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan day: %w", err)
+		}
+		activity.Days = append(activity.Days, day)
+	}
+	rows.Close()
+
+	senders, err := d.senderActivity(`
+		SELECT COALESCE(sender_user_id, sender_agent_id), MAX(sender_display_name), kind = 'agent', COUNT(*) AS n
+		FROM messages
+		WHERE room_id = ? AND created_at >= ? AND created_at < ? AND kind != 'system'
+		GROUP BY COALESCE(sender_user_id, sender_agent_id), kind = 'agent'
+		ORDER BY n DESC LIMIT ?
+	`, roomID, from, to, activityTopSenders)
+	if err != nil {
+		return nil, err
+	}
+	activity.TopSenders = senders
+
+	agents, err := d.senderActivity(`
+		SELECT sender_agent_id, MAX(sender_display_name), 1, COUNT(*) AS n
+		FROM messages
+		WHERE room_id = ? AND created_at >= ? AND created_at < ? AND kind = 'agent'
+		GROUP BY sender_agent_id
+		ORDER BY n DESC
+	`, roomID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	activity.AgentResponses = agents
+
+	return activity, nil
+}
+
+func (d *DB) senderActivity(query string, args ...any) ([]SenderActivity, error) {
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("activity by sender: %w", err)
+	}
+	defer rows.Close()
+
+	senders := []SenderActivity{}
+	for rows.Next() {
+		var s SenderActivity
+		if err := rows.Scan(&s.ID, &s.DisplayName, &s.IsAgent, &s.Messages); err != nil {
+			return nil, fmt.Errorf("scan sender: %w", err)
+		}
+		senders = append(senders, s)
+	}
+	return senders, rows.Err()
+}
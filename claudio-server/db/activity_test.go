@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetRoomActivity(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid, agent := "alice", "bot"
+
+	database.InsertMessage("m1", room.ID, &uid, nil, "Alice", "", "hi", "[]", nil)
+	database.InsertMessage("m2", room.ID, &uid, nil, "Alice", "", "@bot?", "[]", nil)
+	database.InsertMessage("m3", room.ID, nil, &agent, "Bot", "", "hello", "[]", nil)
+	database.InsertSystemMessage("m4", room.ID, "Bob joined")
+	database.Exec(`UPDATE messages SET created_at = ? WHERE id = 'm1'`, time.Now().UTC().AddDate(0, 0, -1))
+
+	now := time.Now().UTC()
+	activity, err := database.GetRoomActivity(room.ID, now.AddDate(0, 0, -7), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(activity.Days) != 2 {
+		t.Fatalf("days = %+v, want 2", activity.Days)
+	}
+	today := activity.Days[1]
+	if today.Date != now.Format("2006-01-02") || today.Messages != 2 || today.AgentMessages != 1 {
+		t.Errorf("today = %+v", today)
+	}
+	if top := activity.TopSenders; len(top) != 2 || top[0].ID != "alice" || top[0].Messages != 2 {
+		t.Errorf("top senders = %+v", top)
+	}
+	if agents := activity.AgentResponses; len(agents) != 1 || agents[0].ID != "bot" || !agents[0].IsAgent {
+		t.Errorf("agent responses = %+v", agents)
+	}
+}
@@ -0,0 +1,224 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type ServerBan struct {
+	UserID    string    `json:"userId"`
+	Reason    string    `json:"reason"`
+	BannedBy  string    `json:"bannedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type IPBan struct {
+	CIDR      string    `json:"cidr"`
+	Reason    string    `json:"reason"`
+	BannedBy  string    `json:"bannedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ServerStats struct {
+	Users    int `json:"users"`
+	Devices  int `json:"devices"`
+	Rooms    int `json:"rooms"`
+	Messages int `json:"messages"`
+	Agents   int `json:"agents"` // agent participants across all rooms
+	Bans     int `json:"bans"`
+}
+
+func (d *DB) IsServerAdmin(userID string) (bool, error) {
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM server_admins WHERE user_id = ?`, userID).Scan(&n); err != nil {
+		return false, fmt.Errorf("check server admin: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (d *DB) GrantServerAdmin(userID, grantedBy string) error {
+	_, err := d.Exec(`
+		INSERT INTO server_admins (user_id, granted_by, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, grantedBy, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("grant server admin: %w", err)
+	}
+	return nil
+}
+
+// ListServerAdmins returns the accounts granted admin with GrantServerAdmin.
+func (d *DB) ListServerAdmins() ([]string, error) {
+	rows, err := d.Query(`SELECT user_id FROM server_admins`)
+	if err != nil {
+		return nil, fmt.Errorf("list server admins: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan server admin: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RevokeServerAdmin removes a granted admin. Returns sql.ErrNoRows if
+// userID had no grant.
+func (d *DB) RevokeServerAdmin(userID string) error {
+	result, err := d.Exec(`DELETE FROM server_admins WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("revoke server admin: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// BanUser bans userID from the server and revokes their resume tokens.
+// Banning again updates the reason.
+func (d *DB) BanUser(userID, reason, bannedBy string) error {
+	now := time.Now().UTC()
+	_, err := d.Exec(`
+		INSERT INTO server_bans (user_id, reason, banned_by, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET reason = excluded.reason, banned_by = excluded.banned_by
+	`, userID, reason, bannedBy, now)
+	if err != nil {
+		return fmt.Errorf("ban user: %w", err)
+	}
+	if _, err := d.Exec(`UPDATE resume_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, userID); err != nil {
+		return fmt.Errorf("revoke banned user's resume tokens: %w", err)
+	}
+	return nil
+}
+
+// UnbanUser lifts a server ban. Returns sql.ErrNoRows if userID wasn't banned.
+func (d *DB) UnbanUser(userID string) error {
+	result, err := d.Exec(`DELETE FROM server_bans WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("unban user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (d *DB) IsUserBanned(userID string) (bool, error) {
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM server_bans WHERE user_id = ?`, userID).Scan(&n); err != nil {
+		return false, fmt.Errorf("check ban: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (d *DB) ListServerBans() ([]ServerBan, error) {
+	rows, err := d.Query(`SELECT user_id, reason, banned_by, created_at FROM server_bans ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("list bans: %w", err)
+	}
+	defer rows.Close()
+
+	bans := []ServerBan{}
+	for rows.Next() {
+		var b ServerBan
+		if err := rows.Scan(&b.UserID, &b.Reason, &b.BannedBy, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan ban: %w", err)
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// BanIP bans an address range, given as a normalized CIDR. Banning again
+// updates the reason.
+func (d *DB) BanIP(cidr, reason, bannedBy string) error {
+	_, err := d.Exec(`
+		INSERT INTO ip_bans (cidr, reason, banned_by, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (cidr) DO UPDATE SET reason = excluded.reason, banned_by = excluded.banned_by
+	`, cidr, reason, bannedBy, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("ban ip: %w", err)
+	}
+	return nil
+}
+
+// UnbanIP lifts an IP ban. Returns sql.ErrNoRows if cidr wasn't banned.
+func (d *DB) UnbanIP(cidr string) error {
+	result, err := d.Exec(`DELETE FROM ip_bans WHERE cidr = ?`, cidr)
+	if err != nil {
+		return fmt.Errorf("unban ip: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (d *DB) ListIPBans() ([]IPBan, error) {
+	rows, err := d.Query(`SELECT cidr, reason, banned_by, created_at FROM ip_bans ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("list ip bans: %w", err)
+	}
+	defer rows.Close()
+
+	bans := []IPBan{}
+	for rows.Next() {
+		var b IPBan
+		if err := rows.Scan(&b.CIDR, &b.Reason, &b.BannedBy, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan ip ban: %w", err)
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// ListAllRooms returns every room on the server, most recently active
+// first, including private rooms and DMs.
+func (d *DB) ListAllRooms(limit, offset int) ([]Room, error) {
+	rows, err := d.Query(`
+		SELECT `+roomColumns+`,
+		       (SELECT COUNT(*) FROM participants WHERE room_id = r.id) as participant_count
+		FROM rooms r
+		ORDER BY r.updated_at DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list all rooms: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := []Room{}
+	for rows.Next() {
+		var count int
+		r, err := scanRoom(rows, &count)
+		if err != nil {
+			return nil, fmt.Errorf("scan room: %w", err)
+		}
+		r.ParticipantCount = count
+		rooms = append(rooms, r)
+	}
+	return rooms, rows.Err()
+}
+
+func (d *DB) ServerStats() (*ServerStats, error) {
+	s := &ServerStats{}
+	err := d.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE id != 'system'),
+			(SELECT COUNT(*) FROM devices),
+			(SELECT COUNT(*) FROM rooms),
+			(SELECT COUNT(*) FROM messages),
+			(SELECT COUNT(*) FROM participants WHERE agent_id IS NOT NULL),
+			(SELECT COUNT(*) FROM server_bans)
+	`).Scan(&s.Users, &s.Devices, &s.Rooms, &s.Messages, &s.Agents, &s.Bans)
+	if err != nil {
+		return nil, fmt.Errorf("server stats: %w", err)
+	}
+	return s, nil
+}
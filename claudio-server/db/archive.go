@@ -0,0 +1,67 @@
+package db
+
+import "fmt"
+
+// RoomMessagesAfter returns up to limit of the room's messages with a seq
+// above afterSeq, oldest first, with their attachments. It walks a room's
+// whole history for rooms.exportArchive.
+func (d *DB) RoomMessagesAfter(roomID string, afterSeq int64, limit int) ([]Message, error) {
+	rows, err := d.Query(`
+		SELECT `+messageColumns+` FROM messages
+		WHERE room_id = ? AND seq > ?
+		ORDER BY seq LIMIT ?
+	`, roomID, afterSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	d.attachAttachments(messages)
+	return messages, nil
+}
+
+// ImportMessages appends msgs to the room in order, keeping their IDs,
+// senders, kinds, blocks and timestamps, for rooms.importArchive. Each gets
+// the room's next seq, as if it had just been sent.
+func (d *DB) ImportMessages(roomID string, msgs []Message) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range msgs {
+		var seq int64
+		err := tx.QueryRow(`
+			UPDATE rooms SET last_message_seq = last_message_seq + 1, updated_at = ?
+			WHERE id = ? RETURNING last_message_seq
+		`, m.CreatedAt, roomID).Scan(&seq)
+		if err != nil {
+			return fmt.Errorf("import message: %w", err)
+		}
+		var blocks *string
+		if len(m.Blocks) > 0 {
+			s := string(m.Blocks)
+			blocks = &s
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO messages (id, room_id, seq, sender_user_id, sender_agent_id, sender_display_name, sender_emoji, content, mentions, reply_to, kind, blocks, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, m.ID, roomID, seq, m.SenderUserID, m.SenderAgentID, m.SenderDisplayName, m.SenderEmoji,
+			m.Content, m.Mentions, m.ReplyTo, m.Kind, blocks, m.CreatedAt); err != nil {
+			return fmt.Errorf("import message: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportMessagesKeepsOrderAndTimes(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid := "alice"
+	if _, err := database.InsertMessage("live", room.ID, &uid, nil, "Alice", "", "already here", "[]", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	then := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	reply := "old1"
+	if err := database.ImportMessages(room.ID, []Message{
+		{ID: "old1", SenderDisplayName: "Bob", Content: "first", Mentions: "[]", Kind: MessageKindUser, CreatedAt: then},
+		{ID: "old2", SenderDisplayName: "Bot", Content: "second", Mentions: "[]", Kind: MessageKindAgent, ReplyTo: &reply, CreatedAt: then.Add(time.Minute)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := database.RoomMessagesAfter(room.ID, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := database.RoomMessagesAfter(room.ID, first[len(first)-1].Seq, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := append(first, rest...)
+	if len(all) != 3 {
+		t.Fatalf("got %d messages, want 3", len(all))
+	}
+	for i, want := range []string{"live", "old1", "old2"} {
+		if all[i].ID != want || all[i].Seq != int64(i+1) {
+			t.Errorf("message %d = %s seq %d, want %s seq %d", i, all[i].ID, all[i].Seq, want, i+1)
+		}
+	}
+	if !all[1].CreatedAt.Equal(then) || all[2].Kind != MessageKindAgent || all[2].ReplyTo == nil || *all[2].ReplyTo != "old1" {
+		t.Errorf("imported messages = %+v, %+v", all[1], all[2])
+	}
+}
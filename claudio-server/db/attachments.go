@@ -0,0 +1,202 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attachment is an uploaded file. Its content lives in the media store,
+// addressed by BlobHash, so identical uploads share storage.
+type Attachment struct {
+	ID          string    `json:"id"`
+	RoomID      string    `json:"roomId"`
+	MessageID   *string   `json:"messageId,omitempty"`
+	BlobHash    string    `json:"-"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	URL         string    `json:"url"` // path on this server
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+const attachmentColumns = `a.id, a.room_id, a.message_id, a.blob_hash, a.filename, a.content_type, b.size, a.created_at`
+
+func scanAttachment(row rowScanner) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.RoomID, &a.MessageID, &a.BlobHash, &a.Filename, &a.ContentType, &a.Size, &a.CreatedAt)
+	a.URL = "/media/" + a.ID
+	return a, err
+}
+
+// CreateAttachment records an upload of an already-stored blob. The blob row is
+// created on first use; its ref_count is maintained by triggers.
+func (d *DB) CreateAttachment(id, roomID, uploadedBy, blobHash string, size int64, filename, contentType string) (*Attachment, error) {
+	now := time.Now().UTC()
+
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO blobs (hash, size) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING`, blobHash, size); err != nil {
+		return nil, fmt.Errorf("insert blob: %w", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO attachments (id, room_id, blob_hash, filename, content_type, uploaded_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, roomID, blobHash, filename, contentType, uploadedBy, now)
+	if err != nil {
+		return nil, fmt.Errorf("insert attachment: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &Attachment{
+		ID:          id,
+		RoomID:      roomID,
+		BlobHash:    blobHash,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		URL:         "/media/" + id,
+		CreatedAt:   now,
+	}, nil
+}
+
+// GetAttachment returns an attachment by ID, or nil if it doesn't exist.
+func (d *DB) GetAttachment(id string) (*Attachment, error) {
+	a, err := scanAttachment(d.QueryRow(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a JOIN blobs b ON b.hash = a.blob_hash
+		WHERE a.id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get attachment: %w", err)
+	}
+	return &a, nil
+}
+
+// CountPendingAttachments counts how many of ids are unsent uploads by userID in roomID.
+func (d *DB) CountPendingAttachments(roomID, userID string, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := []any{roomID, userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	var count int
+	err := d.QueryRow(`
+		SELECT COUNT(*) FROM attachments
+		WHERE room_id = ? AND uploaded_by = ? AND message_id IS NULL
+		  AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, args...).Scan(&count)
+	return count, err
+}
+
+// AttachToMessage links pending uploads to a sent message and returns them.
+func (d *DB) AttachToMessage(messageID, roomID, userID string, ids []string) ([]Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := []any{messageID, roomID, userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := d.Exec(`
+		UPDATE attachments SET message_id = ?
+		WHERE room_id = ? AND uploaded_by = ? AND message_id IS NULL
+		  AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("attach to message: %w", err)
+	}
+
+	msgs := []Message{{ID: messageID}}
+	d.attachAttachments(msgs)
+	return msgs[0].Attachments, nil
+}
+
+// attachAttachments loads attachments for messages with a single query.
+func (d *DB) attachAttachments(messages []Message) {
+	if len(messages) == 0 {
+		return
+	}
+	args := make([]any, len(messages))
+	index := make(map[string]int, len(messages))
+	for i, m := range messages {
+		args[i] = m.ID
+		index[m.ID] = i
+	}
+
+	rows, err := d.Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments a JOIN blobs b ON b.hash = a.blob_hash
+		WHERE a.message_id IN (?`+strings.Repeat(", ?", len(messages)-1)+`)
+		ORDER BY a.created_at
+	`, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil || a.MessageID == nil {
+			continue
+		}
+		if i, ok := index[*a.MessageID]; ok {
+			messages[i].Attachments = append(messages[i].Attachments, a)
+		}
+	}
+}
+
+// DeleteOrphanAttachments removes uploads that were never sent in a message
+// and aren't in use as a room avatar.
+func (d *DB) DeleteOrphanAttachments(olderThan time.Duration) (int64, error) {
+	result, err := d.Exec(`
+		DELETE FROM attachments
+		WHERE message_id IS NULL AND created_at < ?
+		  AND id NOT IN (SELECT avatar_attachment_id FROM rooms WHERE avatar_attachment_id IS NOT NULL)
+	`, time.Now().UTC().Add(-olderThan))
+	if err != nil {
+		return 0, fmt.Errorf("delete orphan attachments: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// UnreferencedBlobs returns the hashes of blobs no attachment points to.
+func (d *DB) UnreferencedBlobs() ([]string, error) {
+	rows, err := d.Query(`SELECT hash FROM blobs WHERE ref_count <= 0`)
+	if err != nil {
+		return nil, fmt.Errorf("list unreferenced blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("scan blob: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// DeleteBlob removes a blob record if it is still unreferenced.
+func (d *DB) DeleteBlob(hash string) (bool, error) {
+	result, err := d.Exec(`DELETE FROM blobs WHERE hash = ? AND ref_count <= 0`, hash)
+	if err != nil {
+		return false, fmt.Errorf("delete blob: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
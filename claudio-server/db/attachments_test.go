@@ -0,0 +1,77 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlobRefCounting(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+
+	hash := "aa00000000000000000000000000000000000000000000000000000000000000"
+	if _, err := database.CreateAttachment("a1", room.ID, "alice", hash, 5, "x.txt", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.CreateAttachment("a2", room.ID, "alice", hash, 5, "y.txt", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	var refs int
+	database.QueryRow(`SELECT ref_count FROM blobs WHERE hash = ?`, hash).Scan(&refs)
+	if refs != 2 {
+		t.Fatalf("ref_count = %d, want 2", refs)
+	}
+
+	uid := "alice"
+	msg, err := database.InsertMessage("m1", room.ID, &uid, nil, "Alice", "", "", "[]", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached, err := database.AttachToMessage(msg.ID, room.ID, "alice", []string{"a1"})
+	if err != nil || len(attached) != 1 {
+		t.Fatalf("AttachToMessage = %v, %v", attached, err)
+	}
+
+	// a2 was never sent: dropping orphans leaves one reference
+	if _, err := database.DeleteOrphanAttachments(-time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if hashes, _ := database.UnreferencedBlobs(); len(hashes) != 0 {
+		t.Fatalf("unreferenced = %v, want none", hashes)
+	}
+
+	// Deleting the message cascades to its attachment and releases the blob
+	if _, err := database.Exec(`DELETE FROM messages WHERE id = ?`, msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	hashes, _ := database.UnreferencedBlobs()
+	if len(hashes) != 1 || hashes[0] != hash {
+		t.Fatalf("unreferenced = %v, want [%s]", hashes, hash)
+	}
+	if ok, err := database.DeleteBlob(hash); !ok || err != nil {
+		t.Fatalf("DeleteBlob = %v, %v", ok, err)
+	}
+}
+
+func TestOrphanGCKeepsRoomAvatar(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+
+	hash := "cc00000000000000000000000000000000000000000000000000000000000000"
+	if _, err := database.CreateAttachment("avatar", room.ID, "alice", hash, 5, "a.png", "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	id := "avatar"
+	if err := database.SetRoomAvatar(room.ID, &id); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := database.DeleteOrphanAttachments(-time.Minute); err != nil || n != 0 {
+		t.Fatalf("deleted %d orphans (err %v), want 0", n, err)
+	}
+	got, _ := database.GetRoom(room.ID)
+	if got.AvatarURL != "/media/avatar" {
+		t.Errorf("avatarUrl = %q", got.AvatarURL)
+	}
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Audit log actions.
+const (
+	AuditAgentAdded        = "agent.added"
+	AuditAgentMemorySet    = "agent.memorySet"
+	AuditAgentRemoved      = "agent.removed"
+	AuditAgentResumed      = "agent.resumed"
+	AuditAgentUpdated      = "agent.updated"
+	AuditInviteCreated     = "invite.created"
+	AuditJoinApproved      = "join.approved"
+	AuditJoinDenied        = "join.denied"
+	AuditMembersAdded      = "members.added"
+	AuditRoleChanged       = "role.changed"
+	AuditOwnershipTransfer = "ownership.transferred"
+	AuditRoomExported      = "room.exported"
+	AuditRoomImported      = "room.imported"
+	AuditRoomUpdated       = "room.updated"
+	AuditScheduleCreated   = "schedule.created"
+	AuditScheduleDeleted   = "schedule.deleted"
+	AuditSettingsUpdated   = "settings.updated"
+	AuditTopicChanged      = "topic.changed"
+)
+
+// AuditEntry is one administrative action taken in a room.
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	RoomID    string                 `json:"roomId"`
+	ActorID   string                 `json:"actorId"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+// AuditPage is one page of a room's audit log, newest first.
+type AuditPage struct {
+	Entries []AuditEntry
+	HasMore bool
+}
+
+// InsertAuditEntry records an administrative action.
+func (d *DB) InsertAuditEntry(roomID, actorID, action, target string, details map[string]interface{}) error {
+	encoded := []byte("{}")
+	if len(details) > 0 {
+		var err error
+		if encoded, err = json.Marshal(details); err != nil {
+			return err
+		}
+	}
+	_, err := d.Exec(`
+		INSERT INTO audit_log (room_id, actor_id, action, target, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, roomID, actorID, action, target, string(encoded), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditLog returns up to limit entries older than before (0 = newest).
+func (d *DB) GetAuditLog(roomID string, before int64, limit int) (*AuditPage, error) {
+	query := `SELECT id, room_id, actor_id, action, target, details, created_at FROM audit_log WHERE room_id = ?`
+	args := []any{roomID}
+	if before > 0 {
+		query += ` AND id < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get audit log: %w", err)
+	}
+	defer rows.Close()
+
+	page := &AuditPage{Entries: []AuditEntry{}}
+	for rows.Next() {
+		var e AuditEntry
+		var details string
+		if err := rows.Scan(&e.ID, &e.RoomID, &e.ActorID, &e.Action, &e.Target, &details, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		json.Unmarshal([]byte(details), &e.Details)
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import "testing"
+
+func TestAuditLogPagination(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+
+	for _, action := range []string{AuditInviteCreated, AuditAgentAdded, AuditRoleChanged} {
+		if err := database.InsertAuditEntry(room.ID, "alice", action, "", map[string]interface{}{"n": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := database.GetAuditLog(room.ID, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 2 || !page.HasMore || page.Entries[0].Action != AuditRoleChanged {
+		t.Fatalf("first page = %+v (hasMore=%v)", page.Entries, page.HasMore)
+	}
+	if page.Entries[0].Details["n"] != float64(1) {
+		t.Errorf("details = %v", page.Entries[0].Details)
+	}
+
+	page, err = database.GetAuditLog(room.ID, page.Entries[1].ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.HasMore || page.Entries[0].Action != AuditInviteCreated {
+		t.Fatalf("second page = %+v (hasMore=%v)", page.Entries, page.HasMore)
+	}
+}
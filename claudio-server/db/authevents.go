@@ -0,0 +1,88 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How a connect authenticated, see AuthEvent.
+const (
+	AuthMethodSigned = "signed" // device signature over the challenge
+	AuthMethodResume = "resume" // resume token from an earlier connect
+	AuthMethodJWT    = "jwt"    // identity provider token, see ws.JWTVerifier
+)
+
+// AuthEvent is one connect attempt.
+type AuthEvent struct {
+	ID            int64     `json:"id"`
+	UserID        string    `json:"userId,omitempty"`
+	DeviceID      string    `json:"deviceId,omitempty"`
+	Method        string    `json:"method"`
+	Success       bool      `json:"success"`
+	ErrorCode     string    `json:"errorCode,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	Platform      string    `json:"platform,omitempty"`
+	ClientVersion string    `json:"clientVersion,omitempty"`
+	IP            string    `json:"ip,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// AuthEventFilter narrows ListAuthEvents. Zero fields match everything.
+type AuthEventFilter struct {
+	// UserID matches events of the account and of any of its devices,
+	// including failed connects that never reached the account
+	UserID       string
+	FailuresOnly bool
+	Before       int64 // event ID cursor for paging back
+}
+
+func (d *DB) RecordAuthEvent(e AuthEvent) error {
+	_, err := d.Exec(`
+		INSERT INTO auth_events (user_id, device_id, method, success, error_code, reason, platform, client_version, ip, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.UserID, e.DeviceID, e.Method, e.Success, e.ErrorCode, e.Reason, e.Platform, e.ClientVersion, e.IP, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("record auth event: %w", err)
+	}
+	return nil
+}
+
+// ListAuthEvents returns up to limit matching events, newest first.
+func (d *DB) ListAuthEvents(f AuthEventFilter, limit int) ([]AuthEvent, error) {
+	var where []string
+	var args []any
+	if f.UserID != "" {
+		where = append(where, `(user_id = ? OR device_id IN (SELECT id FROM devices WHERE user_id = ?))`)
+		args = append(args, f.UserID, f.UserID)
+	}
+	if f.FailuresOnly {
+		where = append(where, `success = 0`)
+	}
+	if f.Before > 0 {
+		where = append(where, `id < ?`)
+		args = append(args, f.Before)
+	}
+	query := `SELECT id, user_id, device_id, method, success, error_code, reason, platform, client_version, ip, created_at FROM auth_events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list auth events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AuthEvent{}
+	for rows.Next() {
+		var e AuthEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.DeviceID, &e.Method, &e.Success, &e.ErrorCode, &e.Reason, &e.Platform, &e.ClientVersion, &e.IP, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan auth event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,33 @@
+package db
+
+import "testing"
+
+func TestListAuthEvents(t *testing.T) {
+	database := openTestDB(t)
+	alice, _ := database.AccountForDevice("alice-phone", DeviceInfo{Name: "Alice"})
+
+	database.RecordAuthEvent(AuthEvent{UserID: alice, DeviceID: "alice-phone", Method: AuthMethodSigned, Success: true, IP: "10.0.0.1"})
+	// A bad signature never reaches the account, only the claimed device
+	database.RecordAuthEvent(AuthEvent{DeviceID: "alice-phone", Method: AuthMethodSigned, ErrorCode: "AUTH_FAILED", Reason: "invalid signature"})
+	database.RecordAuthEvent(AuthEvent{Method: AuthMethodResume, ErrorCode: "RESUME_FAILED"})
+	database.RecordAuthEvent(AuthEvent{UserID: "bob", DeviceID: "bob", Method: AuthMethodSigned, Success: true})
+
+	events, err := database.ListAuthEvents(AuthEventFilter{UserID: alice}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].ErrorCode != "AUTH_FAILED" || !events[1].Success || events[1].IP != "10.0.0.1" {
+		t.Errorf("alice's events = %+v, want the failure then the success", events)
+	}
+
+	failures, _ := database.ListAuthEvents(AuthEventFilter{FailuresOnly: true}, 10)
+	if len(failures) != 2 {
+		t.Errorf("got %d failures, want 2", len(failures))
+	}
+
+	all, _ := database.ListAuthEvents(AuthEventFilter{}, 10)
+	page, _ := database.ListAuthEvents(AuthEventFilter{Before: all[1].ID}, 10)
+	if len(all) != 4 || len(page) != 2 || page[0].ID != all[2].ID {
+		t.Errorf("paging before %d returned %+v", all[1].ID, page)
+	}
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupStepPages is how many pages a backup copies before letting writers
+// in again.
+const backupStepPages = 256
+
+// ErrBackupUnsupported is returned by Backup on Postgres, which is backed
+// up with pg_dump instead.
+var ErrBackupUnsupported = errors.New("online backup is only supported for SQLite databases")
+
+// Backup writes a consistent snapshot of the live database to path with
+// SQLite's online backup API. Writers are only held up between steps, not
+// for the whole copy. The snapshot is written next to path and renamed into
+// place, so path never holds a partial copy; it must not exist yet.
+//
+// To restore, stop the server and run RestoreBackup (claudio-server
+// -db <database> -restore <backup>).
+func (d *DB) Backup(path string) (size int64, err error) {
+	if d.dialect.name != sqliteDialect.name {
+		return 0, ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return 0, fmt.Errorf("backup: %s already exists", path)
+	}
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	dst, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return 0, fmt.Errorf("backup: %w", err)
+	}
+	err = copyDatabase(dst, d.DB)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("backup: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("backup: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// RestoreBackup replaces the database at dbPath with the backup at
+// backupPath, after checking the backup's integrity. The server must not be
+// running on dbPath. The copy goes through the backup API rather than a
+// file copy so dbPath's write-ahead log is reset along with it.
+func RestoreBackup(backupPath, dbPath string) error {
+	if _, err := os.Stat(backupPath); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	src, err := sql.Open("sqlite3", backupPath+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	defer src.Close()
+
+	var check string
+	if err := src.QueryRow(`PRAGMA integrity_check`).Scan(&check); err != nil {
+		return fmt.Errorf("restore: check backup: %w", err)
+	}
+	if check != "ok" {
+		return fmt.Errorf("restore: backup failed integrity check: %s", check)
+	}
+
+	dst, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	defer dst.Close()
+	if err := copyDatabase(dst, src); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	return nil
+}
+
+// copyDatabase copies src's main database over dst's, a step at a time.
+func copyDatabase(dst, src *sql.DB) error {
+	ctx := context.Background()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dc any) error {
+		return srcConn.Raw(func(sc any) error {
+			backup, err := dc.(*sqlite3.SQLiteConn).Backup("main", sc.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// Step reports busy and locked sources as not done yet
+				done, err := backup.Step(backupStepPages)
+				if err != nil {
+					backup.Close()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	})
+}
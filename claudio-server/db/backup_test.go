@@ -0,0 +1,69 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func countMessages(t *testing.T, database *DB, roomID string) int {
+	t.Helper()
+	var n int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM messages WHERE room_id = ?`, roomID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "live.db")
+	backupPath := filepath.Join(dir, "backup.db")
+
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := createTestRoom(t, database)
+	if _, err := database.InsertMessage(nanoid(), room.ID, nil, nil, "Alice", "", "before", "[]", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := database.Backup(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 {
+		t.Error("backup size = 0")
+	}
+	if _, err := database.Backup(backupPath); err == nil {
+		t.Error("backup over an existing file succeeded")
+	}
+
+	// Written after the snapshot, so gone once it's restored
+	if _, err := database.InsertMessage(nanoid(), room.ID, nil, nil, "Alice", "", "after", "[]", nil); err != nil {
+		t.Fatal(err)
+	}
+	database.Close()
+
+	if err := RestoreBackup(backupPath, dbPath); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if n := countMessages(t, restored, room.ID); n != 1 {
+		t.Errorf("restored database has %d messages, want 1", n)
+	}
+	if _, err := restored.GetRoom(room.ID); err != nil {
+		t.Errorf("restored room: %v", err)
+	}
+}
+
+func TestRestoreBackupMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := RestoreBackup(filepath.Join(dir, "nope.db"), filepath.Join(dir, "live.db")); err == nil {
+		t.Error("restore from a missing backup succeeded")
+	}
+}
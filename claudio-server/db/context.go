@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// WithContext returns a DB whose queries run under ctx as well as
+// QueryTimeout: they're abandoned once ctx is done, such as when the
+// connection they answer goes away.
+func (d *DB) WithContext(ctx context.Context) Store {
+	scoped := *d
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// queryContext is the context for one query or transaction.
+func (d *DB) queryContext() (context.Context, context.CancelFunc) {
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if d.QueryTimeout > 0 {
+		return context.WithTimeout(ctx, d.QueryTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Exec runs query under the DB's context and timeout. Exec, Query,
+// QueryRow and Begin stand in for the sql.DB methods of the same names, so
+// every method in this package gets them.
+func (d *DB) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, cancel := d.queryContext()
+	defer cancel()
+	return d.DB.ExecContext(ctx, query, args...)
+}
+
+// Rows is sql.Rows whose query context is released on Close.
+type Rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+func (d *DB) Query(query string, args ...any) (*Rows, error) {
+	ctx, cancel := d.queryContext()
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+// Row is sql.Row whose query context is released on Scan.
+type Row struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+func (r *Row) Scan(dest ...any) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+func (d *DB) QueryRow(query string, args ...any) *Row {
+	ctx, cancel := d.queryContext()
+	return &Row{Row: d.DB.QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+// Tx is a transaction whose statements share one context, and so one
+// QueryTimeout, released on Commit or Rollback.
+type Tx struct {
+	*sql.Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (d *DB) Begin() (*Tx, error) {
+	ctx, cancel := d.queryContext()
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Tx{Tx: tx, ctx: ctx, cancel: cancel}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) Commit() error {
+	defer tx.cancel()
+	return tx.Tx.Commit()
+}
+
+func (tx *Tx) Rollback() error {
+	defer tx.cancel()
+	return tx.Tx.Rollback()
+}
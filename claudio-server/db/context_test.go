@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithContextCancelled(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := database.WithContext(ctx).GetRoom(room.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetRoom with cancelled context: err = %v, want context.Canceled", err)
+	}
+	if _, err := database.GetRoom(room.ID); err != nil {
+		t.Errorf("GetRoom on the unscoped DB: %v", err)
+	}
+}
+
+func TestQueryTimeoutStopsStuckQuery(t *testing.T) {
+	database := openTestDB(t)
+	database.QueryTimeout = 50 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		var n int
+		done <- database.QueryRow(`
+			WITH RECURSIVE forever(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM forever)
+			SELECT COUNT(*) FROM forever
+		`).Scan(&n)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("endless query succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query still running long after QueryTimeout")
+	}
+}
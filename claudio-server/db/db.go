@@ -1,10 +1,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	_ "embed"
 	"fmt"
 	"log/slog"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -12,12 +14,28 @@ import (
 //go:embed schema.sql
 var schema string
 
+// DB is the server's storage. It runs on SQLite (Open) or Postgres
+// (OpenPostgres); queries use ? placeholders either way, see pgConn.
 type DB struct {
 	*sql.DB
+	dialect dialect
+
+	// ctx is what queries run under, see WithContext; nil means none
+	ctx context.Context
+
+	// QueryTimeout bounds each query and transaction (0 = no limit), so a
+	// stuck query fails instead of hanging its caller
+	QueryTimeout time.Duration
 }
 
+// DefaultQueryTimeout is a new DB's QueryTimeout.
+const DefaultQueryTimeout = 10 * time.Second
+
+// Open opens, creating and migrating as needed, the SQLite database at path.
 func Open(path string) (*DB, error) {
-	sqlDB, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_foreign_keys=ON&_busy_timeout=5000")
+	// New databases vacuum incrementally, see RunMaintenance; existing ones
+	// keep their mode until a full VACUUM
+	sqlDB, err := sql.Open("sqlite3", path+"?_auto_vacuum=incremental&_journal_mode=WAL&_foreign_keys=ON&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
@@ -30,7 +48,71 @@ func Open(path string) (*DB, error) {
 	// Migrations: add columns that may not exist on older DBs
 	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN public BOOLEAN NOT NULL DEFAULT 0")
 	sqlDB.Exec("ALTER TABLE participants ADD COLUMN openclaw_agent_id TEXT")
+	sqlDB.Exec("ALTER TABLE participants ADD COLUMN agent_persona TEXT")
+	sqlDB.Exec("ALTER TABLE participants ADD COLUMN agent_backend TEXT")
+	sqlDB.Exec("ALTER TABLE participants ADD COLUMN agent_model TEXT")
+	sqlDB.Exec("ALTER TABLE participants ADD COLUMN agent_trigger TEXT")
+	sqlDB.Exec("ALTER TABLE participants ADD COLUMN agent_trigger_keywords TEXT")
+	sqlDB.Exec("ALTER TABLE messages ADD COLUMN blocks TEXT")
+	if _, err := sqlDB.Exec("ALTER TABLE messages ADD COLUMN kind TEXT NOT NULL DEFAULT 'user'"); err == nil {
+		// Backfill: agent messages predate the kind column
+		sqlDB.Exec("UPDATE messages SET kind = 'agent' WHERE sender_agent_id IS NOT NULL")
+	}
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN last_message_seq INTEGER NOT NULL DEFAULT 0")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN slow_mode_seconds INTEGER NOT NULL DEFAULT 0")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN require_approval BOOLEAN NOT NULL DEFAULT 0")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN agent_chains BOOLEAN NOT NULL DEFAULT 0")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT 0")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN content_filter TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN message_retention_days INTEGER NOT NULL DEFAULT 0")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN description TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN avatar_attachment_id TEXT")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN topic TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN type TEXT NOT NULL DEFAULT 'group'")
+	sqlDB.Exec("ALTER TABLE rooms ADD COLUMN dm_key TEXT")
+	if _, err := sqlDB.Exec("ALTER TABLE rooms ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private'"); err == nil {
+		// Backfill: rooms that were public before visibility existed stay listed
+		sqlDB.Exec("UPDATE rooms SET visibility = 'public' WHERE public = 1")
+	}
+	if _, err := sqlDB.Exec("ALTER TABLE messages ADD COLUMN seq INTEGER NOT NULL DEFAULT 0"); err == nil {
+		// Backfill: number existing messages per room in (created_at, rowid) order
+		sqlDB.Exec(`UPDATE messages SET seq = (
+			SELECT COUNT(*) FROM messages m2
+			WHERE m2.room_id = messages.room_id
+			  AND (m2.created_at < messages.created_at OR (m2.created_at = messages.created_at AND m2.rowid <= messages.rowid))
+		)`)
+		sqlDB.Exec(`UPDATE rooms SET last_message_seq = (SELECT COALESCE(MAX(seq), 0) FROM messages WHERE room_id = rooms.id)`)
+	}
+	sqlDB.Exec("ALTER TABLE room_preferences ADD COLUMN pinned_at DATETIME")
+	sqlDB.Exec("ALTER TABLE invite_codes ADD COLUMN role TEXT NOT NULL DEFAULT 'member'")
+	sqlDB.Exec("ALTER TABLE participants ADD COLUMN last_seen_at DATETIME")
+	sqlDB.Exec("ALTER TABLE resume_tokens ADD COLUMN device_id TEXT")
+	sqlDB.Exec("ALTER TABLE resume_tokens ADD COLUMN scopes TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE users ADD COLUMN pronouns TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE users ADD COLUMN status TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE users ADD COLUMN status_text TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE users ADD COLUMN status_emoji TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE users ADD COLUMN deleted_at DATETIME")
+	sqlDB.Exec("ALTER TABLE devices ADD COLUMN platform TEXT NOT NULL DEFAULT ''")
+	sqlDB.Exec("ALTER TABLE devices ADD COLUMN client_version TEXT NOT NULL DEFAULT ''")
+	var devices int
+	if sqlDB.QueryRow("SELECT COUNT(*) FROM devices").Scan(&devices); devices == 0 {
+		// Before accounts, each user was a single device with the same ID
+		sqlDB.Exec(`INSERT OR IGNORE INTO devices (id, user_id, created_at)
+			SELECT id, id, created_at FROM users WHERE id != 'system' AND id NOT LIKE 'guest-%'`)
+	}
+	if _, err := sqlDB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_seq ON messages(room_id, seq)"); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("create seq index: %w", err)
+	}
+
+	if _, err := sqlDB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_rooms_dm_key ON rooms(dm_key)"); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("create dm index: %w", err)
+	}
 
 	slog.Info("database opened", "path", path)
-	return &DB{sqlDB}, nil
+	return &DB{DB: sqlDB, dialect: sqliteDialect, QueryTimeout: DefaultQueryTimeout}, nil
 }
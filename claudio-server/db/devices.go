@@ -0,0 +1,377 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeviceLinkCodeTTL is how long a code from CreateLinkCode stays valid.
+const DeviceLinkCodeTTL = 10 * time.Minute
+
+// ErrKeyInUse is returned by RotateDeviceKey when the new key already
+// belongs to a device, or was retired before.
+var ErrKeyInUse = errors.New("key is already in use")
+
+// ErrSameAccount is returned by LinkDevice when the device already belongs
+// to the account the code was issued for.
+var ErrSameAccount = errors.New("device already belongs to this account")
+
+type Device struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"userId"`
+	Name          string     `json:"name"`
+	Platform      string     `json:"platform"`
+	ClientVersion string     `json:"clientVersion"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastSeenAt    *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+// DeviceInfo is what a device reports about itself when it connects.
+// Empty fields keep the stored value.
+type DeviceInfo struct {
+	Name          string
+	Platform      string
+	ClientVersion string
+}
+
+// TouchDevice records a connect from deviceID and returns its account, or
+// sql.ErrNoRows if the device isn't linked to one.
+func (d *DB) TouchDevice(deviceID string, info DeviceInfo) (string, error) {
+	var userID string
+	err := d.QueryRow(`
+		UPDATE devices SET
+			last_seen_at = ?,
+			name = CASE WHEN ? != '' THEN ? ELSE name END,
+			platform = CASE WHEN ? != '' THEN ? ELSE platform END,
+			client_version = CASE WHEN ? != '' THEN ? ELSE client_version END
+		WHERE id = ?
+		RETURNING user_id
+	`, time.Now().UTC(), info.Name, info.Name, info.Platform, info.Platform,
+		info.ClientVersion, info.ClientVersion, deviceID).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("touch device: %w", err)
+	}
+	return userID, err
+}
+
+// AccountForDevice returns the account deviceID signs in to, creating a new
+// account for devices seen for the first time. A new account takes the
+// device's ID, unless a removed device already left an account under it.
+func (d *DB) AccountForDevice(deviceID string, info DeviceInfo) (string, error) {
+	userID, err := d.TouchDevice(deviceID, info)
+	if err != sql.ErrNoRows {
+		return userID, err
+	}
+	now := time.Now().UTC()
+
+	tx, err := d.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	userID = deviceID
+	var exists int
+	tx.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, userID).Scan(&exists)
+	if exists > 0 {
+		userID = "acct-" + nanoid()
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO users (id, public_key, display_name, created_at, updated_at) VALUES (?, '', ?, ?, ?)
+	`, userID, info.Name, now, now); err != nil {
+		return "", fmt.Errorf("create account: %w", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO devices (id, user_id, name, platform, client_version, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, deviceID, userID, info.Name, info.Platform, info.ClientVersion, now, now); err != nil {
+		return "", fmt.Errorf("create device: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// ListDevices returns the devices linked to userID, oldest first.
+func (d *DB) ListDevices(userID string) ([]Device, error) {
+	rows, err := d.Query(`
+		SELECT id, user_id, name, platform, client_version, created_at, last_seen_at FROM devices
+		WHERE user_id = ? ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		var dev Device
+		if err := rows.Scan(&dev.ID, &dev.UserID, &dev.Name, &dev.Platform, &dev.ClientVersion, &dev.CreatedAt, &dev.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("scan device: %w", err)
+		}
+		devices = append(devices, dev)
+	}
+	return devices, rows.Err()
+}
+
+// RevokeDevice unlinks deviceID from userID and revokes its resume tokens
+// and encryption keys. Its next connect starts a new account. Returns
+// sql.ErrNoRows if the device isn't linked to userID.
+func (d *DB) RevokeDevice(userID, deviceID string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM devices WHERE id = ? AND user_id = ?`, deviceID, userID)
+	if err != nil {
+		return fmt.Errorf("remove device: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`
+		UPDATE resume_tokens SET revoked_at = ? WHERE device_id = ? AND revoked_at IS NULL
+	`, time.Now().UTC(), deviceID); err != nil {
+		return fmt.Errorf("revoke device resume tokens: %w", err)
+	}
+	if err := deleteDeviceE2EE(tx, deviceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RetiredKey is a device key replaced through RotateDeviceKey.
+type RetiredKey struct {
+	DeviceID   string    `json:"deviceId"`
+	UserID     string    `json:"userId"`
+	ReplacedBy string    `json:"replacedBy"`
+	RotatedAt  time.Time `json:"rotatedAt"`
+}
+
+// RotateDeviceKey moves userID's device oldDeviceID over to a new key,
+// recording the old key in the account's key history and revoking its
+// resume tokens. Returns sql.ErrNoRows if the device isn't linked to userID.
+func (d *DB) RotateDeviceKey(userID, oldDeviceID, oldPublicKey, newDeviceID string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var taken int
+	tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM devices WHERE id = ?) + (SELECT COUNT(*) FROM user_key_history WHERE device_id = ?)
+	`, newDeviceID, newDeviceID).Scan(&taken)
+	if taken > 0 {
+		return ErrKeyInUse
+	}
+
+	now := time.Now().UTC()
+	result, err := tx.Exec(`UPDATE devices SET id = ? WHERE id = ? AND user_id = ?`, newDeviceID, oldDeviceID, userID)
+	if err != nil {
+		return fmt.Errorf("rotate device key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_key_history (device_id, user_id, public_key, replaced_by, rotated_at) VALUES (?, ?, ?, ?, ?)
+	`, oldDeviceID, userID, oldPublicKey, newDeviceID, now); err != nil {
+		return fmt.Errorf("record retired key: %w", err)
+	}
+	if _, err := tx.Exec(`
+		UPDATE resume_tokens SET revoked_at = ? WHERE device_id = ? AND revoked_at IS NULL
+	`, now, oldDeviceID); err != nil {
+		return fmt.Errorf("revoke retired key resume tokens: %w", err)
+	}
+	if err := moveDeviceE2EE(tx, oldDeviceID, newDeviceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRetiredKey returns the rotation record for deviceID, or nil if the
+// key was never retired.
+func (d *DB) GetRetiredKey(deviceID string) (*RetiredKey, error) {
+	var k RetiredKey
+	err := d.QueryRow(`
+		SELECT device_id, user_id, replaced_by, rotated_at FROM user_key_history WHERE device_id = ?
+	`, deviceID).Scan(&k.DeviceID, &k.UserID, &k.ReplacedBy, &k.RotatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get retired key: %w", err)
+	}
+	return &k, nil
+}
+
+// CreateLinkCode issues a short code another device can redeem with
+// LinkDevice to join userID's account.
+func (d *DB) CreateLinkCode(userID string) (code string, expiresAt time.Time, err error) {
+	now := time.Now().UTC()
+	if _, err := d.Exec(`DELETE FROM device_link_codes WHERE expires_at <= ?`, now); err != nil {
+		return "", time.Time{}, fmt.Errorf("delete expired link codes: %w", err)
+	}
+	code = generateInviteCode()
+	expiresAt = now.Add(DeviceLinkCodeTTL)
+	if _, err := d.Exec(`
+		INSERT INTO device_link_codes (code, user_id, expires_at) VALUES (?, ?, ?)
+	`, code, userID, expiresAt); err != nil {
+		return "", time.Time{}, fmt.Errorf("create link code: %w", err)
+	}
+	return code, expiresAt, nil
+}
+
+// LinkDevice redeems code for the account sourceUserID, moving its devices,
+// memberships, messages and settings into the account the code was issued
+// for, then deleting sourceUserID. Returns the target account ID,
+// sql.ErrNoRows for an unknown or expired code, or ErrSameAccount.
+func (d *DB) LinkDevice(code, sourceUserID string) (string, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var targetUserID string
+	err = tx.QueryRow(`
+		DELETE FROM device_link_codes WHERE code = ? AND expires_at > ?
+		RETURNING user_id
+	`, strings.ToUpper(code), time.Now().UTC()).Scan(&targetUserID)
+	if err != nil {
+		return "", err
+	}
+	if targetUserID == sourceUserID {
+		return "", ErrSameAccount
+	}
+	if err := mergeAccounts(tx, sourceUserID, targetUserID); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return targetUserID, nil
+}
+
+// mergeAccounts re-points everything owned by from at to. Where both
+// accounts have a row for the same room or message, to's row wins.
+func mergeAccounts(tx *Tx, from, to string) error {
+	// Rows keyed by user and key: move what doesn't collide, drop the rest
+	for _, t := range []struct{ table, key string }{
+		{"participants", "room_id"},
+		{"room_preferences", "room_id"},
+		{"join_requests", "id"},
+		{"message_stars", "message_id"},
+	} {
+		if _, err := tx.Exec(`UPDATE `+t.table+` SET user_id = ? WHERE user_id = ? AND NOT EXISTS (
+			SELECT 1 FROM `+t.table+` other WHERE other.user_id = ? AND other.`+t.key+` = `+t.table+`.`+t.key+`
+		)`, to, from, to); err != nil {
+			return fmt.Errorf("merge %s: %w", t.table, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM `+t.table+` WHERE user_id = ?`, from); err != nil {
+			return fmt.Errorf("merge %s: %w", t.table, err)
+		}
+	}
+
+	updates := []string{
+		`UPDATE devices SET user_id = ? WHERE user_id = ?`,
+		`UPDATE messages SET sender_user_id = ? WHERE sender_user_id = ?`,
+		`UPDATE rooms SET created_by = ? WHERE created_by = ?`,
+		`UPDATE invite_codes SET created_by = ? WHERE created_by = ?`,
+		`UPDATE attachments SET uploaded_by = ? WHERE uploaded_by = ?`,
+		`UPDATE room_topic_changes SET set_by = ? WHERE set_by = ?`,
+		`UPDATE agent_schedules SET created_by = ? WHERE created_by = ?`,
+		`UPDATE agent_memory SET updated_by = ? WHERE updated_by = ?`,
+		`UPDATE room_templates SET owner_id = ? WHERE owner_id = ?`,
+		`UPDATE auth_events SET user_id = ? WHERE user_id = ?`,
+		`UPDATE reports SET reporter_id = ? WHERE reporter_id = ?`,
+		`UPDATE reports SET target_user_id = ? WHERE target_user_id = ?`,
+		`UPDATE user_key_history SET user_id = ? WHERE user_id = ?`,
+	}
+	for _, q := range updates {
+		if _, err := tx.Exec(q, to, from); err != nil {
+			return fmt.Errorf("merge account: %w", err)
+		}
+	}
+
+	if err := mergeDMKeys(tx, from, to); err != nil {
+		return err
+	}
+
+	// Either account's admin grant carries over
+	if _, err := tx.Exec(`UPDATE server_admins SET user_id = ? WHERE user_id = ? AND NOT EXISTS (
+		SELECT 1 FROM server_admins other WHERE other.user_id = ?
+	)`, to, from, to); err != nil {
+		return fmt.Errorf("merge admin grant: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM server_admins WHERE user_id = ?`, from); err != nil {
+		return fmt.Errorf("merge admin grant: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM resume_tokens WHERE user_id = ?`, from); err != nil {
+		return fmt.Errorf("merge resume tokens: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM device_link_codes WHERE user_id = ?`, from); err != nil {
+		return fmt.Errorf("merge link codes: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, from); err != nil {
+		return fmt.Errorf("delete merged account: %w", err)
+	}
+	return nil
+}
+
+// mergeDMKeys rewrites the DM keys of from's direct rooms for to. A room
+// whose new key is already taken (both accounts had a DM with the same
+// person) keeps its old key, and so stays reachable from history only.
+func mergeDMKeys(tx *Tx, from, to string) error {
+	rows, err := tx.Query(`
+		SELECT id, dm_key FROM rooms
+		WHERE dm_key LIKE ? OR dm_key LIKE ? OR dm_key LIKE ?
+	`, from+":%", "%:"+from, "agent|"+from+"|%")
+	if err != nil {
+		return fmt.Errorf("list dm rooms: %w", err)
+	}
+	type rekey struct{ id, key string }
+	var rekeys []rekey
+	for rows.Next() {
+		var id, key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan dm room: %w", err)
+		}
+		if rest, ok := strings.CutPrefix(key, "agent|"+from+"|"); ok {
+			rekeys = append(rekeys, rekey{id, "agent|" + to + "|" + rest})
+			continue
+		}
+		pair := strings.SplitN(key, ":", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		for i := range pair {
+			if pair[i] == from {
+				pair[i] = to
+			}
+		}
+		rekeys = append(rekeys, rekey{id, dmKey(pair[0], pair[1])})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, rk := range rekeys {
+		if _, err := tx.Exec(`
+			UPDATE rooms SET dm_key = ? WHERE id = ? AND NOT EXISTS (SELECT 1 FROM rooms taken WHERE taken.dm_key = ?)
+		`, rk.key, rk.id, rk.key); err != nil {
+			return fmt.Errorf("rekey dm room: %w", err)
+		}
+	}
+	return nil
+}
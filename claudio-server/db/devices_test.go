@@ -0,0 +1,150 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLinkDeviceMergesAccounts(t *testing.T) {
+	database := openTestDB(t)
+
+	phone, err := database.AccountForDevice("phone", DeviceInfo{Name: "Alice", Platform: "ios", ClientVersion: "1.2"})
+	if err != nil || phone != "phone" {
+		t.Fatalf("AccountForDevice(phone) = %q, %v", phone, err)
+	}
+	laptop, _ := database.AccountForDevice("laptop", DeviceInfo{Name: "Alice"})
+	database.AccountForDevice("bob", DeviceInfo{Name: "Bob"})
+
+	shared, _ := database.CreateRoom("Shared", "", "phone", false)
+	database.AddParticipant(shared.ID, "phone", "owner")
+	database.AddParticipant(shared.ID, "laptop", "member")
+	own, _ := database.CreateRoom("Laptop only", "", "laptop", false)
+	database.AddParticipant(own.ID, "laptop", "owner")
+	dm, _, err := database.OpenDirectRoom("laptop", "bob", "DM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := "laptop"
+	msg, _ := database.InsertMessage("m1", shared.ID, &sender, nil, "Alice", "", "hi", "[]", nil)
+
+	code, _, err := database.CreateLinkCode(phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.LinkDevice(code, phone); !errors.Is(err, ErrSameAccount) {
+		t.Fatalf("linking own account: err = %v, want ErrSameAccount", err)
+	}
+	code, _, _ = database.CreateLinkCode(phone)
+	if got, err := database.LinkDevice(code, laptop); err != nil || got != phone {
+		t.Fatalf("LinkDevice = %q, %v; want %q", got, err, phone)
+	}
+	if _, err := database.LinkDevice(code, "bob"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("code was accepted twice: err = %v", err)
+	}
+
+	if got, _ := database.AccountForDevice("laptop", DeviceInfo{}); got != phone {
+		t.Errorf("laptop signs in to %q, want %q", got, phone)
+	}
+	devices, _ := database.ListDevices(phone)
+	if len(devices) != 2 || devices[0].Platform != "ios" || devices[0].ClientVersion != "1.2" {
+		t.Errorf("devices = %+v, want phone (ios 1.2) and laptop", devices)
+	}
+	if user, _ := database.GetUser("laptop"); user != nil {
+		t.Errorf("merged account still exists")
+	}
+
+	// The owner row in the shared room wins over the laptop's member row
+	if role, _ := database.GetParticipantRole(shared.ID, phone); role != "owner" {
+		t.Errorf("shared room role = %q, want owner", role)
+	}
+	if ok, _ := database.IsParticipant(own.ID, phone); !ok {
+		t.Errorf("laptop's room was not moved")
+	}
+	if m, _ := database.GetMessage(shared.ID, msg.ID); m == nil || m.SenderUserID == nil || *m.SenderUserID != phone {
+		t.Errorf("message sender was not moved: %+v", m)
+	}
+	again, created, _ := database.OpenDirectRoom("bob", phone, "DM")
+	if created || again.ID != dm.ID {
+		t.Errorf("DM with bob was not carried over")
+	}
+
+	// A revoked device starts over with a fresh account
+	if err := database.RevokeDevice(phone, "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.RevokeDevice(phone, "laptop"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second revoke: err = %v, want sql.ErrNoRows", err)
+	}
+	if got, _ := database.AccountForDevice("laptop", DeviceInfo{Name: "Alice"}); got == phone || got == "" {
+		t.Errorf("revoked device signs in to %q, want a new account", got)
+	}
+}
+
+func TestRotateDeviceKey(t *testing.T) {
+	database := openTestDB(t)
+	userID, _ := database.AccountForDevice("old", DeviceInfo{Name: "Alice"})
+	database.CreateResumeToken("tok", ResumeSession{UserID: userID, DeviceID: "old"}, time.Now().Add(time.Hour))
+
+	if err := database.RotateDeviceKey(userID, "old", "old-key", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := database.AccountForDevice("new", DeviceInfo{}); got != userID {
+		t.Errorf("new key signs in to %q, want %q", got, userID)
+	}
+	retired, _ := database.GetRetiredKey("old")
+	if retired == nil || retired.ReplacedBy != "new" || retired.UserID != userID {
+		t.Errorf("retired key = %+v, want old replaced by new", retired)
+	}
+	if s, _ := database.ConsumeResumeToken("tok"); s != nil {
+		t.Errorf("resume token of the retired key still works")
+	}
+
+	if err := database.RotateDeviceKey(userID, "new", "new-key", "old"); !errors.Is(err, ErrKeyInUse) {
+		t.Errorf("rotating back to a retired key: err = %v, want ErrKeyInUse", err)
+	}
+	if err := database.RotateDeviceKey("someone", "new", "new-key", "newer"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("rotating another account's device: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestLinkDeviceAfterKeyRotation(t *testing.T) {
+	database := openTestDB(t)
+	phone, _ := database.AccountForDevice("phone", DeviceInfo{Name: "Alice"})
+	laptop, _ := database.AccountForDevice("laptop", DeviceInfo{Name: "Alice"})
+	if err := database.RotateDeviceKey(laptop, "laptop", "laptop-key", "laptop2"); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, err := database.CreateLinkCode(phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.LinkDevice(code, laptop); err != nil {
+		t.Fatalf("LinkDevice: %v", err)
+	}
+	if retired, _ := database.GetRetiredKey("laptop"); retired == nil || retired.UserID != phone {
+		t.Errorf("retired key = %+v, want it moved to %s", retired, phone)
+	}
+}
+
+func TestLinkDeviceKeepsAdminGrant(t *testing.T) {
+	database := openTestDB(t)
+	phone, _ := database.AccountForDevice("phone", DeviceInfo{Name: "Alice"})
+	laptop, _ := database.AccountForDevice("laptop", DeviceInfo{Name: "Alice"})
+	if err := database.GrantServerAdmin(laptop, "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, err := database.CreateLinkCode(phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.LinkDevice(code, laptop); err != nil {
+		t.Fatalf("LinkDevice: %v", err)
+	}
+	if ok, _ := database.IsServerAdmin(phone); !ok {
+		t.Errorf("admin grant was not carried over to %s", phone)
+	}
+}
@@ -0,0 +1,48 @@
+package db
+
+// dialect is the SQL that differs between SQLite and Postgres. Queries are
+// written to run on both where they can; the few that can't take the
+// differing piece from here.
+type dialect struct {
+	name string
+
+	// ilike matches case-insensitively, as SQLite's LIKE does for ASCII
+	ilike string
+	// greatest returns the larger of its arguments
+	greatest string
+	// instr(haystack, needle) is needle's 1-based position, 0 if absent
+	instr string
+	// utcDay formats a timestamp column as its UTC day, YYYY-MM-DD
+	utcDay func(column string) string
+	// jsonArrayHas tests whether a column holding a JSON array contains
+	// the string bound to the one ? it adds
+	jsonArrayHas func(column string) string
+}
+
+var sqliteDialect = dialect{
+	name:     "sqlite",
+	ilike:    "LIKE",
+	greatest: "MAX",
+	instr:    "instr",
+	// Timestamps are stored as UTC text, so the first 10 characters are the day
+	utcDay: func(column string) string {
+		return "substr(" + column + ", 1, 10)"
+	},
+	jsonArrayHas: func(column string) string {
+		return "EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(" + column + ") THEN " + column + " ELSE '[]' END) WHERE value = ?)"
+	},
+}
+
+var postgresDialect = dialect{
+	name:     "postgres",
+	ilike:    "ILIKE",
+	greatest: "GREATEST",
+	instr:    "strpos",
+	// Connections run with timezone UTC, see OpenPostgres
+	utcDay: func(column string) string {
+		return "to_char(" + column + ", 'YYYY-MM-DD')"
+	},
+	jsonArrayHas: func(column string) string {
+		return "(CASE WHEN " + column + " LIKE '[%' THEN " + column + "::jsonb ELSE '[]'::jsonb END) @> jsonb_build_array(?::text)"
+	},
+}
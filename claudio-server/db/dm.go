@@ -0,0 +1,107 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// dmKey identifies the direct-message room for a pair of users regardless
+// of who opened it.
+func dmKey(userA, userB string) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return userA + ":" + userB
+}
+
+// OpenDirectRoom returns the DM room between two users, creating it if needed.
+// created reports whether this call made the room.
+func (d *DB) OpenDirectRoom(userID, otherUserID, name string) (room *Room, created bool, err error) {
+	key := dmKey(userID, otherUserID)
+	id := nanoid()
+	now := time.Now().UTC()
+
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// The unique index on dm_key makes concurrent opens converge on one room
+	result, err := tx.Exec(`
+		INSERT INTO rooms (id, name, emoji, type, dm_key, created_by, public, created_at, updated_at)
+		VALUES (?, ?, '', ?, ?, ?, 0, ?, ?)
+		ON CONFLICT (dm_key) DO NOTHING
+	`, id, name, RoomTypeDM, key, userID, now, now)
+	if err != nil {
+		return nil, false, fmt.Errorf("create dm room: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		created = true
+		for _, uid := range []string{userID, otherUserID} {
+			if _, err := tx.Exec(`INSERT INTO participants (room_id, user_id, role) VALUES (?, ?, 'member')`, id, uid); err != nil {
+				return nil, false, fmt.Errorf("add dm participant: %w", err)
+			}
+		}
+	} else if err := tx.QueryRow(`SELECT id FROM rooms WHERE dm_key = ?`, key).Scan(&id); err != nil {
+		return nil, false, fmt.Errorf("find dm room: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	room, err = d.GetRoom(id)
+	return room, created, err
+}
+
+// OpenAgentDirectRoom returns the DM room between a user and an agent,
+// creating it (with the agent as a participant) if needed. A user who left
+// the room is added back.
+func (d *DB) OpenAgentDirectRoom(userID, agentID, openclawURL, openclawToken, agentName, agentEmoji, name string) (room *Room, created bool, err error) {
+	key := "agent|" + userID + "|" + agentID + "@" + openclawURL
+	id := nanoid()
+	now := time.Now().UTC()
+
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO rooms (id, name, emoji, type, dm_key, created_by, public, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)
+		ON CONFLICT (dm_key) DO NOTHING
+	`, id, name, agentEmoji, RoomTypeAgentDM, key, userID, now, now)
+	if err != nil {
+		return nil, false, fmt.Errorf("create agent dm room: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		created = true
+		_, err := tx.Exec(`
+			INSERT INTO participants (room_id, agent_id, openclaw_url, openclaw_token, openclaw_agent_id, agent_name, agent_emoji, role)
+			VALUES (?, ?, ?, ?, '', ?, ?, 'member')
+		`, id, agentID, openclawURL, openclawToken, agentName, agentEmoji)
+		if err != nil {
+			return nil, false, fmt.Errorf("add dm agent: %w", err)
+		}
+	} else if err := tx.QueryRow(`SELECT id FROM rooms WHERE dm_key = ?`, key).Scan(&id); err != nil {
+		return nil, false, fmt.Errorf("find agent dm room: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO participants (room_id, user_id, role) VALUES (?, ?, 'member') ON CONFLICT DO NOTHING`, id, userID); err != nil {
+		return nil, false, fmt.Errorf("add dm participant: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	room, err = d.GetRoom(id)
+	return room, created, err
+}
+
+// GetRoomType returns the room's type (group, dm, or agent_dm).
+func (d *DB) GetRoomType(roomID string) (string, error) {
+	var roomType string
+	err := d.QueryRow(`SELECT type FROM rooms WHERE id = ?`, roomID).Scan(&roomType)
+	return roomType, err
+}
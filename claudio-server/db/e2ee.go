@@ -0,0 +1,232 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DeviceKeys are a device's published end-to-end encryption keys. The
+// server never interprets them; clients verify the prekey signature.
+type DeviceKeys struct {
+	IdentityKey           string `json:"identityKey"`
+	SignedPrekey          string `json:"signedPrekey"`
+	SignedPrekeySignature string `json:"signedPrekeySignature"`
+}
+
+// OneTimePrekey is a single-use key a device uploads in batches.
+type OneTimePrekey struct {
+	KeyID     string `json:"keyId"`
+	PublicKey string `json:"publicKey"`
+}
+
+// KeyBundle is what a sender needs to open a session with one device.
+type KeyBundle struct {
+	UserID   string `json:"userId"`
+	DeviceID string `json:"deviceId"`
+	DeviceKeys
+	OneTimePrekey *OneTimePrekey `json:"oneTimePrekey,omitempty"` // nil once the device has run out
+}
+
+// RoomKeyEnvelope is a room key encrypted by one device for another.
+type RoomKeyEnvelope struct {
+	RoomID            string    `json:"roomId"`
+	KeyID             string    `json:"keyId"`
+	SenderDeviceID    string    `json:"senderDeviceId"`
+	RecipientDeviceID string    `json:"deviceId"`
+	Ciphertext        string    `json:"ciphertext"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// PublishDeviceKeys replaces deviceID's identity and signed prekeys and
+// adds oneTime to its prekey pool. It returns how many one-time prekeys
+// are left, so the client knows when to top up.
+func (d *DB) PublishDeviceKeys(deviceID string, keys DeviceKeys, oneTime []OneTimePrekey) (int, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO device_keys (device_id, identity_key, signed_prekey, signed_prekey_signature, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (device_id) DO UPDATE SET
+			identity_key = excluded.identity_key,
+			signed_prekey = excluded.signed_prekey,
+			signed_prekey_signature = excluded.signed_prekey_signature,
+			updated_at = excluded.updated_at
+	`, deviceID, keys.IdentityKey, keys.SignedPrekey, keys.SignedPrekeySignature, time.Now().UTC()); err != nil {
+		return 0, fmt.Errorf("publish device keys: %w", err)
+	}
+	for _, k := range oneTime {
+		if _, err := tx.Exec(`
+			INSERT INTO one_time_prekeys (device_id, key_id, public_key) VALUES (?, ?, ?)
+			ON CONFLICT (device_id, key_id) DO UPDATE SET public_key = excluded.public_key
+		`, deviceID, k.KeyID, k.PublicKey); err != nil {
+			return 0, fmt.Errorf("add one-time prekey: %w", err)
+		}
+	}
+
+	var left int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM one_time_prekeys WHERE device_id = ?`, deviceID).Scan(&left); err != nil {
+		return 0, fmt.Errorf("count one-time prekeys: %w", err)
+	}
+	return left, tx.Commit()
+}
+
+// ClaimKeyBundles returns a bundle for every device of userIDs that has
+// published keys. Each bundle claims, and so removes, one of the device's
+// one-time prekeys.
+func (d *DB) ClaimKeyBundles(userIDs []string) ([]KeyBundle, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bundles := []KeyBundle{}
+	seen := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		rows, err := tx.Query(`
+			SELECT dv.user_id, k.device_id, k.identity_key, k.signed_prekey, k.signed_prekey_signature
+			FROM device_keys k
+			JOIN devices dv ON dv.id = k.device_id
+			WHERE dv.user_id = ?
+			ORDER BY dv.created_at
+		`, userID)
+		if err != nil {
+			return nil, fmt.Errorf("get key bundles: %w", err)
+		}
+		for rows.Next() {
+			var b KeyBundle
+			if err := rows.Scan(&b.UserID, &b.DeviceID, &b.IdentityKey, &b.SignedPrekey, &b.SignedPrekeySignature); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan key bundle: %w", err)
+			}
+			bundles = append(bundles, b)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range bundles {
+		var k OneTimePrekey
+		err := tx.QueryRow(`
+			SELECT key_id, public_key FROM one_time_prekeys WHERE device_id = ? ORDER BY rowid LIMIT 1
+		`, bundles[i].DeviceID).Scan(&k.KeyID, &k.PublicKey)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("claim one-time prekey: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM one_time_prekeys WHERE device_id = ? AND key_id = ?`, bundles[i].DeviceID, k.KeyID); err != nil {
+			return nil, fmt.Errorf("claim one-time prekey: %w", err)
+		}
+		bundles[i].OneTimePrekey = &k
+	}
+	return bundles, tx.Commit()
+}
+
+// StoreRoomKeyEnvelopes saves envelopes for a room key. Sending the same
+// key to a device again replaces its envelope.
+func (d *DB) StoreRoomKeyEnvelopes(envelopes []RoomKeyEnvelope) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, e := range envelopes {
+		if _, err := tx.Exec(`
+			INSERT INTO room_key_envelopes (room_id, key_id, sender_device_id, recipient_device_id, ciphertext, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (room_id, key_id, recipient_device_id) DO UPDATE SET
+				sender_device_id = excluded.sender_device_id,
+				ciphertext = excluded.ciphertext,
+				created_at = excluded.created_at
+		`, e.RoomID, e.KeyID, e.SenderDeviceID, e.RecipientDeviceID, e.Ciphertext, now); err != nil {
+			return fmt.Errorf("store room key envelope: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListRoomKeyEnvelopes returns the room keys sent to deviceID for roomID,
+// oldest first.
+func (d *DB) ListRoomKeyEnvelopes(roomID, deviceID string) ([]RoomKeyEnvelope, error) {
+	rows, err := d.Query(`
+		SELECT room_id, key_id, sender_device_id, recipient_device_id, ciphertext, created_at
+		FROM room_key_envelopes
+		WHERE room_id = ? AND recipient_device_id = ?
+		ORDER BY id
+	`, roomID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("list room key envelopes: %w", err)
+	}
+	defer rows.Close()
+
+	envelopes := []RoomKeyEnvelope{}
+	for rows.Next() {
+		var e RoomKeyEnvelope
+		if err := rows.Scan(&e.RoomID, &e.KeyID, &e.SenderDeviceID, &e.RecipientDeviceID, &e.Ciphertext, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan room key envelope: %w", err)
+		}
+		envelopes = append(envelopes, e)
+	}
+	return envelopes, rows.Err()
+}
+
+// DeviceUserIDs maps each of deviceIDs that exists to its account.
+func (d *DB) DeviceUserIDs(deviceIDs []string) (map[string]string, error) {
+	owners := make(map[string]string, len(deviceIDs))
+	for _, id := range deviceIDs {
+		var userID string
+		err := d.QueryRow(`SELECT user_id FROM devices WHERE id = ?`, id).Scan(&userID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("device owner: %w", err)
+		}
+		owners[id] = userID
+	}
+	return owners, nil
+}
+
+// deleteDeviceE2EE drops a revoked device's keys and the room keys sent to it.
+func deleteDeviceE2EE(tx *Tx, deviceID string) error {
+	for _, q := range []string{
+		`DELETE FROM device_keys WHERE device_id = ?`,
+		`DELETE FROM one_time_prekeys WHERE device_id = ?`,
+		`DELETE FROM room_key_envelopes WHERE recipient_device_id = ?`,
+	} {
+		if _, err := tx.Exec(q, deviceID); err != nil {
+			return fmt.Errorf("delete device e2ee keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// moveDeviceE2EE carries a device's keys and room keys over when its
+// signing key is rotated; the encryption keys themselves don't change.
+func moveDeviceE2EE(tx *Tx, oldDeviceID, newDeviceID string) error {
+	for _, q := range []string{
+		`UPDATE device_keys SET device_id = ? WHERE device_id = ?`,
+		`UPDATE one_time_prekeys SET device_id = ? WHERE device_id = ?`,
+		`UPDATE room_key_envelopes SET recipient_device_id = ? WHERE recipient_device_id = ?`,
+	} {
+		if _, err := tx.Exec(q, newDeviceID, oldDeviceID); err != nil {
+			return fmt.Errorf("move device e2ee keys: %w", err)
+		}
+	}
+	return nil
+}
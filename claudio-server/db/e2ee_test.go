@@ -0,0 +1,84 @@
+package db
+
+import "testing"
+
+func TestKeyBundlesClaimOneTimePrekeys(t *testing.T) {
+	database := openTestDB(t)
+	alice, _ := database.AccountForDevice("alice-phone", DeviceInfo{Name: "Alice"})
+	database.AccountForDevice("bob-phone", DeviceInfo{Name: "Bob"})
+
+	keys := DeviceKeys{IdentityKey: "ik", SignedPrekey: "spk", SignedPrekeySignature: "sig"}
+	left, err := database.PublishDeviceKeys("alice-phone", keys, []OneTimePrekey{{KeyID: "1", PublicKey: "otk1"}, {KeyID: "2", PublicKey: "otk2"}})
+	if err != nil || left != 2 {
+		t.Fatalf("PublishDeviceKeys = %d, %v; want 2", left, err)
+	}
+
+	for _, want := range []string{"1", "2", ""} {
+		bundles, err := database.ClaimKeyBundles([]string{alice, alice, "bob-phone"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bundles) != 1 || bundles[0].DeviceID != "alice-phone" || bundles[0].IdentityKey != "ik" {
+			t.Fatalf("bundles = %+v, want alice-phone only", bundles)
+		}
+		got := ""
+		if bundles[0].OneTimePrekey != nil {
+			got = bundles[0].OneTimePrekey.KeyID
+		}
+		if got != want {
+			t.Errorf("claimed one-time prekey %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRoomKeyEnvelopes(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	alice, _ := database.AccountForDevice("alice-phone", DeviceInfo{Name: "Alice"})
+	database.AccountForDevice("alice-laptop", DeviceInfo{Name: "Alice"})
+
+	if encrypted, _ := database.IsRoomEncrypted(room.ID); encrypted {
+		t.Fatal("new room is encrypted")
+	}
+	if err := database.SetRoomEncrypted(room.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := database.GetRoom(room.ID); got == nil || !got.Encrypted {
+		t.Errorf("rooms.info would not show the room as encrypted")
+	}
+
+	database.PublishDeviceKeys("alice-phone", DeviceKeys{IdentityKey: "ik", SignedPrekey: "spk", SignedPrekeySignature: "sig"}, nil)
+	err := database.StoreRoomKeyEnvelopes([]RoomKeyEnvelope{
+		{RoomID: room.ID, KeyID: "k1", SenderDeviceID: "alice-laptop", RecipientDeviceID: "alice-phone", Ciphertext: "old"},
+		{RoomID: room.ID, KeyID: "k1", SenderDeviceID: "alice-laptop", RecipientDeviceID: "alice-phone", Ciphertext: "new"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelopes, _ := database.ListRoomKeyEnvelopes(room.ID, "alice-phone")
+	if len(envelopes) != 1 || envelopes[0].Ciphertext != "new" {
+		t.Errorf("envelopes = %+v, want the resent k1 only", envelopes)
+	}
+
+	// Rotating the signing key keeps the encryption keys and room keys
+	if err := database.RotateDeviceKey(alice, "alice-phone", "old-key", "alice-phone-2"); err != nil {
+		t.Fatal(err)
+	}
+	if envelopes, _ := database.ListRoomKeyEnvelopes(room.ID, "alice-phone-2"); len(envelopes) != 1 {
+		t.Errorf("room keys were not carried over the key rotation")
+	}
+	if bundles, _ := database.ClaimKeyBundles([]string{alice}); len(bundles) != 1 || bundles[0].DeviceID != "alice-phone-2" {
+		t.Errorf("bundles after rotation = %+v", bundles)
+	}
+
+	// A revoked device loses its keys
+	if err := database.RevokeDevice(alice, "alice-phone-2"); err != nil {
+		t.Fatal(err)
+	}
+	if envelopes, _ := database.ListRoomKeyEnvelopes(room.ID, "alice-phone-2"); len(envelopes) != 0 {
+		t.Errorf("revoked device still has room keys")
+	}
+	if bundles, _ := database.ClaimKeyBundles([]string{alice}); len(bundles) != 0 {
+		t.Errorf("revoked device still has a key bundle")
+	}
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DeletedUserName replaces the display name of a deleted account, on its
+// tombstone and on the messages it keeps.
+const DeletedUserName = "Deleted user"
+
+// What DeleteAccount does with the account's messages.
+const (
+	MessagesAnonymize = "anonymize" // keep them, attributed to DeletedUserName
+	MessagesErase     = "erase"     // delete them and their attachments
+)
+
+// Handover is a room whose owner was deleted and the participant who took
+// it over.
+type Handover struct {
+	RoomID  string
+	UserID  string
+	OldRole string
+}
+
+// AccountDeletion is what DeleteAccount did, for telling the rooms involved.
+type AccountDeletion struct {
+	DisplayName  string     // the account's name before it was deleted
+	Rooms        []string   // rooms it was removed from
+	DeletedRooms []string   // rooms it owned that had no one left to take over
+	Handovers    []Handover // rooms it owned that someone else took over
+	Devices      []string
+	Messages     int64 // anonymized or erased, per the policy
+}
+
+// DeleteAccount erases userID's personal data in one transaction. The
+// users row stays behind as a tombstone, so rooms, invites and audit
+// entries that name the ID keep pointing at something; its profile is
+// blanked and deleted_at set. Devices, keys, tokens, preferences and
+// participations are deleted. A room the account owned goes to its
+// longest-standing admin, else its longest-standing member, and is deleted
+// if it has neither (the lobby excepted). messages is MessagesAnonymize or
+// MessagesErase.
+//
+// Returns sql.ErrNoRows if there's no such account or it's already deleted.
+// Its devices' next connect starts a new account, as after RevokeDevice.
+func (d *DB) DeleteAccount(userID, messages string) (*AccountDeletion, error) {
+	if messages != MessagesAnonymize && messages != MessagesErase {
+		return nil, fmt.Errorf("delete account: unknown message policy %q", messages)
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	del := &AccountDeletion{}
+	err = tx.QueryRow(`SELECT display_name FROM users WHERE id = ? AND deleted_at IS NULL`, userID).Scan(&del.DisplayName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := leaveAllRooms(tx, userID, del); err != nil {
+		return nil, err
+	}
+	if del.Messages, err = scrubMessages(tx, userID, messages); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT id FROM devices WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list devices: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		del.Devices = append(del.Devices, id)
+	}
+	rows.Close()
+	for _, id := range del.Devices {
+		if err := deleteDeviceE2EE(tx, id); err != nil {
+			return nil, err
+		}
+		for _, q := range []string{
+			`DELETE FROM push_tokens WHERE device_id = ?`,
+			`DELETE FROM push_watches WHERE device_id = ?`,
+			`DELETE FROM auth_events WHERE device_id = ?`,
+		} {
+			if _, err := tx.Exec(q, id); err != nil {
+				return nil, fmt.Errorf("delete device data: %w", err)
+			}
+		}
+	}
+
+	for _, q := range []string{
+		`DELETE FROM devices WHERE user_id = ?`,
+		`DELETE FROM user_key_history WHERE user_id = ?`,
+		`DELETE FROM resume_tokens WHERE user_id = ?`,
+		`DELETE FROM device_link_codes WHERE user_id = ?`,
+		`DELETE FROM server_admins WHERE user_id = ?`,
+		`DELETE FROM room_preferences WHERE user_id = ?`,
+		`DELETE FROM join_requests WHERE user_id = ?`,
+		`DELETE FROM message_stars WHERE user_id = ?`,
+		`DELETE FROM room_templates WHERE owner_id = ?`,
+		`DELETE FROM auth_events WHERE user_id = ?`,
+	} {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return nil, fmt.Errorf("delete account data: %w", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`
+		UPDATE users SET public_key = '', display_name = ?, avatar_emoji = '', bio = '',
+			pronouns = '', timezone = '', status = '', status_text = '', status_emoji = '',
+			updated_at = ?, deleted_at = ?
+		WHERE id = ?
+	`, DeletedUserName, now, now, userID); err != nil {
+		return nil, fmt.Errorf("blank account: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return del, nil
+}
+
+// leaveAllRooms removes userID from its rooms, handing over or deleting the
+// ones it owns, and records what happened in del.
+func leaveAllRooms(tx *Tx, userID string, del *AccountDeletion) error {
+	type membership struct{ roomID, role string }
+	var rooms []membership
+	rows, err := tx.Query(`SELECT room_id, role FROM participants WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("list rooms: %w", err)
+	}
+	for rows.Next() {
+		var m membership
+		if err := rows.Scan(&m.roomID, &m.role); err != nil {
+			rows.Close()
+			return err
+		}
+		rooms = append(rooms, m)
+	}
+	rows.Close()
+
+	for _, m := range rooms {
+		if m.role == RoleOwner {
+			var h Handover
+			err := tx.QueryRow(`
+				SELECT user_id, role FROM participants
+				WHERE room_id = ? AND user_id IS NOT NULL AND user_id != ? AND role IN ('admin', 'member')
+				ORDER BY CASE role WHEN 'admin' THEN 0 ELSE 1 END, joined_at, id LIMIT 1
+			`, m.roomID, userID).Scan(&h.UserID, &h.OldRole)
+			switch {
+			case err == sql.ErrNoRows && m.roomID != LobbyRoomID:
+				if err := deleteRoom(tx, m.roomID); err != nil {
+					return err
+				}
+				del.DeletedRooms = append(del.DeletedRooms, m.roomID)
+				continue
+			case err == sql.ErrNoRows:
+			case err != nil:
+				return fmt.Errorf("find new owner: %w", err)
+			default:
+				if _, err := tx.Exec(`UPDATE participants SET role = 'owner' WHERE room_id = ? AND user_id = ?`, m.roomID, h.UserID); err != nil {
+					return fmt.Errorf("hand over room: %w", err)
+				}
+				h.RoomID = m.roomID
+				del.Handovers = append(del.Handovers, h)
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM participants WHERE room_id = ? AND user_id = ?`, m.roomID, userID); err != nil {
+			return fmt.Errorf("leave room: %w", err)
+		}
+		del.Rooms = append(del.Rooms, m.roomID)
+	}
+	return nil
+}
+
+// scrubMessages anonymizes or erases userID's messages and returns how many
+// there were.
+func scrubMessages(tx *Tx, userID, policy string) (int64, error) {
+	if policy == MessagesAnonymize {
+		result, err := tx.Exec(`
+			UPDATE messages SET sender_user_id = NULL, sender_display_name = ?, sender_emoji = ''
+			WHERE sender_user_id = ?
+		`, DeletedUserName, userID)
+		if err != nil {
+			return 0, fmt.Errorf("anonymize messages: %w", err)
+		}
+		return result.RowsAffected()
+	}
+
+	// As in DeleteRoom, dependents go explicitly rather than by cascade
+	for _, q := range []string{
+		`DELETE FROM attachments WHERE message_id IN (SELECT id FROM messages WHERE sender_user_id = ?)`,
+		`DELETE FROM attachments WHERE uploaded_by = ?`,
+		`DELETE FROM message_stars WHERE message_id IN (SELECT id FROM messages WHERE sender_user_id = ?)`,
+		`DELETE FROM message_translations WHERE message_id IN (SELECT id FROM messages WHERE sender_user_id = ?)`,
+	} {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return 0, fmt.Errorf("erase messages: %w", err)
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM messages WHERE sender_user_id = ?`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("erase messages: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	database := openTestDB(t)
+	shared := createTestRoom(t, database)
+	alone, err := database.CreateRoom("Alone", "", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.UpsertUser("bob", "key", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AddParticipant(shared.ID, "bob", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec(`INSERT INTO devices (id, user_id) VALUES ('alice-phone', 'alice')`); err != nil {
+		t.Fatal(err)
+	}
+	uid := "alice"
+	msg, err := database.InsertMessage(nanoid(), shared.ID, &uid, nil, "Alice", "🦊", "hello", "[]", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	del, err := database.DeleteAccount("alice", MessagesAnonymize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if del.DisplayName != "Alice" || del.Messages != 1 || len(del.Devices) != 1 {
+		t.Errorf("deletion = %+v", del)
+	}
+	if len(del.Handovers) != 1 || del.Handovers[0].UserID != "bob" || del.Handovers[0].OldRole != RoleMember {
+		t.Errorf("handovers = %+v, want the shared room to go to bob", del.Handovers)
+	}
+	if len(del.DeletedRooms) != 1 || del.DeletedRooms[0] != alone.ID {
+		t.Errorf("deleted rooms = %v, want the room alice was alone in", del.DeletedRooms)
+	}
+
+	if role, _ := database.GetParticipantRole(shared.ID, "bob"); role != RoleOwner {
+		t.Errorf("bob role = %q, want owner", role)
+	}
+	if _, err := database.GetParticipantRole(shared.ID, "alice"); err == nil {
+		t.Error("alice is still a participant")
+	}
+	if _, err := database.GetRoom(alone.ID); err == nil {
+		t.Error("room with no one left was not deleted")
+	}
+	var sender sql.NullString
+	var name, emoji string
+	database.QueryRow(`SELECT sender_user_id, sender_display_name, sender_emoji FROM messages WHERE id = ?`, msg.ID).Scan(&sender, &name, &emoji)
+	if sender.Valid || name != DeletedUserName || emoji != "" {
+		t.Errorf("message sender = %v %q %q, want anonymized", sender, name, emoji)
+	}
+	var devices int
+	database.QueryRow(`SELECT COUNT(*) FROM devices WHERE user_id = 'alice'`).Scan(&devices)
+	if devices != 0 {
+		t.Errorf("%d devices left", devices)
+	}
+
+	user, err := database.GetUser("alice")
+	if err != nil || user == nil {
+		t.Fatalf("tombstone: %v", err)
+	}
+	if user.DeletedAt == nil || user.DisplayName != DeletedUserName {
+		t.Errorf("tombstone = %+v, want blanked with deleted_at", user)
+	}
+	if _, err := database.DeleteAccount("alice", MessagesAnonymize); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting twice: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDeleteAccountEraseMessages(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	if _, err := database.UpsertUser("bob", "key", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AddParticipant(room.ID, "bob", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := "alice", "bob"
+	database.InsertMessage(nanoid(), room.ID, &alice, nil, "Alice", "", "mine", "[]", nil)
+	database.InsertMessage(nanoid(), room.ID, &bob, nil, "Bob", "", "theirs", "[]", nil)
+
+	del, err := database.DeleteAccount("alice", MessagesErase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if del.Messages != 1 {
+		t.Errorf("erased %d messages, want 1", del.Messages)
+	}
+	if n := countMessages(t, database, room.ID); n != 1 {
+		t.Errorf("room has %d messages, want bob's", n)
+	}
+}
@@ -14,6 +14,7 @@ type InviteCode struct {
 	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 	MaxUses   int        `json:"maxUses"`
 	UseCount  int        `json:"useCount"`
+	Role      string     `json:"role"` // granted on join
 	CreatedAt time.Time  `json:"createdAt"`
 }
 
@@ -28,7 +29,7 @@ func generateInviteCode() string {
 	return string(code)
 }
 
-func (db *DB) CreateInvite(roomID, createdBy string, expiresIn *time.Duration, maxUses int) (*InviteCode, error) {
+func (db *DB) CreateInvite(roomID, createdBy string, expiresIn *time.Duration, maxUses int, role string) (*InviteCode, error) {
 	code := generateInviteCode()
 	now := time.Now().UTC()
 
@@ -39,9 +40,9 @@ func (db *DB) CreateInvite(roomID, createdBy string, expiresIn *time.Duration, m
 	}
 
 	_, err := db.Exec(`
-		INSERT INTO invite_codes (code, room_id, created_by, expires_at, max_uses, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, code, roomID, createdBy, expiresAt, maxUses, now)
+		INSERT INTO invite_codes (code, room_id, created_by, expires_at, max_uses, role, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, code, roomID, createdBy, expiresAt, maxUses, role, now)
 	if err != nil {
 		return nil, err
 	}
@@ -51,6 +52,7 @@ func (db *DB) CreateInvite(roomID, createdBy string, expiresIn *time.Duration, m
 		RoomID:    roomID,
 		ExpiresAt: expiresAt,
 		MaxUses:   maxUses,
+		Role:      role,
 		CreatedAt: now,
 	}, nil
 }
@@ -60,9 +62,9 @@ func (db *DB) LookupInvite(code string) (*InviteCode, error) {
 	var invite InviteCode
 	var expiresAt sql.NullTime
 	err := db.QueryRow(`
-		SELECT code, room_id, expires_at, max_uses, use_count, created_at
+		SELECT code, room_id, expires_at, max_uses, use_count, role, created_at
 		FROM invite_codes WHERE code = ?
-	`, code).Scan(&invite.Code, &invite.RoomID, &expiresAt, &invite.MaxUses, &invite.UseCount, &invite.CreatedAt)
+	`, code).Scan(&invite.Code, &invite.RoomID, &expiresAt, &invite.MaxUses, &invite.UseCount, &invite.Role, &invite.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("invalid invite code")
 	}
@@ -81,31 +83,92 @@ func (db *DB) LookupInvite(code string) (*InviteCode, error) {
 	return &invite, nil
 }
 
-func (db *DB) RedeemInvite(code string) (string, error) {
-	var invite InviteCode
-	var expiresAt sql.NullTime
-	err := db.QueryRow(`
-		SELECT code, room_id, expires_at, max_uses, use_count
-		FROM invite_codes WHERE code = ?
-	`, code).Scan(&invite.Code, &invite.RoomID, &expiresAt, &invite.MaxUses, &invite.UseCount)
-	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("invalid invite code")
+// RedeemInvite counts a use of the invite and returns it.
+func (db *DB) RedeemInvite(code string) (*InviteCode, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invite, err := redeemInvite(tx, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
+	return invite, nil
+}
+
+// JoinWithInvite redeems the invite and adds userID to its room with the
+// invite's role, as one transaction: the use is only counted if the user
+// is added. Users already in the room keep their role and leave the invite
+// as it was; added reports whether they were new.
+func (db *DB) JoinWithInvite(code, userID string) (invite *InviteCode, added bool, err error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return "", err
+		return nil, false, err
 	}
+	defer tx.Rollback()
 
-	if expiresAt.Valid && expiresAt.Time.Before(time.Now().UTC()) {
-		return "", fmt.Errorf("invite code expired")
+	invite, err = redeemInvite(tx, code)
+	if err != nil {
+		return nil, false, err
 	}
-	if invite.MaxUses > 0 && invite.UseCount >= invite.MaxUses {
-		return "", fmt.Errorf("invite code fully used")
+	result, err := tx.Exec(`
+		INSERT INTO participants (room_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, invite.RoomID, userID, invite.Role)
+	if err != nil {
+		return nil, false, fmt.Errorf("add participant: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Already a participant: roll the use back
+		invite.UseCount--
+		return invite, false, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
 	}
+	return invite, true, nil
+}
 
-	_, err = db.Exec(`UPDATE invite_codes SET use_count = use_count + 1 WHERE code = ?`, code)
+// redeemInvite counts a use of code in tx. The use is counted by a single
+// conditional UPDATE, so concurrent redemptions can't take an invite past
+// maxUses; the row is only read back afterwards, for the reason it failed
+// or the invite it returns.
+func redeemInvite(tx *Tx, code string) (*InviteCode, error) {
+	now := time.Now().UTC()
+	result, err := tx.Exec(`
+		UPDATE invite_codes SET use_count = use_count + 1
+		WHERE code = ? AND (expires_at IS NULL OR expires_at > ?) AND (max_uses = 0 OR use_count < max_uses)
+	`, code, now)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	redeemed, _ := result.RowsAffected()
 
-	return invite.RoomID, nil
+	var invite InviteCode
+	var expiresAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT code, room_id, expires_at, max_uses, use_count, role, created_at
+		FROM invite_codes WHERE code = ?
+	`, code).Scan(&invite.Code, &invite.RoomID, &expiresAt, &invite.MaxUses, &invite.UseCount, &invite.Role, &invite.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("invalid invite code")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	if redeemed == 0 {
+		if expiresAt.Valid && !expiresAt.Time.After(now) {
+			return nil, fmt.Errorf("invite code expired")
+		}
+		return nil, fmt.Errorf("invite code fully used")
+	}
+	return &invite, nil
 }
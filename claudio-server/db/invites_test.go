@@ -0,0 +1,112 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJoinWithInviteConcurrentMaxUses(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	invite, err := database.CreateInvite(room.ID, "alice", nil, 3, RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const joiners = 10
+	for i := 0; i < joiners; i++ {
+		if _, err := database.UpsertUser(fmt.Sprintf("user%d", i), fmt.Sprintf("key%d", i), "User", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var joined atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < joiners; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, added, err := database.JoinWithInvite(invite.Code, fmt.Sprintf("user%d", i)); err == nil && added {
+				joined.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := joined.Load(); n != 3 {
+		t.Errorf("%d users joined, want 3", n)
+	}
+	var useCount, participants int
+	database.QueryRow(`SELECT use_count FROM invite_codes WHERE code = ?`, invite.Code).Scan(&useCount)
+	database.QueryRow(`SELECT COUNT(*) FROM participants WHERE room_id = ? AND user_id LIKE 'user%'`, room.ID).Scan(&participants)
+	if useCount != 3 || participants != 3 {
+		t.Errorf("use_count = %d, participants = %d, want 3 and 3", useCount, participants)
+	}
+}
+
+func TestJoinWithInviteKeepsExistingRole(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	invite, err := database.CreateInvite(room.ID, "alice", nil, 0, RoleGuest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, added, err := database.JoinWithInvite(invite.Code, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added {
+		t.Error("owner re-joining was reported as added")
+	}
+	if role, _ := database.GetParticipantRole(room.ID, "alice"); role != RoleOwner {
+		t.Errorf("role = %q, want owner kept", role)
+	}
+}
+
+func TestJoinWithInviteAlreadyInRoomUsesNothing(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	if _, err := database.UpsertUser("bob", "key2", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	invite, err := database.CreateInvite(room.ID, "alice", nil, 1, RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, added, err := database.JoinWithInvite(invite.Code, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added || got.UseCount != 0 {
+		t.Errorf("owner re-joining: added = %v, use count = %d, want false and 0", added, got.UseCount)
+	}
+	if _, added, err := database.JoinWithInvite(invite.Code, "bob"); err != nil || !added {
+		t.Fatalf("single-use invite after a no-op join: added = %v, err = %v", added, err)
+	}
+}
+
+func TestRedeemInviteErrors(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+
+	expired := -time.Minute
+	old, _ := database.CreateInvite(room.ID, "alice", &expired, 0, RoleMember)
+	if _, err := database.RedeemInvite(old.Code); err == nil || err.Error() != "invite code expired" {
+		t.Errorf("expired invite: err = %v", err)
+	}
+	once, _ := database.CreateInvite(room.ID, "alice", nil, 1, RoleMember)
+	if _, err := database.RedeemInvite(once.Code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.RedeemInvite(once.Code); err == nil || err.Error() != "invite code fully used" {
+		t.Errorf("used-up invite: err = %v", err)
+	}
+	if _, err := database.RedeemInvite("NOPE"); err == nil || err.Error() != "invalid invite code" {
+		t.Errorf("unknown invite: err = %v", err)
+	}
+}
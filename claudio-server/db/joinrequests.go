@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Join request states.
+const (
+	JoinPending  = "pending"
+	JoinApproved = "approved"
+	JoinDenied   = "denied"
+)
+
+// JoinRequest is a user waiting to be let into a room that requires approval.
+type JoinRequest struct {
+	ID          string     `json:"id"`
+	RoomID      string     `json:"roomId"`
+	UserID      string     `json:"userId"`
+	DisplayName string     `json:"displayName"`
+	Emoji       string     `json:"emoji"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"`
+	DecidedBy   *string    `json:"decidedBy,omitempty"`
+	DecidedAt   *time.Time `json:"decidedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+const joinRequestColumns = `j.id, j.room_id, j.user_id, COALESCE(u.display_name, ''), COALESCE(u.avatar_emoji, ''),
+	j.role, j.status, j.decided_by, j.decided_at, j.created_at`
+
+func scanJoinRequest(row rowScanner) (JoinRequest, error) {
+	var j JoinRequest
+	err := row.Scan(&j.ID, &j.RoomID, &j.UserID, &j.DisplayName, &j.Emoji, &j.Role, &j.Status, &j.DecidedBy, &j.DecidedAt, &j.CreatedAt)
+	return j, err
+}
+
+// CreateJoinRequest files a pending request, or returns the user's existing
+// pending request for the room. created reports which.
+func (d *DB) CreateJoinRequest(roomID, userID, role string) (req *JoinRequest, created bool, err error) {
+	var existingID string
+	err = d.QueryRow(`
+		SELECT id FROM join_requests WHERE room_id = ? AND user_id = ? AND status = 'pending'
+	`, roomID, userID).Scan(&existingID)
+	if err == nil {
+		req, err = d.GetJoinRequest(existingID)
+		return req, false, err
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("find join request: %w", err)
+	}
+
+	id := nanoid()
+	_, err = d.Exec(`
+		INSERT INTO join_requests (id, room_id, user_id, role, created_at) VALUES (?, ?, ?, ?, ?)
+	`, id, roomID, userID, role, time.Now().UTC())
+	if err != nil {
+		return nil, false, fmt.Errorf("create join request: %w", err)
+	}
+	req, err = d.GetJoinRequest(id)
+	return req, true, err
+}
+
+// GetJoinRequest returns a join request by ID, or nil if it doesn't exist.
+func (d *DB) GetJoinRequest(id string) (*JoinRequest, error) {
+	j, err := scanJoinRequest(d.QueryRow(`
+		SELECT `+joinRequestColumns+`
+		FROM join_requests j LEFT JOIN users u ON u.id = j.user_id
+		WHERE j.id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get join request: %w", err)
+	}
+	return &j, nil
+}
+
+// ListJoinRequests returns a room's pending requests, oldest first.
+func (d *DB) ListJoinRequests(roomID string) ([]JoinRequest, error) {
+	rows, err := d.Query(`
+		SELECT `+joinRequestColumns+`
+		FROM join_requests j LEFT JOIN users u ON u.id = j.user_id
+		WHERE j.room_id = ? AND j.status = 'pending'
+		ORDER BY j.created_at
+	`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("list join requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []JoinRequest{}
+	for rows.Next() {
+		j, err := scanJoinRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan join request: %w", err)
+		}
+		requests = append(requests, j)
+	}
+	return requests, rows.Err()
+}
+
+// ResolveJoinRequest approves or denies a pending request; approval adds the
+// user to the room with the requested role. Returns sql.ErrNoRows if the
+// request isn't pending.
+func (d *DB) ResolveJoinRequest(id string, approve bool, decidedBy string) error {
+	status := JoinDenied
+	if approve {
+		status = JoinApproved
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID, userID, role string
+	err = tx.QueryRow(`
+		UPDATE join_requests SET status = ?, decided_by = ?, decided_at = ?
+		WHERE id = ? AND status = 'pending'
+		RETURNING room_id, user_id, role
+	`, status, decidedBy, time.Now().UTC(), id).Scan(&roomID, &userID, &role)
+	if err != nil {
+		return err
+	}
+	if approve {
+		if _, err := tx.Exec(`
+			INSERT INTO participants (room_id, user_id, role) VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING
+		`, roomID, userID, role); err != nil {
+			return fmt.Errorf("add participant: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestJoinRequestApproval(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	if _, err := database.UpsertUser("bob", "key", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	req, created, err := database.CreateJoinRequest(room.ID, "bob", RoleGuest)
+	if err != nil || !created {
+		t.Fatalf("create = %v, created=%v", err, created)
+	}
+	if again, created, _ := database.CreateJoinRequest(room.ID, "bob", RoleGuest); created || again.ID != req.ID {
+		t.Errorf("second request should return the pending one")
+	}
+
+	if err := database.ResolveJoinRequest(req.ID, true, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := database.GetParticipantRole(room.ID, "bob"); role != RoleGuest {
+		t.Errorf("bob role = %q, want guest", role)
+	}
+	if err := database.ResolveJoinRequest(req.ID, false, "alice"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("resolving twice err = %v, want sql.ErrNoRows", err)
+	}
+	if pending, _ := database.ListJoinRequests(room.ID); len(pending) != 0 {
+		t.Errorf("pending = %+v, want none", pending)
+	}
+}
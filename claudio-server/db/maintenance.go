@@ -0,0 +1,200 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// maintenanceQueryTimeout replaces QueryTimeout for maintenance, whose
+// deletes and vacuum can take a while on a large database.
+const maintenanceQueryTimeout = 10 * time.Minute
+
+// RetentionPolicy is what RunMaintenance prunes besides expired invites and
+// messages past their room's retention. Zero keeps everything.
+type RetentionPolicy struct {
+	AuthEvents time.Duration // auth log entries older than this
+}
+
+// MaintenanceReport is what one RunMaintenance did.
+type MaintenanceReport struct {
+	StartedAt      time.Time `json:"startedAt"`
+	DurationMs     int64     `json:"durationMs"`
+	Invites        int64     `json:"invites"`    // expired or used-up room and server invites
+	AuthEvents     int64     `json:"authEvents"` // auth log entries past RetentionPolicy.AuthEvents
+	Messages       int64     `json:"messages"`   // messages past their room's retention
+	ReclaimedBytes int64     `json:"reclaimedBytes"`
+	// FreeBytes is unused space left in the file, which only a full VACUUM
+	// returns on databases created before incremental vacuum
+	FreeBytes int64 `json:"freeBytes"`
+}
+
+// RunMaintenance prunes expired data, then vacuums incrementally and
+// refreshes the query planner's statistics. It stops at the first error,
+// returning what it did so far.
+func (d *DB) RunMaintenance(policy RetentionPolicy, now time.Time) (*MaintenanceReport, error) {
+	m := *d
+	m.QueryTimeout = maintenanceQueryTimeout
+	report := &MaintenanceReport{StartedAt: now}
+	defer func() { report.DurationMs = time.Since(now).Milliseconds() }()
+
+	var err error
+	if report.Invites, err = m.PruneInvites(now); err != nil {
+		return report, err
+	}
+	if policy.AuthEvents > 0 {
+		if report.AuthEvents, err = m.PruneAuthEvents(now.Add(-policy.AuthEvents)); err != nil {
+			return report, err
+		}
+	}
+	if report.Messages, err = m.PruneRoomMessages(now); err != nil {
+		return report, err
+	}
+	if err := m.vacuum(report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// PruneInvites deletes room and server invites that have expired or been
+// used up, returning how many.
+func (d *DB) PruneInvites(now time.Time) (int64, error) {
+	var total int64
+	for _, table := range []string{"invite_codes", "server_invites"} {
+		result, err := d.Exec(`
+			DELETE FROM `+table+`
+			WHERE (expires_at IS NOT NULL AND expires_at < ?) OR (max_uses > 0 AND use_count >= max_uses)
+		`, now.UTC())
+		if err != nil {
+			return total, fmt.Errorf("prune %s: %w", table, err)
+		}
+		n, _ := result.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+// PruneAuthEvents deletes auth log entries from before cutoff.
+func (d *DB) PruneAuthEvents(cutoff time.Time) (int64, error) {
+	result, err := d.Exec(`DELETE FROM auth_events WHERE created_at < ?`, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("prune auth events: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// PruneRoomMessages deletes messages older than their room's retention,
+// one room per transaction. Attachment blobs are left to the media GC.
+func (d *DB) PruneRoomMessages(now time.Time) (int64, error) {
+	rows, err := d.Query(`SELECT id, message_retention_days FROM rooms WHERE message_retention_days > 0`)
+	if err != nil {
+		return 0, fmt.Errorf("list room retention: %w", err)
+	}
+	cutoffs := map[string]time.Time{}
+	for rows.Next() {
+		var roomID string
+		var days int
+		if err := rows.Scan(&roomID, &days); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan room retention: %w", err)
+		}
+		cutoffs[roomID] = now.UTC().AddDate(0, 0, -days)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("list room retention: %w", err)
+	}
+
+	var total int64
+	for roomID, cutoff := range cutoffs {
+		n, err := d.pruneMessagesBefore(roomID, cutoff)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (d *DB) pruneMessagesBefore(roomID string, cutoff time.Time) (int64, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// As in DeleteRoom, dependents go explicitly rather than by cascade
+	const old = `SELECT id FROM messages WHERE room_id = ? AND created_at < ?`
+	for _, stmt := range []string{
+		`DELETE FROM attachments WHERE message_id IN (` + old + `)`,
+		`DELETE FROM message_stars WHERE message_id IN (` + old + `)`,
+		`DELETE FROM message_translations WHERE message_id IN (` + old + `)`,
+	} {
+		if _, err := tx.Exec(stmt, roomID, cutoff); err != nil {
+			return 0, fmt.Errorf("prune messages: %w", err)
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM messages WHERE room_id = ? AND created_at < ?`, roomID, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("prune messages: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n, tx.Commit()
+}
+
+// vacuum returns free pages to the file system where the database allows
+// it and runs ANALYZE. Postgres leaves vacuuming to autovacuum.
+func (d *DB) vacuum(report *MaintenanceReport) error {
+	if d.dialect.name != sqliteDialect.name {
+		if _, err := d.Exec(`ANALYZE`); err != nil {
+			return fmt.Errorf("analyze: %w", err)
+		}
+		return nil
+	}
+
+	before, err := d.sqliteSize()
+	if err != nil {
+		return err
+	}
+	var mode int
+	if err := d.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if mode == 2 { // incremental
+		// Each step frees one page, so it's read to the end rather than Exec'd
+		rows, err := d.Query(`PRAGMA incremental_vacuum`)
+		if err != nil {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+	}
+	after, err := d.sqliteSize()
+	if err != nil {
+		return err
+	}
+	report.ReclaimedBytes = before - after
+
+	var free int64
+	if err := d.QueryRow(`SELECT freelist_count * page_size FROM pragma_freelist_count(), pragma_page_size()`).Scan(&free); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	report.FreeBytes = free
+
+	if _, err := d.Exec(`ANALYZE`); err != nil {
+		return fmt.Errorf("analyze: %w", err)
+	}
+	return nil
+}
+
+// sqliteSize is the database's size in bytes, not counting the WAL.
+func (d *DB) sqliteSize() (int64, error) {
+	var size int64
+	if err := d.QueryRow(`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size); err != nil {
+		return 0, fmt.Errorf("database size: %w", err)
+	}
+	return size, nil
+}
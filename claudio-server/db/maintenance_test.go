@@ -0,0 +1,60 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMaintenance(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	kept := createTestRoom(t, database)
+	now := time.Now().UTC()
+
+	big := strings.Repeat("x", 4000)
+	for i := 0; i < 200; i++ {
+		for _, roomID := range []string{room.ID, kept.ID} {
+			if _, err := database.InsertMessage(nanoid(), roomID, nil, nil, "Alice", "", big, "[]", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := database.Exec(`UPDATE messages SET created_at = ?`, now.AddDate(0, 0, -10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.InsertMessage(nanoid(), room.ID, nil, nil, "Alice", "", "recent", "[]", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetRoomMessageRetention(room.ID, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := -time.Hour
+	database.CreateInvite(room.ID, "alice", &expired, 0, RoleMember)
+	live, _ := database.CreateInvite(room.ID, "alice", nil, 0, RoleMember)
+	database.CreateServerInvite("alice", &expired, 0)
+	database.RecordAuthEvent(AuthEvent{UserID: "alice", Method: AuthMethodSigned, Success: true})
+	database.Exec(`UPDATE auth_events SET created_at = ?`, now.AddDate(0, 0, -100))
+
+	report, err := database.RunMaintenance(RetentionPolicy{AuthEvents: 90 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Messages != 200 || report.Invites != 2 || report.AuthEvents != 1 {
+		t.Errorf("report = %+v, want 200 messages, 2 invites, 1 auth event", report)
+	}
+	if report.ReclaimedBytes < 200*4000 || report.FreeBytes != 0 {
+		t.Errorf("reclaimed %d bytes with %d left free, want the pruned messages' space back", report.ReclaimedBytes, report.FreeBytes)
+	}
+
+	if n := countMessages(t, database, room.ID); n != 1 {
+		t.Errorf("room with retention has %d messages, want the recent one", n)
+	}
+	if n := countMessages(t, database, kept.ID); n != 200 {
+		t.Errorf("room without retention has %d messages, want 200", n)
+	}
+	if _, err := database.LookupInvite(live.Code); err != nil {
+		t.Errorf("live invite pruned: %v", err)
+	}
+}
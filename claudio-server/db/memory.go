@@ -0,0 +1,58 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// AgentMemory is one note an agent keeps for a room.
+type AgentMemory struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	UpdatedBy string    `json:"updatedBy"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// GetAgentMemory returns an agent's notes for a room, by key.
+func (d *DB) GetAgentMemory(roomID, agentID string) ([]AgentMemory, error) {
+	rows, err := d.Query(`
+		SELECT key, value, updated_by, updated_at FROM agent_memory
+		WHERE room_id = ? AND agent_id = ?
+		ORDER BY key
+	`, roomID, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("get agent memory: %w", err)
+	}
+	defer rows.Close()
+
+	memory := []AgentMemory{}
+	for rows.Next() {
+		var m AgentMemory
+		if err := rows.Scan(&m.Key, &m.Value, &m.UpdatedBy, &m.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan agent memory: %w", err)
+		}
+		memory = append(memory, m)
+	}
+	return memory, rows.Err()
+}
+
+// SetAgentMemory stores a note under key, replacing any previous value. An
+// empty value deletes the note.
+func (d *DB) SetAgentMemory(roomID, agentID, key, value, updatedBy string) error {
+	if value == "" {
+		if _, err := d.Exec(`DELETE FROM agent_memory WHERE room_id = ? AND agent_id = ? AND key = ?`, roomID, agentID, key); err != nil {
+			return fmt.Errorf("delete agent memory: %w", err)
+		}
+		return nil
+	}
+	_, err := d.Exec(`
+		INSERT INTO agent_memory (room_id, agent_id, key, value, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (room_id, agent_id, key) DO UPDATE SET
+			value = excluded.value, updated_by = excluded.updated_by, updated_at = excluded.updated_at
+	`, roomID, agentID, key, value, updatedBy, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("set agent memory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package db
+
+import "testing"
+
+func TestAgentMemory(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+
+	if err := database.SetAgentMemory(room.ID, "bot", "style", "terse", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetAgentMemory(room.ID, "bot", "project", "claudio", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetAgentMemory(room.ID, "bot", "style", "friendly", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetAgentMemory(room.ID, "other", "style", "formal", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	memory, err := database.GetAgentMemory(room.ID, "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memory) != 2 || memory[0].Key != "project" || memory[1].Value != "friendly" {
+		t.Fatalf("memory = %+v, want project=claudio, style=friendly", memory)
+	}
+
+	if err := database.SetAgentMemory(room.ID, "bot", "style", "", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	memory, _ = database.GetAgentMemory(room.ID, "bot")
+	if len(memory) != 1 || memory[0].Key != "project" {
+		t.Errorf("memory after delete = %+v, want only project", memory)
+	}
+}
@@ -1,10 +1,26 @@
 package db
 
-import "time"
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Message kinds distinguish human, agent, and server-generated messages.
+const (
+	MessageKindUser   = "user"
+	MessageKindAgent  = "agent"
+	MessageKindSystem = "system"
+)
+
+// messageColumns is the column list scanned by scanMessage.
+const messageColumns = `id, room_id, seq, sender_user_id, sender_agent_id, sender_display_name, sender_emoji, content, mentions, reply_to, kind, blocks, created_at`
 
 type Message struct {
 	ID              string    `json:"id"`
 	RoomID          string    `json:"roomId"`
+	Seq             int64     `json:"seq"` // per-room, monotonically increasing
 	SenderUserID    *string   `json:"senderUserId,omitempty"`
 	SenderAgentID   *string   `json:"senderAgentId,omitempty"`
 	SenderDisplayName string  `json:"senderDisplayName"`
@@ -12,25 +28,88 @@ type Message struct {
 	Content         string    `json:"content"`
 	Mentions        string    `json:"mentions"`  // JSON array
 	ReplyTo         *string   `json:"replyTo,omitempty"`
-	CreatedAt       time.Time `json:"createdAt"`
+	ReplyPreview    *ReplyPreview `json:"replyPreview,omitempty"`
+	Attachments     []Attachment `json:"attachments,omitempty"`
+	Kind            string    `json:"kind"`
+	// Blocks holds an agent reply's images and tool activity, see openclaw.ContentBlock
+	Blocks    json.RawMessage `json:"blocks,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// ReplyPreview is a snapshot of the quoted message embedded in replies, so
+// clients can render the quote without fetching it separately.
+type ReplyPreview struct {
+	ID                string `json:"id"`
+	SenderDisplayName string `json:"senderDisplayName"`
+	SenderEmoji       string `json:"senderEmoji"`
+	Content           string `json:"content"` // truncated
+}
+
+const replyPreviewLen = 140
+
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
+func scanMessage(row rowScanner) (Message, error) {
+	var m Message
+	var blocks *string
+	err := row.Scan(&m.ID, &m.RoomID, &m.Seq, &m.SenderUserID, &m.SenderAgentID, &m.SenderDisplayName, &m.SenderEmoji, &m.Content, &m.Mentions, &m.ReplyTo, &m.Kind, &blocks, &m.CreatedAt)
+	if blocks != nil && *blocks != "" {
+		m.Blocks = json.RawMessage(*blocks)
+	}
+	return m, err
+}
+
+// InsertMessage stores a user or agent message. The kind is derived from the sender.
 func (db *DB) InsertMessage(id, roomID string, senderUserID, senderAgentID *string, senderDisplayName, senderEmoji, content, mentions string, replyTo *string) (*Message, error) {
+	kind := MessageKindUser
+	if senderAgentID != nil {
+		kind = MessageKindAgent
+	}
+	return db.insertMessage(id, roomID, senderUserID, senderAgentID, senderDisplayName, senderEmoji, content, mentions, replyTo, kind)
+}
+
+// InsertSystemMessage stores a server-generated event (join, leave, agent added)
+// so it appears in history at the right position.
+func (db *DB) InsertSystemMessage(id, roomID, content string) (*Message, error) {
+	return db.insertMessage(id, roomID, nil, nil, "System", "", content, "[]", nil, MessageKindSystem)
+}
+
+func (db *DB) insertMessage(id, roomID string, senderUserID, senderAgentID *string, senderDisplayName, senderEmoji, content, mentions string, replyTo *string, kind string) (*Message, error) {
 	now := time.Now().UTC()
-	_, err := db.Exec(`
-		INSERT INTO messages (id, room_id, sender_user_id, sender_agent_id, sender_display_name, sender_emoji, content, mentions, reply_to, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, id, roomID, senderUserID, senderAgentID, senderDisplayName, senderEmoji, content, mentions, replyTo, now)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Allocate the next per-room sequence number and bump updated_at in one step
+	var seq int64
+	err = tx.QueryRow(`
+		UPDATE rooms SET last_message_seq = last_message_seq + 1, updated_at = ?
+		WHERE id = ? RETURNING last_message_seq
+	`, now, roomID).Scan(&seq)
 	if err != nil {
 		return nil, err
 	}
 
-	// Update room updated_at
-	db.Exec("UPDATE rooms SET updated_at = ? WHERE id = ?", now, roomID)
+	_, err = tx.Exec(`
+		INSERT INTO messages (id, room_id, seq, sender_user_id, sender_agent_id, sender_display_name, sender_emoji, content, mentions, reply_to, kind, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, roomID, seq, senderUserID, senderAgentID, senderDisplayName, senderEmoji, content, mentions, replyTo, kind, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 
-	return &Message{
+	msg := &Message{
 		ID:                id,
 		RoomID:            roomID,
+		Seq:               seq,
 		SenderUserID:      senderUserID,
 		SenderAgentID:     senderAgentID,
 		SenderDisplayName: senderDisplayName,
@@ -38,8 +117,33 @@ func (db *DB) InsertMessage(id, roomID string, senderUserID, senderAgentID *stri
 		Content:           content,
 		Mentions:          mentions,
 		ReplyTo:           replyTo,
+		Kind:              kind,
 		CreatedAt:         now,
-	}, nil
+	}
+	if replyTo != nil {
+		msg.ReplyPreview, _ = db.getReplyPreview(roomID, *replyTo)
+	}
+	return msg, nil
+}
+
+// SetMessageBlocks stores the content blocks of an agent message.
+func (db *DB) SetMessageBlocks(id string, blocks json.RawMessage) error {
+	_, err := db.Exec(`UPDATE messages SET blocks = ? WHERE id = ?`, string(blocks), id)
+	return err
+}
+
+// GetMessage returns a single message in a room, or nil if it doesn't exist there.
+func (db *DB) GetMessage(roomID, id string) (*Message, error) {
+	m, err := scanMessage(db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE room_id = ? AND id = ?`, roomID, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	messages := []Message{m}
+	db.hydrateMessages(roomID, messages)
+	return &messages[0], nil
 }
 
 func (db *DB) GetMessages(roomID string, before *time.Time, limit int) ([]Message, error) {
@@ -53,16 +157,16 @@ func (db *DB) GetMessages(roomID string, before *time.Time, limit int) ([]Messag
 
 	if before != nil {
 		query = `
-			SELECT id, room_id, sender_user_id, sender_agent_id, sender_display_name, sender_emoji, content, mentions, reply_to, created_at
+			SELECT ` + messageColumns + `
 			FROM messages WHERE room_id = ? AND created_at < ?
-			ORDER BY created_at DESC LIMIT ?
+			ORDER BY seq DESC LIMIT ?
 		`
 		args = []any{roomID, *before, limit}
 	} else {
 		query = `
-			SELECT id, room_id, sender_user_id, sender_agent_id, sender_display_name, sender_emoji, content, mentions, reply_to, created_at
+			SELECT ` + messageColumns + `
 			FROM messages WHERE room_id = ?
-			ORDER BY created_at DESC LIMIT ?
+			ORDER BY seq DESC LIMIT ?
 		`
 		args = []any{roomID, limit}
 	}
@@ -76,8 +180,8 @@ func (db *DB) GetMessages(roomID string, before *time.Time, limit int) ([]Messag
 
 	var messages []Message
 	for dbRows.Next() {
-		var m Message
-		if err := dbRows.Scan(&m.ID, &m.RoomID, &m.SenderUserID, &m.SenderAgentID, &m.SenderDisplayName, &m.SenderEmoji, &m.Content, &m.Mentions, &m.ReplyTo, &m.CreatedAt); err != nil {
+		m, err := scanMessage(dbRows)
+		if err != nil {
 			continue
 		}
 		messages = append(messages, m)
@@ -87,20 +191,21 @@ func (db *DB) GetMessages(roomID string, before *time.Time, limit int) ([]Messag
 	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 		messages[i], messages[j] = messages[j], messages[i]
 	}
+	db.hydrateMessages(roomID, messages)
 	return messages, nil
 }
 
-// GetMessagesAfter returns messages created after the given message ID, in chronological order.
+// GetMessagesAfter returns messages sent after the given message ID, in chronological order.
 func (db *DB) GetMessagesAfter(roomID, afterID string, limit int) ([]Message, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 50
 	}
 
 	rows, err := db.Query(`
-		SELECT id, room_id, sender_user_id, sender_agent_id, sender_display_name, sender_emoji, content, mentions, reply_to, created_at
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE room_id = ? AND created_at > (SELECT created_at FROM messages WHERE id = ?)
-		ORDER BY created_at ASC LIMIT ?
+		WHERE room_id = ? AND seq > (SELECT seq FROM messages WHERE id = ?)
+		ORDER BY seq ASC LIMIT ?
 	`, roomID, afterID, limit)
 	if err != nil {
 		return nil, err
@@ -109,11 +214,192 @@ func (db *DB) GetMessagesAfter(roomID, afterID string, limit int) ([]Message, er
 
 	var messages []Message
 	for rows.Next() {
-		var m Message
-		if err := rows.Scan(&m.ID, &m.RoomID, &m.SenderUserID, &m.SenderAgentID, &m.SenderDisplayName, &m.SenderEmoji, &m.Content, &m.Mentions, &m.ReplyTo, &m.CreatedAt); err != nil {
+		m, err := scanMessage(rows)
+		if err != nil {
 			continue
 		}
 		messages = append(messages, m)
 	}
+	db.hydrateMessages(roomID, messages)
 	return messages, nil
 }
+
+// MessagePage is one page of cursor-paginated history.
+type MessagePage struct {
+	Messages []Message
+	HasMore  bool // more messages exist beyond this page in the paging direction
+}
+
+// History filter types accepted by GetMessagesPage.
+const (
+	HistoryFilterMentions = "mentions" // messages mentioning HistoryFilter.UserID
+	HistoryFilterMedia    = "media"    // messages with attachments or links
+	HistoryFilterAgents   = "agents"   // agent replies
+	HistoryFilterSystem   = "system"   // join/leave and other system events
+)
+
+// HistoryFilter narrows a history page. The zero value matches every message.
+type HistoryFilter struct {
+	Type        string
+	UserID      string // mentions: participant ID matched against the mentions array
+	DisplayName string // mentions: also match "@DisplayName" in the content
+}
+
+// ValidHistoryFilter reports whether t is empty or a known filter type.
+func ValidHistoryFilter(t string) bool {
+	switch t {
+	case "", HistoryFilterMentions, HistoryFilterMedia, HistoryFilterAgents, HistoryFilterSystem:
+		return true
+	}
+	return false
+}
+
+func (f HistoryFilter) clause(d dialect) (string, []any) {
+	switch f.Type {
+	case HistoryFilterMentions:
+		clause := ` AND (` + d.jsonArrayHas("mentions")
+		args := []any{f.UserID}
+		if f.DisplayName != "" {
+			clause += ` OR ` + d.instr + `(lower(content), ?) > 0`
+			args = append(args, "@"+strings.ToLower(f.DisplayName))
+		}
+		return clause + `)`, args
+	case HistoryFilterMedia:
+		return ` AND (EXISTS (SELECT 1 FROM attachments a WHERE a.message_id = messages.id)` +
+			` OR ` + d.instr + `(content, 'http://') > 0 OR ` + d.instr + `(content, 'https://') > 0)`, nil
+	case HistoryFilterAgents:
+		return ` AND kind = ?`, []any{MessageKindAgent}
+	case HistoryFilterSystem:
+		return ` AND kind = ?`, []any{MessageKindSystem}
+	}
+	return "", nil
+}
+
+// GetMessagesPage returns a page of messages in chronological order using seq cursors.
+// With after > 0 it pages forward from that seq; otherwise it pages backward from
+// before (or from the newest message when before is 0).
+func (db *DB) GetMessagesPage(roomID string, before, after int64, limit int, filter HistoryFilter) (*MessagePage, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	where := `room_id = ?`
+	args := []any{roomID}
+	filterClause, filterArgs := filter.clause(db.dialect)
+	where += filterClause
+	args = append(args, filterArgs...)
+
+	var query string
+	switch {
+	case after > 0:
+		query = `SELECT ` + messageColumns + ` FROM messages WHERE ` + where + ` AND seq > ? ORDER BY seq ASC LIMIT ?`
+		args = append(args, after, limit+1)
+	case before > 0:
+		query = `SELECT ` + messageColumns + ` FROM messages WHERE ` + where + ` AND seq < ? ORDER BY seq DESC LIMIT ?`
+		args = append(args, before, limit+1)
+	default:
+		query = `SELECT ` + messageColumns + ` FROM messages WHERE ` + where + ` ORDER BY seq DESC LIMIT ?`
+		args = append(args, limit+1)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{}
+	if len(messages) > limit {
+		page.HasMore = true
+		messages = messages[:limit]
+	}
+	if after <= 0 {
+		// Backward pages were fetched newest-first
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	db.hydrateMessages(roomID, messages)
+	page.Messages = messages
+	return page, nil
+}
+
+// hydrateMessages loads the reply previews and attachments for a page of messages.
+func (db *DB) hydrateMessages(roomID string, messages []Message) {
+	db.attachReplyPreviews(roomID, messages)
+	db.attachAttachments(messages)
+}
+
+func (db *DB) getReplyPreview(roomID, messageID string) (*ReplyPreview, error) {
+	rp := &ReplyPreview{}
+	err := db.QueryRow(`
+		SELECT id, sender_display_name, sender_emoji, content
+		FROM messages WHERE room_id = ? AND id = ?
+	`, roomID, messageID).Scan(&rp.ID, &rp.SenderDisplayName, &rp.SenderEmoji, &rp.Content)
+	if err != nil {
+		return nil, err
+	}
+	rp.Content = truncatePreview(rp.Content, replyPreviewLen)
+	return rp, nil
+}
+
+// attachReplyPreviews fills ReplyPreview for every reply in messages with a
+// single query. Quoted messages from other rooms are never resolved.
+func (db *DB) attachReplyPreviews(roomID string, messages []Message) {
+	var ids []any
+	seen := make(map[string]bool)
+	for _, m := range messages {
+		if m.ReplyTo != nil && !seen[*m.ReplyTo] {
+			seen[*m.ReplyTo] = true
+			ids = append(ids, *m.ReplyTo)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, sender_display_name, sender_emoji, content
+		FROM messages WHERE room_id = ? AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, append([]any{roomID}, ids...)...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	previews := make(map[string]*ReplyPreview, len(ids))
+	for rows.Next() {
+		rp := &ReplyPreview{}
+		if err := rows.Scan(&rp.ID, &rp.SenderDisplayName, &rp.SenderEmoji, &rp.Content); err != nil {
+			continue
+		}
+		rp.Content = truncatePreview(rp.Content, replyPreviewLen)
+		previews[rp.ID] = rp
+	}
+	for i := range messages {
+		if messages[i].ReplyTo != nil {
+			messages[i].ReplyPreview = previews[*messages[i].ReplyTo]
+		}
+	}
+}
+
+// truncatePreview shortens s to at most n runes, adding an ellipsis when cut.
+func truncatePreview(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
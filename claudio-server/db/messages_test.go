@@ -0,0 +1,154 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	database, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func createTestRoom(t *testing.T, database *DB) *Room {
+	t.Helper()
+	if _, err := database.UpsertUser("alice", "key", "Alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	room, err := database.CreateRoom("Test", "", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return room
+}
+
+func TestMessageSeqIsMonotonic(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid := "alice"
+
+	for i := 1; i <= 3; i++ {
+		msg, err := database.InsertMessage(fmt.Sprintf("m%d", i), room.ID, &uid, nil, "Alice", "", "hi", "[]", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Seq != int64(i) {
+			t.Errorf("message %d seq = %d, want %d", i, msg.Seq, i)
+		}
+	}
+}
+
+func TestGetMessagesPageCursors(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid := "alice"
+
+	for i := 1; i <= 5; i++ {
+		if _, err := database.InsertMessage(fmt.Sprintf("m%d", i), room.ID, &uid, nil, "Alice", "", "hi", "[]", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Force identical timestamps: timestamp paging would skip messages here
+	if _, err := database.Exec(`UPDATE messages SET created_at = '2025-01-01 00:00:00'`); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := database.GetMessagesPage(room.ID, 0, 0, 2, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(latest.Messages); got != "m4,m5" || !latest.HasMore {
+		t.Fatalf("latest page = %s (hasMore=%v), want m4,m5 (hasMore=true)", got, latest.HasMore)
+	}
+
+	older, err := database.GetMessagesPage(room.ID, latest.Messages[0].Seq, 0, 2, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(older.Messages); got != "m2,m3" || !older.HasMore {
+		t.Fatalf("older page = %s (hasMore=%v), want m2,m3 (hasMore=true)", got, older.HasMore)
+	}
+
+	newer, err := database.GetMessagesPage(room.ID, 0, 3, 10, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(newer.Messages); got != "m4,m5" || newer.HasMore {
+		t.Fatalf("newer page = %s (hasMore=%v), want m4,m5 (hasMore=false)", got, newer.HasMore)
+	}
+}
+
+func ids(messages []Message) string {
+	s := ""
+	for i, m := range messages {
+		if i > 0 {
+			s += ","
+		}
+		s += m.ID
+	}
+	return s
+}
+
+func TestReplyPreviewEmbedded(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid := "alice"
+
+	long := strings.Repeat("é", replyPreviewLen+10)
+	if _, err := database.InsertMessage("orig", room.ID, &uid, nil, "Alice", "🦊", long, "[]", nil); err != nil {
+		t.Fatal(err)
+	}
+	replyTo := "orig"
+	reply, err := database.InsertMessage("reply", room.ID, &uid, nil, "Alice", "🦊", "agreed", "[]", &replyTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.ReplyPreview == nil || reply.ReplyPreview.SenderEmoji != "🦊" {
+		t.Fatalf("insert reply preview = %+v", reply.ReplyPreview)
+	}
+	if got := []rune(reply.ReplyPreview.Content); len(got) != replyPreviewLen+1 {
+		t.Errorf("preview length = %d runes, want %d", len(got), replyPreviewLen+1)
+	}
+
+	page, err := database.GetMessagesPage(room.ID, 0, 0, 10, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Messages[1].ReplyPreview == nil || page.Messages[1].ReplyPreview.ID != "orig" {
+		t.Errorf("history reply preview = %+v", page.Messages[1].ReplyPreview)
+	}
+}
+
+func TestHistoryFilterMentions(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid := "alice"
+
+	database.InsertMessage("m1", room.ID, &uid, nil, "Alice", "", "no mention", "[]", nil)
+	database.InsertMessage("m2", room.ID, &uid, nil, "Alice", "", "ping", `["bob"]`, nil)
+	database.InsertMessage("m3", room.ID, &uid, nil, "Alice", "", "hey @Bob look", "[]", nil)
+	database.InsertSystemMessage("m4", room.ID, "Bob joined")
+
+	page, err := database.GetMessagesPage(room.ID, 0, 0, 10, HistoryFilter{Type: HistoryFilterMentions, UserID: "bob", DisplayName: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(page.Messages); got != "m2,m3" {
+		t.Errorf("mentions = %s, want m2,m3", got)
+	}
+
+	page, err = database.GetMessagesPage(room.ID, 0, 0, 10, HistoryFilter{Type: HistoryFilterSystem})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(page.Messages); got != "m4" {
+		t.Errorf("system = %s, want m4", got)
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+//go:embed schema_postgres.sql
+var postgresSchema string
+
+// schemaLockID is the advisory lock instances hold while creating the
+// schema, so several starting at once don't race on it.
+const schemaLockID = 0x636c6175 // "clau"
+
+// OpenPostgres connects to the Postgres database at url (postgres://...) and
+// creates the schema if needed. Unlike a SQLite file, one database can be
+// shared by several instances; see cluster for relaying their events.
+func OpenPostgres(url string) (*DB, error) {
+	config, err := pgx.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse database url: %w", err)
+	}
+	// The simple protocol types parameters the way Postgres types literals,
+	// which is what lets queries written for SQLite's loose typing run
+	// unchanged
+	config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	config.RuntimeParams["timezone"] = "UTC"
+
+	sqlDB := sql.OpenDB(pgConnector{stdlib.GetConnector(*config)})
+	if err := createPostgresSchema(sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	slog.Info("database opened", "host", config.Host, "database", config.Database)
+	return &DB{DB: sqlDB, dialect: postgresDialect, QueryTimeout: DefaultQueryTimeout}, nil
+}
+
+func createPostgresSchema(sqlDB *sql.DB) error {
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(?)`, schemaLockID); err != nil {
+		return fmt.Errorf("lock schema: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock(?)`, schemaLockID)
+
+	if _, err := conn.ExecContext(ctx, postgresSchema); err != nil {
+		return fmt.Errorf("init schema: %w", err)
+	}
+	return nil
+}
+
+// pgConnector hands out pgx connections wrapped in pgConn.
+type pgConnector struct {
+	driver.Connector
+}
+
+func (c pgConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pgConn{conn.(pgxConn)}, nil
+}
+
+// pgxConn is what database/sql uses of a pgx stdlib connection.
+type pgxConn interface {
+	driver.Conn
+	driver.ConnPrepareContext
+	driver.ConnBeginTx
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.NamedValueChecker
+	driver.SessionResetter
+}
+
+// pgConn runs this package's SQLite-style queries on Postgres: ?
+// placeholders are numbered, and bools are stored as 0 and 1 in the
+// INTEGER columns that are BOOLEAN in SQLite.
+type pgConn struct {
+	pgxConn
+}
+
+func (c pgConn) Prepare(query string) (driver.Stmt, error) {
+	return c.pgxConn.Prepare(rebind(query))
+}
+
+func (c pgConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.pgxConn.PrepareContext(ctx, rebind(query))
+}
+
+func (c pgConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.pgxConn.ExecContext(ctx, rebind(query), args)
+}
+
+func (c pgConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.pgxConn.QueryContext(ctx, rebind(query), args)
+}
+
+func (c pgConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if b, ok := nv.Value.(bool); ok {
+		nv.Value = int64(0)
+		if b {
+			nv.Value = int64(1)
+		}
+		return nil
+	}
+	return c.pgxConn.CheckNamedValue(nv)
+}
+
+// rebind numbers the ? placeholders in query as $1, $2, ..., leaving
+// string literals, quoted identifiers, comments and dollar-quoted bodies
+// alone.
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+1])
+			i += end
+			continue
+		case c == '$' && strings.HasPrefix(query[i:], "$$"):
+			end := strings.Index(query[i+2:], "$$")
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+4])
+			i += end + 3
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
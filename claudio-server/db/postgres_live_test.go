@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// openLivePostgres opens the Postgres database at CLAUDIO_TEST_DATABASE_URL
+// in a schema of its own, dropped when the test ends. Tests using it skip
+// when the variable isn't set.
+func openLivePostgres(t *testing.T) *DB {
+	t.Helper()
+	base := os.Getenv("CLAUDIO_TEST_DATABASE_URL")
+	if base == "" {
+		t.Skip("CLAUDIO_TEST_DATABASE_URL must be set")
+	}
+
+	admin, err := sql.Open("pgx", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("claudio_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	d, err := OpenPostgres(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestLivePostgres(t *testing.T) {
+	d := openLivePostgres(t)
+	var s Store = d
+
+	if _, err := s.UpsertUser("alice", "key", "Alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpsertUser("alice", "key", "Alice B", ""); err != nil {
+		t.Fatalf("upsert existing user: %v", err)
+	}
+	if _, err := s.UpsertUser("bob", "key2", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	room, err := s.CreateRoomWithVisibility("Test", "", "alice", VisibilityPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddParticipant(room.ID, "bob", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.IsParticipant(room.ID, "bob"); err != nil || !ok {
+		t.Errorf("IsParticipant(bob) = %v, %v", ok, err)
+	}
+
+	// Bools are stored as 0/1 for SQLite's sake
+	if err := s.SetRoomRequireApproval(room.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if on, err := s.RoomRequiresApproval(room.ID); err != nil || !on {
+		t.Errorf("RoomRequiresApproval = %v, %v", on, err)
+	}
+
+	uid := "alice"
+	for i := 1; i <= 3; i++ {
+		msg, err := s.InsertMessage(fmt.Sprintf("m%d", i), room.ID, &uid, nil, "Alice", "", "hello", "[]", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Seq != int64(i) {
+			t.Errorf("message %d has seq %d", i, msg.Seq)
+		}
+	}
+	msgs, err := d.RoomMessagesAfter(room.ID, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "m2" {
+		t.Errorf("messages after seq 1 = %+v", msgs)
+	}
+
+	if err := s.DeleteRoom(room.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetRoom(room.ID); got != nil {
+		t.Errorf("room still there after DeleteRoom")
+	}
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM rooms WHERE id = ? AND name = ?`, `SELECT * FROM rooms WHERE id = $1 AND name = $2`},
+		{`SELECT '?', "a?b" FROM t WHERE x = ?`, `SELECT '?', "a?b" FROM t WHERE x = $1`},
+		{`SELECT 'it''s' WHERE x = ?`, `SELECT 'it''s' WHERE x = $1`},
+		{"SELECT 1 -- why?\nWHERE x = ?", "SELECT 1 -- why?\nWHERE x = $1"},
+		{`DO $$ SELECT ? $$; SELECT ?`, `DO $$ SELECT ? $$; SELECT $1`},
+		{`SELECT 'unterminated ?`, `SELECT 'unterminated ?`},
+	}
+	for _, tt := range tests {
+		if got := rebind(tt.query); got != tt.want {
+			t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// The Postgres schema is kept by hand, so check it has every column the
+// SQLite one ends up with after its migrations.
+func TestPostgresSchemaHasEveryColumn(t *testing.T) {
+	d := openTestDB(t)
+
+	pgColumns := map[string]map[string]bool{}
+	table := regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS (\w+) \((.*?)\n\);`)
+	for _, m := range table.FindAllStringSubmatch(postgresSchema, -1) {
+		cols := map[string]bool{}
+		for _, line := range strings.Split(m[2], "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				cols[fields[0]] = true
+			}
+		}
+		pgColumns[m[1]] = cols
+	}
+
+	rows, err := d.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	for _, name := range tables {
+		cols, ok := pgColumns[name]
+		if !ok {
+			t.Errorf("table %s missing from schema_postgres.sql", name)
+			continue
+		}
+		rows, err := d.Query(`SELECT name FROM pragma_table_info(?)`, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var col string
+			if err := rows.Scan(&col); err != nil {
+				t.Fatal(err)
+			}
+			if !cols[col] {
+				t.Errorf("column %s.%s missing from schema_postgres.sql", name, col)
+			}
+		}
+		rows.Close()
+	}
+}
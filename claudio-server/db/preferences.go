@@ -0,0 +1,51 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Per-user room notification levels.
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyMute     = "mute"
+)
+
+// ValidNotifyLevel reports whether level is a known notification level.
+func ValidNotifyLevel(level string) bool {
+	switch level {
+	case NotifyAll, NotifyMentions, NotifyMute:
+		return true
+	}
+	return false
+}
+
+// SetRoomNotifications stores a user's notification level for a room.
+func (d *DB) SetRoomNotifications(roomID, userID, level string) error {
+	_, err := d.Exec(`
+		INSERT INTO room_preferences (room_id, user_id, notifications, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (room_id, user_id) DO UPDATE SET notifications = excluded.notifications, updated_at = excluded.updated_at
+	`, roomID, userID, level, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("set room notifications: %w", err)
+	}
+	return nil
+}
+
+// SetRoomPinned pins or unpins a room in the user's room list.
+func (d *DB) SetRoomPinned(roomID, userID string, pinned bool) error {
+	now := time.Now().UTC()
+	var pinnedAt *time.Time
+	if pinned {
+		pinnedAt = &now
+	}
+	_, err := d.Exec(`
+		INSERT INTO room_preferences (room_id, user_id, pinned_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (room_id, user_id) DO UPDATE SET pinned_at = excluded.pinned_at, updated_at = excluded.updated_at
+	`, roomID, userID, pinnedAt, now)
+	if err != nil {
+		return fmt.Errorf("set room pinned: %w", err)
+	}
+	return nil
+}
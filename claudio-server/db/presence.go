@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TouchParticipant records that userID just read roomID.
+func (d *DB) TouchParticipant(roomID, userID string) error {
+	_, err := d.Exec(`UPDATE participants SET last_seen_at = ? WHERE room_id = ? AND user_id = ?`,
+		time.Now().UTC(), roomID, userID)
+	if err != nil {
+		return fmt.Errorf("touch participant: %w", err)
+	}
+	return nil
+}
+
+// TouchUserRooms records userID as seen now in every room they belong to and
+// returns the IDs of those rooms.
+func (d *DB) TouchUserRooms(userID string) (time.Time, []string, error) {
+	now := time.Now().UTC()
+	rows, err := d.Query(`
+		UPDATE participants SET last_seen_at = ? WHERE user_id = ? RETURNING room_id
+	`, now, userID)
+	if err != nil {
+		return now, nil, fmt.Errorf("touch user rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var roomIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return now, nil, fmt.Errorf("scan room id: %w", err)
+		}
+		roomIDs = append(roomIDs, id)
+	}
+	return now, roomIDs, rows.Err()
+}
+
+// SharesRoom reports whether two users are participants in a common room.
+func (d *DB) SharesRoom(userA, userB string) (bool, error) {
+	var shared bool
+	err := d.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM participants a
+			JOIN participants b ON b.room_id = a.room_id
+			WHERE a.user_id = ? AND b.user_id = ?
+		)
+	`, userA, userB).Scan(&shared)
+	if err != nil {
+		return false, fmt.Errorf("shares room: %w", err)
+	}
+	return shared, nil
+}
+
+// LastSeen returns the most recent time the user was seen in any room.
+func (d *DB) LastSeen(userID string) (*time.Time, error) {
+	var seen *time.Time
+	err := d.QueryRow(`
+		SELECT last_seen_at FROM participants
+		WHERE user_id = ? AND last_seen_at IS NOT NULL
+		ORDER BY last_seen_at DESC LIMIT 1
+	`, userID).Scan(&seen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("last seen: %w", err)
+	}
+	return seen, nil
+}
+
+// Presence statuses a user can pick while online.
+const (
+	StatusOnline = "online"
+	StatusAway   = "away"
+	StatusDND    = "dnd"
+)
+
+func ValidStatus(s string) bool {
+	return s == StatusOnline || s == StatusAway || s == StatusDND
+}
+
+// UserStatus is a user's chosen presence and optional custom status.
+type UserStatus struct {
+	Status string `json:"status"`
+	Text   string `json:"text,omitempty"`
+	Emoji  string `json:"emoji,omitempty"`
+}
+
+// SetUserStatus stores the status a user gets back on their next connect.
+// A nil status clears it.
+func (d *DB) SetUserStatus(userID string, status *UserStatus) error {
+	var s UserStatus
+	if status != nil {
+		s = *status
+	}
+	if s.Status == StatusOnline {
+		s.Status = ""
+	}
+	_, err := d.Exec(`UPDATE users SET status = ?, status_text = ?, status_emoji = ? WHERE id = ?`,
+		s.Status, s.Text, s.Emoji, userID)
+	if err != nil {
+		return fmt.Errorf("set user status: %w", err)
+	}
+	return nil
+}
+
+// GetUserStatus returns the stored status of userID, or nil if none is set.
+func (d *DB) GetUserStatus(userID string) (*UserStatus, error) {
+	var s UserStatus
+	err := d.QueryRow(`SELECT status, status_text, status_emoji FROM users WHERE id = ?`, userID).Scan(&s.Status, &s.Text, &s.Emoji)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get user status: %w", err)
+	}
+	if s == (UserStatus{}) {
+		return nil, nil
+	}
+	if s.Status == "" {
+		s.Status = StatusOnline
+	}
+	return &s, nil
+}
+
+// UserRoomIDs returns the IDs of the rooms userID participates in.
+func (d *DB) UserRoomIDs(userID string) ([]string, error) {
+	rows, err := d.Query(`SELECT room_id FROM participants WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var roomIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan room id: %w", err)
+		}
+		roomIDs = append(roomIDs, id)
+	}
+	return roomIDs, rows.Err()
+}
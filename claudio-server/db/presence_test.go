@@ -0,0 +1,30 @@
+package db
+
+import "testing"
+
+func TestUserStatusPersists(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertUser("alice", "key", "Alice", "")
+
+	if s, err := database.GetUserStatus("alice"); err != nil || s != nil {
+		t.Fatalf("initial status = %+v, %v; want none", s, err)
+	}
+	if err := database.SetUserStatus("alice", &UserStatus{Status: StatusAway, Text: "lunch", Emoji: "🥪"}); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := database.GetUserStatus("alice")
+	if s == nil || *s != (UserStatus{Status: StatusAway, Text: "lunch", Emoji: "🥪"}) {
+		t.Errorf("status = %+v, want away/lunch", s)
+	}
+
+	// A custom status without away/dnd reads back as online
+	database.SetUserStatus("alice", &UserStatus{Status: StatusOnline, Text: "shipping"})
+	if s, _ := database.GetUserStatus("alice"); s == nil || s.Status != StatusOnline || s.Text != "shipping" {
+		t.Errorf("status = %+v, want online/shipping", s)
+	}
+
+	database.SetUserStatus("alice", nil)
+	if s, _ := database.GetUserStatus("alice"); s != nil {
+		t.Errorf("cleared status = %+v, want none", s)
+	}
+}
@@ -6,9 +6,9 @@ import "fmt"
 func (d *DB) UpsertPushToken(deviceID, token, bundleID, platform string) error {
 	_, err := d.Exec(`
 		INSERT INTO push_tokens (device_id, token, bundle_id, platform, updated_at)
-		VALUES (?, ?, ?, ?, datetime('now'))
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT (device_id, bundle_id)
-		DO UPDATE SET token = excluded.token, platform = excluded.platform, updated_at = datetime('now')
+		DO UPDATE SET token = excluded.token, platform = excluded.platform, updated_at = CURRENT_TIMESTAMP
 	`, deviceID, token, bundleID, platform)
 	if err != nil {
 		return fmt.Errorf("upsert push token: %w", err)
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Report kinds.
+const (
+	ReportMessage = "message"
+	ReportUser    = "user"
+)
+
+// Report statuses. Reports start open; an admin resolves (acted on) or
+// dismisses them.
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+var reportReasons = map[string]bool{
+	"spam": true, "harassment": true, "hate": true, "violence": true,
+	"sexual": true, "impersonation": true, "other": true,
+}
+
+func ValidReportReason(reason string) bool {
+	return reportReasons[reason]
+}
+
+type Report struct {
+	ID             string          `json:"id"`
+	Kind           string          `json:"kind"`
+	ReporterID     string          `json:"reporterId"`
+	TargetUserID   string          `json:"targetUserId,omitempty"`
+	RoomID         string          `json:"roomId,omitempty"`
+	MessageID      string          `json:"messageId,omitempty"`
+	Reason         string          `json:"reason"`
+	Details        string          `json:"details,omitempty"`
+	Snapshot       json.RawMessage `json:"messageSnapshot,omitempty"`
+	Status         string          `json:"status"`
+	ResolvedBy     string          `json:"resolvedBy,omitempty"`
+	ResolutionNote string          `json:"resolutionNote,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	ResolvedAt     *time.Time      `json:"resolvedAt,omitempty"`
+}
+
+const reportColumns = `id, kind, reporter_id, target_user_id, room_id, message_id, reason, details, message_snapshot, status, resolved_by, resolution_note, created_at, resolved_at`
+
+func scanReport(row rowScanner) (*Report, error) {
+	var r Report
+	var snapshot sql.NullString
+	var resolvedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.Kind, &r.ReporterID, &r.TargetUserID, &r.RoomID, &r.MessageID, &r.Reason, &r.Details,
+		&snapshot, &r.Status, &r.ResolvedBy, &r.ResolutionNote, &r.CreatedAt, &resolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Valid {
+		r.Snapshot = json.RawMessage(snapshot.String)
+	}
+	if resolvedAt.Valid {
+		r.ResolvedAt = &resolvedAt.Time
+	}
+	return &r, nil
+}
+
+// CreateReport files r, filling in its ID, status and creation time. A
+// reporter who already has an open report on the same message or user
+// gets that one back instead, with created false.
+func (d *DB) CreateReport(r *Report, message *Message) (created bool, err error) {
+	existing, err := scanReport(d.QueryRow(`
+		SELECT `+reportColumns+` FROM reports
+		WHERE reporter_id = ? AND kind = ? AND target_user_id = ? AND message_id = ? AND status = 'open'
+	`, r.ReporterID, r.Kind, r.TargetUserID, r.MessageID))
+	if err == nil {
+		*r = *existing
+		return false, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, fmt.Errorf("check duplicate report: %w", err)
+	}
+
+	var snapshot *string
+	if message != nil {
+		b, err := json.Marshal(message)
+		if err != nil {
+			return false, fmt.Errorf("snapshot message: %w", err)
+		}
+		s := string(b)
+		snapshot = &s
+		r.Snapshot = b
+	}
+	r.ID = nanoid()
+	r.Status = ReportOpen
+	r.CreatedAt = time.Now().UTC()
+	_, err = d.Exec(`
+		INSERT INTO reports (id, kind, reporter_id, target_user_id, room_id, message_id, reason, details, message_snapshot, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.ID, r.Kind, r.ReporterID, r.TargetUserID, r.RoomID, r.MessageID, r.Reason, r.Details, snapshot, r.Status, r.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("create report: %w", err)
+	}
+	return true, nil
+}
+
+// ListReports returns reports with status ("" for all), newest first.
+func (d *DB) ListReports(status string, limit, offset int) ([]Report, error) {
+	rows, err := d.Query(`
+		SELECT `+reportColumns+` FROM reports
+		WHERE ? = '' OR status = ?
+		ORDER BY created_at DESC, id
+		LIMIT ? OFFSET ?
+	`, status, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list reports: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan report: %w", err)
+		}
+		reports = append(reports, *r)
+	}
+	return reports, rows.Err()
+}
+
+// ResolveReport closes a report as resolved or dismissed. It can be
+// re-closed, e.g. to change the note. Returns sql.ErrNoRows if there is
+// no such report.
+func (d *DB) ResolveReport(id, status, resolvedBy, note string) (*Report, error) {
+	result, err := d.Exec(`
+		UPDATE reports SET status = ?, resolved_by = ?, resolution_note = ?, resolved_at = ? WHERE id = ?
+	`, status, resolvedBy, note, time.Now().UTC(), id)
+	if err != nil {
+		return nil, fmt.Errorf("resolve report: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return scanReport(d.QueryRow(`SELECT `+reportColumns+` FROM reports WHERE id = ?`, id))
+}
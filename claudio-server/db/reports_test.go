@@ -0,0 +1,50 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReports(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	sender := "alice"
+	msg, _ := database.InsertMessage("m1", room.ID, &sender, nil, "Alice", "", "buy now", "[]", nil)
+
+	report := &Report{Kind: ReportMessage, ReporterID: "bob", TargetUserID: "alice", RoomID: room.ID, MessageID: msg.ID, Reason: "spam"}
+	created, err := database.CreateReport(report, msg)
+	if err != nil || !created {
+		t.Fatalf("CreateReport = %v, %v", created, err)
+	}
+	// The snapshot survives the message
+	if !strings.Contains(string(report.Snapshot), "buy now") {
+		t.Errorf("snapshot = %s", report.Snapshot)
+	}
+
+	again := &Report{Kind: ReportMessage, ReporterID: "bob", TargetUserID: "alice", RoomID: room.ID, MessageID: msg.ID, Reason: "other"}
+	if created, _ := database.CreateReport(again, msg); created || again.ID != report.ID {
+		t.Errorf("second report of the same message created %q, want the open %q back", again.ID, report.ID)
+	}
+	database.CreateReport(&Report{Kind: ReportUser, ReporterID: "bob", TargetUserID: "alice", Reason: "harassment"}, nil)
+
+	open, _ := database.ListReports(ReportOpen, 10, 0)
+	if len(open) != 2 {
+		t.Fatalf("got %d open reports, want 2", len(open))
+	}
+
+	resolved, err := database.ResolveReport(report.ID, ReportDismissed, "admin", "not spam")
+	if err != nil || resolved.Status != ReportDismissed || resolved.ResolvedAt == nil || resolved.ResolutionNote != "not spam" {
+		t.Fatalf("ResolveReport = %+v, %v", resolved, err)
+	}
+	if open, _ := database.ListReports(ReportOpen, 10, 0); len(open) != 1 {
+		t.Errorf("got %d open reports after dismissing one, want 1", len(open))
+	}
+	if all, _ := database.ListReports("", 10, 0); len(all) != 2 {
+		t.Errorf("got %d reports in total, want 2", len(all))
+	}
+	if _, err := database.ResolveReport("nope", ReportResolved, "admin", ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("resolving a missing report: err = %v, want sql.ErrNoRows", err)
+	}
+}
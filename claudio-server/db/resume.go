@@ -0,0 +1,77 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func hashResumeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ResumeSession is what a resume token restores.
+type ResumeSession struct {
+	UserID   string
+	DeviceID string
+	Scopes   []string // nil for tokens issued before scopes were recorded
+}
+
+// CreateResumeToken stores token for the session until expiresAt, and
+// clears out the user's expired tokens.
+func (d *DB) CreateResumeToken(token string, session ResumeSession, expiresAt time.Time) error {
+	userID := session.UserID
+	now := time.Now().UTC()
+	if _, err := d.Exec(`DELETE FROM resume_tokens WHERE user_id = ? AND expires_at <= ?`, userID, now); err != nil {
+		return fmt.Errorf("delete expired resume tokens: %w", err)
+	}
+	_, err := d.Exec(`
+		INSERT INTO resume_tokens (token_hash, user_id, device_id, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, hashResumeToken(token), userID, session.DeviceID, strings.Join(session.Scopes, ","), now, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("create resume token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeResumeToken revokes token and returns the session it was issued
+// for, or nil if it is unknown, expired or already revoked. Tokens are
+// single use.
+func (d *DB) ConsumeResumeToken(token string) (*ResumeSession, error) {
+	now := time.Now().UTC()
+	var session ResumeSession
+	var device sql.NullString
+	var scopes string
+	err := d.QueryRow(`
+		UPDATE resume_tokens SET revoked_at = ?
+		WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+		RETURNING user_id, device_id, scopes
+	`, now, hashResumeToken(token), now).Scan(&session.UserID, &device, &scopes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume resume token: %w", err)
+	}
+	session.DeviceID = device.String
+	if scopes != "" {
+		session.Scopes = strings.Split(scopes, ",")
+	}
+	return &session, nil
+}
+
+// RevokeResumeTokens revokes every unrevoked token of userID and returns
+// how many there were.
+func (d *DB) RevokeResumeTokens(userID string) (int64, error) {
+	result, err := d.Exec(`
+		UPDATE resume_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL
+	`, time.Now().UTC(), userID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke resume tokens: %w", err)
+	}
+	return result.RowsAffected()
+}
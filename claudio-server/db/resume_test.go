@@ -0,0 +1,39 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResumeTokens(t *testing.T) {
+	database := openTestDB(t)
+	createTestRoom(t, database) // creates alice
+	alice := ResumeSession{UserID: "alice", DeviceID: "dev1", Scopes: []string{"operator.read"}}
+
+	if err := database.CreateResumeToken("tok1", alice, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateResumeToken("old", alice, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := database.ConsumeResumeToken("tok1")
+	if err != nil || s == nil || s.UserID != "alice" || s.DeviceID != "dev1" || len(s.Scopes) != 1 || s.Scopes[0] != "operator.read" {
+		t.Fatalf("consume = %+v, %v; want alice, dev1, [operator.read]", s, err)
+	}
+	if s, _ := database.ConsumeResumeToken("tok1"); s != nil {
+		t.Errorf("token was accepted twice")
+	}
+	if s, _ := database.ConsumeResumeToken("old"); s != nil {
+		t.Errorf("expired token was accepted")
+	}
+
+	database.CreateResumeToken("tok2", alice, time.Now().Add(time.Hour))
+	database.CreateResumeToken("tok3", alice, time.Now().Add(time.Hour))
+	if n, err := database.RevokeResumeTokens("alice"); err != nil || n != 2 {
+		t.Errorf("revoked %d, %v; want 2", n, err)
+	}
+	if s, _ := database.ConsumeResumeToken("tok2"); s != nil {
+		t.Errorf("revoked token was accepted")
+	}
+}
@@ -2,22 +2,39 @@ package db
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 )
 
 type Room struct {
-	ID               string         `json:"id"`
-	Name             string         `json:"name"`
-	Emoji            string         `json:"emoji"`
-	CreatedBy        string         `json:"createdBy"`
-	Public           bool           `json:"public"`
-	CreatedAt        time.Time      `json:"createdAt"`
-	UpdatedAt        time.Time      `json:"updatedAt"`
-	ParticipantCount int            `json:"participantCount,omitempty"`
-	LastMessage      *LastMessage   `json:"lastMessage,omitempty"`
-	UnreadCount      int            `json:"unreadCount,omitempty"`
-	Participants     []Participant  `json:"participants,omitempty"`
+	ID                   string        `json:"id"`
+	Name                 string        `json:"name"`
+	Emoji                string        `json:"emoji"`
+	AvatarURL            string        `json:"avatarUrl,omitempty"` // uploaded image, served from /media/
+	Description          string        `json:"description"`
+	Topic                string        `json:"topic"`
+	Type                 string        `json:"type"` // group, dm
+	CreatedBy            string        `json:"createdBy"`
+	Public               bool          `json:"public"`
+	Visibility           string        `json:"visibility"`
+	SlowModeSeconds      int           `json:"slowModeSeconds"`      // 0 = off
+	RequireApproval      bool          `json:"requireApproval"`      // invite joins need owner/admin approval
+	AgentChains          bool          `json:"agentChains"`          // agent replies may dispatch to other agents
+	Encrypted            bool          `json:"encrypted"`            // end-to-end encrypted, see e2ee.*
+	ContentFilter        string        `json:"contentFilter"`        // "" (off), reject or redact
+	MessageRetentionDays int           `json:"messageRetentionDays"` // older messages are pruned, 0 = keep all
+	CreatedAt            time.Time     `json:"createdAt"`
+	UpdatedAt            time.Time     `json:"updatedAt"`
+	ParticipantCount     int           `json:"participantCount,omitempty"`
+	LastMessage          *LastMessage  `json:"lastMessage,omitempty"`
+	UnreadCount          int           `json:"unreadCount,omitempty"`
+	Notifications        string        `json:"notifications,omitempty"` // caller's preference, see NotifyAll
+	Pinned               bool          `json:"pinned,omitempty"`        // pinned by the caller
+	Participants         []Participant `json:"participants,omitempty"`
 }
 
 type LastMessage struct {
@@ -34,11 +51,56 @@ type Participant struct {
 	IsAgent     bool   `json:"isAgent"`
 	IsOnline    bool   `json:"isOnline"`
 	Role        string `json:"role"`
+	// Presence status of an online human, see UserStatus
+	Status      string `json:"status,omitempty"`
+	StatusText  string `json:"statusText,omitempty"`
+	StatusEmoji string `json:"statusEmoji,omitempty"`
+	Pronouns    string `json:"pronouns,omitempty"` // humans only
+	// LastSeenAt is when a human participant last read or connected to the room
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
 	// Agent-specific fields
 	AgentID        string `json:"agentId,omitempty"`
 	OpenclawURL    string `json:"openclawUrl,omitempty"`
-	OpenclawToken  string `json:"-"` // never sent to clients
-	OpenclawAgentID string `json:"-"` // agent ID on the OpenClaw server
+	OpenclawToken   string `json:"-"`                 // never sent to clients
+	OpenclawAgentID string `json:"-"`                 // agent ID on the OpenClaw server
+	Persona         string `json:"persona,omitempty"` // system prompt sent ahead of every message
+	Backend         string `json:"backend,omitempty"` // "" or openclaw, openai, anthropic
+	Model           string `json:"model,omitempty"`
+	// What makes the agent respond besides an @mention, see TriggerMention
+	Trigger         string   `json:"trigger,omitempty"`
+	TriggerKeywords []string `json:"triggerKeywords,omitempty"` // regexes, for TriggerKeywords
+}
+
+// Agent trigger modes. An @mention always makes an agent respond; the other
+// modes add human messages it answers unprompted.
+const (
+	TriggerMention  = "mention"  // only when mentioned (the default)
+	TriggerAll      = "all"      // every human message
+	TriggerKeywords = "keywords" // human messages matching one of its keyword regexes
+	TriggerReplies  = "replies"  // human replies to one of its messages
+)
+
+// ValidTrigger reports whether t is a trigger mode ("" means TriggerMention).
+func ValidTrigger(t string) bool {
+	switch t {
+	case "", TriggerMention, TriggerAll, TriggerKeywords, TriggerReplies:
+		return true
+	}
+	return false
+}
+
+// roomColumns is the column list scanned by scanRoom; queries alias rooms as r.
+const roomColumns = `r.id, r.name, r.emoji, r.avatar_attachment_id, r.description, r.topic, r.type, r.created_by, r.public, r.visibility, r.slow_mode_seconds, r.require_approval, r.agent_chains, r.encrypted, r.content_filter, r.message_retention_days, r.created_at, r.updated_at`
+
+func scanRoom(row rowScanner, extra ...any) (Room, error) {
+	var r Room
+	var avatarID sql.NullString
+	dest := append([]any{&r.ID, &r.Name, &r.Emoji, &avatarID, &r.Description, &r.Topic, &r.Type, &r.CreatedBy, &r.Public, &r.Visibility, &r.SlowModeSeconds, &r.RequireApproval, &r.AgentChains, &r.Encrypted, &r.ContentFilter, &r.MessageRetentionDays, &r.CreatedAt, &r.UpdatedAt}, extra...)
+	err := row.Scan(dest...)
+	if avatarID.Valid {
+		r.AvatarURL = "/media/" + avatarID.String
+	}
+	return r, err
 }
 
 func nanoid() string {
@@ -49,6 +111,37 @@ func nanoid() string {
 
 const LobbyRoomID = "lobby"
 
+// Room visibility. Public and unlisted rooms can be joined by ID without an
+// invite; only public rooms appear in the directory.
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+)
+
+// ValidVisibility reports whether v is a known visibility setting.
+func ValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// Room types. An agent DM pairs one human with one agent, and every human
+// message goes to the agent without an @mention.
+const (
+	RoomTypeGroup   = "group"
+	RoomTypeDM      = "dm"
+	RoomTypeAgentDM = "agent_dm"
+)
+
+// IsDirectRoomType reports whether rooms of this type are one-to-one: always
+// private, no invites, no admins.
+func IsDirectRoomType(roomType string) bool {
+	return roomType == RoomTypeDM || roomType == RoomTypeAgentDM
+}
+
 // EnsureLobby creates the default public lobby room if it doesn't already exist.
 func (db *DB) EnsureLobby() error {
 	var count int
@@ -62,15 +155,16 @@ func (db *DB) EnsureLobby() error {
 
 	// Ensure system user exists (needed for foreign key on created_by)
 	_, _ = db.Exec(`
-		INSERT OR IGNORE INTO users (id, public_key, display_name, avatar_emoji)
+		INSERT INTO users (id, public_key, display_name, avatar_emoji)
 		VALUES ('system', 'system', 'System', '🤖')
+		ON CONFLICT DO NOTHING
 	`)
 
 	now := time.Now().UTC()
 	_, err = db.Exec(`
-		INSERT INTO rooms (id, name, emoji, created_by, public, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, LobbyRoomID, "Lobby", "🏠", "system", true, now, now)
+		INSERT INTO rooms (id, name, emoji, created_by, public, visibility, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, LobbyRoomID, "Lobby", "🏠", "system", true, VisibilityPublic, now, now)
 	return err
 }
 
@@ -88,12 +182,22 @@ func (db *DB) EnsureLobbyAgent(agentID, openclawURL, openclawToken, openclawAgen
 
 
 func (db *DB) CreateRoom(name, emoji, createdBy string, public bool) (*Room, error) {
+	visibility := VisibilityPrivate
+	if public {
+		visibility = VisibilityPublic
+	}
+	return db.CreateRoomWithVisibility(name, emoji, createdBy, visibility)
+}
+
+// CreateRoomWithVisibility creates a group room owned by createdBy.
+func (db *DB) CreateRoomWithVisibility(name, emoji, createdBy, visibility string) (*Room, error) {
 	id := nanoid()
 	now := time.Now().UTC()
+	public := visibility != VisibilityPrivate
 	_, err := db.Exec(`
-		INSERT INTO rooms (id, name, emoji, created_by, public, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, id, name, emoji, createdBy, public, now, now)
+		INSERT INTO rooms (id, name, emoji, created_by, public, visibility, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, name, emoji, createdBy, public, visibility, now, now)
 	if err != nil {
 		return nil, err
 	}
@@ -107,25 +211,27 @@ func (db *DB) CreateRoom(name, emoji, createdBy string, public bool) (*Room, err
 	}
 
 	return &Room{
-		ID:        id,
-		Name:      name,
-		Emoji:     emoji,
-		CreatedBy: createdBy,
-		Public:    public,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:         id,
+		Name:       name,
+		Emoji:      emoji,
+		Type:       RoomTypeGroup,
+		CreatedBy:  createdBy,
+		Public:     public,
+		Visibility: visibility,
+		CreatedAt:  now,
+		UpdatedAt:  now,
 	}, nil
 }
 
 func (db *DB) GetRoom(id string) (*Room, error) {
-	r := &Room{}
-	err := db.QueryRow(`
-		SELECT id, name, emoji, created_by, public, created_at, updated_at
-		FROM rooms WHERE id = ?
-	`, id).Scan(&r.ID, &r.Name, &r.Emoji, &r.CreatedBy, &r.Public, &r.CreatedAt, &r.UpdatedAt)
+	room, err := scanRoom(db.QueryRow(`
+		SELECT `+roomColumns+`
+		FROM rooms r WHERE r.id = ?
+	`, id))
 	if err != nil {
 		return nil, err
 	}
+	r := &room
 
 	// Load participants
 	participants, err := db.GetParticipants(id)
@@ -143,11 +249,16 @@ func (db *DB) GetRoom(id string) (*Room, error) {
 
 func (db *DB) ListRoomsForUser(userID string) ([]Room, error) {
 	rows, err := db.Query(`
-		SELECT r.id, r.name, r.emoji, r.created_by, r.public, r.created_at, r.updated_at,
-		       (SELECT COUNT(*) FROM participants WHERE room_id = r.id) as participant_count
+		SELECT `+roomColumns+`,
+		       (SELECT COUNT(*) FROM participants WHERE room_id = r.id) as participant_count,
+		       COALESCE(rp.notifications, 'all'),
+		       rp.pinned_at IS NOT NULL as pinned,
+		       `+lastMessageColumns+`
 		FROM rooms r
 		JOIN participants p ON p.room_id = r.id AND p.user_id = ?
-		ORDER BY r.updated_at DESC
+		LEFT JOIN room_preferences rp ON rp.room_id = r.id AND rp.user_id = p.user_id
+		`+lastMessageJoin+`
+		ORDER BY pinned DESC, r.updated_at DESC
 	`, userID)
 	if err != nil {
 		return nil, err
@@ -156,11 +267,18 @@ func (db *DB) ListRoomsForUser(userID string) ([]Room, error) {
 
 	var rooms []Room
 	for rows.Next() {
-		var r Room
-		if err := rows.Scan(&r.ID, &r.Name, &r.Emoji, &r.CreatedBy, &r.Public, &r.CreatedAt, &r.UpdatedAt, &r.ParticipantCount); err != nil {
+		var count int
+		var notifications string
+		var pinned bool
+		var last lastMessageRow
+		r, err := scanRoom(rows, append([]any{&count, &notifications, &pinned}, last.dest()...)...)
+		if err != nil {
 			continue
 		}
-		r.LastMessage, _ = db.getLastMessage(r.ID)
+		r.ParticipantCount = count
+		r.Notifications = notifications
+		r.Pinned = pinned
+		r.LastMessage = last.message()
 		rooms = append(rooms, r)
 	}
 	return rooms, nil
@@ -168,10 +286,12 @@ func (db *DB) ListRoomsForUser(userID string) ([]Room, error) {
 
 func (db *DB) ListPublicRooms() ([]Room, error) {
 	rows, err := db.Query(`
-		SELECT r.id, r.name, r.emoji, r.created_by, r.public, r.created_at, r.updated_at,
-		       (SELECT COUNT(*) FROM participants WHERE room_id = r.id) as participant_count
+		SELECT ` + roomColumns + `,
+		       (SELECT COUNT(*) FROM participants WHERE room_id = r.id) as participant_count,
+		       ` + lastMessageColumns + `
 		FROM rooms r
-		WHERE r.public = 1
+		` + lastMessageJoin + `
+		WHERE r.visibility = 'public'
 		ORDER BY r.updated_at DESC
 	`)
 	if err != nil {
@@ -181,11 +301,14 @@ func (db *DB) ListPublicRooms() ([]Room, error) {
 
 	var rooms []Room
 	for rows.Next() {
-		var r Room
-		if err := rows.Scan(&r.ID, &r.Name, &r.Emoji, &r.CreatedBy, &r.Public, &r.CreatedAt, &r.UpdatedAt, &r.ParticipantCount); err != nil {
+		var count int
+		var last lastMessageRow
+		r, err := scanRoom(rows, append([]any{&count}, last.dest()...)...)
+		if err != nil {
 			continue
 		}
-		r.LastMessage, _ = db.getLastMessage(r.ID)
+		r.ParticipantCount = count
+		r.LastMessage = last.message()
 		rooms = append(rooms, r)
 	}
 	return rooms, nil
@@ -197,25 +320,259 @@ func (db *DB) IsRoomPublic(roomID string) (bool, error) {
 	return public, err
 }
 
+// UpdateRoom changes the given room fields; nil fields are left as they are.
+func (db *DB) UpdateRoom(id string, name, emoji, description *string) error {
+	sets := []string{"updated_at = ?"}
+	args := []any{time.Now().UTC()}
+	if name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *name)
+	}
+	if emoji != nil {
+		sets = append(sets, "emoji = ?")
+		args = append(args, *emoji)
+	}
+	if description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *description)
+	}
+	args = append(args, id)
+	_, err := db.Exec(`UPDATE rooms SET `+strings.Join(sets, ", ")+` WHERE id = ?`, args...)
+	return err
+}
+
+// DeleteRoom removes a room and everything that belongs to it. Foreign keys
+// would cascade most of this, but deleting explicitly keeps the cleanup
+// independent of the connection's foreign_keys setting. Blob ref counts are
+// released by the attachments triggers; the media GC reclaims the files.
+func (db *DB) DeleteRoom(id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteRoom(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteRoom is DeleteRoom within tx.
+func deleteRoom(tx *Tx, id string) error {
+	for _, stmt := range []string{
+		`DELETE FROM attachments WHERE room_id = ?`,
+		`DELETE FROM message_stars WHERE message_id IN (SELECT id FROM messages WHERE room_id = ?)`,
+		`DELETE FROM message_translations WHERE message_id IN (SELECT id FROM messages WHERE room_id = ?)`,
+		`DELETE FROM messages WHERE room_id = ?`,
+		`DELETE FROM invite_codes WHERE room_id = ?`,
+		`DELETE FROM room_topic_changes WHERE room_id = ?`,
+		`DELETE FROM room_preferences WHERE room_id = ?`,
+		`DELETE FROM audit_log WHERE room_id = ?`,
+		`DELETE FROM join_requests WHERE room_id = ?`,
+		`DELETE FROM agent_sessions WHERE room_id = ?`,
+		`DELETE FROM agent_schedules WHERE room_id = ?`,
+		`DELETE FROM agent_usage WHERE room_id = ?`,
+		`DELETE FROM agent_memory WHERE room_id = ?`,
+		`DELETE FROM room_key_envelopes WHERE room_id = ?`,
+		`DELETE FROM participants WHERE room_id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("delete room: %w", err)
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM rooms WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete room: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetRoomVisibility changes who can find and join a room.
+func (db *DB) SetRoomVisibility(roomID, visibility string) error {
+	_, err := db.Exec(`UPDATE rooms SET visibility = ?, public = ? WHERE id = ?`,
+		visibility, visibility != VisibilityPrivate, roomID)
+	return err
+}
+
+// ListDirectory returns public rooms whose name matches query, most recently
+// active first. Rooms' updated_at is bumped on every message, so it doubles
+// as last activity.
+func (db *DB) ListDirectory(query string, limit, offset int) ([]Room, error) {
+	rows, err := db.Query(`
+		SELECT `+roomColumns+`,
+		       (SELECT COUNT(*) FROM participants WHERE room_id = r.id) as participant_count,
+		       `+lastMessageColumns+`
+		FROM rooms r
+		`+lastMessageJoin+`
+		WHERE r.visibility = 'public' AND (? = '' OR r.name `+db.dialect.ilike+` '%' || ? || '%')
+		ORDER BY r.updated_at DESC
+		LIMIT ? OFFSET ?
+	`, query, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list directory: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := []Room{}
+	for rows.Next() {
+		var count int
+		var last lastMessageRow
+		r, err := scanRoom(rows, append([]any{&count}, last.dest()...)...)
+		if err != nil {
+			return nil, fmt.Errorf("scan room: %w", err)
+		}
+		r.ParticipantCount = count
+		r.LastMessage = last.message()
+		rooms = append(rooms, r)
+	}
+	return rooms, rows.Err()
+}
+
+// SetRoomAvatar sets the room's avatar to an uploaded attachment, or clears
+// it when attachmentID is nil.
+func (db *DB) SetRoomAvatar(roomID string, attachmentID *string) error {
+	_, err := db.Exec(`UPDATE rooms SET avatar_attachment_id = ?, updated_at = ? WHERE id = ?`,
+		attachmentID, time.Now().UTC(), roomID)
+	return err
+}
+
+// SetRoomRequireApproval turns the join approval flow on or off.
+func (db *DB) SetRoomRequireApproval(roomID string, require bool) error {
+	_, err := db.Exec(`UPDATE rooms SET require_approval = ? WHERE id = ?`, require, roomID)
+	return err
+}
+
+// RoomRequiresApproval reports whether invite joins must be approved.
+func (db *DB) RoomRequiresApproval(roomID string) (bool, error) {
+	var require bool
+	err := db.QueryRow(`SELECT require_approval FROM rooms WHERE id = ?`, roomID).Scan(&require)
+	return require, err
+}
+
+// SetRoomAgentChains allows or forbids agent-to-agent @mention chains.
+func (db *DB) SetRoomAgentChains(roomID string, enabled bool) error {
+	_, err := db.Exec(`UPDATE rooms SET agent_chains = ? WHERE id = ?`, enabled, roomID)
+	return err
+}
+
+// RoomAllowsAgentChains reports whether agents' replies may dispatch to other agents.
+func (db *DB) RoomAllowsAgentChains(roomID string) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`SELECT agent_chains FROM rooms WHERE id = ?`, roomID).Scan(&enabled)
+	return enabled, err
+}
+
+// SetRoomEncrypted turns on end-to-end encryption. It can't be turned off:
+// the server can't decrypt the history.
+func (db *DB) SetRoomEncrypted(roomID string) error {
+	_, err := db.Exec(`UPDATE rooms SET encrypted = 1 WHERE id = ?`, roomID)
+	return err
+}
+
+// IsRoomEncrypted reports whether roomID holds end-to-end encrypted messages.
+func (db *DB) IsRoomEncrypted(roomID string) (bool, error) {
+	var encrypted bool
+	err := db.QueryRow(`SELECT encrypted FROM rooms WHERE id = ?`, roomID).Scan(&encrypted)
+	return encrypted, err
+}
+
+// Content filter modes for a room's messages, see rpc.MessageFilter.
+const (
+	ContentFilterOff    = ""
+	ContentFilterReject = "reject" // refuse messages that trip the filter
+	ContentFilterRedact = "redact" // store them with the matches masked
+)
+
+func ValidContentFilter(mode string) bool {
+	return mode == ContentFilterOff || mode == ContentFilterReject || mode == ContentFilterRedact
+}
+
+func (db *DB) SetRoomContentFilter(roomID, mode string) error {
+	_, err := db.Exec(`UPDATE rooms SET content_filter = ? WHERE id = ?`, mode, roomID)
+	return err
+}
+
+func (db *DB) GetRoomContentFilter(roomID string) (string, error) {
+	var mode string
+	err := db.QueryRow(`SELECT content_filter FROM rooms WHERE id = ?`, roomID).Scan(&mode)
+	return mode, err
+}
+
+// MaxMessageRetentionDays bounds a room's message retention setting.
+const MaxMessageRetentionDays = 3650
+
+// SetRoomMessageRetention sets how many days of messages the room keeps
+// (0 keeps them all); see PruneRoomMessages.
+func (db *DB) SetRoomMessageRetention(roomID string, days int) error {
+	_, err := db.Exec(`UPDATE rooms SET message_retention_days = ? WHERE id = ?`, days, roomID)
+	return err
+}
+
+// SetRoomSlowMode sets the minimum interval between messages per user (0 disables).
+func (db *DB) SetRoomSlowMode(roomID string, seconds int) error {
+	_, err := db.Exec(`UPDATE rooms SET slow_mode_seconds = ? WHERE id = ?`, seconds, roomID)
+	return err
+}
+
+// GetRoomSlowMode returns the room's slow mode interval in seconds (0 = off).
+func (db *DB) GetRoomSlowMode(roomID string) (int, error) {
+	var seconds int
+	err := db.QueryRow(`SELECT slow_mode_seconds FROM rooms WHERE id = ?`, roomID).Scan(&seconds)
+	return seconds, err
+}
+
 func (db *DB) getLastMessage(roomID string) (*LastMessage, error) {
 	lm := &LastMessage{}
 	err := db.QueryRow(`
 		SELECT content, sender_display_name, sender_emoji, created_at
-		FROM messages WHERE room_id = ? ORDER BY created_at DESC LIMIT 1
+		FROM messages WHERE room_id = ? ORDER BY seq DESC LIMIT 1
 	`, roomID).Scan(&lm.Content, &lm.SenderName, &lm.SenderEmoji, &lm.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	// Truncate content for preview
-	if len(lm.Content) > 100 {
-		lm.Content = lm.Content[:100] + "…"
-	}
+	lm.Content = truncatePreview(lm.Content, 100)
 	return lm, nil
 }
 
+// lastMessageJoin joins each room r to its latest message, lm, in the
+// queries that list rooms, so they don't look it up room by room.
+// idx_messages_room_seq answers the MAX from the index.
+const lastMessageJoin = `LEFT JOIN messages lm ON lm.room_id = r.id AND lm.seq = (SELECT MAX(seq) FROM messages WHERE room_id = r.id)`
+
+// lastMessageColumns are the lm columns scanned by lastMessageRow.
+const lastMessageColumns = `lm.content, lm.sender_display_name, lm.sender_emoji, lm.created_at`
+
+// lastMessageRow scans lastMessageColumns, which are NULL for rooms
+// without messages.
+type lastMessageRow struct {
+	content, senderName, senderEmoji, createdAt sql.NullString
+}
+
+func (l *lastMessageRow) dest() []any {
+	return []any{&l.content, &l.senderName, &l.senderEmoji, &l.createdAt}
+}
+
+func (l *lastMessageRow) message() *LastMessage {
+	if !l.content.Valid {
+		return nil
+	}
+	return &LastMessage{
+		Content:     truncatePreview(l.content.String, 100),
+		SenderName:  l.senderName.String,
+		SenderEmoji: l.senderEmoji.String,
+		CreatedAt:   l.createdAt.String,
+	}
+}
+
 func (db *DB) AddParticipant(roomID, userID, role string) error {
 	_, err := db.Exec(`
-		INSERT OR IGNORE INTO participants (room_id, user_id, role) VALUES (?, ?, ?)
+		INSERT INTO participants (room_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
 	`, roomID, userID, role)
 	return err
 }
@@ -229,12 +586,32 @@ func (db *DB) RemoveParticipant(roomID, userID string) error {
 
 func (db *DB) AddAgentParticipant(roomID, agentID, openclawURL, openclawToken, openclawAgentID, agentName, agentEmoji string) error {
 	_, err := db.Exec(`
-		INSERT OR IGNORE INTO participants (room_id, agent_id, openclaw_url, openclaw_token, openclaw_agent_id, agent_name, agent_emoji, role)
+		INSERT INTO participants (room_id, agent_id, openclaw_url, openclaw_token, openclaw_agent_id, agent_name, agent_emoji, role)
 		VALUES (?, ?, ?, ?, ?, ?, ?, 'member')
+		ON CONFLICT DO NOTHING
 	`, roomID, agentID, openclawURL, openclawToken, openclawAgentID, agentName, agentEmoji)
 	return err
 }
 
+// UpdateAgentParticipant changes an agent's name, emoji, or persona; nil
+// fields are left alone. Returns sql.ErrNoRows if the agent isn't in the room.
+func (db *DB) UpdateAgentParticipant(roomID, agentID, openclawURL string, name, emoji, persona *string) error {
+	result, err := db.Exec(`
+		UPDATE participants
+		SET agent_name = COALESCE(?, agent_name),
+		    agent_emoji = COALESCE(?, agent_emoji),
+		    agent_persona = COALESCE(?, agent_persona)
+		WHERE room_id = ? AND agent_id = ? AND openclaw_url = ?
+	`, name, emoji, persona, roomID, agentID, openclawURL)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (db *DB) RemoveAgentParticipant(roomID, agentID, openclawURL string) error {
 	_, err := db.Exec(`
 		DELETE FROM participants WHERE room_id = ? AND agent_id = ? AND openclaw_url = ?
@@ -242,26 +619,101 @@ func (db *DB) RemoveAgentParticipant(roomID, agentID, openclawURL string) error
 	return err
 }
 
+// SetAgentTrigger sets what makes an agent respond besides an @mention.
+func (db *DB) SetAgentTrigger(roomID, agentID, openclawURL, trigger string, keywords []string) error {
+	var keywordsJSON *string
+	if len(keywords) > 0 {
+		data, _ := json.Marshal(keywords)
+		s := string(data)
+		keywordsJSON = &s
+	}
+	result, err := db.Exec(`
+		UPDATE participants SET agent_trigger = ?, agent_trigger_keywords = ?
+		WHERE room_id = ? AND agent_id = ? AND openclaw_url = ?
+	`, trigger, keywordsJSON, roomID, agentID, openclawURL)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// parseKeywords decodes the agent_trigger_keywords column.
+func parseKeywords(raw *string) []string {
+	if raw == nil || *raw == "" {
+		return nil
+	}
+	var keywords []string
+	json.Unmarshal([]byte(*raw), &keywords)
+	return keywords
+}
+
+// SetAgentBackend chooses how an agent's replies are generated. The API key
+// for direct backends is kept in openclaw_token.
+func (db *DB) SetAgentBackend(roomID, agentID, openclawURL, backend, model string) error {
+	_, err := db.Exec(`
+		UPDATE participants SET agent_backend = ?, agent_model = ?
+		WHERE room_id = ? AND agent_id = ? AND openclaw_url = ?
+	`, backend, model, roomID, agentID, openclawURL)
+	return err
+}
+
 func (db *DB) GetAgentParticipant(roomID, agentID, openclawURL string) (*Participant, error) {
 	var p Participant
 	var openclawToken string
+	var keywords *string
 	err := db.QueryRow(`
-		SELECT agent_id, openclaw_url, openclaw_token, agent_name, COALESCE(agent_emoji, ''), role
+		SELECT agent_id, openclaw_url, openclaw_token, agent_name, COALESCE(agent_emoji, ''), COALESCE(agent_persona, ''),
+		       COALESCE(agent_backend, ''), COALESCE(agent_model, ''), COALESCE(agent_trigger, ''), agent_trigger_keywords, role
 		FROM participants
 		WHERE room_id = ? AND agent_id = ? AND openclaw_url = ?
-	`, roomID, agentID, openclawURL).Scan(&p.AgentID, &p.OpenclawURL, &openclawToken, &p.DisplayName, &p.Emoji, &p.Role)
+	`, roomID, agentID, openclawURL).Scan(&p.AgentID, &p.OpenclawURL, &openclawToken, &p.DisplayName, &p.Emoji, &p.Persona, &p.Backend, &p.Model, &p.Trigger, &keywords, &p.Role)
 	if err != nil {
 		return nil, err
 	}
+	p.TriggerKeywords = parseKeywords(keywords)
 	p.ID = "agent:" + agentID + "@" + openclawURL
 	p.IsAgent = true
 	return &p, nil
 }
 
+// AgentInRoom is an agent participant together with its room.
+type AgentInRoom struct {
+	RoomID      string
+	AgentID     string
+	DisplayName string
+}
+
+// ListAgentsByOpenclaw returns every agent participant served by the OpenClaw
+// server at openclawURL with the given token.
+func (db *DB) ListAgentsByOpenclaw(openclawURL, openclawToken string) ([]AgentInRoom, error) {
+	rows, err := db.Query(`
+		SELECT room_id, agent_id, COALESCE(agent_name, agent_id)
+		FROM participants
+		WHERE agent_id IS NOT NULL AND openclaw_url = ? AND openclaw_token = ?
+	`, openclawURL, openclawToken)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agents []AgentInRoom
+	for rows.Next() {
+		var a AgentInRoom
+		if err := rows.Scan(&a.RoomID, &a.AgentID, &a.DisplayName); err != nil {
+			return nil, err
+		}
+		agents = append(agents, a)
+	}
+	return agents, rows.Err()
+}
+
 func (db *DB) GetParticipants(roomID string) ([]Participant, error) {
 	rows, err := db.Query(`
-		SELECT p.user_id, p.agent_id, p.openclaw_url, p.openclaw_token, p.openclaw_agent_id, p.agent_name, p.agent_emoji, p.role,
-		       COALESCE(u.display_name, ''), COALESCE(u.avatar_emoji, '')
+		SELECT p.user_id, p.agent_id, p.openclaw_url, p.openclaw_token, p.openclaw_agent_id, p.agent_name, p.agent_emoji, p.agent_persona,
+		       p.agent_backend, p.agent_model, p.agent_trigger, p.agent_trigger_keywords, p.role, COALESCE(u.display_name, ''), COALESCE(u.avatar_emoji, ''), COALESCE(u.pronouns, ''), p.last_seen_at
 		FROM participants p
 		LEFT JOIN users u ON u.id = p.user_id
 		WHERE p.room_id = ?
@@ -273,8 +725,9 @@ func (db *DB) GetParticipants(roomID string) ([]Participant, error) {
 
 	var participants []Participant
 	for rows.Next() {
-		var userID, agentID, openclawURL, openclawToken, openclawAgentID, agentName, agentEmoji, role, userName, userEmoji *string
-		if err := rows.Scan(&userID, &agentID, &openclawURL, &openclawToken, &openclawAgentID, &agentName, &agentEmoji, &role, &userName, &userEmoji); err != nil {
+		var userID, agentID, openclawURL, openclawToken, openclawAgentID, agentName, agentEmoji, agentPersona, agentBackend, agentModel, agentTrigger, agentKeywords, role, userName, userEmoji, userPronouns *string
+		var lastSeen *time.Time
+		if err := rows.Scan(&userID, &agentID, &openclawURL, &openclawToken, &openclawAgentID, &agentName, &agentEmoji, &agentPersona, &agentBackend, &agentModel, &agentTrigger, &agentKeywords, &role, &userName, &userEmoji, &userPronouns, &lastSeen); err != nil {
 			continue
 		}
 
@@ -288,11 +741,18 @@ func (db *DB) GetParticipants(roomID string) ([]Participant, error) {
 			p.OpenclawURL = deref(openclawURL)
 			p.OpenclawToken = deref(openclawToken)
 			p.OpenclawAgentID = deref(openclawAgentID)
+			p.Persona = deref(agentPersona)
+			p.Backend = deref(agentBackend)
+			p.Model = deref(agentModel)
+			p.Trigger = deref(agentTrigger)
+			p.TriggerKeywords = parseKeywords(agentKeywords)
 		} else if userID != nil {
 			p.ID = *userID
 			p.DisplayName = deref(userName)
 			p.Emoji = deref(userEmoji)
+			p.Pronouns = deref(userPronouns)
 			p.IsAgent = false
+			p.LastSeenAt = lastSeen
 		}
 		participants = append(participants, p)
 	}
@@ -315,6 +775,81 @@ func (db *DB) GetParticipantRole(roomID, userID string) (string, error) {
 	return role, err
 }
 
+// Participant roles, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleGuest  = "guest" // read-only
+)
+
+var roleRank = map[string]int{RoleOwner: 4, RoleAdmin: 3, RoleMember: 2, RoleGuest: 1}
+
+// RoleAtLeast reports whether role grants at least the privileges of min.
+func RoleAtLeast(role, min string) bool {
+	return roleRank[role] >= roleRank[min] && roleRank[role] > 0
+}
+
+// SetParticipantRole changes a human participant's role. Ownership moves
+// only through TransferOwnership.
+func (db *DB) SetParticipantRole(roomID, userID, role string) error {
+	result, err := db.Exec(`
+		UPDATE participants SET role = ? WHERE room_id = ? AND user_id = ? AND role != 'owner'
+	`, role, roomID, userID)
+	if err != nil {
+		return fmt.Errorf("set role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TransferOwnership makes toUserID the room owner and demotes the current
+// owner to admin.
+func (db *DB) TransferOwnership(roomID, fromUserID, toUserID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE participants SET role = 'admin' WHERE room_id = ? AND user_id = ? AND role = 'owner'
+	`, roomID, fromUserID)
+	if err != nil {
+		return fmt.Errorf("demote owner: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	result, err = tx.Exec(`
+		UPDATE participants SET role = 'owner' WHERE room_id = ? AND user_id = ?
+	`, roomID, toUserID)
+	if err != nil {
+		return fmt.Errorf("promote owner: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// LongestStandingAdmin returns the admin who joined the room first, or ""
+// if there is none.
+func (db *DB) LongestStandingAdmin(roomID string) (string, error) {
+	var userID string
+	err := db.QueryRow(`
+		SELECT user_id FROM participants
+		WHERE room_id = ? AND role = 'admin' AND user_id IS NOT NULL
+		ORDER BY joined_at, id LIMIT 1
+	`, roomID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
 // UpgradeAgentCredentials updates an existing chat-api agent participant with
 // OpenClaw credentials so the server can call the agent via WebSocket on @mentions.
 // If oldAgentID differs from newAgentID, the agent_id is also updated.
@@ -0,0 +1,325 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteRoomCleansUp(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid := "alice"
+
+	hash := "bb00000000000000000000000000000000000000000000000000000000000000"
+	if _, err := database.CreateAttachment("a1", room.ID, "alice", hash, 5, "x.txt", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.InsertMessage("m1", room.ID, &uid, nil, "Alice", "", "hi", "[]", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.AttachToMessage("m1", room.ID, "alice", []string{"a1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.DeleteRoom(room.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"messages", "participants", "attachments"} {
+		var n int
+		database.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE room_id = ?`, room.ID).Scan(&n)
+		if n != 0 {
+			t.Errorf("%s left behind: %d rows", table, n)
+		}
+	}
+	if hashes, _ := database.UnreferencedBlobs(); len(hashes) != 1 || hashes[0] != hash {
+		t.Errorf("unreferenced = %v, want [%s]", hashes, hash)
+	}
+
+	if err := database.DeleteRoom(room.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second delete err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestOpenDirectRoomIsIdempotent(t *testing.T) {
+	database := openTestDB(t)
+	for _, id := range []string{"alice", "bob"} {
+		if _, err := database.UpsertUser(id, "key", id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, created, err := database.OpenDirectRoom("alice", "bob", "alice & bob")
+	if err != nil || !created {
+		t.Fatalf("first open = %v, created=%v", err, created)
+	}
+	if first.Type != RoomTypeDM || first.ParticipantCount != 2 {
+		t.Fatalf("room = %+v", first)
+	}
+
+	// Opening from the other side finds the same room
+	second, created, err := database.OpenDirectRoom("bob", "alice", "bob & alice")
+	if err != nil || created {
+		t.Fatalf("second open = %v, created=%v", err, created)
+	}
+	if second.ID != first.ID {
+		t.Errorf("second open room = %s, want %s", second.ID, first.ID)
+	}
+}
+
+func TestOpenAgentDirectRoomRejoins(t *testing.T) {
+	database := openTestDB(t)
+	if _, err := database.UpsertUser("alice", "key", "Alice", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	room, created, err := database.OpenAgentDirectRoom("alice", "mave", "wss://oc.example", "tok", "Mave", "🌊", "Alice & Mave")
+	if err != nil || !created {
+		t.Fatalf("first open = %v, created=%v", err, created)
+	}
+	if room.Type != RoomTypeAgentDM || room.ParticipantCount != 2 {
+		t.Fatalf("room = %+v", room)
+	}
+
+	if err := database.RemoveParticipant(room.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	again, created, err := database.OpenAgentDirectRoom("alice", "mave", "wss://oc.example", "tok", "Mave", "🌊", "Alice & Mave")
+	if err != nil || created || again.ID != room.ID {
+		t.Fatalf("reopen = %v, created=%v, id=%s", err, created, again.ID)
+	}
+	if ok, _ := database.IsParticipant(room.ID, "alice"); !ok {
+		t.Error("alice was not added back")
+	}
+}
+
+func TestTransferOwnership(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	if _, err := database.UpsertUser("bob", "key", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.AddParticipant(room.ID, "bob", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.SetParticipantRole(room.ID, "alice", RoleMember); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("demoting owner err = %v, want sql.ErrNoRows", err)
+	}
+	if err := database.TransferOwnership(room.ID, "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := database.GetParticipantRole(room.ID, "bob"); role != RoleOwner {
+		t.Errorf("bob role = %q, want owner", role)
+	}
+	if role, _ := database.GetParticipantRole(room.ID, "alice"); role != RoleAdmin {
+		t.Errorf("alice role = %q, want admin", role)
+	}
+}
+
+func TestListDirectoryOnlyListsPublicRooms(t *testing.T) {
+	database := openTestDB(t)
+	createTestRoom(t, database)
+	for _, v := range []string{VisibilityPublic, VisibilityUnlisted} {
+		if _, err := database.CreateRoomWithVisibility("Team "+v, "", "alice", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rooms, err := database.ListDirectory("team", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Name != "Team public" {
+		t.Fatalf("directory = %+v, want only Team public", rooms)
+	}
+	if !rooms[0].Public || rooms[0].ParticipantCount != 1 {
+		t.Errorf("room = %+v", rooms[0])
+	}
+}
+
+func TestListRoomsForUserPinnedFirst(t *testing.T) {
+	database := openTestDB(t)
+	older := createTestRoom(t, database)
+	newer, err := database.CreateRoom("Newer", "", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.Exec(`UPDATE rooms SET updated_at = datetime('now', '-1 hour') WHERE id = ?`, older.ID)
+
+	if err := database.SetRoomPinned(older.ID, "alice", true); err != nil {
+		t.Fatal(err)
+	}
+	rooms, err := database.ListRoomsForUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 || rooms[0].ID != older.ID || !rooms[0].Pinned || rooms[1].ID != newer.ID {
+		t.Fatalf("rooms = %+v, want pinned %s first", rooms, older.ID)
+	}
+
+	if err := database.SetRoomPinned(older.ID, "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	rooms, _ = database.ListRoomsForUser("alice")
+	if rooms[0].ID != newer.ID {
+		t.Errorf("after unpin first room = %s, want %s", rooms[0].ID, newer.ID)
+	}
+}
+
+func TestLongestStandingAdmin(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	if id, _ := database.LongestStandingAdmin(room.ID); id != "" {
+		t.Fatalf("no admins yet, got %q", id)
+	}
+
+	for _, id := range []string{"bob", "carol"} {
+		database.UpsertUser(id, "key", id, "")
+		if err := database.AddParticipant(room.ID, id, RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.Exec(`UPDATE participants SET joined_at = datetime('now', '-1 day') WHERE user_id = 'carol'`)
+
+	if id, _ := database.LongestStandingAdmin(room.ID); id != "carol" {
+		t.Errorf("longest-standing admin = %q, want carol", id)
+	}
+}
+
+func TestListAgentsByOpenclaw(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+
+	database.AddAgentParticipant(room.ID, "mave", "wss://a.example", "tok", "main", "Mave", "")
+	database.AddAgentParticipant(room.ID, "other", "wss://b.example", "tok", "main", "Other", "")
+
+	agents, err := database.ListAgentsByOpenclaw("wss://a.example", "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].AgentID != "mave" || agents[0].RoomID != room.ID {
+		t.Errorf("agents = %+v, want mave in %s", agents, room.ID)
+	}
+}
+
+func TestUpdateAgentParticipantPersona(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	database.AddAgentParticipant(room.ID, "mave", "wss://oc.example", "tok", "main", "Mave", "🌊")
+
+	persona := "You are terse."
+	if err := database.UpdateAgentParticipant(room.ID, "mave", "wss://oc.example", nil, nil, &persona); err != nil {
+		t.Fatal(err)
+	}
+	agent, err := database.GetAgentParticipant(room.ID, "mave", "wss://oc.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agent.Persona != persona || agent.DisplayName != "Mave" {
+		t.Errorf("agent = %+v, want persona set and name unchanged", agent)
+	}
+
+	if err := database.UpdateAgentParticipant(room.ID, "ghost", "wss://oc.example", nil, nil, &persona); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing agent err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSetAgentTrigger(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	database.AddAgentParticipant(room.ID, "mave", "wss://oc.example", "tok", "main", "Mave", "🌊")
+
+	if err := database.SetAgentTrigger(room.ID, "mave", "wss://oc.example", TriggerKeywords, []string{"deploy", `bug\s+\d+`}); err != nil {
+		t.Fatal(err)
+	}
+	participants, err := database.GetParticipants(room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range participants {
+		if p.AgentID == "mave" && (p.Trigger != TriggerKeywords || len(p.TriggerKeywords) != 2) {
+			t.Errorf("agent = %+v, want keywords trigger with 2 keywords", p)
+		}
+	}
+
+	if err := database.SetAgentTrigger(room.ID, "mave", "wss://oc.example", TriggerMention, nil); err != nil {
+		t.Fatal(err)
+	}
+	agent, _ := database.GetAgentParticipant(room.ID, "mave", "wss://oc.example")
+	if agent.Trigger != TriggerMention || agent.TriggerKeywords != nil {
+		t.Errorf("agent = %+v, want mention trigger without keywords", agent)
+	}
+}
+
+func TestListRoomsForUserLastMessage(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	empty, err := database.CreateRoom("Empty", "", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"first", "second"} {
+		if _, err := database.InsertMessage(nanoid(), room.ID, nil, nil, "Alice", "🙂", content, "[]", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rooms, err := database.ListRoomsForUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := database.getLastMessage(room.ID)
+	for _, r := range rooms {
+		switch r.ID {
+		case room.ID:
+			if r.LastMessage == nil || *r.LastMessage != *want {
+				t.Errorf("last message = %+v, want %+v", r.LastMessage, want)
+			}
+		case empty.ID:
+			if r.LastMessage != nil {
+				t.Errorf("empty room last message = %+v, want nil", r.LastMessage)
+			}
+		}
+	}
+}
+
+// setupManyRooms puts alice in n rooms with a few messages each.
+func setupManyRooms(b *testing.B, n int) *DB {
+	b.Helper()
+	dir := b.TempDir()
+	database, err := Open(filepath.Join(dir, "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { database.Close() })
+	if _, err := database.UpsertUser("alice", "key", "Alice", ""); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		room, err := database.CreateRoom(fmt.Sprintf("Room %d", i), "", "alice", false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 5; j++ {
+			if _, err := database.InsertMessage(nanoid(), room.ID, nil, nil, "Alice", "", "hello", "[]", nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return database
+}
+
+func BenchmarkListRoomsForUser250(b *testing.B) {
+	database := setupManyRooms(b, 250)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rooms, err := database.ListRoomsForUser("alice")
+		if err != nil || len(rooms) != 250 {
+			b.Fatalf("rooms = %d, err = %v", len(rooms), err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AgentSchedule is a prompt sent to an agent on a cron schedule.
+type AgentSchedule struct {
+	ID        string     `json:"id"`
+	RoomID    string     `json:"roomId"`
+	AgentID   string     `json:"agentId"`
+	Cron      string     `json:"cron"`
+	Timezone  string     `json:"timezone"`
+	Prompt    string     `json:"prompt"`
+	CreatedBy string     `json:"createdBy"`
+	NextRunAt time.Time  `json:"nextRunAt"`
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+const scheduleColumns = `id, room_id, agent_id, cron, timezone, prompt, created_by, next_run_at, last_run_at, created_at`
+
+func scanSchedule(row rowScanner) (AgentSchedule, error) {
+	var s AgentSchedule
+	err := row.Scan(&s.ID, &s.RoomID, &s.AgentID, &s.Cron, &s.Timezone, &s.Prompt, &s.CreatedBy, &s.NextRunAt, &s.LastRunAt, &s.CreatedAt)
+	return s, err
+}
+
+func (d *DB) querySchedules(query string, args ...any) ([]AgentSchedule, error) {
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := []AgentSchedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan schedule: %w", err)
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// CreateSchedule saves a schedule whose first run is at nextRun.
+func (d *DB) CreateSchedule(roomID, agentID, cron, timezone, prompt, createdBy string, nextRun time.Time) (*AgentSchedule, error) {
+	s := &AgentSchedule{
+		ID:        nanoid(),
+		RoomID:    roomID,
+		AgentID:   agentID,
+		Cron:      cron,
+		Timezone:  timezone,
+		Prompt:    prompt,
+		CreatedBy: createdBy,
+		NextRunAt: nextRun.UTC(),
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err := d.Exec(`
+		INSERT INTO agent_schedules (`+scheduleColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL, ?)
+	`, s.ID, roomID, agentID, cron, timezone, prompt, createdBy, s.NextRunAt, s.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create schedule: %w", err)
+	}
+	return s, nil
+}
+
+// ListSchedules returns a room's schedules, soonest first.
+func (d *DB) ListSchedules(roomID string) ([]AgentSchedule, error) {
+	return d.querySchedules(`SELECT `+scheduleColumns+` FROM agent_schedules WHERE room_id = ? ORDER BY next_run_at`, roomID)
+}
+
+// DueSchedules returns schedules whose next run is at or before now.
+func (d *DB) DueSchedules(now time.Time) ([]AgentSchedule, error) {
+	return d.querySchedules(`SELECT `+scheduleColumns+` FROM agent_schedules WHERE next_run_at <= ? ORDER BY next_run_at`, now.UTC())
+}
+
+// MarkScheduleRun records a run and when the next one is due.
+func (d *DB) MarkScheduleRun(id string, ranAt, nextRun time.Time) error {
+	_, err := d.Exec(`UPDATE agent_schedules SET last_run_at = ?, next_run_at = ? WHERE id = ?`, ranAt.UTC(), nextRun.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("mark schedule run: %w", err)
+	}
+	return nil
+}
+
+// DeleteSchedule removes a schedule from roomID.
+func (d *DB) DeleteSchedule(id, roomID string) error {
+	result, err := d.Exec(`DELETE FROM agent_schedules WHERE id = ? AND room_id = ?`, id, roomID)
+	if err != nil {
+		return fmt.Errorf("delete schedule: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
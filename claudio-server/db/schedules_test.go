@@ -0,0 +1,45 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDueSchedules(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	now := time.Now().UTC()
+
+	due, err := database.CreateSchedule(room.ID, "bot", "0 9 * * *", "UTC", "standup", "alice", now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.CreateSchedule(room.ID, "bot", "0 9 * * *", "UTC", "later", "alice", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	schedules, err := database.DueSchedules(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].ID != due.ID {
+		t.Fatalf("due = %+v, want only %s", schedules, due.ID)
+	}
+
+	if err := database.MarkScheduleRun(due.ID, now, now.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if schedules, _ := database.DueSchedules(now); len(schedules) != 0 {
+		t.Errorf("due after run = %d, want 0", len(schedules))
+	}
+
+	if err := database.DeleteSchedule(due.ID, "other-room"); err == nil {
+		t.Error("deleted schedule from the wrong room")
+	}
+	if err := database.DeleteSchedule(due.ID, room.ID); err != nil {
+		t.Fatal(err)
+	}
+	if schedules, _ := database.ListSchedules(room.ID); len(schedules) != 1 {
+		t.Errorf("schedules left = %d, want 1", len(schedules))
+	}
+}
@@ -1,18 +1,41 @@
+-- Accounts. An account created by a device's first connect has that
+-- device's ID; more devices can be linked to it, see devices
 CREATE TABLE IF NOT EXISTS users (
-    id TEXT PRIMARY KEY,           -- SHA256(public_key) hex
+    id TEXT PRIMARY KEY,           -- SHA256(public_key) hex of the first device
     public_key TEXT NOT NULL,      -- base64url-encoded Ed25519 public key
     display_name TEXT NOT NULL DEFAULT '',
     avatar_emoji TEXT NOT NULL DEFAULT '',
+    bio TEXT NOT NULL DEFAULT '',
+    pronouns TEXT NOT NULL DEFAULT '',
+    timezone TEXT NOT NULL DEFAULT '',      -- IANA name, e.g. Europe/Berlin
+    -- Presence status kept across restarts (presence.set with persist)
+    status TEXT NOT NULL DEFAULT '',        -- '' (online), away, dnd
+    status_text TEXT NOT NULL DEFAULT '',
+    status_emoji TEXT NOT NULL DEFAULT '',
     created_at DATETIME NOT NULL DEFAULT (datetime('now')),
-    updated_at DATETIME NOT NULL DEFAULT (datetime('now'))
+    updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    deleted_at DATETIME                -- set by DeleteAccount; the row stays as a tombstone
 );
 
 CREATE TABLE IF NOT EXISTS rooms (
     id TEXT PRIMARY KEY,           -- nanoid
     name TEXT NOT NULL,
     emoji TEXT NOT NULL DEFAULT '',
+    avatar_attachment_id TEXT,     -- uploaded image shown instead of the emoji
+    description TEXT NOT NULL DEFAULT '',
+    topic TEXT NOT NULL DEFAULT '',
+    type TEXT NOT NULL DEFAULT 'group',  -- group, dm, agent_dm
+    dm_key TEXT,                         -- participant pair for dm and agent_dm rooms
     created_by TEXT NOT NULL REFERENCES users(id),
-    public BOOLEAN NOT NULL DEFAULT 0,
+    public BOOLEAN NOT NULL DEFAULT 0,   -- joinable without an invite; mirrors visibility != 'private'
+    visibility TEXT NOT NULL DEFAULT 'private',  -- private, unlisted, public (listed in the directory)
+    last_message_seq INTEGER NOT NULL DEFAULT 0,  -- last seq handed out in messages
+    slow_mode_seconds INTEGER NOT NULL DEFAULT 0, -- min seconds between messages per user, 0 = off
+    require_approval BOOLEAN NOT NULL DEFAULT 0,  -- invite joins wait for an owner/admin
+    agent_chains BOOLEAN NOT NULL DEFAULT 0,      -- agents' replies may @mention other agents
+    encrypted BOOLEAN NOT NULL DEFAULT 0,         -- end-to-end encrypted; content is client ciphertext
+    content_filter TEXT NOT NULL DEFAULT '',      -- '' (off), reject, redact; see rpc.MessageFilter
+    message_retention_days INTEGER NOT NULL DEFAULT 0,  -- older messages are pruned by maintenance, 0 = keep
     created_at DATETIME NOT NULL DEFAULT (datetime('now')),
     updated_at DATETIME NOT NULL DEFAULT (datetime('now'))
 );
@@ -29,8 +52,14 @@ CREATE TABLE IF NOT EXISTS participants (
     openclaw_agent_id TEXT,    -- agent ID on the OpenClaw server (may differ from agent_id)
     agent_name TEXT,
     agent_emoji TEXT,
-    role TEXT NOT NULL DEFAULT 'member',  -- owner, admin, member
+    agent_persona TEXT,        -- system prompt prepended to what the agent is sent
+    agent_backend TEXT,        -- openclaw (default), openai, anthropic
+    agent_model TEXT,          -- model for direct API backends
+    agent_trigger TEXT,        -- mention (default), all, keywords, replies
+    agent_trigger_keywords TEXT, -- JSON array of regexes for the keywords trigger
+    role TEXT NOT NULL DEFAULT 'member',  -- owner, admin, member, guest (read-only)
     joined_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    last_seen_at DATETIME,                -- last read or connection (humans only)
     UNIQUE(room_id, user_id),
     UNIQUE(room_id, agent_id, openclaw_url)
 );
@@ -38,6 +67,7 @@ CREATE TABLE IF NOT EXISTS participants (
 CREATE TABLE IF NOT EXISTS messages (
     id TEXT PRIMARY KEY,           -- nanoid
     room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL DEFAULT 0,         -- per-room monotonic sequence (pagination cursor)
     sender_user_id TEXT REFERENCES users(id),
     sender_agent_id TEXT,
     sender_display_name TEXT NOT NULL,
@@ -45,6 +75,8 @@ CREATE TABLE IF NOT EXISTS messages (
     content TEXT NOT NULL,
     mentions TEXT NOT NULL DEFAULT '[]',   -- JSON array of participant IDs
     reply_to TEXT,                          -- message id
+    kind TEXT NOT NULL DEFAULT 'user',      -- user, agent, system
+    blocks TEXT,                            -- JSON array of agent content blocks (images, tool calls)
     created_at DATETIME NOT NULL DEFAULT (datetime('now'))
 );
 
@@ -75,5 +107,302 @@ CREATE TABLE IF NOT EXISTS invite_codes (
     expires_at DATETIME,
     max_uses INTEGER NOT NULL DEFAULT 0,   -- 0 = unlimited
     use_count INTEGER NOT NULL DEFAULT 0,
+    role TEXT NOT NULL DEFAULT 'member',   -- role granted on join: member, or guest (read-only)
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    CHECK (max_uses = 0 OR use_count <= max_uses)
+);
+
+CREATE TABLE IF NOT EXISTS message_translations (
+    message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    language TEXT NOT NULL,            -- BCP 47 tag, lowercased
+    content TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (message_id, language)
+);
+
+CREATE TABLE IF NOT EXISTS blobs (
+    hash TEXT PRIMARY KEY,             -- hex SHA-256 of the content
+    size INTEGER NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0,  -- attachments referencing this blob (maintained by triggers)
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id TEXT PRIMARY KEY,
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id TEXT REFERENCES messages(id) ON DELETE CASCADE,  -- NULL until sent
+    blob_hash TEXT NOT NULL REFERENCES blobs(hash),
+    filename TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL DEFAULT 'application/octet-stream',
+    uploaded_by TEXT NOT NULL,         -- user ID, or agent:<agent ID> for agent images
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments(message_id);
+
+CREATE TRIGGER IF NOT EXISTS attachments_ref_inc AFTER INSERT ON attachments BEGIN
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE hash = NEW.blob_hash;
+END;
+
+CREATE TRIGGER IF NOT EXISTS attachments_ref_dec AFTER DELETE ON attachments BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = OLD.blob_hash;
+END;
+
+CREATE TABLE IF NOT EXISTS room_topic_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    topic TEXT NOT NULL,
+    set_by TEXT NOT NULL,              -- user ID
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_topic_changes_room ON room_topic_changes(room_id, id);
+
+CREATE TABLE IF NOT EXISTS room_preferences (
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    notifications TEXT NOT NULL DEFAULT 'all',  -- all, mentions, mute
+    pinned_at DATETIME,                         -- set when pinned to the top of the user's room list
+    updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS room_templates (
+    id TEXT PRIMARY KEY,
+    owner_id TEXT REFERENCES users(id) ON DELETE CASCADE,  -- NULL = server-wide (from config)
+    name TEXT NOT NULL,
+    config TEXT NOT NULL DEFAULT '{}',  -- JSON TemplateConfig
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_templates_owner ON room_templates(owner_id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    actor_id TEXT NOT NULL,            -- user ID (or guest ID) who performed the action
+    action TEXT NOT NULL,              -- see db.Audit* constants
+    target TEXT NOT NULL DEFAULT '',   -- affected user/agent ID, invite code, ...
+    details TEXT NOT NULL DEFAULT '{}',  -- JSON object
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_room ON audit_log(room_id, id);
+
+CREATE TABLE IF NOT EXISTS join_requests (
+    id TEXT PRIMARY KEY,
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    role TEXT NOT NULL DEFAULT 'member',   -- granted on approval (from the invite)
+    status TEXT NOT NULL DEFAULT 'pending',  -- pending, approved, denied
+    decided_by TEXT,
+    decided_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_join_requests_room ON join_requests(room_id, status);
+
+CREATE TABLE IF NOT EXISTS message_stars (
+    user_id TEXT NOT NULL REFERENCES users(id),
+    message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (user_id, message_id)
+);
+
+-- OpenClaw conversation per (room, agent); resetting swaps in a fresh key
+CREATE TABLE IF NOT EXISTS agent_sessions (
+    room_id TEXT NOT NULL REFERENCES rooms(id),
+    agent_id TEXT NOT NULL,
+    session_key TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (room_id, agent_id)
+);
+
+-- Prompts sent to an agent on a cron schedule; replies are posted to the room
+CREATE TABLE IF NOT EXISTS agent_schedules (
+    id TEXT PRIMARY KEY,
+    room_id TEXT NOT NULL REFERENCES rooms(id),
+    agent_id TEXT NOT NULL,
+    cron TEXT NOT NULL,                -- five-field cron expression
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    prompt TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    next_run_at DATETIME NOT NULL,
+    last_run_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_schedules_next ON agent_schedules(next_run_at);
+
+-- Agent calls per room, agent and UTC day
+CREATE TABLE IF NOT EXISTS agent_usage (
+    room_id TEXT NOT NULL REFERENCES rooms(id),
+    agent_id TEXT NOT NULL,
+    day TEXT NOT NULL,                        -- YYYY-MM-DD
+    invocations INTEGER NOT NULL DEFAULT 0,
+    failures INTEGER NOT NULL DEFAULT 0,
+    total_latency_ms INTEGER NOT NULL DEFAULT 0,
+    max_latency_ms INTEGER NOT NULL DEFAULT 0,
+    response_chars INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (room_id, agent_id, day)
+);
+
+-- Standing notes for an agent in a room, included in every prompt
+CREATE TABLE IF NOT EXISTS agent_memory (
+    room_id TEXT NOT NULL REFERENCES rooms(id),
+    agent_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_by TEXT NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (room_id, agent_id, key)
+);
+
+-- Tokens that let a device reconnect without signing a new challenge; only
+-- a SHA-256 hash of each token is stored
+CREATE TABLE IF NOT EXISTS resume_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    device_id TEXT,
+    scopes TEXT NOT NULL DEFAULT '',   -- comma-separated connect scopes to restore
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_resume_tokens_user ON resume_tokens(user_id);
+
+-- Devices (Ed25519 keys) that sign in to an account
+CREATE TABLE IF NOT EXISTS devices (
+    id TEXT PRIMARY KEY,               -- SHA256(public_key) hex
+    user_id TEXT NOT NULL REFERENCES users(id),
+    name TEXT NOT NULL DEFAULT '',     -- client display name at last connect
+    platform TEXT NOT NULL DEFAULT '', -- from the connect client info, e.g. ios
+    client_version TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    last_seen_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_user ON devices(user_id);
+
+-- Short-lived codes for linking a new device to an account
+CREATE TABLE IF NOT EXISTS device_link_codes (
+    code TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    expires_at DATETIME NOT NULL
+);
+
+-- Server admins granted at runtime, on top of CLAUDIO_ADMINS
+CREATE TABLE IF NOT EXISTS server_admins (
+    user_id TEXT PRIMARY KEY REFERENCES users(id),
+    granted_by TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+-- Users banned from the whole server; they can no longer connect
+CREATE TABLE IF NOT EXISTS server_bans (
+    user_id TEXT PRIMARY KEY,
+    reason TEXT NOT NULL DEFAULT '',
+    banned_by TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+-- Codes that let a new device register when registration is invite-only
+CREATE TABLE IF NOT EXISTS server_invites (
+    code TEXT PRIMARY KEY,
+    created_by TEXT NOT NULL,
+    expires_at DATETIME,
+    max_uses INTEGER NOT NULL DEFAULT 0,   -- 0 = unlimited
+    use_count INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+-- Device keys retired by users.rotateKey; connects with them are refused
+CREATE TABLE IF NOT EXISTS user_key_history (
+    device_id TEXT PRIMARY KEY,        -- SHA256 of the retired key
+    user_id TEXT NOT NULL REFERENCES users(id),
+    public_key TEXT NOT NULL,          -- the retired key, base64url
+    replaced_by TEXT NOT NULL,         -- device ID of the new key
+    rotated_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_key_history_user ON user_key_history(user_id);
+
+-- End-to-end encryption. The server only relays public keys and encrypted
+-- room keys; all crypto happens on the clients
+CREATE TABLE IF NOT EXISTS device_keys (
+    device_id TEXT PRIMARY KEY,        -- devices.id
+    identity_key TEXT NOT NULL,        -- base64 public keys, opaque to the server
+    signed_prekey TEXT NOT NULL,
+    signed_prekey_signature TEXT NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+-- One-time prekeys; each is handed out to a single e2ee.getKeyBundles caller
+CREATE TABLE IF NOT EXISTS one_time_prekeys (
+    device_id TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    PRIMARY KEY (device_id, key_id)
+);
+
+-- A room key encrypted for one recipient device
+CREATE TABLE IF NOT EXISTS room_key_envelopes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id TEXT NOT NULL REFERENCES rooms(id),
+    key_id TEXT NOT NULL,              -- client-chosen ID of the room key
+    sender_device_id TEXT NOT NULL,
+    recipient_device_id TEXT NOT NULL,
+    ciphertext TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    UNIQUE (room_id, key_id, recipient_device_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_key_envelopes_recipient ON room_key_envelopes(recipient_device_id, room_id);
+
+-- Connect attempts, successful or not, for devices.activity and admin.authLog
+CREATE TABLE IF NOT EXISTS auth_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL DEFAULT '',   -- '' when the connect never got to an account
+    device_id TEXT NOT NULL DEFAULT '', -- as claimed by the client when the signature failed
+    method TEXT NOT NULL,               -- signed, resume, jwt
+    success BOOLEAN NOT NULL,
+    error_code TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    platform TEXT NOT NULL DEFAULT '',
+    client_version TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_events_user ON auth_events(user_id, id);
+CREATE INDEX IF NOT EXISTS idx_auth_events_device ON auth_events(device_id, id);
+
+-- Abuse reports against messages or users, reviewed by server admins.
+-- They outlive the reported message and room, hence the snapshot
+CREATE TABLE IF NOT EXISTS reports (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,                -- message, user
+    reporter_id TEXT NOT NULL,
+    target_user_id TEXT NOT NULL DEFAULT '',
+    room_id TEXT NOT NULL DEFAULT '',
+    message_id TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL,              -- spam, harassment, ..., see ValidReportReason
+    details TEXT NOT NULL DEFAULT '',
+    message_snapshot TEXT,             -- JSON of the message when reported
+    status TEXT NOT NULL DEFAULT 'open',  -- open, resolved, dismissed
+    resolved_by TEXT NOT NULL DEFAULT '',
+    resolution_note TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    resolved_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, created_at);
+
+-- Addresses and CIDR ranges refused at the WebSocket upgrade
+CREATE TABLE IF NOT EXISTS ip_bans (
+    cidr TEXT PRIMARY KEY,             -- normalized, e.g. 203.0.113.7/32
+    reason TEXT NOT NULL DEFAULT '',
+    banned_by TEXT NOT NULL,
     created_at DATETIME NOT NULL DEFAULT (datetime('now'))
 );
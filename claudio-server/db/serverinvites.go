@@ -0,0 +1,67 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type ServerInvite struct {
+	Code      string     `json:"code"`
+	CreatedBy string     `json:"createdBy"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	MaxUses   int        `json:"maxUses"`
+	UseCount  int        `json:"useCount"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// CreateServerInvite issues a code that admits new devices while
+// registration is invite-only. maxUses 0 means unlimited.
+func (d *DB) CreateServerInvite(createdBy string, expiresIn *time.Duration, maxUses int) (*ServerInvite, error) {
+	now := time.Now().UTC()
+	invite := &ServerInvite{
+		Code:      generateInviteCode(),
+		CreatedBy: createdBy,
+		MaxUses:   maxUses,
+		CreatedAt: now,
+	}
+	if expiresIn != nil {
+		t := now.Add(*expiresIn)
+		invite.ExpiresAt = &t
+	}
+	_, err := d.Exec(`
+		INSERT INTO server_invites (code, created_by, expires_at, max_uses, created_at) VALUES (?, ?, ?, ?, ?)
+	`, invite.Code, createdBy, invite.ExpiresAt, maxUses, now)
+	if err != nil {
+		return nil, fmt.Errorf("create server invite: %w", err)
+	}
+	return invite, nil
+}
+
+// RedeemServerInvite counts a use of code. Returns sql.ErrNoRows if the
+// code is unknown, expired or used up.
+func (d *DB) RedeemServerInvite(code string) error {
+	now := time.Now().UTC()
+	result, err := d.Exec(`
+		UPDATE server_invites SET use_count = use_count + 1
+		WHERE code = ? AND (expires_at IS NULL OR expires_at > ?) AND (max_uses = 0 OR use_count < max_uses)
+	`, strings.ToUpper(code), now)
+	if err != nil {
+		return fmt.Errorf("redeem server invite: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeviceExists reports whether deviceID has connected before and is still
+// linked to an account.
+func (d *DB) DeviceExists(deviceID string) (bool, error) {
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM devices WHERE id = ?`, deviceID).Scan(&n); err != nil {
+		return false, fmt.Errorf("check device: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedeemServerInvite(t *testing.T) {
+	database := openTestDB(t)
+
+	invite, err := database.CreateServerInvite("admin", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := database.RedeemServerInvite(invite.Code); err != nil {
+			t.Fatalf("use %d: %v", i, err)
+		}
+	}
+	if err := database.RedeemServerInvite(invite.Code); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("used-up invite: err = %v, want sql.ErrNoRows", err)
+	}
+
+	expired := -time.Minute
+	old, _ := database.CreateServerInvite("admin", &expired, 0)
+	if err := database.RedeemServerInvite(old.Code); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expired invite: err = %v, want sql.ErrNoRows", err)
+	}
+	if err := database.RedeemServerInvite("NOPE"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown invite: err = %v, want sql.ErrNoRows", err)
+	}
+}
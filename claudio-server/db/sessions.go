@@ -0,0 +1,45 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultSessionKey is the OpenClaw session used for an agent in a room
+// until someone resets it.
+func defaultSessionKey(openclawAgentID, roomID string) string {
+	return "agent:" + openclawAgentID + ":" + roomID
+}
+
+// AgentSessionKey returns the OpenClaw session key for agentID in roomID,
+// recording the default key on first use.
+func (d *DB) AgentSessionKey(roomID, agentID, openclawAgentID string) (string, error) {
+	_, err := d.Exec(`
+		INSERT INTO agent_sessions (room_id, agent_id, session_key, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, roomID, agentID, defaultSessionKey(openclawAgentID, roomID), time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("insert agent session: %w", err)
+	}
+
+	var key string
+	err = d.QueryRow(`SELECT session_key FROM agent_sessions WHERE room_id = ? AND agent_id = ?`, roomID, agentID).Scan(&key)
+	if err != nil {
+		return "", fmt.Errorf("get agent session: %w", err)
+	}
+	return key, nil
+}
+
+// ResetAgentSession starts a fresh OpenClaw session for agentID in roomID and
+// returns its key.
+func (d *DB) ResetAgentSession(roomID, agentID, openclawAgentID string) (string, error) {
+	key := defaultSessionKey(openclawAgentID, roomID) + ":" + nanoid()
+	_, err := d.Exec(`
+		INSERT INTO agent_sessions (room_id, agent_id, session_key, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (room_id, agent_id) DO UPDATE SET session_key = excluded.session_key, created_at = excluded.created_at
+	`, roomID, agentID, key, time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("reset agent session: %w", err)
+	}
+	return key, nil
+}
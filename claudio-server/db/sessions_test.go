@@ -0,0 +1,35 @@
+package db
+
+import "testing"
+
+func TestAgentSessionKeyPerRoom(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	other, err := database.CreateRoom("Other", "", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := database.AgentSessionKey(room.ID, "mave", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "agent:main:"+room.ID {
+		t.Errorf("key = %q, want agent:main:%s", key, room.ID)
+	}
+	otherKey, _ := database.AgentSessionKey(other.ID, "mave", "main")
+	if otherKey == key {
+		t.Error("rooms share a session key")
+	}
+
+	reset, err := database.ResetAgentSession(room.ID, "mave", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset == key {
+		t.Error("reset kept the old session key")
+	}
+	if got, _ := database.AgentSessionKey(room.ID, "mave", "main"); got != reset {
+		t.Errorf("key after reset = %q, want %q", got, reset)
+	}
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// StarredMessage is a message a user bookmarked.
+type StarredMessage struct {
+	Message   Message   `json:"message"`
+	StarredAt time.Time `json:"starredAt"`
+	Cursor    int64     `json:"-"`
+}
+
+// StarredPage is one page of a user's stars, most recent first.
+type StarredPage struct {
+	Stars   []StarredMessage
+	HasMore bool
+}
+
+// GetMessageRoom returns the room a message belongs to.
+func (d *DB) GetMessageRoom(messageID string) (string, error) {
+	var roomID string
+	err := d.QueryRow(`SELECT room_id FROM messages WHERE id = ?`, messageID).Scan(&roomID)
+	return roomID, err
+}
+
+// StarMessage bookmarks a message for userID. Starring twice is a no-op.
+func (d *DB) StarMessage(userID, messageID string) error {
+	_, err := d.Exec(`
+		INSERT INTO message_stars (user_id, message_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, userID, messageID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("star message: %w", err)
+	}
+	return nil
+}
+
+// UnstarMessage removes a bookmark.
+func (d *DB) UnstarMessage(userID, messageID string) error {
+	_, err := d.Exec(`DELETE FROM message_stars WHERE user_id = ? AND message_id = ?`, userID, messageID)
+	if err != nil {
+		return fmt.Errorf("unstar message: %w", err)
+	}
+	return nil
+}
+
+// ListStarred returns userID's starred messages in rooms they still belong
+// to. before is a cursor from a previous page (0 = newest).
+func (d *DB) ListStarred(userID string, before int64, limit int) (*StarredPage, error) {
+	query := `
+		SELECT s.rowid, s.message_id, m.room_id, s.created_at
+		FROM message_stars s
+		JOIN messages m ON m.id = s.message_id
+		JOIN participants p ON p.room_id = m.room_id AND p.user_id = s.user_id
+		WHERE s.user_id = ?`
+	args := []any{userID}
+	if before > 0 {
+		query += ` AND s.rowid < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY s.rowid DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list starred: %w", err)
+	}
+	type star struct {
+		cursor            int64
+		messageID, roomID string
+		starredAt         time.Time
+	}
+	var stars []star
+	for rows.Next() {
+		var s star
+		if err := rows.Scan(&s.cursor, &s.messageID, &s.roomID, &s.starredAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan star: %w", err)
+		}
+		stars = append(stars, s)
+	}
+	rows.Close()
+
+	page := &StarredPage{Stars: []StarredMessage{}}
+	if len(stars) > limit {
+		stars = stars[:limit]
+		page.HasMore = true
+	}
+	for _, s := range stars {
+		msg, err := d.GetMessage(s.roomID, s.messageID)
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			continue
+		}
+		page.Stars = append(page.Stars, StarredMessage{Message: *msg, StarredAt: s.starredAt, Cursor: s.cursor})
+	}
+	return page, nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import "testing"
+
+func TestListStarredHidesLeftRooms(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	uid := "alice"
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if _, err := database.InsertMessage(id, room.ID, &uid, nil, "Alice", "", "hi", "[]", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"m1", "m3", "m3"} {
+		if err := database.StarMessage("alice", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := database.ListStarred("alice", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Stars) != 1 || page.Stars[0].Message.ID != "m3" || !page.HasMore {
+		t.Fatalf("first page = %+v, want m3 with more", page)
+	}
+	page, err = database.ListStarred("alice", page.Stars[0].Cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Stars) != 1 || page.Stars[0].Message.ID != "m1" || page.HasMore {
+		t.Fatalf("second page = %+v, want m1 only", page)
+	}
+
+	if err := database.RemoveParticipant(room.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	page, err = database.ListStarred("alice", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Stars) != 0 {
+		t.Errorf("stars after leaving = %d, want 0", len(page.Stars))
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Store is what the rpc and ws packages need of the database. *DB
+// implements it, over SQLite or Postgres; main keeps the *DB itself for
+// what only it does, such as backups and closing.
+type Store interface {
+	GetRoomActivity(roomID string, from, to time.Time) (*RoomActivity, error)
+
+	IsServerAdmin(userID string) (bool, error)
+	GrantServerAdmin(userID, grantedBy string) error
+	ListServerAdmins() ([]string, error)
+	RevokeServerAdmin(userID string) error
+	BanUser(userID, reason, bannedBy string) error
+	UnbanUser(userID string) error
+	IsUserBanned(userID string) (bool, error)
+	ListServerBans() ([]ServerBan, error)
+	BanIP(cidr, reason, bannedBy string) error
+	UnbanIP(cidr string) error
+	ListIPBans() ([]IPBan, error)
+	ListAllRooms(limit, offset int) ([]Room, error)
+	ServerStats() (*ServerStats, error)
+
+	RoomMessagesAfter(roomID string, afterSeq int64, limit int) ([]Message, error)
+	ImportMessages(roomID string, msgs []Message) error
+
+	CreateAttachment(id, roomID, uploadedBy, blobHash string, size int64, filename, contentType string) (*Attachment, error)
+	GetAttachment(id string) (*Attachment, error)
+	CountPendingAttachments(roomID, userID string, ids []string) (int, error)
+	AttachToMessage(messageID, roomID, userID string, ids []string) ([]Attachment, error)
+
+	InsertAuditEntry(roomID, actorID, action, target string, details map[string]interface{}) error
+	GetAuditLog(roomID string, before int64, limit int) (*AuditPage, error)
+
+	RecordAuthEvent(e AuthEvent) error
+	ListAuthEvents(f AuthEventFilter, limit int) ([]AuthEvent, error)
+
+	Backup(path string) (size int64, err error)
+
+	WithContext(ctx context.Context) Store
+
+	TouchDevice(deviceID string, info DeviceInfo) (string, error)
+	AccountForDevice(deviceID string, info DeviceInfo) (string, error)
+	ListDevices(userID string) ([]Device, error)
+	RevokeDevice(userID, deviceID string) error
+	RotateDeviceKey(userID, oldDeviceID, oldPublicKey, newDeviceID string) error
+	GetRetiredKey(deviceID string) (*RetiredKey, error)
+	CreateLinkCode(userID string) (code string, expiresAt time.Time, err error)
+	LinkDevice(code, sourceUserID string) (string, error)
+
+	OpenDirectRoom(userID, otherUserID, name string) (room *Room, created bool, err error)
+	OpenAgentDirectRoom(userID, agentID, openclawURL, openclawToken, agentName, agentEmoji, name string) (room *Room, created bool, err error)
+	GetRoomType(roomID string) (string, error)
+
+	PublishDeviceKeys(deviceID string, keys DeviceKeys, oneTime []OneTimePrekey) (int, error)
+	ClaimKeyBundles(userIDs []string) ([]KeyBundle, error)
+	StoreRoomKeyEnvelopes(envelopes []RoomKeyEnvelope) error
+	ListRoomKeyEnvelopes(roomID, deviceID string) ([]RoomKeyEnvelope, error)
+	DeviceUserIDs(deviceIDs []string) (map[string]string, error)
+
+	DeleteAccount(userID, messages string) (*AccountDeletion, error)
+
+	CreateInvite(roomID, createdBy string, expiresIn *time.Duration, maxUses int, role string) (*InviteCode, error)
+	LookupInvite(code string) (*InviteCode, error)
+	RedeemInvite(code string) (*InviteCode, error)
+	JoinWithInvite(code, userID string) (invite *InviteCode, added bool, err error)
+
+	CreateJoinRequest(roomID, userID, role string) (req *JoinRequest, created bool, err error)
+	GetJoinRequest(id string) (*JoinRequest, error)
+	ListJoinRequests(roomID string) ([]JoinRequest, error)
+	ResolveJoinRequest(id string, approve bool, decidedBy string) error
+
+	RunMaintenance(policy RetentionPolicy, now time.Time) (*MaintenanceReport, error)
+
+	GetAgentMemory(roomID, agentID string) ([]AgentMemory, error)
+	SetAgentMemory(roomID, agentID, key, value, updatedBy string) error
+
+	InsertMessage(id, roomID string, senderUserID, senderAgentID *string, senderDisplayName, senderEmoji, content, mentions string, replyTo *string) (*Message, error)
+	InsertSystemMessage(id, roomID, content string) (*Message, error)
+	SetMessageBlocks(id string, blocks json.RawMessage) error
+	GetMessage(roomID, id string) (*Message, error)
+	GetMessages(roomID string, before *time.Time, limit int) ([]Message, error)
+	GetMessagesPage(roomID string, before, after int64, limit int, filter HistoryFilter) (*MessagePage, error)
+
+	SetRoomNotifications(roomID, userID, level string) error
+	SetRoomPinned(roomID, userID string, pinned bool) error
+
+	TouchParticipant(roomID, userID string) error
+	TouchUserRooms(userID string) (time.Time, []string, error)
+	SharesRoom(userA, userB string) (bool, error)
+	LastSeen(userID string) (*time.Time, error)
+	SetUserStatus(userID string, status *UserStatus) error
+	GetUserStatus(userID string) (*UserStatus, error)
+	UserRoomIDs(userID string) ([]string, error)
+
+	CreateReport(r *Report, message *Message) (created bool, err error)
+	ListReports(status string, limit, offset int) ([]Report, error)
+	ResolveReport(id, status, resolvedBy, note string) (*Report, error)
+
+	CreateResumeToken(token string, session ResumeSession, expiresAt time.Time) error
+	ConsumeResumeToken(token string) (*ResumeSession, error)
+	RevokeResumeTokens(userID string) (int64, error)
+
+	CreateRoomWithVisibility(name, emoji, createdBy, visibility string) (*Room, error)
+	GetRoom(id string) (*Room, error)
+	ListRoomsForUser(userID string) ([]Room, error)
+	ListPublicRooms() ([]Room, error)
+	IsRoomPublic(roomID string) (bool, error)
+	UpdateRoom(id string, name, emoji, description *string) error
+	DeleteRoom(id string) error
+	SetRoomVisibility(roomID, visibility string) error
+	ListDirectory(query string, limit, offset int) ([]Room, error)
+	SetRoomAvatar(roomID string, attachmentID *string) error
+	SetRoomRequireApproval(roomID string, require bool) error
+	RoomRequiresApproval(roomID string) (bool, error)
+	SetRoomAgentChains(roomID string, enabled bool) error
+	RoomAllowsAgentChains(roomID string) (bool, error)
+	SetRoomEncrypted(roomID string) error
+	IsRoomEncrypted(roomID string) (bool, error)
+	SetRoomContentFilter(roomID, mode string) error
+	GetRoomContentFilter(roomID string) (string, error)
+	SetRoomMessageRetention(roomID string, days int) error
+	SetRoomSlowMode(roomID string, seconds int) error
+	GetRoomSlowMode(roomID string) (int, error)
+	AddParticipant(roomID, userID, role string) error
+	RemoveParticipant(roomID, userID string) error
+	AddAgentParticipant(roomID, agentID, openclawURL, openclawToken, openclawAgentID, agentName, agentEmoji string) error
+	UpdateAgentParticipant(roomID, agentID, openclawURL string, name, emoji, persona *string) error
+	RemoveAgentParticipant(roomID, agentID, openclawURL string) error
+	SetAgentTrigger(roomID, agentID, openclawURL, trigger string, keywords []string) error
+	SetAgentBackend(roomID, agentID, openclawURL, backend, model string) error
+	GetAgentParticipant(roomID, agentID, openclawURL string) (*Participant, error)
+	ListAgentsByOpenclaw(openclawURL, openclawToken string) ([]AgentInRoom, error)
+	GetParticipants(roomID string) ([]Participant, error)
+	IsParticipant(roomID, userID string) (bool, error)
+	GetParticipantRole(roomID, userID string) (string, error)
+	SetParticipantRole(roomID, userID, role string) error
+	TransferOwnership(roomID, fromUserID, toUserID string) error
+	LongestStandingAdmin(roomID string) (string, error)
+
+	CreateSchedule(roomID, agentID, cron, timezone, prompt, createdBy string, nextRun time.Time) (*AgentSchedule, error)
+	ListSchedules(roomID string) ([]AgentSchedule, error)
+	DueSchedules(now time.Time) ([]AgentSchedule, error)
+	MarkScheduleRun(id string, ranAt, nextRun time.Time) error
+	DeleteSchedule(id, roomID string) error
+
+	CreateServerInvite(createdBy string, expiresIn *time.Duration, maxUses int) (*ServerInvite, error)
+	RedeemServerInvite(code string) error
+	DeviceExists(deviceID string) (bool, error)
+
+	AgentSessionKey(roomID, agentID, openclawAgentID string) (string, error)
+	ResetAgentSession(roomID, agentID, openclawAgentID string) (string, error)
+
+	GetMessageRoom(messageID string) (string, error)
+	StarMessage(userID, messageID string) error
+	UnstarMessage(userID, messageID string) error
+	ListStarred(userID string, before int64, limit int) (*StarredPage, error)
+
+	CreateTemplate(ownerID, name string, config TemplateConfig) (*RoomTemplate, error)
+	GetTemplate(id string) (*RoomTemplate, error)
+	ListTemplates(userID string) ([]RoomTemplate, error)
+	DeleteTemplate(id, ownerID string) error
+
+	SetRoomTopic(roomID, topic, setBy string) error
+	GetTopicHistory(roomID string, limit int) ([]TopicChange, error)
+
+	GetTranslation(messageID, language string) (string, error)
+	SaveTranslation(messageID, language, content string) error
+
+	RecordAgentUsage(roomID, agentID string, at time.Time, latency time.Duration, responseChars int, failed bool) error
+	GetAgentUsage(roomID string, from, to time.Time) ([]AgentUsage, error)
+
+	UpsertUser(id, publicKey, displayName, avatarEmoji string) (*User, error)
+	GetUser(id string) (*User, error)
+	UpdateUser(id, displayName, avatarEmoji string) error
+	UpdateUserProfile(id string, bio, pronouns, timezone *string) error
+}
+
+var _ Store = (*DB)(nil)
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RoomTemplate presets a new room's settings, agents, and welcome message.
+// Templates without an owner are server-wide and come from the config file.
+type RoomTemplate struct {
+	ID        string    `json:"id"`
+	OwnerID   *string   `json:"ownerId,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	TemplateConfig
+}
+
+// TemplateConfig is the part of a template applied to the room.
+type TemplateConfig struct {
+	Emoji           string          `json:"emoji,omitempty"`
+	Description     string          `json:"description,omitempty"`
+	Topic           string          `json:"topic,omitempty"`
+	Visibility      string          `json:"visibility,omitempty"`
+	SlowModeSeconds int             `json:"slowModeSeconds,omitempty"`
+	WelcomeMessage  string          `json:"welcomeMessage,omitempty"`
+	Agents          []TemplateAgent `json:"agents,omitempty"`
+}
+
+// TemplateAgent is an agent added to every room created from a template.
+type TemplateAgent struct {
+	AgentID         string `json:"agentId"`
+	OpenclawURL     string `json:"openclawUrl"`
+	OpenclawToken   string `json:"openclawToken,omitempty"`
+	OpenclawAgentID string `json:"openclawAgentId,omitempty"`
+	AgentName       string `json:"agentName,omitempty"`
+	AgentEmoji      string `json:"agentEmoji,omitempty"`
+}
+
+// Redacted returns a copy of the template safe to send to clients.
+func (t RoomTemplate) Redacted() RoomTemplate {
+	agents := make([]TemplateAgent, len(t.Agents))
+	for i, a := range t.Agents {
+		a.OpenclawToken = ""
+		agents[i] = a
+	}
+	t.Agents = agents
+	return t
+}
+
+func scanTemplate(row rowScanner) (RoomTemplate, error) {
+	var t RoomTemplate
+	var config string
+	if err := row.Scan(&t.ID, &t.OwnerID, &t.Name, &config, &t.CreatedAt); err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal([]byte(config), &t.TemplateConfig); err != nil {
+		return t, fmt.Errorf("decode template %s: %w", t.ID, err)
+	}
+	return t, nil
+}
+
+// CreateTemplate saves a template owned by ownerID.
+func (d *DB) CreateTemplate(ownerID, name string, config TemplateConfig) (*RoomTemplate, error) {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	t := &RoomTemplate{
+		ID:             nanoid(),
+		OwnerID:        &ownerID,
+		Name:           name,
+		CreatedAt:      time.Now().UTC(),
+		TemplateConfig: config,
+	}
+	_, err = d.Exec(`
+		INSERT INTO room_templates (id, owner_id, name, config, created_at) VALUES (?, ?, ?, ?, ?)
+	`, t.ID, ownerID, name, string(encoded), t.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create template: %w", err)
+	}
+	return t, nil
+}
+
+// GetTemplate returns a template by ID, or nil if it doesn't exist.
+func (d *DB) GetTemplate(id string) (*RoomTemplate, error) {
+	t, err := scanTemplate(d.QueryRow(`
+		SELECT id, owner_id, name, config, created_at FROM room_templates WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get template: %w", err)
+	}
+	return &t, nil
+}
+
+// ListTemplates returns the server-wide templates followed by userID's own.
+func (d *DB) ListTemplates(userID string) ([]RoomTemplate, error) {
+	rows, err := d.Query(`
+		SELECT id, owner_id, name, config, created_at FROM room_templates
+		WHERE owner_id IS NULL OR owner_id = ?
+		ORDER BY owner_id IS NOT NULL, name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []RoomTemplate{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// DeleteTemplate removes one of ownerID's templates. Server-wide templates
+// can only be changed through the config file.
+func (d *DB) DeleteTemplate(id, ownerID string) error {
+	result, err := d.Exec(`DELETE FROM room_templates WHERE id = ? AND owner_id = ?`, id, ownerID)
+	if err != nil {
+		return fmt.Errorf("delete template: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SyncServerTemplates replaces the server-wide templates with templates.
+func (d *DB) SyncServerTemplates(templates []RoomTemplate) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM room_templates WHERE owner_id IS NULL`); err != nil {
+		return fmt.Errorf("clear server templates: %w", err)
+	}
+	now := time.Now().UTC()
+	for _, t := range templates {
+		encoded, err := json.Marshal(t.TemplateConfig)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO room_templates (id, owner_id, name, config, created_at) VALUES (?, NULL, ?, ?, ?)
+		`, t.ID, t.Name, string(encoded), now); err != nil {
+			return fmt.Errorf("insert server template %s: %w", t.ID, err)
+		}
+	}
+	return tx.Commit()
+}
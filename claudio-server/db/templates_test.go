@@ -0,0 +1,39 @@
+package db
+
+import "testing"
+
+func TestListTemplatesScoping(t *testing.T) {
+	database := openTestDB(t)
+	createTestRoom(t, database)
+	if _, err := database.UpsertUser("bob", "key", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	server := RoomTemplate{ID: "standup", Name: "Standup", TemplateConfig: TemplateConfig{
+		Agents: []TemplateAgent{{AgentID: "bot", OpenclawURL: "https://oc", OpenclawToken: "secret"}},
+	}}
+	if err := database.SyncServerTemplates([]RoomTemplate{server}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.CreateTemplate("bob", "Bob's", TemplateConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := database.ListTemplates("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].ID != "standup" {
+		t.Fatalf("alice templates = %+v, want only standup", templates)
+	}
+	if templates[0].Agents[0].OpenclawToken != "secret" {
+		t.Errorf("stored token lost")
+	}
+	if templates[0].Redacted().Agents[0].OpenclawToken != "" || templates[0].Agents[0].OpenclawToken == "" {
+		t.Errorf("Redacted should clear the token on a copy only")
+	}
+
+	if templates, _ := database.ListTemplates("bob"); len(templates) != 2 {
+		t.Errorf("bob templates = %d, want 2", len(templates))
+	}
+}
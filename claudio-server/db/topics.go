@@ -0,0 +1,56 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// TopicChange is one entry in a room's topic history.
+type TopicChange struct {
+	Topic     string    `json:"topic"`
+	SetBy     string    `json:"setBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SetRoomTopic updates the room's current topic and records the change.
+func (d *DB) SetRoomTopic(roomID, topic, setBy string) error {
+	now := time.Now().UTC()
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE rooms SET topic = ?, updated_at = ? WHERE id = ?`, topic, now, roomID); err != nil {
+		return fmt.Errorf("set topic: %w", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO room_topic_changes (room_id, topic, set_by, created_at) VALUES (?, ?, ?, ?)
+	`, roomID, topic, setBy, now); err != nil {
+		return fmt.Errorf("record topic change: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetTopicHistory returns the room's most recent topic changes, newest first.
+func (d *DB) GetTopicHistory(roomID string, limit int) ([]TopicChange, error) {
+	rows, err := d.Query(`
+		SELECT topic, set_by, created_at FROM room_topic_changes
+		WHERE room_id = ? ORDER BY id DESC LIMIT ?
+	`, roomID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get topic history: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []TopicChange{}
+	for rows.Next() {
+		var c TopicChange
+		if err := rows.Scan(&c.Topic, &c.SetBy, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan topic change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetTranslation returns the cached translation of a message, or "" if none is cached.
+func (d *DB) GetTranslation(messageID, language string) (string, error) {
+	var content string
+	err := d.QueryRow(`SELECT content FROM message_translations WHERE message_id = ? AND language = ?`, messageID, language).Scan(&content)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get translation: %w", err)
+	}
+	return content, nil
+}
+
+// SaveTranslation caches a translation of a message.
+func (d *DB) SaveTranslation(messageID, language, content string) error {
+	_, err := d.Exec(`
+		INSERT INTO message_translations (message_id, language, content, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (message_id, language)
+		DO UPDATE SET content = excluded.content, created_at = CURRENT_TIMESTAMP
+	`, messageID, language, content)
+	if err != nil {
+		return fmt.Errorf("save translation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// AgentUsage totals one agent's calls in a room over a date range.
+type AgentUsage struct {
+	AgentID       string `json:"agentId"`
+	DisplayName   string `json:"displayName"`
+	Invocations   int    `json:"invocations"`
+	Failures      int    `json:"failures"`
+	AvgLatencyMs  int64  `json:"avgLatencyMs"`
+	MaxLatencyMs  int64  `json:"maxLatencyMs"`
+	ResponseChars int64  `json:"responseChars"`
+}
+
+// RecordAgentUsage counts one finished agent call.
+func (d *DB) RecordAgentUsage(roomID, agentID string, at time.Time, latency time.Duration, responseChars int, failed bool) error {
+	ms := latency.Milliseconds()
+	_, err := d.Exec(`
+		INSERT INTO agent_usage (room_id, agent_id, day, invocations, failures, total_latency_ms, max_latency_ms, response_chars)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+		ON CONFLICT (room_id, agent_id, day) DO UPDATE SET
+			invocations = agent_usage.invocations + 1,
+			failures = agent_usage.failures + excluded.failures,
+			total_latency_ms = agent_usage.total_latency_ms + excluded.total_latency_ms,
+			max_latency_ms = `+d.dialect.greatest+`(agent_usage.max_latency_ms, excluded.max_latency_ms),
+			response_chars = agent_usage.response_chars + excluded.response_chars
+	`, roomID, agentID, at.UTC().Format("2006-01-02"), failed, ms, ms, responseChars)
+	if err != nil {
+		return fmt.Errorf("record agent usage: %w", err)
+	}
+	return nil
+}
+
+// GetAgentUsage totals each agent's calls in roomID on the UTC days in
+// [from, to), busiest first. Agents since removed from the room are included.
+func (d *DB) GetAgentUsage(roomID string, from, to time.Time) ([]AgentUsage, error) {
+	rows, err := d.Query(`
+		SELECT u.agent_id,
+		       COALESCE((SELECT agent_name FROM participants p WHERE p.room_id = ? AND p.agent_id = u.agent_id LIMIT 1), u.agent_id),
+		       SUM(u.invocations), SUM(u.failures), CAST(SUM(u.total_latency_ms) / SUM(u.invocations) AS BIGINT),
+		       MAX(u.max_latency_ms), SUM(u.response_chars)
+		FROM agent_usage u
+		WHERE u.room_id = ? AND u.day >= ? AND u.day < ?
+		GROUP BY u.agent_id
+		ORDER BY SUM(u.invocations) DESC, u.agent_id
+	`, roomID, roomID, from.UTC().Format("2006-01-02"), to.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("agent usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []AgentUsage{}
+	for rows.Next() {
+		var u AgentUsage
+		if err := rows.Scan(&u.AgentID, &u.DisplayName, &u.Invocations, &u.Failures, &u.AvgLatencyMs, &u.MaxLatencyMs, &u.ResponseChars); err != nil {
+			return nil, fmt.Errorf("scan agent usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
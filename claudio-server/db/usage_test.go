@@ -0,0 +1,30 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgentUsageTotals(t *testing.T) {
+	database := openTestDB(t)
+	room := createTestRoom(t, database)
+	database.AddAgentParticipant(room.ID, "mave", "wss://oc.example", "tok", "main", "Mave", "🌊")
+
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	database.RecordAgentUsage(room.ID, "mave", day, 100*time.Millisecond, 40, false)
+	database.RecordAgentUsage(room.ID, "mave", day, 300*time.Millisecond, 0, true)
+	database.RecordAgentUsage(room.ID, "mave", day.AddDate(0, 0, 1), 200*time.Millisecond, 20, false)
+	database.RecordAgentUsage(room.ID, "mave", day.AddDate(0, 0, 5), time.Second, 10, false) // outside the range
+
+	usage, err := database.GetAgentUsage(room.ID, day.Truncate(24*time.Hour), day.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 {
+		t.Fatalf("usage = %+v, want one agent", usage)
+	}
+	want := AgentUsage{AgentID: "mave", DisplayName: "Mave", Invocations: 3, Failures: 1, AvgLatencyMs: 200, MaxLatencyMs: 300, ResponseChars: 60}
+	if usage[0] != want {
+		t.Errorf("usage = %+v, want %+v", usage[0], want)
+	}
+}
@@ -2,16 +2,21 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 )
 
 type User struct {
-	ID          string    `json:"id"`
-	PublicKey   string    `json:"publicKey"`
-	DisplayName string    `json:"displayName"`
-	AvatarEmoji string    `json:"avatarEmoji"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID          string     `json:"id"`
+	PublicKey   string     `json:"publicKey"`
+	DisplayName string     `json:"displayName"`
+	AvatarEmoji string     `json:"avatarEmoji"`
+	Bio         string     `json:"bio"`
+	Pronouns    string     `json:"pronouns"`
+	Timezone    string     `json:"timezone"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"` // see DeleteAccount
 }
 
 func (db *DB) UpsertUser(id, publicKey, displayName, avatarEmoji string) (*User, error) {
@@ -22,7 +27,8 @@ func (db *DB) UpsertUser(id, publicKey, displayName, avatarEmoji string) (*User,
 		ON CONFLICT(id) DO UPDATE SET
 			display_name = CASE WHEN excluded.display_name != '' THEN excluded.display_name ELSE users.display_name END,
 			avatar_emoji = CASE WHEN excluded.avatar_emoji != '' THEN excluded.avatar_emoji ELSE users.avatar_emoji END,
-			updated_at = excluded.updated_at
+			updated_at = excluded.updated_at,
+			deleted_at = NULL
 	`, id, publicKey, displayName, avatarEmoji, now, now)
 	if err != nil {
 		return nil, err
@@ -33,9 +39,9 @@ func (db *DB) UpsertUser(id, publicKey, displayName, avatarEmoji string) (*User,
 func (db *DB) GetUser(id string) (*User, error) {
 	u := &User{}
 	err := db.QueryRow(`
-		SELECT id, public_key, display_name, avatar_emoji, created_at, updated_at
+		SELECT id, public_key, display_name, avatar_emoji, bio, pronouns, timezone, created_at, updated_at, deleted_at
 		FROM users WHERE id = ?
-	`, id).Scan(&u.ID, &u.PublicKey, &u.DisplayName, &u.AvatarEmoji, &u.CreatedAt, &u.UpdatedAt)
+	`, id).Scan(&u.ID, &u.PublicKey, &u.DisplayName, &u.AvatarEmoji, &u.Bio, &u.Pronouns, &u.Timezone, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -47,8 +53,30 @@ func (db *DB) UpdateUser(id, displayName, avatarEmoji string) error {
 		UPDATE users SET
 			display_name = CASE WHEN ? != '' THEN ? ELSE display_name END,
 			avatar_emoji = CASE WHEN ? != '' THEN ? ELSE avatar_emoji END,
-			updated_at = datetime('now')
+			updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`, displayName, displayName, avatarEmoji, avatarEmoji, id)
 	return err
 }
+
+// UpdateUserProfile sets the optional profile fields; nil fields are left as
+// they are and empty strings clear them.
+func (db *DB) UpdateUserProfile(id string, bio, pronouns, timezone *string) error {
+	sets := []string{"updated_at = ?"}
+	args := []any{time.Now().UTC()}
+	if bio != nil {
+		sets = append(sets, "bio = ?")
+		args = append(args, *bio)
+	}
+	if pronouns != nil {
+		sets = append(sets, "pronouns = ?")
+		args = append(args, *pronouns)
+	}
+	if timezone != nil {
+		sets = append(sets, "timezone = ?")
+		args = append(args, *timezone)
+	}
+	args = append(args, id)
+	_, err := db.Exec(`UPDATE users SET `+strings.Join(sets, ", ")+` WHERE id = ?`, args...)
+	return err
+}
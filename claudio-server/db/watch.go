@@ -15,11 +15,11 @@ type Watch struct {
 func (d *DB) UpsertWatch(deviceID, openclawURL, openclawToken string) error {
 	_, err := d.Exec(`
 		INSERT INTO push_watches (device_id, openclaw_url, openclaw_token, updated_at)
-		VALUES (?, ?, ?, datetime('now'))
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
 		ON CONFLICT (device_id)
 		DO UPDATE SET openclaw_url = excluded.openclaw_url,
 		              openclaw_token = excluded.openclaw_token,
-		              updated_at = datetime('now')
+		              updated_at = CURRENT_TIMESTAMP
 	`, deviceID, openclawURL, openclawToken)
 	if err != nil {
 		return fmt.Errorf("upsert watch: %w", err)
@@ -0,0 +1,51 @@
+// Package wstest connects ws clients for tests of packages built on ws.
+package wstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// NewClient returns a client of hub, signed in as userID, over a WebSocket
+// connection to an in-process server. What's sent to it arrives on the
+// returned channel. The connection is closed when the test ends.
+func NewClient(t testing.TB, hub *ws.Hub, userID, displayName string) (*ws.Client, <-chan []byte) {
+	t.Helper()
+	clients := make(chan *ws.Client, 1)
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := ws.NewClient(hub, conn)
+		c.SetAuth(userID, displayName)
+		go c.WritePump()
+		clients <- c
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	out := make(chan []byte, 256)
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			out <- msg
+		}
+	}()
+	return <-clients, out
+}
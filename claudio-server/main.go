@@ -1,27 +1,35 @@
 package main
 
 import (
+	"compress/flate"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/nicebartender/claudio-server/apns"
+	"github.com/nicebartender/claudio-server/cluster"
+	"github.com/nicebartender/claudio-server/cron"
 	"github.com/nicebartender/claudio-server/db"
 	"github.com/nicebartender/claudio-server/joincode"
+	"github.com/nicebartender/claudio-server/media"
+	"github.com/nicebartender/claudio-server/openclaw"
 	"github.com/nicebartender/claudio-server/relay"
 	"github.com/nicebartender/claudio-server/rpc"
 	"github.com/nicebartender/claudio-server/ws"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
+// upgrader's CheckOrigin is set from config in main
+var upgrader = websocket.Upgrader{}
 
 // agentBridgeScript is a Node.js script that agents download and run.
 // It bridges a WebSocket connection to our server with the local OpenClaw HTTP API.
@@ -111,13 +119,48 @@ func main() {
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})))
 
 	cfg := LoadConfig()
+	upgrader.CheckOrigin = ws.OriginChecker(cfg.AllowedOrigins, cfg.AllowAnyOrigin)
+	upgrader.EnableCompression = cfg.Compression
+	if cfg.CompressionLevel < flate.HuffmanOnly || cfg.CompressionLevel > flate.BestCompression {
+		slog.Error("invalid WebSocket compression level", "level", cfg.CompressionLevel)
+		os.Exit(1)
+	}
+	if cfg.AllowAnyOrigin {
+		slog.Warn("WebSocket origin check disabled; any website can connect on a user's behalf")
+	}
 
-	database, err := db.Open(cfg.DBPath)
+	if cfg.RestoreFrom != "" {
+		if err := db.RestoreBackup(cfg.RestoreFrom, cfg.DBPath); err != nil {
+			slog.Error("restore failed", "err", err)
+			os.Exit(1)
+		}
+		slog.Info("database restored", "from", cfg.RestoreFrom, "db", cfg.DBPath)
+		return
+	}
+
+	var database *db.DB
+	var err error
+	if cfg.DatabaseURL != "" {
+		database, err = db.OpenPostgres(cfg.DatabaseURL)
+	} else {
+		database, err = db.Open(cfg.DBPath)
+	}
 	if err != nil {
 		slog.Error("failed to open database", "err", err)
 		os.Exit(1)
 	}
 	defer database.Close()
+	database.QueryTimeout = cfg.DBQueryTimeout
+
+	if cfg.BackupTo != "" {
+		size, err := database.Backup(cfg.BackupTo)
+		if err != nil {
+			slog.Error("backup failed", "err", err)
+			os.Exit(1)
+		}
+		slog.Info("database backed up", "to", cfg.BackupTo, "bytes", size)
+		return
+	}
 
 
 
@@ -140,12 +183,111 @@ func main() {
 		}
 	}
 
+	if cfg.TemplatesPath != "" {
+		templates, err := loadRoomTemplates(cfg.TemplatesPath)
+		if err != nil {
+			slog.Error("failed to load room templates", "err", err)
+		} else if err := database.SyncServerTemplates(templates); err != nil {
+			slog.Error("failed to sync room templates", "err", err)
+		} else {
+			slog.Info("room templates loaded", "count", len(templates))
+		}
+	}
+
 	hub := ws.NewHub(database)
+	hub.ResumeTokenTTL = cfg.ResumeTokenTTL
+	hub.MaxSessionAge = cfg.MaxSessionAge
+	hub.SessionExpiryWarning = cfg.SessionExpiryWarning
+	hub.ReplayBuffer = cfg.ReplayBuffer
+	hub.LateJoinEvents = cfg.LateJoinEvents
+	hub.CompressionLevel = cfg.CompressionLevel
+	if !ws.ValidSlowConsumer(cfg.SlowConsumer) {
+		slog.Error("unknown slow consumer policy", "policy", cfg.SlowConsumer)
+		os.Exit(1)
+	}
+	hub.SendQueueLimit = cfg.SendQueueLimit
+	hub.SlowConsumer = cfg.SlowConsumer
+	hub.BatchInterval = cfg.BatchInterval
+	if cfg.TickInterval <= 0 {
+		slog.Error("tick interval must be positive", "interval", cfg.TickInterval)
+		os.Exit(1)
+	}
+	hub.TickInterval = cfg.TickInterval
+	hub.ConnRPCLimit = cfg.ConnRPCLimit
+	hub.UserRPCs = ws.NewRateLimiter(cfg.UserRPCLimit)
+	hub.IPConnects = ws.NewRateLimiter(cfg.IPConnectLimit)
+	if err := hub.ReloadIPBans(); err != nil {
+		slog.Error("failed to load ip bans", "err", err)
+	}
+	switch cfg.Registration {
+	case ws.RegistrationOpen, ws.RegistrationInvite:
+	case ws.RegistrationSecret:
+		if cfg.JoinSecret == "" {
+			slog.Error("registration mode secret needs CLAUDIO_JOIN_SECRET")
+			os.Exit(1)
+		}
+	default:
+		slog.Error("unknown registration mode", "mode", cfg.Registration)
+		os.Exit(1)
+	}
+	if cfg.ClusterURL != "" {
+		broker, err := cluster.Open(cfg.ClusterURL, cfg.ClusterChannel)
+		if err != nil {
+			slog.Error("failed to join cluster", "err", err)
+			os.Exit(1)
+		}
+		if err := hub.StartCluster(broker); err != nil {
+			slog.Error("failed to join cluster", "err", err)
+			os.Exit(1)
+		}
+	}
+	hub.Registration = cfg.Registration
+	hub.JoinSecret = cfg.JoinSecret
+	if cfg.JWTSecret != "" || cfg.JWTPublicKeysPath != "" {
+		verifier := &ws.JWTVerifier{
+			Issuer:     cfg.JWTIssuer,
+			Audience:   cfg.JWTAudience,
+			HMACSecret: []byte(cfg.JWTSecret),
+			UserClaim:  cfg.JWTUserClaim,
+			NameClaim:  cfg.JWTNameClaim,
+		}
+		if cfg.JWTPublicKeysPath != "" {
+			keys, err := ws.LoadJWTPublicKeys(cfg.JWTPublicKeysPath)
+			if err != nil {
+				slog.Error("failed to load JWT public keys", "err", err)
+				os.Exit(1)
+			}
+			verifier.PublicKeys = keys
+		}
+		if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+			slog.Warn("JWT connect enabled without CLAUDIO_JWT_ISSUER and CLAUDIO_JWT_AUDIENCE; any token from these keys is accepted")
+		}
+		hub.JWT = verifier
+		slog.Info("JWT connect enabled", "issuer", cfg.JWTIssuer)
+	}
 	keyDir := filepath.Dir(cfg.DBPath)
 	router := rpc.NewRouter(hub, database, keyDir)
 	router.ExternalURL = cfg.ExternalURL
-
-	go hub.Run()
+	if cfg.TranslateURL != "" {
+		router.Translator = rpc.NewLibreTranslator(cfg.TranslateURL, cfg.TranslateAPIKey)
+		slog.Info("message translation enabled", "url", cfg.TranslateURL)
+	}
+	var filters rpc.FilterChain
+	if cfg.FilterWordlistPath != "" {
+		wordlist, err := rpc.LoadWordlistFilter(cfg.FilterWordlistPath)
+		if err != nil {
+			slog.Error("failed to load content filter wordlist", "err", err)
+			os.Exit(1)
+		}
+		filters = append(filters, wordlist)
+	}
+	if cfg.FilterWebhookURL != "" {
+		filters = append(filters, rpc.NewWebhookFilter(cfg.FilterWebhookURL))
+	}
+	if len(filters) > 0 {
+		router.MessageFilter = filters
+		slog.Info("content filter available", "filters", len(filters))
+	}
 
 	// Initialize APNs client (optional — server works without it)
 	var apnsClient *apns.Client
@@ -160,17 +302,70 @@ func main() {
 		slog.Info("APNs not configured, push notifications disabled")
 	}
 
+	// Attachment storage — blobs are shared across identical uploads and
+	// collected once nothing references them
+	mediaStore, err := media.NewStore(cfg.MediaDir)
+	if err != nil {
+		slog.Error("failed to open media store", "err", err)
+		os.Exit(1)
+	}
+	go mediaStore.RunGC(database, time.Hour, 24*time.Hour)
+	router.Media = mediaStore
+	router.MaxUploadBytes = cfg.MaxUploadBytes
+	router.MaxMessageLength = cfg.MaxMessageLength
+	router.LongMessageAsAttachment = cfg.LongMessageAsAttachment
+	router.AgentChains = openclaw.NewChainGuard(cfg.MaxAgentChainDepth)
+	router.AgentThrottle = openclaw.NewThrottle(cfg.AgentMinInterval, cfg.AgentBreakerLimit, cfg.AgentBreakerWindow)
+	router.AgentConcurrency = cfg.AgentConcurrency
+	router.AgentQueueLimit = cfg.AgentQueueLimit
+	router.AgentQueueStrategy = cfg.AgentQueueStrategy
+	router.AgentMentionOrder = cfg.AgentMentionOrder
+	router.ServerAdmins = serverAdminIDs(cfg.Admins)
+	router.ReportWebhookURL = cfg.ReportWebhookURL
+	router.BackupDir = cfg.BackupDir
+	router.MessageLimiter = ws.NewRateLimiter(cfg.UserMessageLimit)
+	router.OpenClawPool.Timeouts.Request = cfg.OpenclawRequestTimeout
+	router.OpenClawPool.Timeouts.Chat = cfg.OpenclawChatTimeout
+	router.OpenClawPool.IdleTimeout = cfg.OpenclawIdleTimeout
+	router.OpenClawPool.MaxConns = cfg.OpenclawMaxConns
+	router.OpenClawPool.Compression = cfg.Compression
+	if cfg.AgentProbeInterval > 0 {
+		router.StartAgentProber(cfg.AgentProbeInterval)
+	}
+	router.StartAgentScheduler(30 * time.Second)
+	if cfg.MaintenanceSchedule != "" {
+		schedule, err := cron.Parse(cfg.MaintenanceSchedule)
+		if err != nil {
+			slog.Error("invalid maintenance schedule", "schedule", cfg.MaintenanceSchedule, "err", err)
+			os.Exit(1)
+		}
+		router.StartMaintenance(schedule, db.RetentionPolicy{AuthEvents: cfg.AuthEventRetention})
+	}
+
 	// Initialize relay manager for DM push notifications
 	relayMgr := relay.NewManager(database, apnsClient)
 	relayMgr.LoadAll()
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		ip := ws.RequestIP(r, cfg.TrustedProxies)
+		wait, banned := hub.AdmitIP(ip)
+		if banned {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+			http.Error(w, "Too many connections", http.StatusTooManyRequests)
+			return
+		}
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			slog.Error("upgrade failed", "err", err)
 			return
 		}
 		client := ws.NewClient(hub, conn)
+		client.SetRemoteIP(ip)
+		client.SetUserAgent(r.UserAgent())
 		hub.Register(client)
 		go client.WritePump()
 		go client.ReadPump()
@@ -216,13 +411,104 @@ func main() {
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"serverURL":  "https://" + cfg.ExternalURL,
-			"inviteCode": inviteCode,
-			"roomName":   room.Name,
-			"roomEmoji":  room.Emoji,
+			"serverURL":     "https://" + cfg.ExternalURL,
+			"inviteCode":    inviteCode,
+			"roomName":      room.Name,
+			"roomEmoji":     room.Emoji,
+			"roomTopic":     room.Topic,
+			"roomAvatarUrl": room.AvatarURL,
+			"role":          invite.Role,
 		})
 	})
 
+	// Media upload — body is the raw file; the token comes from rooms.createUpload
+	http.HandleFunc("/media/upload/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := strings.TrimPrefix(r.URL.Path, "/media/upload/")
+		pending, ok := router.ConsumeUpload(token)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid or expired upload token"})
+			return
+		}
+
+		// Read the body without the store's lock, so a slow uploader can't hold up GC
+		blob, err := mediaStore.Stage(r.Body, cfg.MaxUploadBytes)
+		if err == media.ErrTooLarge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "file too large", "maxBytes": cfg.MaxUploadBytes})
+			return
+		}
+		if err != nil {
+			slog.Error("media upload failed", "err", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "upload failed"})
+			return
+		}
+		defer blob.Discard()
+
+		// Hold the store's read lock until the blob is referenced so GC can't race us
+		mediaStore.RLock()
+		defer mediaStore.RUnlock()
+		if err := blob.Commit(); err != nil {
+			slog.Error("media upload failed", "err", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "upload failed"})
+			return
+		}
+
+		attachment, err := database.CreateAttachment(rpc.GenerateMsgID(), pending.RoomID, pending.UserID, blob.Hash, blob.Size, pending.Filename, pending.ContentType)
+		if err != nil {
+			slog.Error("create attachment failed", "err", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "upload failed"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"attachment": attachment})
+	})
+
+	// Media download — attachment IDs are unguessable, so the URL is the capability
+	http.HandleFunc("/media/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		attachment, err := database.GetAttachment(strings.TrimPrefix(r.URL.Path, "/media/"))
+		if err != nil || attachment == nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := mediaStore.Open(attachment.BlobHash)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		disposition := "attachment"
+		if ct := attachment.ContentType; strings.HasPrefix(ct, "image/") || strings.HasPrefix(ct, "audio/") || strings.HasPrefix(ct, "video/") {
+			disposition = "inline"
+		}
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		http.ServeContent(w, r, "", attachment.CreatedAt, f)
+	})
+
 	// Push: register device token (+ optional OpenClaw relay info)
 	http.HandleFunc("/push/register", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -421,6 +707,48 @@ func main() {
 		})
 	})
 
+	// OpenClaw: pooled agent connection health
+	http.HandleFunc("/openclaw/status", func(w http.ResponseWriter, r *http.Request) {
+		health := router.OpenClawPool.Health()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"deviceId":    router.OpenClawPool.DeviceID(),
+			"connections": health,
+			"count":       len(health),
+			"stats":       router.OpenClawPool.Stats(),
+		})
+	})
+
+	// OpenClaw: rotate the device key agents connect with (admin only)
+	http.HandleFunc("/openclaw/rotate-key", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if cfg.AdminSecret == "" || !strings.HasPrefix(auth, "Bearer ") || strings.TrimPrefix(auth, "Bearer ") != cfg.AdminSecret {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		deviceID, err := router.OpenClawPool.RotateKey()
+		if err != nil {
+			slog.Error("rotate openclaw device key failed", "err", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "rotate failed"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":       true,
+			"deviceId": deviceID,
+		})
+	})
+
 	// Push: test — send a test notification to a device
 	http.HandleFunc("/push/test", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -556,7 +884,7 @@ Once registered, humans in the room can @mention you and you will receive and re
 			}
 
 			// Verify we can actually connect to this OpenClaw server
-			testClient, err := router.OpenClawPool.Get(req.OpenclawURL, req.OpenclawToken)
+			testClient, err := router.OpenClawPool.Get(r.Context(), req.OpenclawURL, req.OpenclawToken)
 			if err != nil {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusBadGateway)
@@ -581,6 +909,8 @@ Once registered, humans in the room can @mention you and you will receive and re
 				"isAgent":     true,
 			}), nil)
 
+			router.PostSystemMessage(room.ID, req.AgentName+" joined")
+
 			slog.Info("agent joined via relay", "agent", req.AgentName, "room", room.Name, "openclawUrl", req.OpenclawURL)
 
 			w.Header().Set("Content-Type", "application/json")
@@ -1018,6 +1348,9 @@ Once registered, humans in the room can @mention you and you will receive and re
 			}
 		}()
 
+		// Chain depth of the agent's next reply (see openclaw.ChainGuard)
+		replyDepth := 1
+
 		// Main loop: forward @mentions to agent, post responses to room
 		for {
 			select {
@@ -1036,6 +1369,7 @@ Once registered, humans in the room can @mention you and you will receive and re
 					Payload struct {
 						RoomID  string `json:"roomId"`
 						Message struct {
+							ID                 string  `json:"id"`
 							SenderAgentID      *string `json:"senderAgentId"`
 							SenderDisplayName  string  `json:"senderDisplayName"`
 							Content            string  `json:"content"`
@@ -1057,13 +1391,30 @@ Once registered, humans in the room can @mention you and you will receive and re
 				if !strings.Contains(strings.ToLower(evt.Payload.Message.Content), mention) {
 					continue
 				}
+				// Agent messages only dispatch in rooms that allow agent chains
+				depth := router.AgentChains.Depth(evt.Payload.Message.ID, evt.Payload.Message.SenderAgentID != nil)
+				if depth > 0 {
+					enabled, _ := database.RoomAllowsAgentChains(roomID)
+					if !router.AgentChains.Allow(depth, enabled) {
+						continue
+					}
+				}
+				if !router.AllowAgentDispatch(roomID, agentID, identity.AgentName) {
+					continue
+				}
+				replyDepth = depth + 1
 
 				// Send mention to agent
+				sessionKey, err := database.AgentSessionKey(roomID, agentID, identity.AgentID)
+				if err != nil {
+					slog.Error("agent-ws: session lookup failed", "err", err)
+					continue
+				}
 				conn.WriteJSON(map[string]interface{}{
 					"type":       "mention",
 					"sender":     evt.Payload.Message.SenderDisplayName,
 					"content":    evt.Payload.Message.Content,
-					"sessionKey": "agent:" + identity.AgentID + ":" + roomID,
+					"sessionKey": sessionKey,
 				})
 
 				// Send typing indicator
@@ -1080,6 +1431,7 @@ Once registered, humans in the room can @mention you and you will receive and re
 					slog.Error("agent-ws: insert failed", "err", err)
 					continue
 				}
+				router.AgentChains.Record(msg.ID, replyDepth)
 				hub.BroadcastToRoom(roomID, ws.NewEvent("room.message", map[string]interface{}{
 					"roomId":  roomID,
 					"message": msg,
@@ -1096,8 +1448,27 @@ Once registered, humans in the room can @mention you and you will receive and re
 		fmt.Fprint(w, agentBridgeScript)
 	})
 
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout, // WebSockets set their own deadlines once upgraded
+	}
+
+	// On SIGINT/SIGTERM, close WebSockets with a shutdown code so clients
+	// reconnect with jitter instead of all at once, then drain HTTP.
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		slog.Info("shutting down", "connections", hub.Shutdown())
+		hub.StopCluster()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
 	slog.Info("claudio-server starting", "addr", cfg.ListenAddr)
-	if err := http.ListenAndServe(cfg.ListenAddr, nil); err != nil {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("server failed", "err", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,63 @@
+package media
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Index is the database side of blob bookkeeping.
+type Index interface {
+	// DeleteOrphanAttachments removes uploads never attached to anything.
+	DeleteOrphanAttachments(olderThan time.Duration) (int64, error)
+	// UnreferencedBlobs lists blobs with no remaining references.
+	UnreferencedBlobs() ([]string, error)
+	// DeleteBlob removes the blob record if it is still unreferenced.
+	DeleteBlob(hash string) (bool, error)
+}
+
+// CollectGarbage drops abandoned uploads and deletes blobs nothing references.
+func (s *Store) CollectGarbage(idx Index, orphanAge time.Duration) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, err := idx.DeleteOrphanAttachments(orphanAge); err != nil {
+		return 0, err
+	}
+	hashes, err := idx.UnreferencedBlobs()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, hash := range hashes {
+		deleted, err := idx.DeleteBlob(hash)
+		if err != nil {
+			return removed, err
+		}
+		if !deleted {
+			continue
+		}
+		if err := s.Remove(hash); err != nil {
+			slog.Warn("media gc: remove blob failed", "hash", hash, "err", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// RunGC collects garbage every interval. It never returns.
+func (s *Store) RunGC(idx Index, interval, orphanAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := s.CollectGarbage(idx, orphanAge)
+		if err != nil {
+			slog.Error("media gc failed", "err", err)
+			continue
+		}
+		if removed > 0 {
+			slog.Info("media gc: removed unreferenced blobs", "count", removed)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrTooLarge is returned by Put when the content exceeds the size limit.
+var ErrTooLarge = errors.New("content too large")
+
+// Store keeps blobs on disk addressed by the hex SHA-256 of their content,
+// so identical uploads share a single file.
+//
+// Uploads hold the read lock from adding a blob (Put, or Staged.Commit)
+// until it is referenced in the database; garbage collection takes the
+// write lock so it never deletes a blob that an in-flight upload is about
+// to reference.
+type Store struct {
+	sync.RWMutex
+	dir string
+}
+
+// NewStore creates a store rooted at dir, creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create media dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Put streams r to disk and returns its content hash and size. If a blob with
+// the same hash already exists, the new copy is discarded. Callers hold the
+// read lock; to keep it only briefly, Stage slow readers first instead.
+func (s *Store) Put(r io.Reader, maxBytes int64) (hash string, size int64, err error) {
+	b, err := s.Stage(r, maxBytes)
+	if err != nil {
+		return "", 0, err
+	}
+	defer b.Discard()
+	if err := b.Commit(); err != nil {
+		return "", 0, err
+	}
+	return b.Hash, b.Size, nil
+}
+
+// Staged is content written to a temp file by Stage but not yet in the
+// store.
+type Staged struct {
+	Hash string
+	Size int64
+	s    *Store
+	tmp  string
+}
+
+// Stage streams r to a temp file in the store's directory and hashes it,
+// without the lock, so a slow reader such as an upload body doesn't hold
+// up garbage collection. Commit adds it to the store; Discard drops it.
+func (s *Store) Stage(r io.Reader, maxBytes int64) (*Staged, error) {
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	b := &Staged{s: s, tmp: tmp.Name()}
+
+	h := sha256.New()
+	b.Size, err = io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, maxBytes+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && b.Size > maxBytes {
+		err = ErrTooLarge
+	} else if err != nil {
+		err = fmt.Errorf("write upload: %w", err)
+	}
+	if err != nil {
+		b.Discard()
+		return nil, err
+	}
+	b.Hash = hex.EncodeToString(h.Sum(nil))
+	return b, nil
+}
+
+// Commit moves the staged content into the store, unless a blob with the
+// same hash is already there. Hold the read lock from Commit until the
+// blob is referenced in the database.
+func (b *Staged) Commit() error {
+	path := b.s.path(b.Hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil // already stored
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create blob dir: %w", err)
+	}
+	if err := os.Rename(b.tmp, path); err != nil {
+		return fmt.Errorf("store blob: %w", err)
+	}
+	return nil
+}
+
+// Discard removes the temp file; after Commit it's a no-op.
+func (b *Staged) Discard() {
+	os.Remove(b.tmp)
+}
+
+// Open returns the blob with the given hash.
+func (s *Store) Open(hash string) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(s.path(hash))
+}
+
+// Remove deletes a blob. Removing a missing blob is not an error.
+func (s *Store) Remove(hash string) error {
+	if !validHash(hash) {
+		return nil
+	}
+	if err := os.Remove(s.path(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path shards blobs by the first two hex chars to keep directories small.
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
@@ -0,0 +1,113 @@
+package media
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutDeduplicates(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h1, n1, err := s.Put(strings.NewReader("hello"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, n2, err := s.Put(strings.NewReader("hello"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 != h2 || n1 != 5 || n2 != 5 {
+		t.Fatalf("Put = (%s, %d), (%s, %d); want identical hashes of size 5", h1, n1, h2, n2)
+	}
+
+	f, err := s.Open(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "hello" {
+		t.Errorf("content = %q, want hello", data)
+	}
+
+	if err := s.Remove(h1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(h1); err == nil {
+		t.Error("Open after Remove succeeded")
+	}
+}
+
+func TestPutTooLarge(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Put(strings.NewReader("0123456789"), 5); err != ErrTooLarge {
+		t.Errorf("err = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestStageRunsDuringGC(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Staging doesn't wait for the lock garbage collection holds
+	s.Lock()
+	staged := make(chan *Staged, 1)
+	go func() {
+		b, err := s.Stage(strings.NewReader("hello"), 100)
+		if err != nil {
+			t.Error(err)
+		}
+		staged <- b
+	}()
+	var b *Staged
+	select {
+	case b = <-staged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stage blocked on the store lock")
+	}
+	s.Unlock()
+	if b == nil {
+		return
+	}
+
+	s.RLock()
+	err = b.Commit()
+	s.RUnlock()
+	b.Discard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := s.Open(b.Hash); err != nil {
+		t.Fatal(err)
+	} else {
+		f.Close()
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "upload-") {
+			t.Errorf("temp file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestOpenRejectsBadHash(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open("../../etc/passwd"); err == nil {
+		t.Error("Open accepted a path-like hash")
+	}
+}
@@ -1,12 +1,12 @@
 package openclaw
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -17,6 +17,10 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// ErrConnectRejected means the server refused the connect handshake, usually
+// because the token is wrong.
+var ErrConnectRejected = errors.New("connect rejected")
+
 type Client struct {
 	url       string
 	token     string
@@ -31,6 +35,17 @@ type Client struct {
 	events chan wireMessage
 	done   chan struct{}
 
+	// Chat events are routed to the ChatSend calls waiting on their session,
+	// so concurrent calls on one connection don't see each other's events.
+	chatSubs map[string]map[*chatSub]bool // key: sessionKey
+	chatMu   sync.Mutex
+
+	// Timeouts applies when a caller's context has no deadline of its own.
+	Timeouts Timeouts
+	// Compression offers permessage-deflate when dialing; the server may
+	// decline it.
+	Compression bool
+
 	// Ed25519 device identity
 	privateKey ed25519.PrivateKey
 	publicKey  ed25519.PublicKey
@@ -55,23 +70,51 @@ type wireError struct {
 }
 
 type ChatResponse struct {
-	Text string
+	Text   string
+	Blocks []ContentBlock // images and tool activity, in order
+}
+
+// Timeouts bounds how long client operations wait when the caller's context
+// carries no deadline.
+type Timeouts struct {
+	Handshake time.Duration // dial and authenticate
+	Request   time.Duration // one request/response round trip
+	Chat      time.Duration // a whole agent response in ChatSend
+}
+
+// DefaultTimeouts are used by new clients and pools.
+var DefaultTimeouts = Timeouts{
+	Handshake: 10 * time.Second,
+	Request:   60 * time.Second,
+	Chat:      120 * time.Second,
+}
+
+// withDefaultTimeout bounds ctx by d unless it already has a deadline.
+func withDefaultTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
 }
 
+// NewClient creates a client with a throwaway device identity, so the server
+// sees a new device each time. Long-lived connections should go through a
+// Pool, which persists its identity.
 func NewClient(url, token string) *Client {
 	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
-	hash := sha256.Sum256(pub)
-	deviceID := hex.EncodeToString(hash[:])
-	return NewClientWithIdentity(url, token, priv, pub, deviceID)
+	return NewClientWithIdentity(url, token, priv, pub, deviceIDFor(pub))
 }
 
+// NewClientWithIdentity creates a client that connects as the given device.
 func NewClientWithIdentity(url, token string, priv ed25519.PrivateKey, pub ed25519.PublicKey, deviceID string) *Client {
 	return &Client{
 		url:        url,
 		token:      token,
 		pending:    make(map[string]chan json.RawMessage),
 		events:     make(chan wireMessage, 100),
+		chatSubs:   make(map[string]map[*chatSub]bool),
 		done:       make(chan struct{}),
+		Timeouts:   DefaultTimeouts,
 		privateKey: priv,
 		publicKey:  pub,
 		deviceID:   deviceID,
@@ -84,7 +127,11 @@ func (c *Client) IsConnected() bool {
 	return c.connected
 }
 
-func (c *Client) Connect() error {
+// Connect dials and authenticates. ctx bounds the whole handshake.
+func (c *Client) Connect(ctx context.Context) error {
+	ctx, cancel := withDefaultTimeout(ctx, c.Timeouts.Handshake)
+	defer cancel()
+
 	url := c.url
 	scheme := "wss://"
 	if strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "http://") {
@@ -96,7 +143,9 @@ func (c *Client) Connect() error {
 	url = strings.TrimSuffix(url, "/")
 	wsURL := scheme + url
 
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = c.Compression
+	conn, _, err := dialer.DialContext(ctx, wsURL, nil)
 	if err != nil {
 		return fmt.Errorf("dial %s: %w", wsURL, err)
 	}
@@ -107,7 +156,7 @@ func (c *Client) Connect() error {
 
 	go c.readLoop()
 
-	if err := c.authenticate(); err != nil {
+	if err := c.authenticate(ctx); err != nil {
 		conn.Close()
 		return fmt.Errorf("auth: %w", err)
 	}
@@ -178,6 +227,9 @@ func (c *Client) readLoop() {
 
 		// Event?
 		if msg.Type == "event" {
+			if c.routeChatEvent(msg) {
+				continue
+			}
 			select {
 			case c.events <- msg:
 			default:
@@ -186,7 +238,12 @@ func (c *Client) readLoop() {
 	}
 }
 
-func (c *Client) send(method string, params interface{}) (wireMessage, error) {
+// send issues a request and waits for its response. If ctx ends first the
+// request is dropped from the pending map and ctx's error is returned.
+func (c *Client) send(ctx context.Context, method string, params interface{}) (wireMessage, error) {
+	ctx, cancel := withDefaultTimeout(ctx, c.Timeouts.Request)
+	defer cancel()
+
 	id := fmt.Sprintf("go-%d", c.nextID.Add(1))
 
 	ch := make(chan json.RawMessage, 1)
@@ -221,11 +278,14 @@ func (c *Client) send(method string, params interface{}) (wireMessage, error) {
 		var resp wireMessage
 		json.Unmarshal(raw, &resp)
 		return resp, nil
-	case <-time.After(60 * time.Second):
+	case <-ctx.Done():
 		c.pendingMu.Lock()
 		delete(c.pending, id)
 		c.pendingMu.Unlock()
-		return wireMessage{}, fmt.Errorf("timeout waiting for %s response", method)
+		if ctx.Err() == context.DeadlineExceeded {
+			return wireMessage{}, fmt.Errorf("timeout waiting for %s response", method)
+		}
+		return wireMessage{}, ctx.Err()
 	case <-c.done:
 		return wireMessage{}, fmt.Errorf("connection closed")
 	}
@@ -240,10 +300,9 @@ func base64URLEncode(data []byte) string {
 	return s
 }
 
-func (c *Client) authenticate() error {
+func (c *Client) authenticate(ctx context.Context) error {
 	// Wait for connect.challenge event
 	var nonce string
-	timeout := time.After(10 * time.Second)
 	for {
 		select {
 		case evt := <-c.events:
@@ -254,8 +313,8 @@ func (c *Client) authenticate() error {
 					nonce = n
 				}
 			}
-		case <-timeout:
-			return fmt.Errorf("timeout waiting for challenge")
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for challenge: %w", ctx.Err())
 		case <-c.done:
 			return fmt.Errorf("connection closed before challenge")
 		}
@@ -299,23 +358,81 @@ func (c *Client) authenticate() error {
 		},
 	}
 
-	resp, err := c.send("connect", params)
+	resp, err := c.send(ctx, "connect", params)
 	if err != nil {
 		return err
 	}
 
 	if resp.Error != nil {
-		return fmt.Errorf("connect error: %s: %s", resp.Error.Code, resp.Error.Message)
+		return fmt.Errorf("%w: %s: %s", ErrConnectRejected, resp.Error.Code, resp.Error.Message)
 	}
 	if !resp.OK {
-		return fmt.Errorf("connect rejected")
+		return ErrConnectRejected
 	}
 
 	return nil
 }
 
-// ChatSend sends a message to an agent and waits for the final chat event response.
-func (c *Client) ChatSend(sessionKey, message string) (*ChatResponse, error) {
+// chatSub receives the chat events for one ChatSend call.
+type chatSub struct {
+	sessionKey string
+	ch         chan wireMessage
+}
+
+// chatSubBuffer is large enough to hold a whole streamed response; events
+// that don't fit are dropped rather than stalling the read loop.
+const chatSubBuffer = 256
+
+func (c *Client) subscribeChat(sessionKey string) *chatSub {
+	sub := &chatSub{sessionKey: sessionKey, ch: make(chan wireMessage, chatSubBuffer)}
+	c.chatMu.Lock()
+	defer c.chatMu.Unlock()
+	if c.chatSubs[sessionKey] == nil {
+		c.chatSubs[sessionKey] = make(map[*chatSub]bool)
+	}
+	c.chatSubs[sessionKey][sub] = true
+	return sub
+}
+
+func (c *Client) unsubscribeChat(sub *chatSub) {
+	c.chatMu.Lock()
+	defer c.chatMu.Unlock()
+	delete(c.chatSubs[sub.sessionKey], sub)
+	if len(c.chatSubs[sub.sessionKey]) == 0 {
+		delete(c.chatSubs, sub.sessionKey)
+	}
+}
+
+// routeChatEvent delivers a chat event to the ChatSend calls waiting on its
+// session and reports whether any were.
+func (c *Client) routeChatEvent(msg wireMessage) bool {
+	if msg.Event != "chat" {
+		return false
+	}
+	var payload struct {
+		SessionKey string `json:"sessionKey"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.SessionKey == "" {
+		return false
+	}
+
+	c.chatMu.Lock()
+	defer c.chatMu.Unlock()
+	subs := c.chatSubs[payload.SessionKey]
+	for sub := range subs {
+		select {
+		case sub.ch <- msg:
+		default:
+			slog.Warn("openclaw: chat event dropped", "sessionKey", payload.SessionKey)
+		}
+	}
+	return len(subs) > 0
+}
+
+// ChatSend sends a message to an agent and waits for the final chat event
+// response. If no final event arrives within Timeouts.Chat, any partial text
+// is returned. Cancelling ctx aborts the run on the OpenClaw server.
+func (c *Client) ChatSend(ctx context.Context, sessionKey, message string) (*ChatResponse, error) {
 	params := map[string]interface{}{
 		"sessionKey":     sessionKey,
 		"message":        message,
@@ -323,7 +440,11 @@ func (c *Client) ChatSend(sessionKey, message string) (*ChatResponse, error) {
 		"idempotencyKey": fmt.Sprintf("srv-%d", time.Now().UnixNano()),
 	}
 
-	resp, err := c.send("chat.send", params)
+	// Subscribe before sending: events can arrive ahead of the response
+	sub := c.subscribeChat(sessionKey)
+	defer c.unsubscribeChat(sub)
+
+	resp, err := c.send(ctx, "chat.send", params)
 	if err != nil {
 		return nil, fmt.Errorf("chat.send: %w", err)
 	}
@@ -334,68 +455,183 @@ func (c *Client) ChatSend(sessionKey, message string) (*ChatResponse, error) {
 		}
 		return nil, fmt.Errorf("chat.send rejected: %s", errMsg)
 	}
+	// The run ID tells our events apart from other runs on the same session
+	var accepted struct {
+		RunID string `json:"runId"`
+	}
+	json.Unmarshal(resp.Payload, &accepted)
 
 	// Collect chat events until state=="final"
 	var fullText string
-	timeout := time.After(120 * time.Second)
+	var blocks []ContentBlock
+	timer := time.NewTimer(c.Timeouts.Chat)
+	defer timer.Stop()
 	for {
 		select {
-		case evt := <-c.events:
-			if evt.Event == "tick" {
-				continue
-			}
-			if evt.Event != "chat" {
-				continue
-			}
+		case evt := <-sub.ch:
 			var payload map[string]interface{}
 			json.Unmarshal(evt.Payload, &payload)
+			if runID, _ := payload["runId"].(string); accepted.RunID != "" && runID != "" && runID != accepted.RunID {
+				continue
+			}
 
 			state, _ := payload["state"].(string)
-			text := extractChatText(payload)
+			text, eventBlocks := extractChatContent(payload)
 
 			switch state {
 			case "delta":
 				fullText += text
+				blocks = append(blocks, eventBlocks...)
 			case "final":
 				if text != "" {
 					fullText = text
 				}
-				return &ChatResponse{Text: fullText}, nil
+				// The final message repeats the whole reply
+				if len(eventBlocks) > 0 {
+					blocks = eventBlocks
+				}
+				return &ChatResponse{Text: fullText, Blocks: blocks}, nil
 			case "error":
 				errMsg, _ := payload["error"].(string)
 				return nil, fmt.Errorf("agent error: %s", errMsg)
 			case "aborted":
 				return nil, fmt.Errorf("agent aborted")
 			}
-		case <-timeout:
+		case <-timer.C:
 			if fullText != "" {
-				return &ChatResponse{Text: fullText}, nil
+				return &ChatResponse{Text: fullText, Blocks: blocks}, nil
 			}
 			return nil, fmt.Errorf("timeout waiting for agent response")
 		case <-c.done:
 			return nil, fmt.Errorf("connection closed during chat")
+		case <-ctx.Done():
+			// ctx is finished, so the abort needs its own
+			if err := c.ChatAbort(context.Background(), sessionKey); err != nil {
+				slog.Warn("openclaw: abort failed", "sessionKey", sessionKey, "err", err)
+			}
+			return nil, ctx.Err()
 		}
 	}
 }
 
-func extractChatText(payload map[string]interface{}) string {
-	msg, ok := payload["message"].(map[string]interface{})
-	if !ok {
-		return ""
+// AgentInfo describes an agent configured on an OpenClaw server.
+type AgentInfo struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Emoji        string   `json:"emoji,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Default      bool     `json:"default,omitempty"`
+}
+
+// ListAgents returns the agents available on the server.
+func (c *Client) ListAgents(ctx context.Context) ([]AgentInfo, error) {
+	resp, err := c.send(ctx, "agents.list", map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("agents.list: %w", err)
 	}
-	content, ok := msg["content"].([]interface{})
-	if !ok {
-		return ""
+	if !resp.OK {
+		errMsg := "unknown error"
+		if resp.Error != nil {
+			errMsg = resp.Error.Message
+		}
+		return nil, fmt.Errorf("agents.list rejected: %s", errMsg)
+	}
+	return parseAgentList(resp.Payload)
+}
+
+// parseAgentList accepts either a bare array or {"agents": [...]}, and
+// agents whose name and emoji live under "identity".
+func parseAgentList(payload json.RawMessage) ([]AgentInfo, error) {
+	type rawAgent struct {
+		ID           string   `json:"id"`
+		AgentID      string   `json:"agentId"`
+		Name         string   `json:"name"`
+		Emoji        string   `json:"emoji"`
+		Model        string   `json:"model"`
+		Capabilities []string `json:"capabilities"`
+		Default      bool     `json:"default"`
+		Identity     struct {
+			Name  string `json:"name"`
+			Emoji string `json:"emoji"`
+		} `json:"identity"`
 	}
-	var texts []string
-	for _, block := range content {
-		bm, ok := block.(map[string]interface{})
-		if !ok {
+	var list []rawAgent
+	if err := json.Unmarshal(payload, &list); err != nil {
+		var wrapped struct {
+			Agents []rawAgent `json:"agents"`
+		}
+		if err := json.Unmarshal(payload, &wrapped); err != nil {
+			return nil, fmt.Errorf("parse agents.list: %w", err)
+		}
+		list = wrapped.Agents
+	}
+
+	agents := make([]AgentInfo, 0, len(list))
+	for _, a := range list {
+		info := AgentInfo{
+			ID:           a.ID,
+			Name:         a.Name,
+			Emoji:        a.Emoji,
+			Model:        a.Model,
+			Capabilities: a.Capabilities,
+			Default:      a.Default,
+		}
+		if info.ID == "" {
+			info.ID = a.AgentID
+		}
+		if info.ID == "" {
 			continue
 		}
-		if t, ok := bm["text"].(string); ok && t != "" {
-			texts = append(texts, t)
+		if info.Name == "" {
+			info.Name = a.Identity.Name
+		}
+		if info.Name == "" {
+			info.Name = info.ID
 		}
+		if info.Emoji == "" {
+			info.Emoji = a.Identity.Emoji
+		}
+		agents = append(agents, info)
+	}
+	return agents, nil
+}
+
+// Ping checks that the server answers requests. Any reply counts, even an
+// error response; only transport failures and timeouts are errors.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.send(ctx, "health", nil)
+	return err
+}
+
+// ChatAbort stops the agent run in progress for sessionKey, if any.
+func (c *Client) ChatAbort(ctx context.Context, sessionKey string) error {
+	resp, err := c.send(ctx, "chat.abort", map[string]interface{}{
+		"sessionKey": sessionKey,
+	})
+	if err != nil {
+		return fmt.Errorf("chat.abort: %w", err)
+	}
+	if !resp.OK {
+		errMsg := "unknown error"
+		if resp.Error != nil {
+			errMsg = resp.Error.Message
+		}
+		return fmt.Errorf("chat.abort rejected: %s", errMsg)
+	}
+	return nil
+}
+
+// extractChatContent returns the text and other content blocks of a chat
+// event's message.
+func extractChatContent(payload map[string]interface{}) (string, []ContentBlock) {
+	msg, ok := payload["message"].(map[string]interface{})
+	if !ok {
+		return "", nil
+	}
+	content, ok := msg["content"].([]interface{})
+	if !ok {
+		return "", nil
 	}
-	return strings.Join(texts, "")
+	return ParseContentBlocks(content)
 }
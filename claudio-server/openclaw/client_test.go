@@ -1,6 +1,7 @@
 package openclaw
 
 import (
+	"context"
 	"os"
 	"testing"
 )
@@ -13,7 +14,7 @@ func TestLiveConnect(t *testing.T) {
 	}
 
 	c := NewClient(url, token)
-	err := c.Connect()
+	err := c.Connect(context.Background())
 	if err != nil {
 		t.Fatalf("Connect failed: %v", err)
 	}
@@ -33,13 +34,13 @@ func TestLiveChatSend(t *testing.T) {
 	}
 
 	c := NewClient(url, token)
-	err := c.Connect()
+	err := c.Connect(context.Background())
 	if err != nil {
 		t.Fatalf("Connect failed: %v", err)
 	}
 	defer c.Close()
 
-	resp, err := c.ChatSend("agent:hallman:main", "Say hi in one sentence")
+	resp, err := c.ChatSend(context.Background(), "agent:hallman:main", "Say hi in one sentence")
 	if err != nil {
 		t.Fatalf("ChatSend failed: %v", err)
 	}
@@ -49,3 +50,47 @@ func TestLiveChatSend(t *testing.T) {
 	}
 	t.Logf("Agent response: %s", resp.Text)
 }
+
+func TestParseAgentList(t *testing.T) {
+	agents, err := parseAgentList([]byte(`{"agents":[
+		{"id":"main","default":true,"identity":{"name":"Hallman","emoji":"🎩"}},
+		{"agentId":"coder","name":"Coder","capabilities":["tools"]},
+		{"name":"no id"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 2 {
+		t.Fatalf("got %d agents, want 2", len(agents))
+	}
+	if a := agents[0]; a.ID != "main" || a.Name != "Hallman" || a.Emoji != "🎩" || !a.Default {
+		t.Errorf("agents[0] = %+v", a)
+	}
+	if a := agents[1]; a.ID != "coder" || len(a.Capabilities) != 1 {
+		t.Errorf("agents[1] = %+v", a)
+	}
+
+	bare, err := parseAgentList([]byte(`[{"id":"main"}]`))
+	if err != nil || len(bare) != 1 || bare[0].Name != "main" {
+		t.Errorf("bare array = %+v, %v", bare, err)
+	}
+}
+
+func TestChatEventsRoutedBySession(t *testing.T) {
+	c := NewClient("ws://unused", "token")
+	room1 := c.subscribeChat("agent:main:room1")
+	room2 := c.subscribeChat("agent:main:room2")
+
+	evt := wireMessage{Type: "event", Event: "chat", Payload: []byte(`{"sessionKey":"agent:main:room2","state":"delta"}`)}
+	if !c.routeChatEvent(evt) {
+		t.Fatal("event for a subscribed session was not routed")
+	}
+	if len(room1.ch) != 0 || len(room2.ch) != 1 {
+		t.Errorf("room1 got %d events, room2 got %d; want 0 and 1", len(room1.ch), len(room2.ch))
+	}
+
+	c.unsubscribeChat(room2)
+	if c.routeChatEvent(evt) {
+		t.Error("event routed after unsubscribe")
+	}
+}
@@ -0,0 +1,162 @@
+package openclaw
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// Normalized content block types. Text blocks are folded into the reply text.
+const (
+	BlockImage      = "image"
+	BlockToolCall   = "tool_call"
+	BlockToolResult = "tool_result"
+)
+
+// maxToolOutputLen caps tool output kept on a message; the rest is cut.
+const maxToolOutputLen = 2000
+
+// ContentBlock is a non-text part of an agent reply. OpenClaw, OpenAI and
+// Anthropic each spell these differently; ParseContentBlocks normalizes them.
+type ContentBlock struct {
+	Type         string          `json:"type"`
+	MimeType     string          `json:"mimeType,omitempty"`
+	Data         string          `json:"-"` // base64 image data, stored as an attachment
+	URL          string          `json:"url,omitempty"`
+	AttachmentID string          `json:"attachmentId,omitempty"`
+	ToolName     string          `json:"toolName,omitempty"`
+	ToolCallID   string          `json:"toolCallId,omitempty"`
+	Input        json.RawMessage `json:"input,omitempty"`
+	Output       string          `json:"output,omitempty"`
+	IsError      bool            `json:"isError,omitempty"`
+}
+
+// ParseContentBlocks splits a decoded content array into its text and its
+// other blocks. Unknown block types are dropped.
+func ParseContentBlocks(content []interface{}) (string, []ContentBlock) {
+	var text strings.Builder
+	var blocks []ContentBlock
+	for _, raw := range content {
+		bm, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		typ, _ := bm["type"].(string)
+		switch typ {
+		case "text", "output_text", "":
+			if t, ok := bm["text"].(string); ok {
+				text.WriteString(t)
+			}
+		case "image", "image_url":
+			if b, ok := parseImageBlock(bm); ok {
+				blocks = append(blocks, b)
+			}
+		case "toolCall", "tool_call", "tool_use", "function_call":
+			b := ContentBlock{Type: BlockToolCall}
+			b.ToolCallID = firstString(bm, "id", "toolCallId", "call_id")
+			b.ToolName = firstString(bm, "name", "toolName")
+			for _, key := range []string{"arguments", "input"} {
+				if v, ok := bm[key]; ok {
+					b.Input = rawJSON(v)
+					break
+				}
+			}
+			blocks = append(blocks, b)
+		case "toolResult", "tool_result", "function_call_output":
+			b := ContentBlock{Type: BlockToolResult}
+			b.ToolCallID = firstString(bm, "toolCallId", "tool_use_id", "call_id", "id")
+			b.ToolName = firstString(bm, "toolName", "name")
+			b.IsError, _ = bm["isError"].(bool)
+			if !b.IsError {
+				b.IsError, _ = bm["is_error"].(bool)
+			}
+			b.Output = truncateOutput(toolOutput(bm))
+			blocks = append(blocks, b)
+		}
+	}
+	return text.String(), blocks
+}
+
+// parseImageBlock understands OpenClaw ({data, mimeType}), Anthropic
+// ({source: {type, media_type, data | url}}) and OpenAI ({image_url: {url}}).
+func parseImageBlock(bm map[string]interface{}) (ContentBlock, bool) {
+	b := ContentBlock{Type: BlockImage}
+	b.Data, _ = bm["data"].(string)
+	b.MimeType = firstString(bm, "mimeType", "media_type")
+	b.URL, _ = bm["url"].(string)
+
+	if src, ok := bm["source"].(map[string]interface{}); ok {
+		if b.Data == "" {
+			b.Data, _ = src["data"].(string)
+		}
+		if b.MimeType == "" {
+			b.MimeType, _ = src["media_type"].(string)
+		}
+		if b.URL == "" {
+			b.URL, _ = src["url"].(string)
+		}
+	}
+	switch v := bm["image_url"].(type) {
+	case string:
+		b.URL = v
+	case map[string]interface{}:
+		b.URL, _ = v["url"].(string)
+	}
+
+	// Inline data URLs are stored like base64 data
+	if rest, ok := strings.CutPrefix(b.URL, "data:"); ok {
+		meta, data, found := strings.Cut(rest, ",")
+		if found && strings.HasSuffix(meta, ";base64") {
+			b.MimeType = strings.TrimSuffix(meta, ";base64")
+			b.Data = data
+		}
+		b.URL = ""
+	}
+	if b.MimeType == "" {
+		b.MimeType = "image/png"
+	}
+	return b, b.Data != "" || b.URL != ""
+}
+
+// toolOutput flattens a tool result's content, which may be a string or a
+// list of text blocks.
+func toolOutput(bm map[string]interface{}) string {
+	for _, key := range []string{"content", "output", "result"} {
+		switch v := bm[key].(type) {
+		case string:
+			return v
+		case []interface{}:
+			text, _ := ParseContentBlocks(v)
+			return text
+		}
+	}
+	return ""
+}
+
+func truncateOutput(s string) string {
+	if utf8.RuneCountInString(s) <= maxToolOutputLen {
+		return s
+	}
+	return string([]rune(s)[:maxToolOutputLen]) + "…"
+}
+
+func firstString(m map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := m[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// rawJSON re-encodes a decoded value; strings that hold JSON are kept as is.
+func rawJSON(v interface{}) json.RawMessage {
+	if s, ok := v.(string); ok && json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
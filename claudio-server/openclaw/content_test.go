@@ -0,0 +1,43 @@
+package openclaw
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseContentBlocks(t *testing.T) {
+	var content []interface{}
+	json.Unmarshal([]byte(`[
+		{"type": "text", "text": "Here "},
+		{"type": "image", "data": "aGk=", "mimeType": "image/jpeg"},
+		{"type": "image", "source": {"type": "base64", "media_type": "image/gif", "data": "Z2lm"}},
+		{"type": "image_url", "image_url": {"url": "data:image/webp;base64,d2VicA=="}},
+		{"type": "toolCall", "id": "c1", "name": "search", "arguments": {"q": "go"}},
+		{"type": "toolResult", "toolCallId": "c1", "content": [{"type": "text", "text": "3 hits"}], "isError": false},
+		{"type": "thinking", "thinking": "hmm"},
+		{"type": "text", "text": "you go"}
+	]`), &content)
+
+	text, blocks := ParseContentBlocks(content)
+	if text != "Here you go" {
+		t.Errorf("text = %q", text)
+	}
+	if len(blocks) != 5 {
+		t.Fatalf("blocks = %+v, want 5", blocks)
+	}
+	if b := blocks[0]; b.Type != BlockImage || b.Data != "aGk=" || b.MimeType != "image/jpeg" {
+		t.Errorf("openclaw image = %+v", b)
+	}
+	if b := blocks[1]; b.Data != "Z2lm" || b.MimeType != "image/gif" {
+		t.Errorf("anthropic image = %+v", b)
+	}
+	if b := blocks[2]; b.Data != "d2VicA==" || b.MimeType != "image/webp" || b.URL != "" {
+		t.Errorf("data URL image = %+v", b)
+	}
+	if b := blocks[3]; b.Type != BlockToolCall || b.ToolName != "search" || string(b.Input) != `{"q":"go"}` {
+		t.Errorf("tool call = %+v", b)
+	}
+	if b := blocks[4]; b.Type != BlockToolResult || b.ToolCallID != "c1" || b.Output != "3 hits" {
+		t.Errorf("tool result = %+v", b)
+	}
+}
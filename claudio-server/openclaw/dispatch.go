@@ -1 +1,191 @@
 package openclaw
+
+import (
+	"sync"
+	"time"
+)
+
+// Anti-loop protection for agent dispatch.
+//
+// Only messages sent by humans dispatch @mentions to agents. A room can opt
+// in to agent-to-agent chains, in which case an agent's reply may mention
+// another agent, up to a maximum chain depth: the human message is depth 0,
+// the first agent reply depth 1, and so on. A reply at the maximum depth
+// dispatches nothing, so two agents mentioning each other cannot loop.
+
+// DefaultMaxChainDepth is how many agent replies may follow a human message
+// in rooms that allow agent-to-agent chains.
+const DefaultMaxChainDepth = 3
+
+// chainTTL bounds how long an agent message's depth is remembered. Chains
+// run within seconds; an agent message older than this counts as depth 1.
+const chainTTL = 10 * time.Minute
+
+type chainEntry struct {
+	depth int
+	at    time.Time
+}
+
+// ChainGuard tracks the chain depth of agent messages and decides whether a
+// message may dispatch @mentions.
+type ChainGuard struct {
+	maxDepth int
+
+	mu     sync.Mutex
+	depths map[string]chainEntry // agent message ID -> depth
+}
+
+// NewChainGuard creates a guard allowing chains of up to maxDepth agent replies.
+func NewChainGuard(maxDepth int) *ChainGuard {
+	if maxDepth < 1 {
+		maxDepth = 1
+	}
+	return &ChainGuard{maxDepth: maxDepth, depths: make(map[string]chainEntry)}
+}
+
+// MaxDepth returns the configured chain depth limit.
+func (g *ChainGuard) MaxDepth() int { return g.maxDepth }
+
+// Record remembers the chain depth of an agent's message.
+func (g *ChainGuard) Record(messageID string, depth int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if len(g.depths) >= 1024 {
+		for id, e := range g.depths {
+			if now.Sub(e.at) > chainTTL {
+				delete(g.depths, id)
+			}
+		}
+	}
+	g.depths[messageID] = chainEntry{depth: depth, at: now}
+}
+
+// Depth returns the chain depth of a message. Human messages are depth 0;
+// agent messages the guard didn't see (posted over HTTP, or long ago) are
+// treated as direct replies to a human, depth 1.
+func (g *ChainGuard) Depth(messageID string, fromAgent bool) int {
+	if !fromAgent {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e, ok := g.depths[messageID]; ok && time.Since(e.at) <= chainTTL {
+		return e.depth
+	}
+	return 1
+}
+
+// Allow reports whether a message at depth may dispatch @mentions to agents.
+func (g *ChainGuard) Allow(depth int, chainsEnabled bool) bool {
+	if depth == 0 {
+		return true
+	}
+	return chainsEnabled && depth < g.maxDepth
+}
+
+// Rate limiting for agent dispatch.
+//
+// Each agent may respond at most once per MinInterval in a room. If it
+// responds BreakerLimit times within BreakerWindow, its circuit breaker trips
+// and the agent is paused in that room until someone resets it.
+
+// Defaults for NewThrottle.
+const (
+	DefaultMinInterval   = 3 * time.Second
+	DefaultBreakerLimit  = 20
+	DefaultBreakerWindow = 5 * time.Minute
+)
+
+// Verdict is the outcome of a Throttle check.
+type Verdict int
+
+const (
+	Allowed     Verdict = iota
+	RateLimited         // responded too recently; skip this dispatch
+	Tripped             // this dispatch tripped the breaker; agent is now paused
+	Paused              // breaker already tripped; waiting for a reset
+)
+
+type throttleState struct {
+	recent []time.Time // dispatch times within the breaker window, oldest first
+	paused bool
+}
+
+// Throttle enforces per-agent per-room rate limits and circuit breakers.
+type Throttle struct {
+	minInterval   time.Duration
+	breakerLimit  int
+	breakerWindow time.Duration
+
+	mu     sync.Mutex
+	states map[string]*throttleState // key: roomID|agentID
+}
+
+// NewThrottle creates a throttle. A zero minInterval disables rate limiting
+// and a zero breakerLimit disables the circuit breaker.
+func NewThrottle(minInterval time.Duration, breakerLimit int, breakerWindow time.Duration) *Throttle {
+	return &Throttle{
+		minInterval:   minInterval,
+		breakerLimit:  breakerLimit,
+		breakerWindow: breakerWindow,
+		states:        make(map[string]*throttleState),
+	}
+}
+
+// Check records a dispatch to agentID in roomID if it is allowed.
+func (t *Throttle) Check(roomID, agentID string) Verdict {
+	key := roomID + "|" + agentID
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.states[key]
+	if s == nil {
+		s = &throttleState{}
+		t.states[key] = s
+	}
+	if s.paused {
+		return Paused
+	}
+	if n := len(s.recent); n > 0 && now.Sub(s.recent[n-1]) < t.minInterval {
+		return RateLimited
+	}
+
+	window := t.breakerWindow
+	if window < t.minInterval {
+		window = t.minInterval
+	}
+	keep := s.recent[:0]
+	for _, ts := range s.recent {
+		if now.Sub(ts) < window {
+			keep = append(keep, ts)
+		}
+	}
+	s.recent = keep
+
+	if t.breakerLimit > 0 && len(s.recent) >= t.breakerLimit {
+		s.paused = true
+		s.recent = nil
+		return Tripped
+	}
+	s.recent = append(s.recent, now)
+	return Allowed
+}
+
+// Reset clears agentID's breaker in roomID and reports whether it was paused.
+func (t *Throttle) Reset(roomID, agentID string) bool {
+	key := roomID + "|" + agentID
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.states[key]
+	if s == nil {
+		return false
+	}
+	delete(t.states, key)
+	return s.paused
+}
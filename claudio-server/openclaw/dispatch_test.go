@@ -0,0 +1,63 @@
+package openclaw
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChainGuard(t *testing.T) {
+	g := NewChainGuard(2)
+
+	if d := g.Depth("human", false); d != 0 || !g.Allow(d, false) {
+		t.Fatalf("human message: depth %d, want 0 and allowed", d)
+	}
+
+	// An unseen agent message counts as a direct reply to a human
+	if d := g.Depth("unknown", true); d != 1 || g.Allow(d, false) {
+		t.Errorf("agent message without chains: depth %d, want 1 and blocked", d)
+	}
+	if !g.Allow(1, true) {
+		t.Error("depth 1 with chains should be allowed")
+	}
+
+	g.Record("reply2", 2)
+	if d := g.Depth("reply2", true); d != 2 || g.Allow(d, true) {
+		t.Errorf("reply at max depth: depth %d, want 2 and blocked", d)
+	}
+}
+
+func TestThrottleBreaker(t *testing.T) {
+	th := NewThrottle(0, 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if v := th.Check("room", "agent"); v != Allowed {
+			t.Fatalf("dispatch %d = %v, want Allowed", i, v)
+		}
+	}
+	if v := th.Check("room", "agent"); v != Tripped {
+		t.Fatalf("third dispatch = %v, want Tripped", v)
+	}
+	if v := th.Check("room", "agent"); v != Paused {
+		t.Fatalf("after trip = %v, want Paused", v)
+	}
+	if v := th.Check("other", "agent"); v != Allowed {
+		t.Errorf("other room = %v, want Allowed", v)
+	}
+
+	if !th.Reset("room", "agent") {
+		t.Error("Reset should report the agent was paused")
+	}
+	if v := th.Check("room", "agent"); v != Allowed {
+		t.Errorf("after reset = %v, want Allowed", v)
+	}
+}
+
+func TestThrottleMinInterval(t *testing.T) {
+	th := NewThrottle(time.Hour, 0, 0)
+	if v := th.Check("room", "agent"); v != Allowed {
+		t.Fatalf("first dispatch = %v, want Allowed", v)
+	}
+	if v := th.Check("room", "agent"); v != RateLimited {
+		t.Errorf("second dispatch = %v, want RateLimited", v)
+	}
+}
@@ -1,6 +1,7 @@
 package openclaw
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
@@ -8,25 +9,146 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	mrand "math/rand/v2"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 )
 
+// Reconnection tuning.
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+
+	// maxInitialAttempts bounds retries for a server that was never reached,
+	// so bad credentials don't retry forever.
+	maxInitialAttempts = 5
+
+	// DefaultGetWait bounds how long Get waits for an in-flight (re)connect.
+	DefaultGetWait = 10 * time.Second
+
+	// trackCooldown keeps Track from retrying a server the pool gave up on.
+	trackCooldown = 10 * time.Minute
+)
+
+// Eviction tuning.
+const (
+	DefaultIdleTimeout = 30 * time.Minute
+	DefaultMaxConns    = 100
+
+	// staleTimeout evicts a disconnected connection nobody has used for a
+	// while, such as one whose token was replaced, sooner than IdleTimeout.
+	staleTimeout = 5 * time.Minute
+
+	evictInterval = time.Minute
+)
+
+// errEvicted is the error waiters see when their connection is evicted.
+var errEvicted = fmt.Errorf("connection evicted from pool")
+
 // Pool manages WebSocket connections to OpenClaw servers.
 // One connection per unique (url, token) pair.
 // A single Ed25519 identity is shared across all connections so that
 // the device only needs to be paired once — even across deploys.
+//
+// Each connection is maintained in the background: when it drops, the pool
+// reconnects with exponential backoff and jitter.
 type Pool struct {
-	mu      sync.Mutex
-	clients map[string]*Client // key: "url|token"
+	mu     sync.Mutex
+	conns  map[string]*poolConn // key: "url|token"
+	ctx    context.Context      // cancelled by Close
+	cancel context.CancelFunc
+	closed bool
+
+	// GetWait bounds how long Get blocks while a connection attempt is in flight.
+	GetWait time.Duration
+	// Timeouts and Compression are applied to every client the pool creates.
+	Timeouts    Timeouts
+	Compression bool
+	// OnChange, if set, is called when a server becomes reachable or
+	// unreachable: on connect, on a dropped connection, and from the prober.
+	// Set it before the pool is used.
+	OnChange HealthChange
+	// IdleTimeout closes connections unused for this long (0 = never).
+	// MaxConns caps the pool, evicting the least recently used connection
+	// (0 = unlimited).
+	IdleTimeout time.Duration
+	MaxConns    int
 
-	// Stable device identity — persisted to disk
+	abandoned map[string]time.Time // servers given up on, for Track
+	stats     PoolStats            // cumulative counters; Conns and Connected are filled in by Stats
+
+	// Stable device identity — persisted to keyPath, guarded by mu so
+	// RotateKey can replace it
+	keyPath    string
 	privateKey ed25519.PrivateKey
 	publicKey  ed25519.PublicKey
 	deviceID   string
 }
 
+// poolConn tracks one pooled connection. Fields are guarded by Pool.mu.
+type poolConn struct {
+	key, url, token string
+	ctx             context.Context // cancelled when the pool closes or evicts the connection
+	cancel          context.CancelFunc
+	evicted         bool
+	lastUsed        time.Time
+
+	client        *Client       // latest connected client, nil before the first success
+	attempted     chan struct{} // closed (and replaced) after every connect attempt
+	everConnected bool
+	gaveUp        bool // never connected within maxInitialAttempts
+
+	failures      int // consecutive failed attempts
+	lastErr       error
+	lastConnected time.Time
+	nextRetry     time.Time
+
+	lastOK   time.Time // last successful ping or reported call
+	errors   int       // failed pings and reported calls
+	reported bool      // health last passed to OnChange
+}
+
+func (pc *poolConn) connected() bool {
+	return pc.client != nil && pc.client.IsConnected()
+}
+
+func (pc *poolConn) health() ConnHealth {
+	h := ConnHealth{
+		URL:       pc.url,
+		Connected: pc.connected(),
+		Failures:  pc.failures,
+		Errors:    pc.errors,
+	}
+	if pc.lastErr != nil {
+		h.LastError = pc.lastErr.Error()
+	}
+	if !pc.lastConnected.IsZero() {
+		h.LastConnected = pc.lastConnected.Format(time.RFC3339)
+	}
+	if !pc.lastOK.IsZero() {
+		h.LastOK = pc.lastOK.Format(time.RFC3339)
+	}
+	if !h.Connected && !pc.nextRetry.IsZero() {
+		h.NextRetry = pc.nextRetry.Format(time.RFC3339)
+	}
+	return h
+}
+
+// ConnHealth reports the state of one pooled connection.
+type ConnHealth struct {
+	URL           string `json:"url"`
+	Connected     bool   `json:"connected"`
+	Failures      int    `json:"failures"` // consecutive failed attempts
+	LastError     string `json:"lastError,omitempty"`
+	LastConnected string `json:"lastConnected,omitempty"` // RFC3339
+	NextRetry     string `json:"nextRetry,omitempty"`     // RFC3339
+	LastOK        string `json:"lastOk,omitempty"`        // RFC3339, last successful response
+	Errors        int    `json:"errors"`                  // failed pings and calls
+}
+
 type persistedKey struct {
 	PrivateKey []byte `json:"privateKey"`
 	PublicKey  []byte `json:"publicKey"`
@@ -46,71 +168,487 @@ func NewPool(keyDir string) *Pool {
 		if data, err := os.ReadFile(keyPath); err == nil {
 			var pk persistedKey
 			if err := json.Unmarshal(data, &pk); err == nil && len(pk.PrivateKey) == ed25519.PrivateKeySize && len(pk.PublicKey) == ed25519.PublicKeySize {
-				hash := sha256.Sum256(pk.PublicKey)
-				deviceID := hex.EncodeToString(hash[:])
+				deviceID := deviceIDFor(pk.PublicKey)
 				slog.Info("openclaw pool: loaded persisted device key", "deviceID", deviceID[:12]+"...")
-				return &Pool{
-					clients:    make(map[string]*Client),
-					privateKey: ed25519.PrivateKey(pk.PrivateKey),
-					publicKey:  ed25519.PublicKey(pk.PublicKey),
-					deviceID:   deviceID,
-				}
+				return newPool(keyPath, ed25519.PrivateKey(pk.PrivateKey), ed25519.PublicKey(pk.PublicKey), deviceID)
 			}
 		}
 	}
 
 	// Generate new key
 	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
-	hash := sha256.Sum256(pub)
-	deviceID := hex.EncodeToString(hash[:])
+	deviceID := deviceIDFor(pub)
 
 	// Persist it
 	if keyPath != "" {
-		data, _ := json.Marshal(persistedKey{PrivateKey: priv, PublicKey: pub})
-		if err := os.WriteFile(keyPath, data, 0600); err != nil {
+		if err := saveKey(keyPath, priv, pub); err != nil {
 			slog.Warn("openclaw pool: failed to persist device key", "err", err)
 		} else {
 			slog.Info("openclaw pool: generated and saved new device key", "deviceID", deviceID[:12]+"...", "path", keyPath)
 		}
 	}
 
-	return &Pool{
-		clients:    make(map[string]*Client),
-		privateKey: priv,
-		publicKey:  pub,
-		deviceID:   deviceID,
+	return newPool(keyPath, priv, pub, deviceID)
+}
+
+// deviceIDFor derives the device ID OpenClaw servers know us by.
+func deviceIDFor(pub ed25519.PublicKey) string {
+	hash := sha256.Sum256(pub)
+	return hex.EncodeToString(hash[:])
+}
+
+func saveKey(path string, priv ed25519.PrivateKey, pub ed25519.PublicKey) error {
+	data, _ := json.Marshal(persistedKey{PrivateKey: priv, PublicKey: pub})
+	return os.WriteFile(path, data, 0600)
+}
+
+func newPool(keyPath string, priv ed25519.PrivateKey, pub ed25519.PublicKey, deviceID string) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		conns:       make(map[string]*poolConn),
+		abandoned:   make(map[string]time.Time),
+		ctx:         ctx,
+		cancel:      cancel,
+		GetWait:     DefaultGetWait,
+		Timeouts:    DefaultTimeouts,
+		IdleTimeout: DefaultIdleTimeout,
+		MaxConns:    DefaultMaxConns,
+		keyPath:     keyPath,
+		privateKey:  priv,
+		publicKey:   pub,
+		deviceID:    deviceID,
 	}
+	go p.evictLoop()
+	return p
 }
 
-// Get returns a connected client for the given URL/token, creating one if needed.
-func (p *Pool) Get(url, token string) (*Client, error) {
-	key := url + "|" + token
+// DeviceID returns the device ID the pool currently connects with.
+func (p *Pool) DeviceID() string {
 	p.mu.Lock()
-	if c, ok := p.clients[key]; ok && c.IsConnected() {
+	defer p.mu.Unlock()
+	return p.deviceID
+}
+
+// RotateKey replaces the pool's device identity with a new key, persists it,
+// and drops every pooled connection so each reconnects as the new device.
+// OpenClaw servers that pair devices will need to approve the new one.
+func (p *Pool) RotateKey() (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("generate device key: %w", err)
+	}
+	deviceID := deviceIDFor(pub)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keyPath != "" {
+		if err := saveKey(p.keyPath, priv, pub); err != nil {
+			return "", fmt.Errorf("save device key: %w", err)
+		}
+	}
+	old := p.deviceID
+	p.privateKey, p.publicKey, p.deviceID = priv, pub, deviceID
+	for _, pc := range p.conns {
+		if pc.client != nil {
+			pc.client.Close()
+		}
+	}
+	slog.Info("openclaw pool: rotated device key", "old", old[:12]+"...", "deviceID", deviceID[:12]+"...")
+	return deviceID, nil
+}
+
+// Get returns a connected client for the given URL/token. On first use it
+// starts maintaining a connection in the background; while an attempt is in
+// flight it waits up to GetWait, or until ctx ends, for the outcome.
+func (p *Pool) Get(ctx context.Context, url, token string) (*Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("pool closed")
+	}
+	pc := p.entry(url, token)
+	pc.lastUsed = time.Now()
+	if pc.connected() {
+		c := pc.client
 		p.mu.Unlock()
 		return c, nil
 	}
+	if pc.gaveUp {
+		err := pc.lastErr
+		p.mu.Unlock()
+		return nil, fmt.Errorf("pool connect: %w", err)
+	}
+	attempted := pc.attempted
 	p.mu.Unlock()
 
-	// Create and connect a new client with the pool's stable identity
-	slog.Info("openclaw pool: connecting", "url", url, "deviceID", p.deviceID[:12]+"...")
-	c := NewClientWithIdentity(url, token, p.privateKey, p.publicKey, p.deviceID)
-	if err := c.Connect(); err != nil {
-		return nil, fmt.Errorf("pool connect: %w", err)
+	timer := time.NewTimer(p.GetWait)
+	defer timer.Stop()
+	select {
+	case <-attempted:
+	case <-timer.C:
+		return nil, fmt.Errorf("pool connect: timed out waiting for %s", url)
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 
 	p.mu.Lock()
-	p.clients[key] = c
+	defer p.mu.Unlock()
+	if pc.connected() {
+		return pc.client, nil
+	}
+	return nil, fmt.Errorf("pool connect: %w", pc.lastErr)
+}
+
+// entry returns the pooled connection for url/token, starting to maintain
+// one if there is none. p.mu must be held.
+func (p *Pool) entry(url, token string) *poolConn {
+	key := url + "|" + token
+	pc := p.conns[key]
+	if pc == nil {
+		if p.MaxConns > 0 && len(p.conns) >= p.MaxConns {
+			p.evictLRU()
+		}
+		ctx, cancel := context.WithCancel(p.ctx)
+		pc = &poolConn{key: key, url: url, token: token, ctx: ctx, cancel: cancel, attempted: make(chan struct{}), lastUsed: time.Now()}
+		p.conns[key] = pc
+		delete(p.abandoned, key)
+		go p.maintain(pc)
+	}
+	return pc
+}
+
+// Track starts maintaining a connection to url/token in the background
+// without waiting for it, so its health is known and reported to OnChange.
+// Servers the pool recently gave up on are left alone.
+func (p *Pool) Track(url, token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if at, ok := p.abandoned[url+"|"+token]; ok && time.Since(at) < trackCooldown {
+		return
+	}
+	p.entry(url, token).lastUsed = time.Now()
+}
+
+// evict closes pc and removes it from the pool. p.mu must be held.
+func (p *Pool) evict(pc *poolConn) {
+	if pc.evicted {
+		return
+	}
+	pc.evicted = true
+	pc.cancel()
+	if pc.client != nil {
+		pc.client.Close()
+	}
+	pc.lastErr = errEvicted
+	close(pc.attempted) // wake Get calls waiting on it
+	delete(p.conns, pc.key)
+}
+
+// evictLRU evicts the least recently used connection. p.mu must be held.
+func (p *Pool) evictLRU() {
+	var oldest *poolConn
+	for _, pc := range p.conns {
+		if oldest == nil || pc.lastUsed.Before(oldest.lastUsed) {
+			oldest = pc
+		}
+	}
+	if oldest != nil {
+		slog.Info("openclaw pool: evicting least recently used connection", "url", oldest.url)
+		p.evict(oldest)
+		p.stats.EvictedLRU++
+	}
+}
+
+// evictIdle evicts connections unused for IdleTimeout, and disconnected ones
+// unused for staleTimeout.
+func (p *Pool) evictIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pc := range p.conns {
+		idle := now.Sub(pc.lastUsed)
+		switch {
+		case p.IdleTimeout > 0 && idle > p.IdleTimeout:
+			slog.Info("openclaw pool: evicting idle connection", "url", pc.url, "idle", idle.Round(time.Second))
+			p.evict(pc)
+			p.stats.EvictedIdle++
+		case !pc.connected() && idle > staleTimeout:
+			slog.Info("openclaw pool: evicting stale connection", "url", pc.url, "failures", pc.failures)
+			p.evict(pc)
+			p.stats.EvictedStale++
+		}
+	}
+}
+
+func (p *Pool) evictLoop() {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.evictIdle(now)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// PoolStats summarizes the pool for monitoring.
+type PoolStats struct {
+	Conns        int `json:"conns"`
+	Connected    int `json:"connected"`
+	EvictedIdle  int `json:"evictedIdle"`  // unused for IdleTimeout
+	EvictedStale int `json:"evictedStale"` // disconnected and unused
+	EvictedLRU   int `json:"evictedLru"`   // to stay within MaxConns
+	GaveUp       int `json:"gaveUp"`       // never connected
+}
+
+// Stats returns the current number of connections and how many the pool
+// has dropped since it started.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Conns = len(p.conns)
+	for _, pc := range p.conns {
+		if pc.connected() {
+			stats.Connected++
+		}
+	}
+	return stats
+}
+
+// setReported records that OnChange was (or is about to be) told healthy and
+// reports whether that is news. p.mu must be held.
+func (pc *poolConn) setReported(healthy bool) bool {
+	if pc.reported == healthy {
+		return false
+	}
+	pc.reported = healthy
+	return true
+}
+
+func (p *Pool) notify(pc *poolConn, healthy bool, err error) {
+	if healthy {
+		slog.Info("openclaw pool: server reachable", "url", pc.url)
+	} else {
+		slog.Warn("openclaw pool: server unreachable", "url", pc.url, "err", err)
+	}
+	if p.OnChange != nil {
+		p.OnChange(pc.url, pc.token, healthy)
+	}
+}
+
+// maintain keeps pc connected until the pool is closed, reconnecting with
+// exponential backoff and jitter.
+func (p *Pool) maintain(pc *poolConn) {
+	backoff := minBackoff
+	for {
+		p.mu.Lock()
+		priv, pub, deviceID := p.privateKey, p.publicKey, p.deviceID
+		p.mu.Unlock()
+		slog.Info("openclaw pool: connecting", "url", pc.url, "deviceID", deviceID[:12]+"...")
+		c := NewClientWithIdentity(pc.url, pc.token, priv, pub, deviceID)
+		c.Timeouts = p.Timeouts
+		c.Compression = p.Compression
+		err := c.Connect(pc.ctx)
+
+		p.mu.Lock()
+		if p.closed || pc.evicted {
+			p.mu.Unlock()
+			c.Close()
+			return
+		}
+		changed := false
+		if err == nil {
+			pc.client = c
+			changed = pc.setReported(true)
+			pc.everConnected = true
+			pc.failures = 0
+			pc.lastErr = nil
+			pc.lastConnected = time.Now()
+			pc.nextRetry = time.Time{}
+			backoff = minBackoff
+		} else {
+			pc.failures++
+			pc.lastErr = err
+			if !pc.everConnected && pc.failures >= maxInitialAttempts {
+				pc.gaveUp = true
+				delete(p.conns, pc.key)
+				p.abandoned[pc.key] = time.Now()
+				p.stats.GaveUp++
+			} else {
+				pc.nextRetry = time.Now().Add(backoff)
+			}
+		}
+		close(pc.attempted)
+		pc.attempted = make(chan struct{})
+		gaveUp := pc.gaveUp
+		p.mu.Unlock()
+		if changed {
+			p.notify(pc, true, nil)
+		}
+
+		if gaveUp {
+			slog.Warn("openclaw pool: giving up", "url", pc.url, "attempts", maxInitialAttempts, "err", err)
+			return
+		}
+
+		if err == nil {
+			// Connected — wait for the connection to drop, then reconnect
+			select {
+			case <-c.done:
+				slog.Warn("openclaw pool: connection lost, reconnecting", "url", pc.url)
+				p.mu.Lock()
+				changed := !p.closed && !pc.evicted && pc.setReported(false)
+				p.mu.Unlock()
+				if changed {
+					p.notify(pc, false, fmt.Errorf("connection lost"))
+				}
+				continue
+			case <-pc.ctx.Done():
+				return
+			}
+		}
+
+		slog.Warn("openclaw pool: connect failed", "url", pc.url, "failures", pc.failures, "retryIn", backoff, "err", err)
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-pc.ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// jitter spreads d over [d/2, d) so that many connections to one server
+// don't retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(mrand.Int64N(int64(half)+1))
+}
+
+// Health returns the state of every pooled connection.
+func (p *Pool) Health() []ConnHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	health := make([]ConnHealth, 0, len(p.conns))
+	for _, pc := range p.conns {
+		health = append(health, pc.health())
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].URL < health[j].URL })
+	return health
+}
+
+// Status returns the state of the connection for url/token, if pooled.
+func (p *Pool) Status(url, token string) (ConnHealth, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc := p.conns[url+"|"+token]
+	if pc == nil {
+		return ConnHealth{}, false
+	}
+	return pc.health(), true
+}
+
+// Report records the outcome of a call made to a pooled server outside the
+// pool's own connection, such as an HTTP chat completion.
+func (p *Pool) Report(url, token string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc := p.conns[url+"|"+token]
+	if pc == nil {
+		return
+	}
+	pc.lastUsed = time.Now()
+	if err != nil {
+		pc.errors++
+		pc.lastErr = err
+		return
+	}
+	pc.lastOK = time.Now()
+}
+
+// HealthChange is called when a server becomes reachable or unreachable.
+type HealthChange func(url, token string, healthy bool)
+
+// StartProber pings every pooled connection each interval until the pool is
+// closed. A connection that fails its ping is closed so it gets reconnected;
+// OnChange hears about the change.
+func (p *Pool) StartProber(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.probe()
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pool) probe() {
+	p.mu.Lock()
+	conns := make([]*poolConn, 0, len(p.conns))
+	for _, pc := range p.conns {
+		conns = append(conns, pc)
+	}
 	p.mu.Unlock()
 
-	return c, nil
+	for _, pc := range conns {
+		p.mu.Lock()
+		client := pc.client
+		connected := pc.connected()
+		p.mu.Unlock()
+
+		var err error
+		if connected {
+			ctx, cancel := context.WithTimeout(p.ctx, p.Timeouts.Request)
+			err = client.Ping(ctx)
+			cancel()
+		}
+
+		p.mu.Lock()
+		if connected {
+			if err == nil {
+				pc.lastOK = time.Now()
+			} else {
+				pc.errors++
+				pc.lastErr = err
+				client.Close()
+			}
+		}
+		healthy := connected && err == nil
+		changed := pc.everConnected && pc.setReported(healthy)
+		p.mu.Unlock()
+
+		if changed {
+			p.notify(pc, healthy, err)
+		}
+	}
 }
 
 func (p *Pool) Close() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for _, c := range p.clients {
-		c.Close()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.cancel()
+	for _, pc := range p.conns {
+		if pc.client != nil {
+			pc.client.Close()
+		}
 	}
-	p.clients = make(map[string]*Client)
+	p.conns = make(map[string]*poolConn)
 }
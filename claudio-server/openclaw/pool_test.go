@@ -0,0 +1,106 @@
+package openclaw
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPoolGetReportsFailure(t *testing.T) {
+	p := NewPool("")
+	defer p.Close()
+
+	start := time.Now()
+	if _, err := p.Get(context.Background(), "ws://127.0.0.1:1", "token"); err == nil {
+		t.Fatal("expected an error connecting to a closed port")
+	}
+	if elapsed := time.Since(start); elapsed > p.GetWait {
+		t.Errorf("Get took %v, want at most %v", elapsed, p.GetWait)
+	}
+
+	health := p.Health()
+	if len(health) != 1 || health[0].Connected || health[0].Failures == 0 || health[0].LastError == "" {
+		t.Errorf("health = %+v, want one failing connection", health)
+	}
+}
+
+func TestJitterRange(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("jitter(1s) = %v, want within [500ms, 1s]", d)
+		}
+	}
+}
+
+func TestPoolTrackStartsConnecting(t *testing.T) {
+	p := NewPool("")
+	defer p.Close()
+	changes := make(chan bool, 1)
+	p.OnChange = func(url, token string, healthy bool) { changes <- healthy }
+
+	p.Track("ws://127.0.0.1:1", "token")
+	if _, ok := p.Status("ws://127.0.0.1:1", "token"); !ok {
+		t.Fatal("Track did not add the server to the pool")
+	}
+
+	// A server that never answered was never reported up, so it isn't
+	// reported down either
+	select {
+	case healthy := <-changes:
+		t.Errorf("OnChange(%v) for a server that never connected", healthy)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestPoolRotateKeyPersists(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPool(dir)
+	first := p.DeviceID()
+	p.Close()
+
+	p = NewPool(dir)
+	if got := p.DeviceID(); got != first {
+		t.Fatalf("reloaded device ID = %s, want %s", got, first)
+	}
+	rotated, err := p.RotateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if rotated == first {
+		t.Fatal("RotateKey kept the old device ID")
+	}
+
+	p = NewPool(dir)
+	defer p.Close()
+	if got := p.DeviceID(); got != rotated {
+		t.Errorf("device ID after rotation and reload = %s, want %s", got, rotated)
+	}
+}
+
+func TestPoolEviction(t *testing.T) {
+	p := NewPool("")
+	defer p.Close()
+	p.MaxConns = 2
+
+	p.Track("ws://127.0.0.1:1", "a")
+	p.Track("ws://127.0.0.1:1", "b")
+	p.mu.Lock()
+	p.conns["ws://127.0.0.1:1|a"].lastUsed = time.Now().Add(-time.Minute)
+	p.mu.Unlock()
+
+	// A third connection pushes out the least recently used one
+	p.Track("ws://127.0.0.1:1", "c")
+	if _, ok := p.Status("ws://127.0.0.1:1", "a"); ok {
+		t.Error("least recently used connection was not evicted")
+	}
+	if stats := p.Stats(); stats.Conns != 2 || stats.EvictedLRU != 1 {
+		t.Errorf("stats after LRU eviction = %+v", stats)
+	}
+
+	// Neither connected, so both are stale well before the idle timeout
+	p.evictIdle(time.Now().Add(staleTimeout + time.Second))
+	if stats := p.Stats(); stats.Conns != 0 || stats.EvictedStale != 2 {
+		t.Errorf("stats after stale eviction = %+v", stats)
+	}
+}
@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/nicebartender/claudio-server/backend"
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// agentCall is one in-flight agent response.
+type agentCall struct {
+	cancel     context.CancelFunc
+	agent      db.Participant
+	sessionKey string
+}
+
+// agentCalls tracks in-flight agent responses so rooms.abortAgent can cancel them.
+type agentCalls struct {
+	mu    sync.Mutex
+	calls map[string]map[*agentCall]bool // key: roomID|agentID
+}
+
+func newAgentCalls() *agentCalls {
+	return &agentCalls{calls: make(map[string]map[*agentCall]bool)}
+}
+
+func (a *agentCalls) add(roomID string, call *agentCall) {
+	key := roomID + "|" + call.agent.AgentID
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.calls[key] == nil {
+		a.calls[key] = make(map[*agentCall]bool)
+	}
+	a.calls[key][call] = true
+}
+
+func (a *agentCalls) remove(roomID string, call *agentCall) {
+	key := roomID + "|" + call.agent.AgentID
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.calls[key], call)
+	if len(a.calls[key]) == 0 {
+		delete(a.calls, key)
+	}
+}
+
+// take removes and returns every in-flight call to agentID in roomID.
+func (a *agentCalls) take(roomID, agentID string) []*agentCall {
+	key := roomID + "|" + agentID
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var calls []*agentCall
+	for call := range a.calls[key] {
+		calls = append(calls, call)
+	}
+	delete(a.calls, key)
+	return calls
+}
+
+// handleRoomsAbortAgent cancels an agent's in-flight and queued responses in a room.
+func (r *Router) handleRoomsAbortAgent(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	agentID := jsonString(req.Params["agentId"])
+	if roomID == "" || agentID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and agentId are required"))
+		return
+	}
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+
+	dropped := r.agentQueue.dropQueued(roomID, agentID)
+	calls := r.agentCalls.take(roomID, agentID)
+	if len(calls) == 0 {
+		if dropped > 0 {
+			client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+				"roomId":  roomID,
+				"agentId": agentID,
+				"aborted": 0,
+				"dropped": dropped,
+			}))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "No response in progress"))
+		return
+	}
+
+	agent := calls[0].agent
+	for _, call := range calls {
+		call.cancel()
+		if !backend.IsOpenClaw(call.agent.Backend) {
+			continue
+		}
+		// Stop the run on the OpenClaw side too; the HTTP request alone may not
+		go func(call *agentCall) {
+			ctx := context.Background()
+			c, err := r.OpenClawPool.Get(ctx, call.agent.OpenclawURL, call.agent.OpenclawToken)
+			if err != nil {
+				slog.Warn("abortAgent: no OpenClaw connection", "agent", call.agent.DisplayName, "err", err)
+				return
+			}
+			if err := c.ChatAbort(ctx, call.sessionKey); err != nil {
+				slog.Warn("abortAgent: abort failed", "agent", call.agent.DisplayName, "err", err)
+			}
+		}(call)
+	}
+
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.typing.stopped", map[string]interface{}{
+		"roomId":      roomID,
+		"displayName": agent.DisplayName,
+	}), nil)
+	r.PostSystemMessage(roomID, r.displayNameFor(client)+" cancelled "+agent.DisplayName+"'s response")
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId":  roomID,
+		"agentId": agentID,
+		"aborted": len(calls),
+		"dropped": dropped,
+	}))
+}
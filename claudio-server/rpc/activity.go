@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	activityDateLayout   = "2006-01-02"
+	defaultActivityDays  = 30
+	maxActivityRangeDays = 366
+)
+
+// handleRoomsActivity returns message statistics for the inclusive UTC date
+// range [from, to], defaulting to the last 30 days.
+func (r *Router) handleRoomsActivity(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+
+	from, to, ok := parseDateRange(client, req)
+	if !ok {
+		return
+	}
+
+	if !r.checkRoomAccess(client, req, roomID) {
+		return
+	}
+
+	activity, err := r.dbFor(req).GetRoomActivity(roomID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId":         roomID,
+		"from":           from.Format(activityDateLayout),
+		"to":             to.Format(activityDateLayout),
+		"days":           activity.Days,
+		"topSenders":     activity.TopSenders,
+		"agentResponses": activity.AgentResponses,
+	}))
+}
+
+// parseDateRange reads the inclusive UTC date range [from, to] from req,
+// defaulting to the last 30 days. It replies with an error if it's invalid.
+func parseDateRange(client *ws.Client, req ws.RPCRequest) (from, to time.Time, ok bool) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to, from = today, today.AddDate(0, 0, -(defaultActivityDays-1))
+	var err error
+	if s := jsonString(req.Params["to"]); s != "" {
+		if to, err = time.Parse(activityDateLayout, s); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "to must be a YYYY-MM-DD date"))
+			return from, to, false
+		}
+		from = to.AddDate(0, 0, -(defaultActivityDays - 1))
+	}
+	if s := jsonString(req.Params["from"]); s != "" {
+		if from, err = time.Parse(activityDateLayout, s); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "from must be a YYYY-MM-DD date"))
+			return from, to, false
+		}
+	}
+	if from.After(to) || to.Sub(from) >= maxActivityRangeDays*24*time.Hour {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "date range must be 1-366 days"))
+		return from, to, false
+	}
+	return from, to, true
+}
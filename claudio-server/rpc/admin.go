@@ -0,0 +1,405 @@
+package rpc
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// isServerAdmin reports whether the client may use admin.* methods: its
+// device or account is listed in ServerAdmins, or the account was granted
+// admin with admin.grant.
+func (r *Router) isServerAdmin(client *ws.Client) bool {
+	if client.IsGuest() {
+		return false
+	}
+	if r.ServerAdmins[client.DeviceID()] || r.ServerAdmins[client.UserID()] {
+		return true
+	}
+	ok, err := r.DB.IsServerAdmin(client.UserID())
+	return err == nil && ok
+}
+
+type adminListRoomsParams struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+func (r *Router) handleAdminListRooms(client *ws.Client, req ws.RPCRequest, p adminListRoomsParams) {
+	limit, offset := p.Limit, p.Offset
+	if limit <= 0 {
+		limit = defaultDirectoryLimit
+	}
+	if limit > maxDirectoryLimit {
+		limit = maxDirectoryLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rooms, err := r.dbFor(req).ListAllRooms(limit+1, offset)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	hasMore := len(rooms) > limit
+	if hasMore {
+		rooms = rooms[:limit]
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"rooms":   rooms,
+		"hasMore": hasMore,
+	}))
+}
+
+// adminUserParams names the account an admin.* method acts on.
+type adminUserParams struct {
+	UserID string `json:"userId" rpc:"required"`
+}
+
+type adminAuthLogParams struct {
+	UserID string `json:"userId"`
+}
+
+// handleAdminAuthLog lists connect attempts server-wide, or for userId.
+func (r *Router) handleAdminAuthLog(client *ws.Client, req ws.RPCRequest, p adminAuthLogParams) {
+	r.sendAuthEvents(client, req, db.AuthEventFilter{UserID: p.UserID})
+}
+
+type adminDeleteRoomParams struct {
+	RoomID string `json:"roomId" rpc:"required"`
+	Reason string `json:"reason"`
+}
+
+func (r *Router) handleAdminDeleteRoom(client *ws.Client, req ws.RPCRequest, p adminDeleteRoomParams) {
+	roomID := p.RoomID
+	if roomID == db.LobbyRoomID {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "The lobby cannot be deleted"))
+		return
+	}
+
+	if err := r.removeRoom(roomID, client.UserID()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Room not found"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	slog.Info("admin deleted room", "roomID", roomID, "admin", client.UserID(), "reason", p.Reason)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+// handleAdminBanUser bans an account server-wide and drops its connections.
+// Its rooms and messages stay; it just can't connect.
+type adminBanUserParams struct {
+	UserID string `json:"userId" rpc:"required"`
+	Reason string `json:"reason"`
+}
+
+func (r *Router) handleAdminBanUser(client *ws.Client, req ws.RPCRequest, p adminBanUserParams) {
+	userID, reason := p.UserID, p.Reason
+	if userID == client.UserID() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "Cannot ban yourself"))
+		return
+	}
+
+	if err := r.dbFor(req).BanUser(userID, reason, client.UserID()); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	disconnected := r.Hub.DisconnectUser(userID)
+	slog.Info("admin banned user", "userID", userID, "admin", client.UserID(), "reason", reason)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"banned":       true,
+		"disconnected": disconnected,
+	}))
+}
+
+// handleAdminDeleteUser deletes an account on its owner's behalf, e.g. for
+// an erasure request that came in by email, and drops its connections.
+type adminDeleteUserParams struct {
+	UserID string `json:"userId" rpc:"required"`
+	deleteAccountParams
+}
+
+func (r *Router) handleAdminDeleteUser(client *ws.Client, req ws.RPCRequest, p adminDeleteUserParams) {
+	userID, policy := p.UserID, p.policy()
+
+	del, err := r.deleteAccount(req, userID, policy, client.UserID())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User not found"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	disconnected := r.Hub.DisconnectUser(userID)
+	slog.Info("admin deleted account", "userID", userID, "admin", client.UserID(), "messages", policy)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"deleted":      true,
+		"messages":     del.Messages,
+		"rooms":        len(del.Rooms),
+		"deletedRooms": len(del.DeletedRooms),
+		"disconnected": disconnected,
+	}))
+}
+
+func (r *Router) handleAdminUnbanUser(client *ws.Client, req ws.RPCRequest, p adminUserParams) {
+	userID := p.UserID
+
+	if err := r.dbFor(req).UnbanUser(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User is not banned"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	slog.Info("admin unbanned user", "userID", userID, "admin", client.UserID())
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+func (r *Router) handleAdminListBans(client *ws.Client, req ws.RPCRequest) {
+	bans, err := r.dbFor(req).ListServerBans()
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"bans": bans,
+	}))
+}
+
+// handleAdminBanIP bans an address or CIDR range from connecting and drops
+// its open connections.
+type adminBanIPParams struct {
+	IP     string `json:"ip" rpc:"required"`
+	Reason string `json:"reason"`
+}
+
+func (r *Router) handleAdminBanIP(client *ws.Client, req ws.RPCRequest, p adminBanIPParams) {
+	ipNet, err := ws.ParseIPBan(p.IP)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", err.Error()))
+		return
+	}
+	if own := net.ParseIP(client.RemoteIP()); own != nil && ipNet.Contains(own) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "Cannot ban your own address"))
+		return
+	}
+	reason := p.Reason
+
+	if err := r.dbFor(req).BanIP(ipNet.String(), reason, client.UserID()); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if err := r.Hub.ReloadIPBans(); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	disconnected := r.Hub.DisconnectIP(ipNet)
+	slog.Info("admin banned ip", "cidr", ipNet.String(), "admin", client.UserID(), "reason", reason)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"cidr":         ipNet.String(),
+		"banned":       true,
+		"disconnected": disconnected,
+	}))
+}
+
+type adminUnbanIPParams struct {
+	IP string `json:"ip"`
+}
+
+func (r *Router) handleAdminUnbanIP(client *ws.Client, req ws.RPCRequest, p adminUnbanIPParams) {
+	ipNet, err := ws.ParseIPBan(p.IP)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "ip must be an address or CIDR range"))
+		return
+	}
+
+	if err := r.dbFor(req).UnbanIP(ipNet.String()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Address is not banned"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if err := r.Hub.ReloadIPBans(); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	slog.Info("admin unbanned ip", "cidr", ipNet.String(), "admin", client.UserID())
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+func (r *Router) handleAdminListIPBans(client *ws.Client, req ws.RPCRequest) {
+	bans, err := r.dbFor(req).ListIPBans()
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"bans": bans,
+	}))
+}
+
+func (r *Router) handleAdminStats(client *ws.Client, req ws.RPCRequest) {
+	stats, err := r.dbFor(req).ServerStats()
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	onlineUsers, connections := r.Hub.OnlineCounts()
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"stats":       stats,
+		"onlineUsers": onlineUsers,
+		"connections": connections,
+		"openclaw":    r.OpenClawPool.Stats(),
+		"delivery":    r.Hub.SendStats(),
+		"rpc":         r.MethodStats(),
+		"maintenance": r.MaintenanceStats(),
+	}))
+}
+
+const maxAdminConnections = 500
+
+type adminConnectionsParams struct {
+	UserID string `json:"userId"`
+}
+
+// handleAdminConnections lists this instance's open connections, all or
+// one user's, oldest first.
+func (r *Router) handleAdminConnections(client *ws.Client, req ws.RPCRequest, p adminConnectionsParams) {
+	conns := r.Hub.Connections(p.UserID)
+	total := len(conns)
+	if len(conns) > maxAdminConnections {
+		conns = conns[:maxAdminConnections]
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"connections": conns,
+		"total":       total,
+	}))
+}
+
+func (r *Router) handleAdminGrant(client *ws.Client, req ws.RPCRequest, p adminUserParams) {
+	userID := p.UserID
+	if user, err := r.dbFor(req).GetUser(userID); err != nil || user == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User not found"))
+		return
+	}
+
+	if err := r.dbFor(req).GrantServerAdmin(userID, client.UserID()); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	slog.Info("server admin granted", "userID", userID, "admin", client.UserID())
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+// handleAdminRevoke removes a granted admin. Admins from config can't be
+// revoked this way.
+func (r *Router) handleAdminRevoke(client *ws.Client, req ws.RPCRequest, p adminUserParams) {
+	userID := p.UserID
+
+	if err := r.dbFor(req).RevokeServerAdmin(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User has no admin grant"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	slog.Info("server admin revoked", "userID", userID, "admin", client.UserID())
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+// handleAdminBackup snapshots the database into BackupDir with SQLite's
+// online backup API, without stopping the server. It answers once the
+// snapshot is written; see db.RestoreBackup for restoring one.
+func (r *Router) handleAdminBackup(client *ws.Client, req ws.RPCRequest) {
+	if r.BackupDir == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "BACKUP_UNAVAILABLE", "Backups are not configured on this server"))
+		return
+	}
+	if !r.backupMu.TryLock() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "A backup is already running"))
+		return
+	}
+
+	go func() {
+		defer r.backupMu.Unlock()
+		start := time.Now()
+		path := filepath.Join(r.BackupDir, "claudio-"+start.UTC().Format("20060102-150405")+".db")
+		size, err := r.DB.Backup(path)
+		if errors.Is(err, db.ErrBackupUnsupported) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "BACKUP_UNAVAILABLE", err.Error()))
+			return
+		}
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		slog.Info("database backed up", "path", path, "bytes", size, "admin", client.UserID())
+
+		client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+			"path":       path,
+			"bytes":      size,
+			"durationMs": time.Since(start).Milliseconds(),
+		}))
+	}()
+}
+
+// handleAdminCreateServerInvite issues a code new devices send as
+// auth.token to register while registration is invite-only.
+type adminCreateServerInviteParams struct {
+	MaxUses   int `json:"maxUses"`
+	ExpiresIn int `json:"expiresIn"` // seconds
+}
+
+func (r *Router) handleAdminCreateServerInvite(client *ws.Client, req ws.RPCRequest, p adminCreateServerInviteParams) {
+	maxUses := p.MaxUses
+	d := 7 * 24 * time.Hour
+	if p.ExpiresIn > 0 {
+		d = time.Duration(p.ExpiresIn) * time.Second
+	}
+
+	invite, err := r.dbFor(req).CreateServerInvite(client.UserID(), &d, maxUses)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	slog.Info("server invite created", "admin", client.UserID(), "maxUses", maxUses)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"code":      invite.Code,
+		"expiresAt": invite.ExpiresAt,
+		"maxUses":   invite.MaxUses,
+	}))
+}
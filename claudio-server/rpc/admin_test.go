@@ -0,0 +1,33 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/nicebartender/claudio-server/internal/wstest"
+)
+
+func TestAdminParamsAreValidated(t *testing.T) {
+	r := newTestRouter(t)
+	newTestRoom(t, r)
+	r.ServerAdmins = map[string]bool{"alice": true}
+	client, out := wstest.NewClient(t, r.Hub, "alice", "Alice")
+
+	for _, tt := range []struct {
+		method  string
+		params  map[string]interface{}
+		wantErr string
+	}{
+		{"admin.banUser", map[string]interface{}{}, "userId is required"},
+		{"admin.deleteUser", map[string]interface{}{"userId": "bob", "messages": "wipe"}, "messages must be anonymize or erase"},
+		{"admin.listRooms", map[string]interface{}{"limit": "ten"}, "limit must be a number"},
+		{"users.deleteAccount", map[string]interface{}{"messages": "wipe"}, "messages must be anonymize or erase"},
+	} {
+		resp := call(t, r, client, out, tt.method, tt.params)
+		if resp.OK || resp.Error.Code != "INVALID_PARAMS" || resp.Error.Message != tt.wantErr {
+			t.Errorf("%s %v = %+v, want INVALID_PARAMS %q", tt.method, tt.params, resp.Error, tt.wantErr)
+		}
+	}
+	if resp := call(t, r, client, out, "admin.listRooms", map[string]interface{}{"limit": 5}); !resp.OK {
+		t.Errorf("admin.listRooms = %+v", resp.Error)
+	}
+}
@@ -0,0 +1,204 @@
+package rpc
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// What happens to a message for an agent whose queue already holds one from
+// the same room.
+const (
+	QueueMerge = "merge" // fold it into the waiting call
+	QueueDrop  = "drop"  // queue separately; drop once the queue is full
+)
+
+// How the agents mentioned in one message are dispatched.
+const (
+	MentionGrouped = "grouped" // all at once
+	MentionOrdered = "ordered" // one after another, in mention order
+)
+
+const (
+	DefaultAgentConcurrency = 1
+	DefaultAgentQueueLimit  = 5
+)
+
+// queuedCall is an agent response waiting for a free slot.
+type queuedCall struct {
+	roomID string
+	agent  db.Participant
+	msgs   []*db.Message
+	depth  int
+	then   []func() // run once the call finishes or is dropped
+}
+
+func runAll(fns []func()) {
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// message is what the agent is sent: the one message, or all merged ones.
+func (c *queuedCall) message() *db.Message {
+	if len(c.msgs) == 1 {
+		return c.msgs[0]
+	}
+	lines := make([]string, len(c.msgs))
+	for i, m := range c.msgs {
+		lines[i] = "[" + m.SenderDisplayName + "]: " + m.Content
+	}
+	last := c.msgs[len(c.msgs)-1]
+	return &db.Message{ID: last.ID, RoomID: c.roomID, Content: strings.Join(lines, "\n")}
+}
+
+// agentQueue bounds how many responses each agent works on at once. Agents
+// are keyed by ID and server, so the limit spans rooms.
+type agentQueue struct {
+	mu      sync.Mutex
+	running map[string]int
+	pending map[string][]*queuedCall
+}
+
+func newAgentQueue() *agentQueue {
+	return &agentQueue{
+		running: make(map[string]int),
+		pending: make(map[string][]*queuedCall),
+	}
+}
+
+func agentQueueKey(agent db.Participant) string {
+	return agent.AgentID + "@" + agent.OpenclawURL
+}
+
+// dispatchAgent asks agent to reply to msg, now if it has a free slot and
+// otherwise once one frees up. It replaces calling callAgent directly.
+func (r *Router) dispatchAgent(roomID string, msg *db.Message, agent db.Participant, depth int) {
+	r.dispatchAgentThen(roomID, msg, agent, depth, nil)
+}
+
+// dispatchAgentThen is dispatchAgent, calling then (if not nil) once the
+// agent has replied, failed, or had the message dropped.
+func (r *Router) dispatchAgentThen(roomID string, msg *db.Message, agent db.Participant, depth int, then func()) {
+	q := r.agentQueue
+	key := agentQueueKey(agent)
+	call := &queuedCall{roomID: roomID, agent: agent, msgs: []*db.Message{msg}, depth: depth}
+	if then != nil {
+		call.then = []func(){then}
+	}
+
+	q.mu.Lock()
+	if r.AgentConcurrency <= 0 || q.running[key] < r.AgentConcurrency {
+		q.running[key]++
+		q.mu.Unlock()
+		go r.runAgentQueue(key, call)
+		return
+	}
+
+	pending := q.pending[key]
+	if r.AgentQueueStrategy != QueueDrop {
+		for i, c := range pending {
+			if c.roomID == roomID {
+				c.msgs = append(c.msgs, msg)
+				c.depth = max(c.depth, depth)
+				c.then = append(c.then, call.then...)
+				q.mu.Unlock()
+				r.broadcastQueuePosition(roomID, agent, i+1)
+				return
+			}
+		}
+	}
+	if len(pending) >= r.AgentQueueLimit {
+		q.mu.Unlock()
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.agent.dropped", map[string]interface{}{
+			"roomId":      roomID,
+			"agentId":     agent.AgentID,
+			"displayName": agent.DisplayName,
+			"messageId":   msg.ID,
+			"reason":      "queue_full",
+		}), nil)
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.typing.stopped", map[string]interface{}{
+			"roomId":      roomID,
+			"displayName": agent.DisplayName,
+		}), nil)
+		r.PostSystemMessage(roomID, agent.DisplayName+" is busy and couldn't take another message")
+		runAll(call.then)
+		return
+	}
+	q.pending[key] = append(pending, call)
+	position := len(q.pending[key])
+	q.mu.Unlock()
+
+	r.broadcastQueuePosition(roomID, agent, position)
+}
+
+// runAgentQueue runs call, then the agent's queued calls in order, until
+// the queue is empty.
+func (r *Router) runAgentQueue(key string, call *queuedCall) {
+	q := r.agentQueue
+	for call != nil {
+		r.callAgent(call.roomID, call.message(), call.agent, call.depth)
+		runAll(call.then)
+
+		q.mu.Lock()
+		pending := q.pending[key]
+		if len(pending) == 0 {
+			q.running[key]--
+			if q.running[key] <= 0 {
+				delete(q.running, key)
+			}
+			q.mu.Unlock()
+			return
+		}
+		call = pending[0]
+		rest := pending[1:]
+		if len(rest) == 0 {
+			delete(q.pending, key)
+		} else {
+			q.pending[key] = rest
+		}
+		q.mu.Unlock()
+
+		for i, c := range rest {
+			r.broadcastQueuePosition(c.roomID, c.agent, i+1)
+		}
+	}
+}
+
+// dropQueued removes agentID's waiting calls in roomID and returns how many there were.
+func (q *agentQueue) dropQueued(roomID, agentID string) int {
+	q.mu.Lock()
+	dropped := 0
+	var then []func()
+	// Unlock first: the callbacks may dispatch again
+	defer func() { runAll(then) }()
+	defer q.mu.Unlock()
+	for key, pending := range q.pending {
+		kept := pending[:0]
+		for _, c := range pending {
+			if c.roomID == roomID && c.agent.AgentID == agentID {
+				dropped++
+				then = append(then, c.then...)
+				continue
+			}
+			kept = append(kept, c)
+		}
+		if len(kept) == 0 {
+			delete(q.pending, key)
+		} else {
+			q.pending[key] = kept
+		}
+	}
+	return dropped
+}
+
+func (r *Router) broadcastQueuePosition(roomID string, agent db.Participant, position int) {
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.agent.queued", map[string]interface{}{
+		"roomId":      roomID,
+		"agentId":     agent.AgentID,
+		"displayName": agent.DisplayName,
+		"position":    position,
+	}), nil)
+}
@@ -1,27 +1,48 @@
 package rpc
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/nicebartender/claudio-server/backend"
 	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/openclaw"
 	"github.com/nicebartender/claudio-server/ws"
 )
 
-var httpClient = &http.Client{Timeout: 120 * time.Second}
+// Agent calls are bounded by their context (OpenClawPool.Timeouts.Chat).
+var httpClient = &http.Client{}
 
-// dispatchAgentResponses sends a human message to @mentioned agents in the room.
-// Only agents explicitly mentioned with @Name are called.
+const (
+	// agentHistoryLen is how many recent messages stateless backends are sent.
+	agentHistoryLen = 20
+
+	// Transient failures are retried, waiting agentRetryBackoff and then
+	// twice as long each time.
+	maxAgentAttempts  = 3
+	agentRetryBackoff = 2 * time.Second
+)
+
+// dispatchAgentResponses sends a message to @mentioned agents in the room.
+// Only agents explicitly mentioned with @Name are called. Agent messages only
+// dispatch in rooms that opted into agent chaining, up to the ChainGuard's
+// depth and subject to the Throttle's circuit breaker.
 func (r *Router) dispatchAgentResponses(roomID string, msg *db.Message) {
-	// Skip messages from agents (prevent loops)
-	if msg.SenderAgentID != nil {
-		return
+	depth := r.AgentChains.Depth(msg.ID, msg.SenderAgentID != nil)
+	chainsEnabled := false
+	if depth > 0 {
+		chainsEnabled, _ = r.DB.RoomAllowsAgentChains(roomID)
+		if !chainsEnabled {
+			return
+		}
 	}
 
 	participants, err := r.DB.GetParticipants(roomID)
@@ -29,118 +50,358 @@ func (r *Router) dispatchAgentResponses(roomID string, msg *db.Message) {
 		return
 	}
 
+	// In agent DMs every human message is for the agent
+	roomType, _ := r.DB.GetRoomType(roomID)
+	everyMessage := roomType == db.RoomTypeAgentDM && msg.SenderAgentID == nil
+
 	// Parse which participant IDs were mentioned
 	mentionedIDs := ParseMentions(msg.Content, participants)
-	if len(mentionedIDs) == 0 {
-		return
-	}
 	mentionSet := make(map[string]bool, len(mentionedIDs))
 	for _, id := range mentionedIDs {
 		mentionSet[id] = true
 	}
 
+	// The chain is as long as the room allows; say so rather than go quiet
+	if !r.AgentChains.Allow(depth, chainsEnabled) {
+		if !mentionsOtherAgent(participants, mentionSet, msg) {
+			return
+		}
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.agent.chainStopped", map[string]interface{}{
+			"roomId":    roomID,
+			"messageId": msg.ID,
+			"depth":     depth,
+		}), nil)
+		r.PostSystemMessage(roomID, fmt.Sprintf("Agent chain stopped after %d replies", depth))
+		return
+	}
+
+	// Fetched at most once, for agents that answer replies to their messages
+	var repliedTo *db.Message
+	fetchedReply := false
+	getRepliedTo := func() *db.Message {
+		if !fetchedReply && msg.ReplyTo != nil {
+			repliedTo, _ = r.DB.GetMessage(roomID, *msg.ReplyTo)
+		}
+		fetchedReply = true
+		return repliedTo
+	}
+
+	var responders []db.Participant
 	for _, p := range participants {
 		if !p.IsAgent {
 			continue
 		}
-		if !mentionSet[p.ID] {
+		if !everyMessage && !mentionSet[p.ID] && !triggeredWithoutMention(p, msg, getRepliedTo) {
+			continue
+		}
+		// Agents never dispatch to themselves
+		if msg.SenderAgentID != nil && *msg.SenderAgentID == p.AgentID {
 			continue
 		}
 		// Skip chat-api agents — they poll for messages via HTTP, not via OpenClaw WS
 		if p.OpenclawURL == "" {
 			continue
 		}
+		if !r.AllowAgentDispatch(roomID, p.AgentID, p.DisplayName) {
+			continue
+		}
+		responders = append(responders, p)
+	}
+	if len(responders) == 0 {
+		return
+	}
+	sortByMention(msg.Content, responders)
 
-		slog.Info("dispatching to agent", "agent", p.DisplayName, "agentId", p.AgentID, "roomId", roomID)
-
-		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.typing", map[string]interface{}{
-			"roomId":      roomID,
+	mode := MentionGrouped
+	if r.AgentMentionOrder == MentionOrdered {
+		mode = MentionOrdered
+	}
+	queued := make([]map[string]interface{}, len(responders))
+	for i, p := range responders {
+		queued[i] = map[string]interface{}{
+			"agentId":     p.AgentID,
 			"displayName": p.DisplayName,
-		}), nil)
+			"position":    i + 1,
+		}
+	}
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.respondingAgents", map[string]interface{}{
+		"roomId":    roomID,
+		"messageId": msg.ID,
+		"mode":      mode,
+		"agents":    queued,
+	}), nil)
+
+	if mode == MentionOrdered {
+		r.dispatchInOrder(roomID, msg, responders, depth+1)
+		return
+	}
+	for _, p := range responders {
+		r.startAgent(roomID, msg, p, depth+1, nil)
+	}
+}
 
-		go r.callAgent(roomID, msg, p)
+// dispatchInOrder dispatches to agents one at a time, each once the one
+// before it has replied, so later agents see earlier replies.
+func (r *Router) dispatchInOrder(roomID string, msg *db.Message, agents []db.Participant, depth int) {
+	if len(agents) == 0 {
+		return
 	}
+	r.startAgent(roomID, msg, agents[0], depth, func() {
+		r.dispatchInOrder(roomID, msg, agents[1:], depth)
+	})
+}
+
+// startAgent shows agent typing and dispatches msg to it.
+func (r *Router) startAgent(roomID string, msg *db.Message, agent db.Participant, depth int, then func()) {
+	slog.Info("dispatching to agent", "agent", agent.DisplayName, "agentId", agent.AgentID, "roomId", roomID)
+
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.typing", map[string]interface{}{
+		"roomId":      roomID,
+		"displayName": agent.DisplayName,
+	}), nil)
+
+	r.dispatchAgentThen(roomID, msg, agent, depth, then)
+}
+
+// sortByMention orders agents by where content first mentions them. Agents
+// it doesn't mention (everyone, in an agent DM) keep their order at the end.
+func sortByMention(content string, agents []db.Participant) {
+	lower := strings.ToLower(content)
+	position := func(p db.Participant) int {
+		if i := strings.Index(lower, "@"+strings.ToLower(p.DisplayName)); i >= 0 {
+			return i
+		}
+		return len(lower)
+	}
+	sort.SliceStable(agents, func(i, j int) bool {
+		return position(agents[i]) < position(agents[j])
+	})
 }
 
 // OpenclawHTTPURL converts a WebSocket or HTTP OpenClaw URL to an HTTP base URL.
 func OpenclawHTTPURL(raw string) string {
-	u := raw
-	u = strings.Replace(u, "wss://", "https://", 1)
-	u = strings.Replace(u, "ws://", "http://", 1)
-	u = strings.TrimSuffix(u, "/")
-	return u
+	return backend.HTTPURL(raw)
 }
 
-func (r *Router) callAgent(roomID string, msg *db.Message, agent db.Participant) {
+// callAgent asks an agent to reply to msg. depth is the chain depth of the reply.
+// A msg without a sender name is sent as is. Use dispatchAgent, which queues.
+func (r *Router) callAgent(roomID string, msg *db.Message, agent db.Participant, depth int) {
 	// Use the OpenClaw agent ID if set, otherwise fall back to our agent ID
 	ocAgentID := agent.OpenclawAgentID
 	if ocAgentID == "" {
 		ocAgentID = agent.AgentID
 	}
 	// Session key scoped per room so each room gets its own conversation thread.
-	sessionKey := "agent:" + ocAgentID + ":" + roomID
-
-	contextMsg := fmt.Sprintf("[%s]: %s", msg.SenderDisplayName, msg.Content)
-
-	// Use OpenClaw's OpenAI-compatible HTTP REST API — no pairing required.
-	baseURL := OpenclawHTTPURL(agent.OpenclawURL)
-	body, _ := json.Marshal(map[string]interface{}{
-		"model": "default",
-		"user":  sessionKey,
-		"messages": []map[string]string{
-			{"role": "user", "content": contextMsg},
-		},
-	})
-
-	req, err := http.NewRequest("POST", baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	sessionKey, err := r.DB.AgentSessionKey(roomID, agent.AgentID, ocAgentID)
 	if err != nil {
-		slog.Error("callAgent: build request failed", "err", err)
-		r.postAgentError(roomID, agent, err.Error())
+		slog.Error("callAgent: session lookup failed", "err", err)
+		r.postAgentError(roomID, agent, backend.ErrUnknown, err.Error())
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+agent.OpenclawToken)
 
-	resp, err := httpClient.Do(req)
+	// Register the call so rooms.abortAgent can cancel it. Each attempt gets
+	// its own timeout below.
+	ctx, cancel := context.WithCancel(context.Background())
+	call := &agentCall{cancel: cancel, agent: agent, sessionKey: sessionKey}
+	r.agentCalls.add(roomID, call)
+	defer func() {
+		r.agentCalls.remove(roomID, call)
+		cancel()
+	}()
+
+	b, err := backend.New(backend.Config{
+		Kind:    agent.Backend,
+		BaseURL: agent.OpenclawURL,
+		Token:   agent.OpenclawToken,
+		Model:   agent.Model,
+	}, httpClient)
 	if err != nil {
-		slog.Error("callAgent: HTTP request failed", "err", err, "url", baseURL)
-		r.postAgentError(roomID, agent, err.Error())
+		slog.Error("callAgent: no backend", "err", err, "agent", agent.DisplayName)
+		r.postAgentError(roomID, agent, backend.ErrRejected, err.Error())
 		return
 	}
-	defer resp.Body.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		slog.Error("callAgent: OpenClaw returned error", "status", resp.StatusCode, "body", string(respBody))
-		r.postAgentError(roomID, agent, fmt.Sprintf("OpenClaw returned %d", resp.StatusCode))
-		return
+	var messages []backend.Message
+	if agent.Persona != "" {
+		messages = append(messages, backend.Message{Role: "system", Content: agent.Persona})
+	}
+	if note := r.agentMemoryNote(roomID, agent); note != "" {
+		messages = append(messages, backend.Message{Role: "system", Content: note})
+	}
+	if note := r.agentChainNote(roomID, agent); note != "" {
+		messages = append(messages, backend.Message{Role: "system", Content: note})
+	}
+	// Direct API backends don't remember the conversation, so send it along
+	if !b.Stateful() {
+		messages = append(messages, r.agentHistory(roomID, agent, msg.ID)...)
 	}
+	prompt := msg.Content
+	if msg.SenderDisplayName != "" {
+		prompt = fmt.Sprintf("[%s]: %s", msg.SenderDisplayName, msg.Content)
+	}
+	messages = append(messages, backend.Message{Role: "user", Content: prompt})
+
+	req := backend.Request{SessionKey: sessionKey, Messages: messages}
+	var reply *backend.Reply
+	started := time.Now()
+	for attempt := 1; ; attempt++ {
+		attemptCtx, attemptCancel := context.WithTimeout(ctx, r.OpenClawPool.Timeouts.Chat)
+		reply, err = b.Complete(attemptCtx, req)
+		attemptCancel()
+		if ctx.Err() == context.Canceled {
+			slog.Info("callAgent: aborted", "agent", agent.DisplayName, "roomId", roomID)
+			return
+		}
+		if backend.IsOpenClaw(agent.Backend) {
+			r.OpenClawPool.Report(agent.OpenclawURL, agent.OpenclawToken, err)
+		}
+		if err == nil {
+			break
+		}
+
+		code, retryable := backend.Classify(err)
+		// A stateful backend may still be working on a timed-out message;
+		// sending it again would put it in the session twice
+		if code == backend.ErrTimeout && b.Stateful() {
+			retryable = false
+		}
+		var status *backend.StatusError
+		if errors.As(err, &status) {
+			slog.Error("callAgent: backend returned error", "status", status.Code, "body", status.Body, "attempt", attempt)
+		} else {
+			slog.Error("callAgent: request failed", "err", err, "agent", agent.DisplayName, "attempt", attempt)
+		}
+		if !retryable || attempt >= maxAgentAttempts {
+			r.recordAgentUsage(roomID, agent, started, 0, true)
+			r.postAgentError(roomID, agent, code, err.Error())
+			return
+		}
 
-	// Parse OpenAI-compatible response
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+		// Back off before retrying, unless the call is aborted meanwhile
+		select {
+		case <-time.After(agentRetryBackoff << (attempt - 1)):
+		case <-ctx.Done():
+			slog.Info("callAgent: aborted", "agent", agent.DisplayName, "roomId", roomID)
+			return
+		}
 	}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		slog.Error("callAgent: parse response failed", "err", err)
-		return
+
+	r.recordAgentUsage(roomID, agent, started, utf8.RuneCountInString(reply.Text), false)
+
+	if reply.Text != "" || len(reply.Blocks) > 0 {
+		if posted := r.postAgentReply(roomID, agent, reply.Text, reply.Blocks); posted != nil {
+			r.AgentChains.Record(posted.ID, depth)
+			r.dispatchAgentResponses(roomID, posted)
+		}
+	}
+}
+
+// mentionsOtherAgent reports whether msg @mentions an agent besides its sender.
+func mentionsOtherAgent(participants []db.Participant, mentionSet map[string]bool, msg *db.Message) bool {
+	for _, p := range participants {
+		if p.IsAgent && mentionSet[p.ID] && (msg.SenderAgentID == nil || *msg.SenderAgentID != p.AgentID) {
+			return true
+		}
+	}
+	return false
+}
+
+// agentChainNote tells an agent which other agents it can hand work to with
+// an @mention, in rooms that allow agent chaining.
+func (r *Router) agentChainNote(roomID string, agent db.Participant) string {
+	if enabled, _ := r.DB.RoomAllowsAgentChains(roomID); !enabled {
+		return ""
+	}
+	participants, err := r.DB.GetParticipants(roomID)
+	if err != nil {
+		return ""
+	}
+	var names []string
+	for _, p := range participants {
+		if p.IsAgent && p.OpenclawURL != "" && p.AgentID != agent.AgentID {
+			names = append(names, "@"+p.DisplayName)
+		}
+	}
+	if len(names) == 0 {
+		return ""
 	}
+	return "Other agents in this room: " + strings.Join(names, ", ") +
+		". Mention one by name to ask it for help; its reply will appear in the room."
+}
 
-	if len(result.Choices) > 0 && result.Choices[0].Message.Content != "" {
-		r.postAgentMessage(roomID, agent, result.Choices[0].Message.Content)
+func (r *Router) recordAgentUsage(roomID string, agent db.Participant, started time.Time, responseChars int, failed bool) {
+	if err := r.DB.RecordAgentUsage(roomID, agent.AgentID, started, time.Since(started), responseChars, failed); err != nil {
+		slog.Warn("callAgent: record usage failed", "err", err)
+	}
+}
+
+// agentHistory returns recent room messages as conversation turns for a
+// stateless backend, leaving out the message being answered.
+func (r *Router) agentHistory(roomID string, agent db.Participant, excludeID string) []backend.Message {
+	page, err := r.DB.GetMessagesPage(roomID, 0, 0, agentHistoryLen, db.HistoryFilter{})
+	if err != nil {
+		slog.Warn("callAgent: load history failed", "err", err)
+		return nil
 	}
+	var messages []backend.Message
+	for _, m := range page.Messages {
+		if m.ID == excludeID || m.Kind == db.MessageKindSystem || m.Content == "" {
+			continue
+		}
+		if m.SenderAgentID != nil && *m.SenderAgentID == agent.AgentID {
+			messages = append(messages, backend.Message{Role: "assistant", Content: m.Content})
+			continue
+		}
+		messages = append(messages, backend.Message{Role: "user", Content: fmt.Sprintf("[%s]: %s", m.SenderDisplayName, m.Content)})
+	}
+	return messages
+}
+
+func (r *Router) postAgentMessage(roomID string, agent db.Participant, content string) *db.Message {
+	return r.postAgentReply(roomID, agent, content, nil)
 }
 
-func (r *Router) postAgentMessage(roomID string, agent db.Participant, content string) {
+// postAgentReply posts an agent message with its content blocks. Inline
+// images are stored as attachments; the blocks are kept on the message.
+func (r *Router) postAgentReply(roomID string, agent db.Participant, content string, blocks []openclaw.ContentBlock) *db.Message {
 	agentID := agent.AgentID
+	uploader := "agent:" + agentID
+	var attachmentIDs []string
+	for i := range blocks {
+		if blocks[i].Type != openclaw.BlockImage || blocks[i].Data == "" {
+			continue
+		}
+		if len(attachmentIDs) >= maxAttachmentsPerMessage {
+			break
+		}
+		id, err := r.storeImageAttachment(roomID, uploader, blocks[i])
+		if err != nil {
+			slog.Warn("postAgentReply: store image failed", "agent", agent.DisplayName, "err", err)
+			continue
+		}
+		blocks[i].AttachmentID = id
+		attachmentIDs = append(attachmentIDs, id)
+	}
+
 	msgID := generateMsgID()
 	msg, err := r.DB.InsertMessage(msgID, roomID, nil, &agentID, agent.DisplayName, agent.Emoji, content, "[]", nil)
 	if err != nil {
 		slog.Error("postAgentMessage: insert failed", "err", err)
-		return
+		return nil
+	}
+	if len(blocks) > 0 {
+		encoded, _ := json.Marshal(blocks)
+		if err := r.DB.SetMessageBlocks(msgID, encoded); err != nil {
+			slog.Error("postAgentReply: store blocks failed", "err", err)
+		} else {
+			msg.Blocks = encoded
+		}
+	}
+	if len(attachmentIDs) > 0 {
+		msg.Attachments, err = r.DB.AttachToMessage(msgID, roomID, uploader, attachmentIDs)
+		if err != nil {
+			slog.Error("postAgentReply: attach images failed", "err", err)
+		}
 	}
 
 	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.message", map[string]interface{}{
@@ -149,9 +410,20 @@ func (r *Router) postAgentMessage(roomID string, agent db.Participant, content s
 	}), nil)
 
 	slog.Info("agent responded", "agent", agent.DisplayName, "roomId", roomID, "len", len(content))
+	return msg
 }
 
-func (r *Router) postAgentError(roomID string, agent db.Participant, errMsg string) {
+// postAgentError tells the room an agent failed to respond: a chat message
+// for people, and a room.agent.error event with code (see backend.Classify)
+// for clients.
+func (r *Router) postAgentError(roomID string, agent db.Participant, code, errMsg string) {
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.agent.error", map[string]interface{}{
+		"roomId":      roomID,
+		"agentId":     agent.AgentID,
+		"displayName": agent.DisplayName,
+		"code":        code,
+		"message":     errMsg,
+	}), nil)
 	content := fmt.Sprintf("_%s encountered an error: %s_", agent.DisplayName, errMsg)
 	r.postAgentMessage(roomID, agent, content)
 }
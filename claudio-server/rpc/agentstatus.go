@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// agentPingTimeout bounds each ping made by agents.status.
+const agentPingTimeout = 5 * time.Second
+
+// handleAgentsStatus pings the OpenClaw connection of every agent in a room.
+func (r *Router) handleAgentsStatus(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+
+	participants, err := r.dbFor(req).GetParticipants(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	agents := []map[string]interface{}{}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, p := range participants {
+		if !p.IsAgent {
+			continue
+		}
+		status := map[string]interface{}{
+			"agentId":     p.AgentID,
+			"displayName": p.DisplayName,
+		}
+		agents = append(agents, status)
+
+		// Bridge and chat-api agents have no OpenClaw connection to ping
+		if p.OpenclawURL == "" {
+			status["transport"] = "bridge"
+			continue
+		}
+		status["transport"] = "openclaw"
+
+		wg.Add(1)
+		go func(url, token string, status map[string]interface{}) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), agentPingTimeout)
+			defer cancel()
+
+			start := time.Now()
+			c, err := r.OpenClawPool.Get(ctx, url, token)
+			if err == nil {
+				err = c.Ping(ctx)
+				r.OpenClawPool.Report(url, token, err)
+			}
+			health, _ := r.OpenClawPool.Status(url, token)
+
+			mu.Lock()
+			defer mu.Unlock()
+			status["reachable"] = err == nil
+			status["connected"] = health.Connected
+			status["errorCount"] = health.Errors
+			if health.LastOK != "" {
+				status["lastResponseAt"] = health.LastOK
+			}
+			if err != nil {
+				status["lastError"] = err.Error()
+			} else {
+				status["latencyMs"] = time.Since(start).Milliseconds()
+			}
+		}(p.OpenclawURL, p.OpenclawToken, status)
+	}
+	wg.Wait()
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId": roomID,
+		"agents": agents,
+	}))
+}
+
+// StartAgentProber periodically pings pooled OpenClaw connections so an
+// agent whose server stops answering is reported before anyone talks to it.
+func (r *Router) StartAgentProber(interval time.Duration) {
+	r.OpenClawPool.StartProber(interval)
+}
+
+// agentHealthChanged is the pool's OnChange callback. It emits
+// room.agent.status and room.presence to every room with an agent on the
+// server that became reachable or unreachable.
+func (r *Router) agentHealthChanged(url, token string, healthy bool) {
+	agents, err := r.DB.ListAgentsByOpenclaw(url, token)
+	if err != nil {
+		slog.Error("agent health: list agents failed", "err", err)
+		return
+	}
+	for _, a := range agents {
+		r.Hub.BroadcastToRoom(a.RoomID, ws.NewEvent("room.agent.status", map[string]interface{}{
+			"roomId":      a.RoomID,
+			"agentId":     a.AgentID,
+			"displayName": a.DisplayName,
+			"reachable":   healthy,
+		}), nil)
+		r.Hub.BroadcastToRoom(a.RoomID, ws.NewEvent("room.presence", map[string]interface{}{
+			"roomId":        a.RoomID,
+			"participantId": "agent:" + a.AgentID + "@" + url,
+			"agentId":       a.AgentID,
+			"online":        healthy,
+		}), nil)
+	}
+}
@@ -0,0 +1,563 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nicebartender/claudio-server/archive"
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/media"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	maxArchiveBytes      = 4 << 30 // an exported archive, or an imported one uncompressed
+	maxImportMessages    = 1 << 20
+	maxImportAttachments = 1 << 16
+	archivePageSize      = 500 // messages read or written per query
+	importedRoomName     = "Imported room"
+)
+
+// handleRoomsExportArchive writes the room's settings, participants,
+// messages and attachments to an archive (see package archive) and stores
+// it as an unsent upload of the caller's. The client downloads it from the
+// returned URL; like any unsent upload it's collected after a day. Owners
+// and admins only, see roomRoles. The archive is written in the background,
+// off the connection's read loop; the response comes once it's stored.
+type roomsExportArchiveParams struct {
+	RoomID string `json:"roomId" rpc:"required"`
+}
+
+func (r *Router) handleRoomsExportArchive(client *ws.Client, req ws.RPCRequest, p roomsExportArchiveParams) {
+	roomID := p.RoomID
+	if r.Media == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "UNAVAILABLE", "Media storage is not configured"))
+		return
+	}
+	d := r.dbFor(req)
+	room, err := d.GetRoom(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Room not found"))
+		return
+	}
+	if room.Type == db.RoomTypeDM {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Direct messages can't be exported"))
+		return
+	}
+	// Members' devices hold the keys, so the history would be unreadable
+	if room.Encrypted {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Encrypted rooms can't be exported"))
+		return
+	}
+
+	go func() {
+		pr, pw := io.Pipe()
+		written := make(chan int, 1)
+		go func() {
+			n, err := r.writeArchive(d, room, pw)
+			pw.CloseWithError(err)
+			written <- n
+		}()
+		blob, err := r.Media.Stage(pr, maxArchiveBytes)
+		pr.Close() // stops the writer if Stage gave up early
+		count := <-written
+		if err != nil {
+			slog.Error("room export failed", "roomID", roomID, "err", err)
+			client.SendJSON(ws.NewErrorResponse(req.ID, "EXPORT_FAILED", err.Error()))
+			return
+		}
+		defer blob.Discard()
+
+		// Hold the store's read lock until the blob is referenced, as uploads do
+		r.Media.RLock()
+		err = blob.Commit()
+		var attachment *db.Attachment
+		if err == nil {
+			attachment, err = d.CreateAttachment(GenerateMsgID(), roomID, client.UserID(), blob.Hash, blob.Size, room.Name+".claudio.zip", "application/zip")
+		}
+		r.Media.RUnlock()
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		r.audit(roomID, client.UserID(), db.AuditRoomExported, "", map[string]interface{}{
+			"messages": count,
+			"bytes":    blob.Size,
+		})
+
+		resp := map[string]interface{}{
+			"attachment": attachment,
+			"messages":   count,
+		}
+		if r.ExternalURL != "" {
+			resp["downloadUrl"] = "https://" + r.ExternalURL + attachment.URL
+		}
+		client.SendJSON(ws.NewResponse(req.ID, resp))
+	}()
+}
+
+// writeArchive writes room's archive to w and returns how many messages it
+// holds. An attachment whose blob was collected since it was listed, with
+// its message deleted meanwhile, is left out.
+func (r *Router) writeArchive(d db.Store, room *db.Room, w io.Writer) (int, error) {
+	aw := archive.NewWriter(w)
+	participants, err := d.GetParticipants(room.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	var files []db.Attachment
+	var count int
+	var after int64
+	for {
+		page, err := d.RoomMessagesAfter(room.ID, after, archivePageSize)
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range page {
+			am := archive.Message{
+				ID:                m.ID,
+				SenderUserID:      deref(m.SenderUserID),
+				SenderAgentID:     deref(m.SenderAgentID),
+				SenderDisplayName: m.SenderDisplayName,
+				SenderEmoji:       m.SenderEmoji,
+				Content:           m.Content,
+				ReplyTo:           deref(m.ReplyTo),
+				Kind:              m.Kind,
+				Blocks:            m.Blocks,
+				CreatedAt:         m.CreatedAt,
+			}
+			if m.Mentions != "" && m.Mentions != "[]" {
+				am.Mentions = json.RawMessage(m.Mentions)
+			}
+			for _, a := range m.Attachments {
+				am.Attachments = append(am.Attachments, a.ID)
+				files = append(files, a)
+			}
+			if err := aw.AddMessage(am); err != nil {
+				return 0, err
+			}
+			after = m.Seq
+			count++
+		}
+		if len(page) < archivePageSize {
+			break
+		}
+	}
+
+	var avatarID string
+	if room.AvatarURL != "" {
+		if a, _ := d.GetAttachment(strings.TrimPrefix(room.AvatarURL, "/media/")); a != nil {
+			avatarID = a.ID
+			files = append(files, *a)
+		}
+	}
+	for _, a := range files {
+		// An open blob stays readable even if it's collected afterwards
+		r.Media.RLock()
+		f, err := r.Media.Open(a.BlobHash)
+		r.Media.RUnlock()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("attachment %s: %w", a.ID, err)
+		}
+		err = aw.AddAttachment(archive.Attachment{
+			ID:          a.ID,
+			MessageID:   deref(a.MessageID),
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			CreatedAt:   a.CreatedAt,
+		}, f)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	manifest := archive.Manifest{
+		ExportedAt: time.Now().UTC(),
+		Source:     r.ExternalURL,
+		Room: archive.Room{
+			ID:                   room.ID,
+			Name:                 room.Name,
+			Emoji:                room.Emoji,
+			Description:          room.Description,
+			Topic:                room.Topic,
+			Visibility:           room.Visibility,
+			SlowModeSeconds:      room.SlowModeSeconds,
+			RequireApproval:      room.RequireApproval,
+			AgentChains:          room.AgentChains,
+			ContentFilter:        room.ContentFilter,
+			MessageRetentionDays: room.MessageRetentionDays,
+			AvatarID:             avatarID,
+			CreatedAt:            room.CreatedAt,
+		},
+	}
+	for _, p := range participants {
+		ap := archive.Participant{ID: p.ID, DisplayName: p.DisplayName, Emoji: p.Emoji, Role: p.Role, Agent: p.IsAgent}
+		if p.IsAgent {
+			ap.ID = p.AgentID // without the gateway URL
+		}
+		manifest.Participants = append(manifest.Participants, ap)
+	}
+	return count, aw.Close(manifest)
+}
+
+// handleRoomsImportArchive creates a room from an archive made by
+// rooms.exportArchive, here or on another server. The archive must be the
+// caller's unsent upload (rooms.createUpload in any room they're in), so
+// -max-upload-bytes bounds its size on the wire. What it inflates to is
+// bounded by importLimits.
+//
+// The caller owns the new room. Messages keep their senders' names and
+// timestamps but not their user IDs, which mean nothing here and would let
+// an archive put words in a local account's mouth. Other human participants
+// get a single-use invite each, with their role (owners become admins), for
+// the caller to pass on; agents are listed but not re-added, since their
+// credentials aren't exported. The import runs in the background, off the
+// connection's read loop; the response comes once it's done.
+type roomsImportArchiveParams struct {
+	AttachmentID string `json:"attachmentId" rpc:"required"`
+}
+
+func (r *Router) handleRoomsImportArchive(client *ws.Client, req ws.RPCRequest, p roomsImportArchiveParams) {
+	attachmentID := p.AttachmentID
+	if r.Media == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "UNAVAILABLE", "Media storage is not configured"))
+		return
+	}
+	d := r.dbFor(req)
+	upload, err := d.GetAttachment(attachmentID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if upload != nil {
+		if n, err := d.CountPendingAttachments(upload.RoomID, client.UserID(), []string{attachmentID}); err != nil || n != 1 {
+			upload = nil
+		}
+	}
+	if upload == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Unknown attachment"))
+		return
+	}
+	go r.importUpload(client, req, d, upload)
+}
+
+// importUpload imports the archive in upload and answers req.
+func (r *Router) importUpload(client *ws.Client, req ws.RPCRequest, d db.Store, upload *db.Attachment) {
+	r.Media.RLock()
+	f, err := r.Media.Open(upload.BlobHash)
+	r.Media.RUnlock()
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Archive not found"))
+		return
+	}
+	defer f.Close()
+	ar, err := archive.NewReader(f, upload.Size, r.importLimits())
+	if errors.Is(err, archive.ErrTooLarge) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "TOO_LARGE", "Archive is too large to import"))
+		return
+	}
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", err.Error()))
+		return
+	}
+
+	result, err := r.importArchive(d, ar, client.UserID())
+	if errors.Is(err, archive.ErrTooLarge) || errors.Is(err, media.ErrTooLarge) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "TOO_LARGE", "Archive is too large to import"))
+		return
+	}
+	if err != nil {
+		slog.Error("room import failed", "attachmentID", upload.ID, "err", err)
+		client.SendJSON(ws.NewErrorResponse(req.ID, "IMPORT_FAILED", err.Error()))
+		return
+	}
+	r.audit(result.RoomID, client.UserID(), db.AuditRoomImported, "", map[string]interface{}{
+		"source":   ar.Manifest.Source,
+		"messages": result.Messages,
+	})
+	room, err := d.GetRoom(result.RoomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"room":        room,
+		"messages":    result.Messages,
+		"attachments": result.Attachments,
+		"invites":     result.Invites,
+		"agents":      result.Agents,
+	}))
+}
+
+// importLimits bounds what an imported archive may inflate to: each
+// attachment no larger than an upload, and all of it no larger than an
+// export.
+func (r *Router) importLimits() archive.Limits {
+	attachmentBytes := r.MaxUploadBytes
+	if attachmentBytes <= 0 {
+		attachmentBytes = maxArchiveBytes
+	}
+	return archive.Limits{
+		TotalBytes:      maxArchiveBytes,
+		AttachmentBytes: attachmentBytes,
+		Attachments:     maxImportAttachments,
+		Messages:        maxImportMessages,
+	}
+}
+
+// archiveInvite is the invite made for one of an imported room's members.
+type archiveInvite struct {
+	DisplayName string `json:"displayName"`
+	Role        string `json:"role"`
+	Code        string `json:"code"`
+}
+
+type importResult struct {
+	RoomID      string
+	Messages    int
+	Attachments int
+	Invites     []archiveInvite
+	Agents      []archive.Participant
+}
+
+// importArchive creates the room described by ar, owned by userID. A
+// failed import deletes what it created.
+func (r *Router) importArchive(d db.Store, ar *archive.Reader, userID string) (result *importResult, err error) {
+	m := ar.Manifest.Room
+	name := m.Name
+	if name == "" {
+		name = importedRoomName
+	}
+	visibility := m.Visibility
+	if !db.ValidVisibility(visibility) {
+		visibility = db.VisibilityPrivate
+	}
+	room, err := d.CreateRoomWithVisibility(name, m.Emoji, userID, visibility)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			r.DB.DeleteRoom(room.ID)
+		}
+	}()
+
+	if err := applyArchiveSettings(d, room.ID, m, userID); err != nil {
+		return nil, err
+	}
+	result = &importResult{RoomID: room.ID}
+
+	// Everything gets a new ID; references are rewritten as they're read
+	attachmentIDs := make(map[string]string, len(ar.Manifest.Attachments))
+	for _, a := range ar.Manifest.Attachments {
+		attachmentIDs[a.ID] = GenerateMsgID()
+	}
+	messageIDs := make(map[string]string)
+	var batch []db.Message
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := d.ImportMessages(room.ID, batch)
+		batch = batch[:0]
+		return err
+	}
+	err = ar.Messages(func(am archive.Message) error {
+		msg := importedMessage(am, messageIDs, attachmentIDs)
+		messageIDs[am.ID] = msg.ID
+		batch = append(batch, msg)
+		result.Messages++
+		if len(batch) == archivePageSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.importAttachments(d, ar, room.ID, userID, attachmentIDs, messageIDs); err != nil {
+		return nil, err
+	}
+	result.Attachments = len(attachmentIDs)
+
+	for _, p := range ar.Manifest.Participants {
+		if p.Agent {
+			result.Agents = append(result.Agents, p)
+			continue
+		}
+		if p.ID == userID {
+			continue
+		}
+		role := p.Role
+		switch role {
+		case db.RoleOwner:
+			role = db.RoleAdmin
+		case db.RoleAdmin, db.RoleMember, db.RoleGuest:
+		default:
+			role = db.RoleMember
+		}
+		invite, err := d.CreateInvite(room.ID, userID, nil, 1, role)
+		if err != nil {
+			return nil, err
+		}
+		result.Invites = append(result.Invites, archiveInvite{DisplayName: p.DisplayName, Role: role, Code: invite.Code})
+	}
+	return result, nil
+}
+
+// applyArchiveSettings sets the imported room's settings, skipping any
+// this server wouldn't accept.
+func applyArchiveSettings(d db.Store, roomID string, m archive.Room, userID string) error {
+	if m.Description != "" {
+		if err := d.UpdateRoom(roomID, nil, nil, &m.Description); err != nil {
+			return err
+		}
+	}
+	if m.Topic != "" {
+		if err := d.SetRoomTopic(roomID, m.Topic, userID); err != nil {
+			return err
+		}
+	}
+	if m.SlowModeSeconds > 0 && m.SlowModeSeconds <= maxSlowModeSeconds {
+		if err := d.SetRoomSlowMode(roomID, m.SlowModeSeconds); err != nil {
+			return err
+		}
+	}
+	if m.RequireApproval {
+		if err := d.SetRoomRequireApproval(roomID, true); err != nil {
+			return err
+		}
+	}
+	if m.AgentChains {
+		if err := d.SetRoomAgentChains(roomID, true); err != nil {
+			return err
+		}
+	}
+	if m.ContentFilter != "" && db.ValidContentFilter(m.ContentFilter) {
+		if err := d.SetRoomContentFilter(roomID, m.ContentFilter); err != nil {
+			return err
+		}
+	}
+	if m.MessageRetentionDays > 0 && m.MessageRetentionDays <= db.MaxMessageRetentionDays {
+		if err := d.SetRoomMessageRetention(roomID, m.MessageRetentionDays); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importedMessage turns an archived message into one for the new room,
+// with a new ID and its reply and image references rewritten.
+func importedMessage(am archive.Message, messageIDs, attachmentIDs map[string]string) db.Message {
+	msg := db.Message{
+		ID:                GenerateMsgID(),
+		SenderDisplayName: am.SenderDisplayName,
+		SenderEmoji:       am.SenderEmoji,
+		Content:           am.Content,
+		Mentions:          "[]",
+		Kind:              am.Kind,
+		CreatedAt:         am.CreatedAt.UTC(),
+	}
+	switch msg.Kind {
+	case db.MessageKindUser, db.MessageKindAgent, db.MessageKindSystem:
+	default:
+		msg.Kind = db.MessageKindUser
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now().UTC()
+	}
+	var mentions []string
+	if json.Unmarshal(am.Mentions, &mentions) == nil && len(mentions) > 0 {
+		msg.Mentions = string(am.Mentions)
+	}
+	if id, ok := messageIDs[am.ReplyTo]; ok {
+		msg.ReplyTo = &id
+	}
+	// Agent image blocks point at their attachment
+	var blocks []map[string]interface{}
+	if json.Unmarshal(am.Blocks, &blocks) == nil && len(blocks) > 0 {
+		for _, b := range blocks {
+			if old, ok := b["attachmentId"].(string); ok {
+				b["attachmentId"] = attachmentIDs[old]
+				b["url"] = "/media/" + attachmentIDs[old]
+			}
+		}
+		msg.Blocks, _ = json.Marshal(blocks)
+	}
+	return msg
+}
+
+// importAttachments stores the archive's attachments in the new room and
+// links them to their messages, or sets the room avatar.
+func (r *Router) importAttachments(d db.Store, ar *archive.Reader, roomID, userID string, attachmentIDs, messageIDs map[string]string) error {
+	byMessage := make(map[string][]string)
+	for _, a := range ar.Manifest.Attachments {
+		if err := r.importAttachment(d, ar, a, attachmentIDs[a.ID], roomID, userID); err != nil {
+			return err
+		}
+		if id, ok := messageIDs[a.MessageID]; ok {
+			byMessage[id] = append(byMessage[id], attachmentIDs[a.ID])
+		}
+	}
+	for messageID, ids := range byMessage {
+		if _, err := d.AttachToMessage(messageID, roomID, userID, ids); err != nil {
+			return err
+		}
+	}
+	if id, ok := attachmentIDs[ar.Manifest.Room.AvatarID]; ok {
+		if err := d.SetRoomAvatar(roomID, &id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importAttachment stores one of the archive's attachments as id. The
+// content is staged without the media store's lock, which is only held
+// while the blob is added and referenced, as uploads do.
+func (r *Router) importAttachment(d db.Store, ar *archive.Reader, a archive.Attachment, id, roomID, userID string) error {
+	rc, err := ar.OpenAttachment(a.ID)
+	if err != nil {
+		return err
+	}
+	blob, err := r.Media.Stage(rc, r.importLimits().AttachmentBytes)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("attachment %s: %w", a.ID, err)
+	}
+	defer blob.Discard()
+
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	r.Media.RLock()
+	defer r.Media.RUnlock()
+	if err := blob.Commit(); err != nil {
+		return err
+	}
+	_, err = d.CreateAttachment(id, roomID, userID, blob.Hash, blob.Size, a.Filename, contentType)
+	return err
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicebartender/claudio-server/archive"
+	"github.com/nicebartender/claudio-server/internal/wstest"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+func TestImportArchiveOverLimits(t *testing.T) {
+	r := newTestRouter(t)
+	room := newTestRoom(t, r)
+
+	// A 1 MiB attachment that compresses to almost nothing
+	var buf bytes.Buffer
+	aw := archive.NewWriter(&buf)
+	if err := aw.AddMessage(archive.Message{ID: "m1", SenderDisplayName: "Bob", Content: "hi", Kind: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.AddAttachment(archive.Attachment{ID: "a1", MessageID: "m1", Filename: "zeros"}, bytes.NewReader(make([]byte, 1<<20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(archive.Manifest{Room: archive.Room{Name: "Old"}}); err != nil {
+		t.Fatal(err)
+	}
+	hash, size, err := r.Media.Put(&buf, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DB.CreateAttachment("upload1", room.ID, "alice", hash, size, "room.zip", "application/zip"); err != nil {
+		t.Fatal(err)
+	}
+	client, out := wstest.NewClient(t, r.Hub, "alice", "Alice")
+
+	r.MaxUploadBytes = 64 << 10
+	resp := call(t, r, client, out, "rooms.importArchive", map[string]interface{}{"attachmentId": "upload1"})
+	if resp.OK || resp.Error.Code != "TOO_LARGE" {
+		t.Fatalf("import over the upload limit = %+v, want TOO_LARGE", resp)
+	}
+	if rooms, _ := r.DB.UserRoomIDs("alice"); len(rooms) != 1 {
+		t.Errorf("alice is in %d rooms after a rejected import, want 1", len(rooms))
+	}
+
+	r.MaxUploadBytes = 2 << 20
+	if resp := call(t, r, client, out, "rooms.importArchive", map[string]interface{}{"attachmentId": "upload1"}); !resp.OK {
+		t.Fatalf("import within the limits = %+v", resp.Error)
+	}
+}
+
+func TestExportAndImportRunOffTheReadLoop(t *testing.T) {
+	r := newTestRouter(t)
+	room := newTestRoom(t, r)
+	r.MaxMessageLength = 10
+	r.LongMessageAsAttachment = true
+	client, out := wstest.NewClient(t, r.Hub, "alice", "Alice")
+	if resp := call(t, r, client, out, "rooms.send", map[string]interface{}{"roomId": room.ID, "content": strings.Repeat("long ", 10)}); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+
+	// With garbage collection holding the store, the handler still returns
+	r.Media.Lock()
+	handled := make(chan struct{})
+	go func() {
+		r.Handle(client, ws.RPCRequest{ID: "export", Method: "rooms.exportArchive", Params: map[string]json.RawMessage{"roomId": json.RawMessage(strconv.Quote(room.ID))}})
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rooms.exportArchive blocked the read loop on the media lock")
+	}
+	r.Media.Unlock()
+
+	var exported ws.RPCResponse
+	for exported.ID != "export" {
+		select {
+		case data := <-out:
+			json.Unmarshal(data, &exported)
+		case <-time.After(10 * time.Second):
+			t.Fatal("no export response")
+		}
+	}
+	if !exported.OK {
+		t.Fatalf("export = %+v", exported.Error)
+	}
+	attachmentID := exported.Payload.(map[string]interface{})["attachment"].(map[string]interface{})["id"].(string)
+
+	resp := call(t, r, client, out, "rooms.importArchive", map[string]interface{}{"attachmentId": attachmentID})
+	if !resp.OK {
+		t.Fatalf("import = %+v", resp.Error)
+	}
+	payload := resp.Payload.(map[string]interface{})
+	if payload["messages"] != float64(1) || payload["attachments"] != float64(1) {
+		t.Errorf("import = %v, want 1 message and 1 attachment", payload)
+	}
+}
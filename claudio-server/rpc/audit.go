@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"log/slog"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// audit records an administrative action. The action has already happened,
+// so a failure to log it is only reported.
+func (r *Router) audit(roomID, actorID, action, target string, details map[string]interface{}) {
+	if err := r.DB.InsertAuditEntry(roomID, actorID, action, target, details); err != nil {
+		slog.Error("audit log write failed", "room", roomID, "action", action, "err", err)
+	}
+}
+
+// handleRoomsAuditLog pages through a room's audit log, newest first.
+// roomRoleGate has checked the caller is an owner or admin.
+func (r *Router) handleRoomsAuditLog(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+
+	limit := jsonInt(req.Params["limit"])
+	if limit <= 0 {
+		limit = defaultAuditPageSize
+	}
+	if limit > maxAuditPageSize {
+		limit = maxAuditPageSize
+	}
+
+	page, err := r.dbFor(req).GetAuditLog(roomID, int64(jsonInt(req.Params["before"])), limit)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	resp := map[string]interface{}{
+		"entries": page.Entries,
+		"hasMore": page.HasMore,
+	}
+	if n := len(page.Entries); n > 0 {
+		resp["beforeCursor"] = page.Entries[n-1].ID
+	}
+	client.SendJSON(ws.NewResponse(req.ID, resp))
+}
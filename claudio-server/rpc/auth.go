@@ -0,0 +1,19 @@
+package rpc
+
+import (
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// handleAuthRevokeResumeTokens revokes every resume token issued to the
+// caller, e.g. after losing a device. Open connections stay up; the next
+// reconnect needs a signed challenge.
+func (r *Router) handleAuthRevokeResumeTokens(client *ws.Client, req ws.RPCRequest) {
+	revoked, err := r.dbFor(req).RevokeResumeTokens(client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"revoked": revoked,
+	}))
+}
@@ -0,0 +1,230 @@
+package rpc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// summarizeHistoryLen is how many recent messages /summarize sends the agent.
+const summarizeHistoryLen = 50
+
+// commandContext is a slash command invocation.
+type commandContext struct {
+	Client *ws.Client
+	RoomID string
+	Args   string // text after the command name
+}
+
+// commandError is returned to the caller as an RPC error.
+type commandError struct {
+	Code    string
+	Message string
+}
+
+// slashCommand is a /command typed into a room. Built-in commands answer the
+// caller directly; agent-backed commands ask an agent, whose reply is posted
+// to the room like any other agent message.
+type slashCommand struct {
+	Usage       string
+	Description string
+	// Run executes the command. A non-empty reply is sent only to the caller.
+	Run func(r *Router, cmd commandContext) (reply string, cerr *commandError)
+}
+
+// slashCommands is the command registry, keyed by name without the slash.
+var slashCommands map[string]*slashCommand
+
+func init() {
+	slashCommands = map[string]*slashCommand{
+		"help": {
+			Usage:       "/help",
+			Description: "List the available commands",
+			Run:         runHelpCommand,
+		},
+		"status": {
+			Usage:       "/status",
+			Description: "Show the agents in this room and whether they are reachable",
+			Run:         runStatusCommand,
+		},
+		"reset": {
+			Usage:       "/reset [@agent]",
+			Description: "Start a new conversation with an agent",
+			Run:         runResetCommand,
+		},
+		"summarize": {
+			Usage:       "/summarize [@agent]",
+			Description: "Ask an agent to summarize the recent conversation",
+			Run:         agentCommand(summarizePrompt),
+		},
+	}
+}
+
+// parseSlashCommand splits "/name args" into its parts. A bare "/" or a
+// leading "//" is not a command.
+func parseSlashCommand(content string) (name, args string, ok bool) {
+	content = strings.TrimSpace(content)
+	if len(content) < 2 || content[0] != '/' || content[1] == '/' {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(content[1:], " ")
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// runSlashCommand executes a registered command in place of sending a
+// message, and reports whether it succeeded.
+func (r *Router) runSlashCommand(client *ws.Client, req ws.RPCRequest, roomID, name, args string, cmd *slashCommand) bool {
+	if client.IsGuest() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "GUEST_FORBIDDEN", "Guests cannot use commands"))
+		return false
+	}
+
+	reply, cerr := cmd.Run(r, commandContext{Client: client, RoomID: roomID, Args: args})
+	if cerr != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, cerr.Code, cerr.Message))
+		return false
+	}
+
+	resp := map[string]interface{}{"command": name}
+	if reply != "" {
+		resp["reply"] = reply
+	}
+	client.SendJSON(ws.NewResponse(req.ID, resp))
+	return true
+}
+
+func runHelpCommand(r *Router, cmd commandContext) (string, *commandError) {
+	names := make([]string, 0, len(slashCommands))
+	for name := range slashCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		c := slashCommands[name]
+		fmt.Fprintf(&b, "%s — %s\n", c.Usage, c.Description)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func runStatusCommand(r *Router, cmd commandContext) (string, *commandError) {
+	participants, err := r.DB.GetParticipants(cmd.RoomID)
+	if err != nil {
+		return "", &commandError{"DB_ERROR", err.Error()}
+	}
+
+	var lines []string
+	for _, p := range participants {
+		if !p.IsAgent {
+			continue
+		}
+		state := "connected via bridge"
+		if p.OpenclawURL != "" {
+			state = "not checked yet"
+			if health, ok := r.OpenClawPool.Status(p.OpenclawURL, p.OpenclawToken); ok {
+				if health.Connected {
+					state = "reachable"
+				} else {
+					state = "unreachable"
+				}
+			}
+		}
+		lines = append(lines, p.DisplayName+": "+state)
+	}
+	if len(lines) == 0 {
+		return "No agents in this room", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func runResetCommand(r *Router, cmd commandContext) (string, *commandError) {
+	if !r.canManageAgents(cmd.Client, cmd.RoomID) {
+		return "", &commandError{"FORBIDDEN", "Only owners and admins can reset agent sessions"}
+	}
+
+	agent, cerr := r.commandAgent(cmd.RoomID, cmd.Args)
+	if cerr != nil {
+		return "", cerr
+	}
+	if err := r.resetAgentSession(cmd.Client, cmd.RoomID, *agent); err != nil {
+		return "", &commandError{"DB_ERROR", err.Error()}
+	}
+	return "", nil
+}
+
+// agentCommand builds a command that sends prompt(r, roomID) to the targeted agent.
+func agentCommand(prompt func(r *Router, roomID string) (string, error)) func(*Router, commandContext) (string, *commandError) {
+	return func(r *Router, cmd commandContext) (string, *commandError) {
+		agent, cerr := r.commandAgent(cmd.RoomID, cmd.Args)
+		if cerr != nil {
+			return "", cerr
+		}
+		if !r.AllowAgentDispatch(cmd.RoomID, agent.AgentID, agent.DisplayName) {
+			return "", &commandError{"RATE_LIMITED", agent.DisplayName + " is busy or paused"}
+		}
+		text, err := prompt(r, cmd.RoomID)
+		if err != nil {
+			return "", &commandError{"DB_ERROR", err.Error()}
+		}
+
+		r.Hub.BroadcastToRoom(cmd.RoomID, ws.NewEvent("room.typing", map[string]interface{}{
+			"roomId":      cmd.RoomID,
+			"displayName": agent.DisplayName,
+		}), nil)
+		r.dispatchAgent(cmd.RoomID, &db.Message{SenderDisplayName: r.displayNameFor(cmd.Client), Content: text}, *agent, 1)
+		return "", nil
+	}
+}
+
+// commandAgent picks the agent a command targets: the one @mentioned in
+// args, or the room's only OpenClaw agent.
+func (r *Router) commandAgent(roomID, args string) (*db.Participant, *commandError) {
+	participants, err := r.DB.GetParticipants(roomID)
+	if err != nil {
+		return nil, &commandError{"DB_ERROR", err.Error()}
+	}
+	var agents []db.Participant
+	for _, p := range participants {
+		if p.IsAgent && p.OpenclawURL != "" {
+			agents = append(agents, p)
+		}
+	}
+
+	if args != "" {
+		mentioned := ParseMentions(args, agents)
+		for i := range agents {
+			if len(mentioned) > 0 && agents[i].ID == mentioned[0] {
+				return &agents[i], nil
+			}
+		}
+		return nil, &commandError{"NOT_FOUND", "No such agent in this room"}
+	}
+	switch len(agents) {
+	case 0:
+		return nil, &commandError{"NOT_FOUND", "No agents in this room"}
+	case 1:
+		return &agents[0], nil
+	default:
+		return nil, &commandError{"INVALID_PARAMS", "Several agents are here; name one with @"}
+	}
+}
+
+func summarizePrompt(r *Router, roomID string) (string, error) {
+	page, err := r.DB.GetMessagesPage(roomID, 0, 0, summarizeHistoryLen, db.HistoryFilter{})
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("Summarize the recent conversation in this room in a few bullet points.\n\n")
+	for _, m := range page.Messages {
+		if m.Content == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "[%s]: %s\n", m.SenderDisplayName, m.Content)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+func (r *Router) handleDevicesList(client *ws.Client, req ws.RPCRequest) {
+	devices, err := r.dbFor(req).ListDevices(client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"devices":         devices,
+		"currentDeviceId": client.DeviceID(),
+		// Open connections on this server, so users can spot one they don't recognize
+		"connections":         r.Hub.Connections(client.UserID()),
+		"currentConnectionId": client.Info().ID,
+	}))
+}
+
+const (
+	defaultAuthEventLimit = 50
+	maxAuthEventLimit     = 200
+)
+
+// handleDevicesActivity lists recent connects to the caller's account,
+// including refused ones that claimed one of its devices.
+func (r *Router) handleDevicesActivity(client *ws.Client, req ws.RPCRequest) {
+	r.sendAuthEvents(client, req, db.AuthEventFilter{UserID: client.UserID()})
+}
+
+// sendAuthEvents answers req with a page of auth events matching f, plus
+// the failuresOnly, before and limit params.
+func (r *Router) sendAuthEvents(client *ws.Client, req ws.RPCRequest, f db.AuthEventFilter) {
+	f.FailuresOnly = jsonBool(req.Params["failuresOnly"])
+	f.Before = int64(jsonInt(req.Params["before"]))
+	limit := jsonInt(req.Params["limit"])
+	if limit <= 0 {
+		limit = defaultAuthEventLimit
+	}
+	if limit > maxAuthEventLimit {
+		limit = maxAuthEventLimit
+	}
+
+	events, err := r.dbFor(req).ListAuthEvents(f, limit+1)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	hasMore := len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"events":  events,
+		"hasMore": hasMore,
+	}))
+}
+
+// handleDevicesCreateLinkCode issues a code (shown as text or QR) that a new
+// device redeems with devices.link to join the caller's account.
+func (r *Router) handleDevicesCreateLinkCode(client *ws.Client, req ws.RPCRequest) {
+	code, expiresAt, err := r.dbFor(req).CreateLinkCode(client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"code":      code,
+		"expiresAt": expiresAt.Format(time.RFC3339),
+	}))
+}
+
+// handleDevicesLink moves the caller's account, with all its devices and
+// rooms, into the account that issued the code. Connections still carry
+// the old account ID, so every one of them is told to reconnect.
+func (r *Router) handleDevicesLink(client *ws.Client, req ws.RPCRequest) {
+	code := jsonString(req.Params["code"])
+	if code == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "code required"))
+		return
+	}
+
+	oldUserID := client.UserID()
+	userID, err := r.dbFor(req).LinkDevice(code, oldUserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Link code is invalid or expired"))
+		return
+	}
+	if errors.Is(err, db.ErrSameAccount) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", err.Error()))
+		return
+	}
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"userId":    userID,
+		"reconnect": true,
+	}))
+	r.Hub.SendToUser(oldUserID, ws.NewEvent("account.linked", map[string]interface{}{
+		"userId":    userID,
+		"reconnect": true,
+	}))
+}
+
+// handleDevicesRevoke unlinks one of the caller's other devices, e.g. a lost
+// phone, and drops its live connections.
+func (r *Router) handleDevicesRevoke(client *ws.Client, req ws.RPCRequest) {
+	deviceID := jsonString(req.Params["deviceId"])
+	if deviceID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "deviceId required"))
+		return
+	}
+	if deviceID == client.DeviceID() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "Cannot revoke the device you are using"))
+		return
+	}
+
+	err := r.dbFor(req).RevokeDevice(client.UserID(), deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Device not found"))
+		return
+	}
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	disconnected := r.Hub.DisconnectDevice(client.UserID(), deviceID)
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"revoked":      true,
+		"disconnected": disconnected,
+	}))
+}
+
+// handleUsersRotateKey moves the caller's device to a new key. The old key
+// signs ws.KeyRotationPayload; afterwards it can no longer connect, and the
+// device reconnects with the new key.
+func (r *Router) handleUsersRotateKey(client *ws.Client, req ws.RPCRequest) {
+	oldPublicKey := jsonString(req.Params["oldPublicKey"])
+	newPublicKey := jsonString(req.Params["newPublicKey"])
+	signature := jsonString(req.Params["signature"])
+	signedAt := int64(jsonInt(req.Params["signedAt"]))
+	if oldPublicKey == "" || newPublicKey == "" || signature == "" || signedAt == 0 {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "oldPublicKey, newPublicKey, signature and signedAt are required"))
+		return
+	}
+
+	oldDeviceID := client.DeviceID()
+	newDeviceID, err := ws.VerifyKeyRotation(oldDeviceID, oldPublicKey, newPublicKey, signature, signedAt)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "AUTH_FAILED", err.Error()))
+		return
+	}
+
+	err = r.dbFor(req).RotateDeviceKey(client.UserID(), oldDeviceID, oldPublicKey, newDeviceID)
+	if errors.Is(err, db.ErrKeyInUse) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", err.Error()))
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Device not found"))
+		return
+	}
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SetDevice(newDeviceID)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"deviceId":         newDeviceID,
+		"previousDeviceId": oldDeviceID,
+	}))
+}
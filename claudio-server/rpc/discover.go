@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/nicebartender/claudio-server/backend"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// agentDiscoverTimeout bounds connecting to and querying an OpenClaw server.
+const agentDiscoverTimeout = 15 * time.Second
+
+// handleAgentsDiscover lists the agents available on an OpenClaw server so
+// clients can offer a picker before rooms.addAgent.
+func (r *Router) handleAgentsDiscover(client *ws.Client, req ws.RPCRequest) {
+	openclawURL := jsonString(req.Params["openclawUrl"])
+	openclawToken := jsonString(req.Params["openclawToken"])
+	if openclawURL == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "openclawUrl is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentDiscoverTimeout)
+	defer cancel()
+
+	c, err := r.OpenClawPool.Get(ctx, openclawURL, openclawToken)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "OPENCLAW_UNAVAILABLE", "Could not connect to OpenClaw: "+err.Error()))
+		return
+	}
+	agents, err := c.ListAgents(ctx)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "OPENCLAW_UNAVAILABLE", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"openclawUrl": openclawURL,
+		"agents":      agents,
+	}))
+}
+
+// validateAgent checks that an OpenClaw agent can be reached before it is
+// added to a room: it completes the connect handshake and, when the server
+// can list its agents, confirms agentID is one of them. The returned error
+// says why in Details["reason"], using the room.agent.error codes.
+func (r *Router) validateAgent(openclawURL, openclawToken, agentID string) *ws.RPCError {
+	ctx, cancel := context.WithTimeout(context.Background(), agentDiscoverTimeout)
+	defer cancel()
+
+	c, err := r.OpenClawPool.Get(ctx, openclawURL, openclawToken)
+	if err != nil {
+		code, _ := backend.Classify(err)
+		if code == backend.ErrUnknown {
+			code = backend.ErrUnreachable
+		}
+		message := "Could not connect to OpenClaw: " + err.Error()
+		if code == backend.ErrUnauthorized {
+			message = "OpenClaw rejected the token: " + err.Error()
+		}
+		return &ws.RPCError{Code: "OPENCLAW_UNAVAILABLE", Message: message, Details: map[string]interface{}{
+			"reason":      code,
+			"openclawUrl": openclawURL,
+		}}
+	}
+
+	// Discovery is optional: older servers don't implement agents.list
+	agents, err := c.ListAgents(ctx)
+	if err != nil || len(agents) == 0 {
+		return nil
+	}
+	ids := make([]string, len(agents))
+	for i, a := range agents {
+		if a.ID == agentID {
+			return nil
+		}
+		ids[i] = a.ID
+	}
+	return &ws.RPCError{Code: "NOT_FOUND", Message: "No agent " + agentID + " on this OpenClaw server", Details: map[string]interface{}{
+		"reason":      "unknown_agent",
+		"openclawUrl": openclawURL,
+		"agents":      ids,
+	}}
+}
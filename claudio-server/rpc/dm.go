@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+func (r *Router) handleDMOpen(client *ws.Client, req ws.RPCRequest) {
+	otherID := jsonString(req.Params["userId"])
+	if otherID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "userId is required"))
+		return
+	}
+	if otherID == client.UserID() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Cannot open a DM with yourself"))
+		return
+	}
+
+	other, err := r.dbFor(req).GetUser(otherID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if other == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User not found"))
+		return
+	}
+
+	// Clients render DMs from the participant list; the name is a fallback
+	name := r.displayNameFor(client) + " & " + other.DisplayName
+	room, created, err := r.dbFor(req).OpenDirectRoom(client.UserID(), otherID, name)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	r.Hub.SubscribeRoom(room.ID, client)
+	r.mergeOnlineGuests(room)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"room":    room,
+		"created": created,
+	}))
+}
+
+// handleDMOpenAgent opens a one-to-one room with an agent, where every
+// message is dispatched to the agent without an @mention.
+func (r *Router) handleDMOpenAgent(client *ws.Client, req ws.RPCRequest) {
+	openclawURL := jsonString(req.Params["openclawUrl"])
+	openclawToken := jsonString(req.Params["openclawToken"])
+	agentID := jsonString(req.Params["agentId"])
+	agentName := jsonString(req.Params["agentName"])
+	agentEmoji := jsonString(req.Params["agentEmoji"])
+	if openclawURL == "" || agentID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "openclawUrl and agentId are required"))
+		return
+	}
+	if agentName == "" {
+		agentName = agentID
+	}
+
+	name := r.displayNameFor(client) + " & " + agentName
+	room, created, err := r.dbFor(req).OpenAgentDirectRoom(client.UserID(), agentID, openclawURL, openclawToken, agentName, agentEmoji, name)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	r.Hub.SubscribeRoom(room.ID, client)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"room":    room,
+		"created": created,
+	}))
+}
@@ -0,0 +1,246 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// End-to-end encrypted rooms: devices publish public keys, senders fetch
+// key bundles and hand out room keys as per-device envelopes. The server
+// stores and relays all of it but can't read any of it.
+
+const (
+	maxOneTimePrekeys   = 100 // per e2ee.publishKeys call
+	maxKeyBundleUsers   = 100
+	maxRoomKeyEnvelopes = 500 // per e2ee.distributeRoomKey call
+	maxE2EEKeyLen       = 1024
+	maxEnvelopeLen      = 16 * 1024
+)
+
+// handleE2EEPublishKeys stores the calling device's identity key, signed
+// prekey and a batch of one-time prekeys.
+func (r *Router) handleE2EEPublishKeys(client *ws.Client, req ws.RPCRequest) {
+	if client.DeviceID() == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only signed-in devices can publish keys"))
+		return
+	}
+	keys := db.DeviceKeys{
+		IdentityKey:           jsonString(req.Params["identityKey"]),
+		SignedPrekey:          jsonString(req.Params["signedPrekey"]),
+		SignedPrekeySignature: jsonString(req.Params["signedPrekeySignature"]),
+	}
+	if keys.IdentityKey == "" || keys.SignedPrekey == "" || keys.SignedPrekeySignature == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "identityKey, signedPrekey and signedPrekeySignature are required"))
+		return
+	}
+	if len(keys.IdentityKey) > maxE2EEKeyLen || len(keys.SignedPrekey) > maxE2EEKeyLen || len(keys.SignedPrekeySignature) > maxE2EEKeyLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "key is too long"))
+		return
+	}
+
+	var oneTime []db.OneTimePrekey
+	if raw, ok := req.Params["oneTimePrekeys"]; ok {
+		if err := json.Unmarshal(raw, &oneTime); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "oneTimePrekeys must be an array of {keyId, publicKey}"))
+			return
+		}
+	}
+	if len(oneTime) > maxOneTimePrekeys {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "too many oneTimePrekeys"))
+		return
+	}
+	for _, k := range oneTime {
+		if k.KeyID == "" || k.PublicKey == "" || len(k.KeyID) > maxE2EEKeyLen || len(k.PublicKey) > maxE2EEKeyLen {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "each one-time prekey needs a keyId and publicKey"))
+			return
+		}
+	}
+
+	left, err := r.dbFor(req).PublishDeviceKeys(client.DeviceID(), keys, oneTime)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"deviceId":           client.DeviceID(),
+		"oneTimePrekeyCount": left,
+	}))
+}
+
+// handleE2EEGetKeyBundles returns key bundles for the devices of userIds,
+// or of every human in roomId. The caller's own device is left out.
+func (r *Router) handleE2EEGetKeyBundles(client *ws.Client, req ws.RPCRequest) {
+	var userIDs []string
+	if raw, ok := req.Params["userIds"]; ok {
+		if err := json.Unmarshal(raw, &userIDs); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "userIds must be an array of user IDs"))
+			return
+		}
+	}
+	if roomID := jsonString(req.Params["roomId"]); roomID != "" {
+		if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+			return
+		}
+		participants, err := r.dbFor(req).GetParticipants(roomID)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		for _, p := range participants {
+			if !p.IsAgent {
+				userIDs = append(userIDs, p.ID)
+			}
+		}
+	}
+	if len(userIDs) == 0 {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "userIds or roomId is required"))
+		return
+	}
+	if len(userIDs) > maxKeyBundleUsers {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "too many userIds"))
+		return
+	}
+
+	bundles, err := r.dbFor(req).ClaimKeyBundles(userIDs)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	others := bundles[:0]
+	for _, b := range bundles {
+		if b.DeviceID != client.DeviceID() {
+			others = append(others, b)
+		}
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"bundles": others,
+	}))
+}
+
+// handleE2EEDistributeRoomKey stores a room key encrypted for each
+// recipient device and pushes it to the ones that are online.
+func (r *Router) handleE2EEDistributeRoomKey(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	keyID := jsonString(req.Params["keyId"])
+	var envelopes []struct {
+		DeviceID   string `json:"deviceId"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	if raw, ok := req.Params["envelopes"]; ok {
+		if err := json.Unmarshal(raw, &envelopes); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "envelopes must be an array of {deviceId, ciphertext}"))
+			return
+		}
+	}
+	if roomID == "" || keyID == "" || len(envelopes) == 0 {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId, keyId and envelopes are required"))
+		return
+	}
+	if len(keyID) > maxE2EEKeyLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "keyId is too long"))
+		return
+	}
+	if len(envelopes) > maxRoomKeyEnvelopes {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "too many envelopes"))
+		return
+	}
+	if client.DeviceID() == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only signed-in devices can distribute keys"))
+		return
+	}
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+	if encrypted, _ := r.dbFor(req).IsRoomEncrypted(roomID); !encrypted {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "Room is not end-to-end encrypted"))
+		return
+	}
+
+	deviceIDs := make([]string, len(envelopes))
+	for i, e := range envelopes {
+		if e.DeviceID == "" || e.Ciphertext == "" || len(e.Ciphertext) > maxEnvelopeLen {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "each envelope needs a deviceId and ciphertext"))
+			return
+		}
+		deviceIDs[i] = e.DeviceID
+	}
+	owners, err := r.dbFor(req).DeviceUserIDs(deviceIDs)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	// Room keys only go to devices of the room's participants
+	stored := make([]db.RoomKeyEnvelope, 0, len(envelopes))
+	var skipped []string
+	member := map[string]bool{}
+	for _, e := range envelopes {
+		userID, ok := owners[e.DeviceID]
+		if ok {
+			if _, seen := member[userID]; !seen {
+				member[userID], _ = r.dbFor(req).IsParticipant(roomID, userID)
+			}
+		}
+		if !ok || !member[userID] {
+			skipped = append(skipped, e.DeviceID)
+			continue
+		}
+		stored = append(stored, db.RoomKeyEnvelope{
+			RoomID:            roomID,
+			KeyID:             keyID,
+			SenderDeviceID:    client.DeviceID(),
+			RecipientDeviceID: e.DeviceID,
+			Ciphertext:        e.Ciphertext,
+		})
+	}
+	if err := r.dbFor(req).StoreRoomKeyEnvelopes(stored); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	for _, e := range stored {
+		r.Hub.SendToDevice(owners[e.RecipientDeviceID], e.RecipientDeviceID, ws.NewEvent("room.keyEnvelope", map[string]interface{}{
+			"roomId":         roomID,
+			"keyId":          keyID,
+			"senderUserId":   client.UserID(),
+			"senderDeviceId": client.DeviceID(),
+			"deviceId":       e.RecipientDeviceID,
+			"ciphertext":     e.Ciphertext,
+		}))
+	}
+
+	resp := map[string]interface{}{
+		"delivered": len(stored),
+	}
+	if len(skipped) > 0 {
+		resp["skippedDeviceIds"] = skipped
+	}
+	client.SendJSON(ws.NewResponse(req.ID, resp))
+}
+
+// handleE2EEFetchRoomKeys returns the room keys sent to the calling device,
+// e.g. after it was offline when they were distributed.
+func (r *Router) handleE2EEFetchRoomKeys(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+
+	envelopes, err := r.dbFor(req).ListRoomKeyEnvelopes(roomID, client.DeviceID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"envelopes": envelopes,
+	}))
+}
@@ -0,0 +1,208 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// MessageFilter checks message content before it is stored. Set
+// Router.MessageFilter to let room owners turn filtering on with
+// rooms.updateSettings contentFilter.
+type MessageFilter interface {
+	Check(ctx context.Context, in FilterInput) (FilterResult, error)
+}
+
+// FilterInput is a message about to be sent.
+type FilterInput struct {
+	RoomID  string `json:"roomId"`
+	UserID  string `json:"userId"`
+	Content string `json:"content"`
+}
+
+// FilterResult says whether a message tripped the filter. Redacted is the
+// content with the offending parts masked, or "" if the filter can't
+// redact, in which case the message is rejected even in redact mode.
+type FilterResult struct {
+	Matched  bool
+	Redacted string
+	Reason   string
+}
+
+// WordlistFilter matches whole words, case-insensitively, and regexes.
+type WordlistFilter struct {
+	patterns []*regexp.Regexp
+}
+
+// NewWordlistFilter compiles words and regex patterns into a filter.
+func NewWordlistFilter(words, patterns []string) (*WordlistFilter, error) {
+	f := &WordlistFilter{}
+	for _, w := range words {
+		f.patterns = append(f.patterns, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(w)+`\b`))
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("filter pattern %q: %w", p, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return f, nil
+}
+
+// LoadWordlistFilter reads a wordlist file: one word per line, "re:" before
+// a regex, and "#" for comments.
+func LoadWordlistFilter(path string) (*WordlistFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words, patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "re:"):
+			patterns = append(patterns, strings.TrimPrefix(line, "re:"))
+		default:
+			words = append(words, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewWordlistFilter(words, patterns)
+}
+
+func (f *WordlistFilter) Check(_ context.Context, in FilterInput) (FilterResult, error) {
+	res := FilterResult{Redacted: in.Content}
+	for _, re := range f.patterns {
+		res.Redacted = re.ReplaceAllStringFunc(res.Redacted, func(match string) string {
+			res.Matched = true
+			return strings.Repeat("*", utf8.RuneCountInString(match))
+		})
+	}
+	if res.Matched {
+		res.Reason = "wordlist"
+	}
+	return res, nil
+}
+
+// WebhookFilter asks an external service about each message. The service
+// gets a FilterInput as JSON and answers {"action": "allow" | "reject" |
+// "redact", "content": redacted text, "reason": "..."}.
+type WebhookFilter struct {
+	URL  string
+	HTTP *http.Client
+}
+
+func NewWebhookFilter(url string) *WebhookFilter {
+	return &WebhookFilter{URL: url, HTTP: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (f *WebhookFilter) Check(ctx context.Context, in FilterInput) (FilterResult, error) {
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequestWithContext(ctx, "POST", f.URL, bytes.NewReader(body))
+	if err != nil {
+		return FilterResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.HTTP.Do(req)
+	if err != nil {
+		return FilterResult{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return FilterResult{}, fmt.Errorf("filter webhook returned %d", resp.StatusCode)
+	}
+	var verdict struct {
+		Action  string `json:"action"`
+		Content string `json:"content"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.Unmarshal(respBody, &verdict); err != nil {
+		return FilterResult{}, fmt.Errorf("parse filter webhook response: %w", err)
+	}
+	switch verdict.Action {
+	case "", "allow":
+		return FilterResult{}, nil
+	case "redact":
+		return FilterResult{Matched: true, Redacted: verdict.Content, Reason: verdict.Reason}, nil
+	default:
+		return FilterResult{Matched: true, Reason: verdict.Reason}, nil
+	}
+}
+
+// FilterChain runs filters in order, each on the previous one's redaction.
+type FilterChain []MessageFilter
+
+func (c FilterChain) Check(ctx context.Context, in FilterInput) (FilterResult, error) {
+	var res FilterResult
+	for _, f := range c {
+		r, err := f.Check(ctx, in)
+		if err != nil {
+			return FilterResult{}, err
+		}
+		if !r.Matched {
+			continue
+		}
+		if r.Redacted == "" {
+			return r, nil
+		}
+		res = r
+		in.Content = r.Redacted
+	}
+	return res, nil
+}
+
+// filterContent runs the room's content filter over text. It returns the
+// text to store, or false after sending the client an error. A filter that
+// fails lets the message through, so an outage doesn't stop the chat.
+func (r *Router) filterContent(client *ws.Client, req ws.RPCRequest, roomID, text string) (string, bool) {
+	if r.MessageFilter == nil || text == "" {
+		return text, true
+	}
+	mode, _ := r.dbFor(req).GetRoomContentFilter(roomID)
+	if mode == db.ContentFilterOff {
+		return text, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := r.MessageFilter.Check(ctx, FilterInput{RoomID: roomID, UserID: client.UserID(), Content: text})
+	if err != nil {
+		slog.Error("message filter failed", "err", err, "roomId", roomID)
+		return text, true
+	}
+	if !res.Matched {
+		return text, true
+	}
+	if mode == db.ContentFilterRedact && res.Redacted != "" {
+		return res.Redacted, true
+	}
+	details := map[string]interface{}{"contentFilter": mode}
+	if res.Reason != "" {
+		details["reason"] = res.Reason
+	}
+	client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "CONTENT_REJECTED", "Message was blocked by the room's content filter", details))
+	return "", false
+}
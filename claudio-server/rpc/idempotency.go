@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyWindow    = 10 * time.Minute
+	maxIdempotencyKeyLen = 128
+)
+
+// idempotencyCache remembers recent rooms.send idempotency keys so a client
+// retrying after a reconnect gets the original message back instead of a duplicate.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry // key: userID|roomID|idempotencyKey
+}
+
+type idempotencyEntry struct {
+	messageID string // empty while the first send is still in flight
+	expires   time.Time
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]idempotencyEntry)}
+}
+
+// reserve claims key for a new send. If the key was already used within the
+// window it returns the original message ID (empty if still in flight) and false.
+func (c *idempotencyCache) reserve(key string) (string, bool) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok && now.Before(e.expires) {
+		return e.messageID, false
+	}
+	if len(c.entries) > 10000 {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = idempotencyEntry{expires: now.Add(idempotencyWindow)}
+	return "", true
+}
+
+// complete records the message created for a reserved key.
+func (c *idempotencyCache) complete(key, messageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = idempotencyEntry{messageID: messageID, expires: time.Now().Add(idempotencyWindow)}
+}
+
+// release frees a reserved key after a failed send so the client can retry.
+func (c *idempotencyCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,127 @@
+package rpc
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// requestToJoin redeems an invite for a room that requires approval, filing a
+// join request instead of adding the user straight away.
+func (r *Router) requestToJoin(client *ws.Client, req ws.RPCRequest, code string) {
+	invite, err := r.dbFor(req).RedeemInvite(code)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_INVITE", err.Error()))
+		return
+	}
+
+	joinReq, created, err := r.dbFor(req).CreateJoinRequest(invite.RoomID, client.UserID(), invite.Role)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if created {
+		r.notifyRoomAdmins(invite.RoomID, ws.NewEvent("room.joinRequest.created", map[string]interface{}{
+			"roomId":  invite.RoomID,
+			"request": joinReq,
+		}))
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"pending": true,
+		"request": joinReq,
+	}))
+}
+
+func (r *Router) handleRoomsListJoinRequests(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+
+	requests, err := r.dbFor(req).ListJoinRequests(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"requests": requests,
+	}))
+}
+
+// handleRoomsResolveJoin serves rooms.approveJoin and rooms.denyJoin.
+func (r *Router) handleRoomsResolveJoin(client *ws.Client, req ws.RPCRequest, approve bool) {
+	requestID := jsonString(req.Params["requestId"])
+	if requestID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "requestId is required"))
+		return
+	}
+
+	joinReq, err := r.dbFor(req).GetJoinRequest(requestID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if joinReq == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Join request not found"))
+		return
+	}
+	roomID := joinReq.RoomID
+
+	role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+	if err != nil || !db.RoleAtLeast(role, db.RoleAdmin) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can review join requests"))
+		return
+	}
+
+	if err := r.dbFor(req).ResolveJoinRequest(requestID, approve, client.UserID()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "Join request was already resolved"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	joinReq, _ = r.dbFor(req).GetJoinRequest(requestID)
+
+	resolved := map[string]interface{}{
+		"roomId":  roomID,
+		"request": joinReq,
+	}
+	if approve {
+		r.Hub.SubscribeUser(roomID, joinReq.UserID)
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.join", map[string]interface{}{
+			"roomId":      roomID,
+			"displayName": joinReq.DisplayName,
+			"emoji":       joinReq.Emoji,
+			"userId":      joinReq.UserID,
+		}), nil)
+		r.PostSystemMessage(roomID, joinReq.DisplayName+" joined")
+		r.audit(roomID, client.UserID(), db.AuditJoinApproved, joinReq.UserID, nil)
+		if room, err := r.dbFor(req).GetRoom(roomID); err == nil {
+			resolved["room"] = room
+		}
+	} else {
+		r.audit(roomID, client.UserID(), db.AuditJoinDenied, joinReq.UserID, nil)
+	}
+
+	event := ws.NewEvent("room.joinRequest.resolved", resolved)
+	r.Hub.SendToUser(joinReq.UserID, event)
+	r.notifyRoomAdmins(roomID, event)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"request": joinReq,
+	}))
+}
+
+// notifyRoomAdmins sends an event to the connections of the room's owners and admins.
+func (r *Router) notifyRoomAdmins(roomID string, event ws.RPCEvent) {
+	participants, err := r.DB.GetParticipants(roomID)
+	if err != nil {
+		return
+	}
+	for _, p := range participants {
+		if !p.IsAgent && db.RoleAtLeast(p.Role, db.RoleAdmin) {
+			r.Hub.SendToUser(p.ID, event)
+		}
+	}
+}
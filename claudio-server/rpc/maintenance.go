@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nicebartender/claudio-server/cron"
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// maintenanceStats accumulates RunMaintenance reports for admin.stats and
+// CollectMetrics.
+type maintenanceStats struct {
+	mu             sync.Mutex
+	runs           int64
+	failures       int64
+	pruned         int64 // invites, auth events and messages
+	reclaimedBytes int64
+	last           *db.MaintenanceReport
+	lastErr        string
+}
+
+// MaintenanceStats summarizes database maintenance since startup.
+type MaintenanceStats struct {
+	Runs           int64                 `json:"runs"`
+	Failures       int64                 `json:"failures"`
+	PrunedRows     int64                 `json:"prunedRows"`
+	ReclaimedBytes int64                 `json:"reclaimedBytes"`
+	Next           time.Time             `json:"next,omitempty"`
+	Last           *db.MaintenanceReport `json:"last,omitempty"`
+	LastError      string                `json:"lastError,omitempty"`
+}
+
+// StartMaintenance runs database maintenance whenever schedule fires, in
+// server local time.
+func (r *Router) StartMaintenance(schedule *cron.Schedule, policy db.RetentionPolicy) {
+	r.maintenanceSchedule = schedule
+	go func() {
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				slog.Warn("maintenance schedule never runs")
+				return
+			}
+			time.Sleep(time.Until(next))
+			r.runMaintenance(policy)
+		}
+	}()
+}
+
+func (r *Router) runMaintenance(policy db.RetentionPolicy) {
+	report, err := r.DB.RunMaintenance(policy, time.Now().UTC())
+
+	m := &r.maintenance
+	m.mu.Lock()
+	m.runs++
+	m.pruned += report.Invites + report.AuthEvents + report.Messages
+	m.reclaimedBytes += report.ReclaimedBytes
+	m.last = report
+	m.lastErr = ""
+	if err != nil {
+		m.failures++
+		m.lastErr = err.Error()
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		slog.Error("maintenance failed", "err", err)
+		return
+	}
+	slog.Info("maintenance done",
+		"invites", report.Invites,
+		"authEvents", report.AuthEvents,
+		"messages", report.Messages,
+		"reclaimedBytes", report.ReclaimedBytes,
+		"freeBytes", report.FreeBytes,
+		"durationMs", report.DurationMs)
+}
+
+// MaintenanceStats returns maintenance totals and the latest run.
+func (r *Router) MaintenanceStats() MaintenanceStats {
+	m := &r.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := MaintenanceStats{
+		Runs:           m.runs,
+		Failures:       m.failures,
+		PrunedRows:     m.pruned,
+		ReclaimedBytes: m.reclaimedBytes,
+		Last:           m.last,
+		LastError:      m.lastErr,
+	}
+	if r.maintenanceSchedule != nil {
+		stats.Next = r.maintenanceSchedule.Next(time.Now())
+	}
+	return stats
+}
+
+// CollectMetrics reports the router's maintenance counters to c, alongside
+// the hub's.
+func (r *Router) CollectMetrics(c ws.MetricsCollector) {
+	stats := r.MaintenanceStats()
+	c.Counter("db_maintenance_runs_total", "Database maintenance runs", stats.Runs)
+	c.Counter("db_maintenance_failures_total", "Database maintenance runs that failed", stats.Failures)
+	c.Counter("db_pruned_rows_total", "Expired invites, auth events and messages pruned", stats.PrunedRows)
+	c.Counter("db_reclaimed_bytes_total", "Bytes returned to the file system by incremental vacuum", stats.ReclaimedBytes)
+	if stats.Last != nil {
+		c.Gauge("db_free_bytes", "Unused bytes in the database file after the last maintenance", float64(stats.Last.FreeBytes))
+	}
+}
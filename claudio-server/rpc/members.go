@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const maxAddMembers = 50
+
+// handleRoomsAddMembers adds existing server users to a room directly,
+// without an invite code. Either every user is valid or none are added.
+func (r *Router) handleRoomsAddMembers(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	var userIDs []string
+	if raw := req.Params["userIds"]; raw != nil {
+		if err := json.Unmarshal(raw, &userIDs); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "userIds must be an array of strings"))
+			return
+		}
+	}
+	if roomID == "" || len(userIDs) == 0 {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and userIds are required"))
+		return
+	}
+	if len(userIDs) > maxAddMembers {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "At most 50 users can be added at once"))
+		return
+	}
+	if roomType, _ := r.dbFor(req).GetRoomType(roomID); db.IsDirectRoomType(roomType) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Direct messages cannot have more members"))
+		return
+	}
+
+	var users []*db.User
+	var missing []string
+	seen := make(map[string]bool)
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		user, err := r.dbFor(req).GetUser(id)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		if user == nil {
+			missing = append(missing, id)
+			continue
+		}
+		users = append(users, user)
+	}
+	if len(missing) > 0 {
+		client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "NOT_FOUND", "Some users do not exist", map[string]interface{}{
+			"missing": missing,
+		}))
+		return
+	}
+
+	added := []string{}
+	existing := []string{}
+	adder := r.displayNameFor(client)
+	for _, user := range users {
+		if ok, _ := r.dbFor(req).IsParticipant(roomID, user.ID); ok {
+			existing = append(existing, user.ID)
+			continue
+		}
+		if err := r.dbFor(req).AddParticipant(roomID, user.ID, db.RoleMember); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		added = append(added, user.ID)
+
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.join", map[string]interface{}{
+			"roomId":      roomID,
+			"displayName": user.DisplayName,
+			"emoji":       user.AvatarEmoji,
+			"userId":      user.ID,
+			"addedBy":     client.UserID(),
+		}), nil)
+		r.PostSystemMessage(roomID, adder+" added "+user.DisplayName)
+	}
+
+	if len(added) > 0 {
+		r.audit(roomID, client.UserID(), db.AuditMembersAdded, "", map[string]interface{}{
+			"userIds": added,
+		})
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"added":          added,
+		"alreadyMembers": existing,
+	}))
+}
@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	maxMemoryKeyLen   = 64
+	maxMemoryValueLen = 2000
+	maxMemoryEntries  = 50 // per agent per room
+)
+
+// handleAgentsGetMemory returns an agent's notes for a room.
+func (r *Router) handleAgentsGetMemory(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	agentID := jsonString(req.Params["agentId"])
+	if roomID == "" || agentID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and agentId are required"))
+		return
+	}
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+
+	memory, err := r.dbFor(req).GetAgentMemory(roomID, agentID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId":  roomID,
+		"agentId": agentID,
+		"memory":  memory,
+	}))
+}
+
+// handleAgentsSetMemory stores, replaces or (with an empty value) deletes
+// one of an agent's notes for a room.
+func (r *Router) handleAgentsSetMemory(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	agentID := jsonString(req.Params["agentId"])
+	key := strings.TrimSpace(jsonString(req.Params["key"]))
+	value := strings.TrimSpace(jsonString(req.Params["value"]))
+	if roomID == "" || agentID == "" || key == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId, agentId and key are required"))
+		return
+	}
+	if utf8.RuneCountInString(key) > maxMemoryKeyLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "key must be at most 64 characters"))
+		return
+	}
+	if utf8.RuneCountInString(value) > maxMemoryValueLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "value must be at most 2000 characters"))
+		return
+	}
+
+	if !r.canManageAgents(client, roomID) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can change agent memory"))
+		return
+	}
+	agent, err := r.roomAgent(roomID, agentID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if agent == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Agent not in this room"))
+		return
+	}
+
+	if value != "" {
+		memory, err := r.dbFor(req).GetAgentMemory(roomID, agentID)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		exists := false
+		for _, m := range memory {
+			if m.Key == key {
+				exists = true
+				break
+			}
+		}
+		if !exists && len(memory) >= maxMemoryEntries {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "This agent already has the maximum number of notes"))
+			return
+		}
+	}
+
+	if err := r.dbFor(req).SetAgentMemory(roomID, agentID, key, value, client.UserID()); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	r.audit(roomID, client.UserID(), db.AuditAgentMemorySet, agentID, map[string]interface{}{
+		"key":     key,
+		"deleted": value == "",
+	})
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+// agentMemoryNote lists the agent's notes for the room, for its prompt.
+func (r *Router) agentMemoryNote(roomID string, agent db.Participant) string {
+	memory, err := r.DB.GetAgentMemory(roomID, agent.AgentID)
+	if err != nil || len(memory) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Notes kept for you in this room:")
+	for _, m := range memory {
+		b.WriteString("\n- " + m.Key + ": " + m.Value)
+	}
+	return b.String()
+}
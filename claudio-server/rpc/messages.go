@@ -3,7 +3,10 @@ package rpc
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"log/slog"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nicebartender/claudio-server/db"
 	"github.com/nicebartender/claudio-server/ws"
@@ -13,10 +16,40 @@ func (r *Router) handleRoomsSend(client *ws.Client, req ws.RPCRequest) {
 	roomID := jsonString(req.Params["roomId"])
 	content := jsonString(req.Params["content"])
 
-	if roomID == "" || content == "" {
+	var attachmentIDs []string
+	if raw, ok := req.Params["attachments"]; ok {
+		if err := json.Unmarshal(raw, &attachmentIDs); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "attachments must be an array of attachment IDs"))
+			return
+		}
+	}
+
+	if roomID == "" || (content == "" && len(attachmentIDs) == 0) {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and content are required"))
 		return
 	}
+	if len(attachmentIDs) > maxAttachmentsPerMessage {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "too many attachments"))
+		return
+	}
+
+	// Encrypted rooms carry client ciphertext: the server stores and relays
+	// it as is, and never reads it for commands or agents
+	encrypted, _ := r.dbFor(req).IsRoomEncrypted(roomID)
+
+	// Length limit: reject, or keep a preview and move the full text to an attachment
+	var longContent string
+	if length := utf8.RuneCountInString(content); r.MaxMessageLength > 0 && length > r.MaxMessageLength {
+		if encrypted || !r.LongMessageAsAttachment || r.Media == nil || len(attachmentIDs) >= maxAttachmentsPerMessage {
+			client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "MESSAGE_TOO_LONG", "Message exceeds the maximum length", map[string]interface{}{
+				"maxLength": r.MaxMessageLength,
+				"length":    length,
+			}))
+			return
+		}
+		longContent = content
+		content = string([]rune(content)[:min(longMessagePreviewLen, r.MaxMessageLength)]) + "…"
+	}
 
 	// Verify access
 	senderName := client.DisplayName()
@@ -24,18 +57,26 @@ func (r *Router) handleRoomsSend(client *ws.Client, req ws.RPCRequest) {
 
 	if client.IsGuest() {
 		// Allow if room is public OR guest joined via invite code (is subscribed)
-		isPublic, _ := r.DB.IsRoomPublic(roomID)
+		isPublic, _ := r.dbFor(req).IsRoomPublic(roomID)
 		if !isPublic && !r.Hub.IsClientSubscribed(roomID, client) {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Guests can only send in rooms they have joined"))
 			return
 		}
+		if client.IsReadOnly(roomID) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "You have read-only access to this room"))
+			return
+		}
 	} else {
-		ok, _ := r.DB.IsParticipant(roomID, client.UserID())
-		if !ok {
+		role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+		if err != nil {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
 			return
 		}
-		user, _ := r.DB.GetUser(client.UserID())
+		if role == db.RoleGuest {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "You have read-only access to this room"))
+			return
+		}
+		user, _ := r.dbFor(req).GetUser(client.UserID())
 		if user != nil {
 			if user.DisplayName != "" {
 				senderName = user.DisplayName
@@ -44,6 +85,106 @@ func (r *Router) handleRoomsSend(client *ws.Client, req ws.RPCRequest) {
 		}
 	}
 
+	// Idempotency: a retried send returns the original message instead of a duplicate
+	idemKey := jsonString(req.Params["idempotencyKey"])
+	if len(idemKey) > maxIdempotencyKeyLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "idempotencyKey is too long"))
+		return
+	}
+	if idemKey != "" {
+		idemKey = client.UserID() + "|" + roomID + "|" + idemKey
+		if existingID, fresh := r.sends.reserve(idemKey); !fresh {
+			if existingID == "" {
+				client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "A send with this idempotencyKey is still in progress"))
+				return
+			}
+			client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+				"messageId": existingID,
+				"duplicate": true,
+			}))
+			return
+		}
+	}
+	sent, ranCommand := false, false
+	defer func() {
+		if idemKey != "" && !sent {
+			r.sends.release(idemKey)
+		}
+	}()
+
+	if wait, first := r.MessageLimiter.Allow(client.UserID()); wait > 0 {
+		if first {
+			client.Log().Warn("message rate limited", "roomId", roomID)
+		}
+		client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "RATE_LIMITED", "You are sending messages too fast", map[string]interface{}{
+			"retryAfterMs": wait.Milliseconds(),
+		}))
+		return
+	}
+
+	// Slow mode: owners and admins are exempt
+	if seconds, _ := r.dbFor(req).GetRoomSlowMode(roomID); seconds > 0 {
+		role, _ := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+		if !db.RoleAtLeast(role, db.RoleAdmin) {
+			if wait := r.slowMode.allow(roomID, client.UserID(), time.Duration(seconds)*time.Second); wait > 0 {
+				client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "RATE_LIMITED", "Slow mode is on in this room", map[string]interface{}{
+					"retryAfterMs":    wait.Milliseconds(),
+					"slowModeSeconds": seconds,
+				}))
+				return
+			}
+			// A send rejected from here on doesn't use up the interval
+			defer func() {
+				if !sent && !ranCommand {
+					r.slowMode.release(roomID, client.UserID())
+				}
+			}()
+		}
+	}
+
+	// Slash commands run instead of being posted; unknown ones are sent as
+	// text. They're limited like sends: an agent-backed one posts to the room.
+	if len(attachmentIDs) == 0 && !encrypted {
+		if name, args, ok := parseSlashCommand(content); ok {
+			if cmd := slashCommands[name]; cmd != nil {
+				ranCommand = r.runSlashCommand(client, req, roomID, name, args, cmd)
+				return
+			}
+		}
+	}
+
+	if len(attachmentIDs) > 0 {
+		n, err := r.dbFor(req).CountPendingAttachments(roomID, client.UserID(), attachmentIDs)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		if n != len(attachmentIDs) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "unknown or already-sent attachment"))
+			return
+		}
+	}
+
+	// Content filter, on the full text of long messages too
+	if !encrypted {
+		var ok bool
+		if content, ok = r.filterContent(client, req, roomID, content); !ok {
+			return
+		}
+		if longContent, ok = r.filterContent(client, req, roomID, longContent); !ok {
+			return
+		}
+	}
+
+	if longContent != "" {
+		attachmentID, err := r.storeTextAttachment(roomID, client.UserID(), "message.txt", longContent)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INTERNAL_ERROR", "could not store long message: "+err.Error()))
+			return
+		}
+		attachmentIDs = append(attachmentIDs, attachmentID)
+	}
+
 	// Parse mentions
 	mentions := "[]"
 	if raw, ok := req.Params["mentions"]; ok {
@@ -62,24 +203,41 @@ func (r *Router) handleRoomsSend(client *ws.Client, req ws.RPCRequest) {
 		uid := client.UserID()
 		senderUserID = &uid
 	}
-	msg, err := r.DB.InsertMessage(msgID, roomID, senderUserID, nil, senderName, senderEmoji, content, mentions, replyTo)
+	msg, err := r.dbFor(req).InsertMessage(msgID, roomID, senderUserID, nil, senderName, senderEmoji, content, mentions, replyTo)
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
+	sent = true
+	if idemKey != "" {
+		r.sends.complete(idemKey, msg.ID)
+	}
+	if len(attachmentIDs) > 0 {
+		msg.Attachments, err = r.dbFor(req).AttachToMessage(msg.ID, roomID, client.UserID(), attachmentIDs)
+		if err != nil {
+			slog.Error("attach to message failed", "err", err, "messageId", msg.ID)
+		}
+	}
 
-	// Broadcast to room
+	// Broadcast to room. The sending connection gets its own message back
+	// unless it asked not to; the user's other devices always do.
+	var exclude *ws.Client
+	if raw, ok := req.Params["echoToSender"]; ok && !jsonBool(raw) {
+		exclude = client
+	}
 	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.message", map[string]interface{}{
 		"roomId":  roomID,
 		"message": msg,
-	}), nil)
+	}), exclude)
 
 	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
 		"messageId": msg.ID,
 	}))
 
 	// Dispatch to all agents in the room
-	r.dispatchAgentResponses(roomID, msg)
+	if !encrypted {
+		r.dispatchAgentResponses(roomID, msg)
+	}
 }
 
 func (r *Router) handleRoomsHistory(client *ws.Client, req ws.RPCRequest) {
@@ -91,17 +249,18 @@ func (r *Router) handleRoomsHistory(client *ws.Client, req ws.RPCRequest) {
 
 	// Verify access
 	if client.IsGuest() {
-		isPublic, _ := r.DB.IsRoomPublic(roomID)
+		isPublic, _ := r.dbFor(req).IsRoomPublic(roomID)
 		if !isPublic && !r.Hub.IsClientSubscribed(roomID, client) {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Guests can only access rooms they have joined"))
 			return
 		}
 	} else {
-		ok, _ := r.DB.IsParticipant(roomID, client.UserID())
+		ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID())
 		if !ok {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
 			return
 		}
+		r.dbFor(req).TouchParticipant(roomID, client.UserID())
 	}
 
 	limit := jsonInt(req.Params["limit"])
@@ -109,41 +268,100 @@ func (r *Router) handleRoomsHistory(client *ws.Client, req ws.RPCRequest) {
 		limit = 50
 	}
 
-	var before *time.Time
+	// Legacy clients page with an RFC3339 timestamp in "before"
 	if bs := jsonString(req.Params["before"]); bs != "" {
 		if t, err := time.Parse(time.RFC3339, bs); err == nil {
-			before = &t
+			messages, err := r.dbFor(req).GetMessages(roomID, &t, limit)
+			if err != nil {
+				client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+				return
+			}
+			if messages == nil {
+				messages = []db.Message{}
+			}
+			client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+				"messages": messages,
+			}))
+			return
 		}
 	}
 
-	messages, err := r.DB.GetMessages(roomID, before, limit)
+	// Cursors are message seq numbers: "before" pages backward, "after" pages forward
+	before := int64(jsonInt(req.Params["before"]))
+	after := int64(jsonInt(req.Params["after"]))
+
+	filter := db.HistoryFilter{Type: jsonString(req.Params["filter"])}
+	if !db.ValidHistoryFilter(filter.Type) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "filter must be one of mentions, media, agents, system"))
+		return
+	}
+	if filter.Type == db.HistoryFilterMentions {
+		filter.UserID = client.UserID()
+		filter.DisplayName = r.displayNameFor(client)
+	}
+
+	page, err := r.dbFor(req).GetMessagesPage(roomID, before, after, limit, filter)
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
+	messages := page.Messages
 	if messages == nil {
 		messages = []db.Message{}
 	}
 
-	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+	resp := map[string]interface{}{
 		"messages": messages,
-	}))
+		"hasMore":  page.HasMore,
+	}
+	if len(messages) > 0 {
+		resp["beforeCursor"] = messages[0].Seq
+		resp["afterCursor"] = messages[len(messages)-1].Seq
+	}
+	client.SendJSON(ws.NewResponse(req.ID, resp))
 }
 
 func (r *Router) handleUserUpdate(client *ws.Client, req ws.RPCRequest) {
 	displayName := jsonString(req.Params["displayName"])
 	avatarEmoji := jsonString(req.Params["avatarEmoji"])
+	bio := jsonOptionalString(req.Params, "bio")
+	pronouns := jsonOptionalString(req.Params, "pronouns")
+	timezone := jsonOptionalString(req.Params, "timezone")
+	if msg := validateProfile(bio, pronouns, timezone); msg != "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", msg))
+		return
+	}
 
-	if err := r.DB.UpdateUser(client.UserID(), displayName, avatarEmoji); err != nil {
+	if err := r.dbFor(req).UpdateUser(client.UserID(), displayName, avatarEmoji); err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
+	if bio != nil || pronouns != nil || timezone != nil {
+		if err := r.dbFor(req).UpdateUserProfile(client.UserID(), bio, pronouns, timezone); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+	}
 
 	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
 		"ok": true,
 	}))
 }
 
+// PostSystemMessage persists a system event (join, leave, agent added) in the
+// room's history and broadcasts it like any other message.
+func (r *Router) PostSystemMessage(roomID, content string) {
+	msg, err := r.DB.InsertSystemMessage(generateMsgID(), roomID, content)
+	if err != nil {
+		slog.Error("post system message failed", "err", err, "roomId", roomID)
+		return
+	}
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.message", map[string]interface{}{
+		"roomId":  roomID,
+		"message": msg,
+	}), nil)
+}
+
 func generateMsgID() string {
 	return GenerateMsgID()
 }
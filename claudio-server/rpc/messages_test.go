@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/internal/wstest"
+)
+
+func TestSendLongMessageBelowPreviewLength(t *testing.T) {
+	r := newTestRouter(t)
+	room := newTestRoom(t, r)
+	r.MaxMessageLength = 10
+	r.LongMessageAsAttachment = true
+	client, out := wstest.NewClient(t, r.Hub, "alice", "Alice")
+
+	long := strings.Repeat("é", 30)
+	resp := call(t, r, client, out, "rooms.send", map[string]interface{}{"roomId": room.ID, "content": long})
+	if !resp.OK {
+		t.Fatalf("send = %+v", resp.Error)
+	}
+	msgs, err := r.DB.RoomMessagesAfter(room.ID, 0, 1)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("messages = %v, %v", msgs, err)
+	}
+	msg := msgs[0]
+	if want := strings.Repeat("é", 10) + "…"; msg.Content != want {
+		t.Errorf("content = %q, want %q", msg.Content, want)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Filename != "message.txt" {
+		t.Errorf("attachments = %+v, want the full text as message.txt", msg.Attachments)
+	}
+}
+
+func TestRejectedSendKeepsSlowModeSlot(t *testing.T) {
+	r := newTestRouter(t)
+	room := newTestRoom(t, r)
+	if _, err := r.DB.UpsertUser("bob", "key2", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DB.AddParticipant(room.ID, "bob", db.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DB.SetRoomSlowMode(room.ID, 60); err != nil {
+		t.Fatal(err)
+	}
+	client, out := wstest.NewClient(t, r.Hub, "bob", "Bob")
+
+	resp := call(t, r, client, out, "rooms.send", map[string]interface{}{"roomId": room.ID, "content": "hi", "attachments": []string{"nope"}})
+	if resp.OK || resp.Error.Code != "INVALID_PARAMS" {
+		t.Fatalf("send with an unknown attachment = %+v", resp)
+	}
+	if resp := call(t, r, client, out, "rooms.send", map[string]interface{}{"roomId": room.ID, "content": "hi"}); !resp.OK {
+		t.Fatalf("send after a rejected one = %+v", resp.Error)
+	}
+	if resp := call(t, r, client, out, "rooms.send", map[string]interface{}{"roomId": room.ID, "content": "again"}); resp.OK || resp.Error.Code != "RATE_LIMITED" {
+		t.Errorf("second send within the interval = %+v, want RATE_LIMITED", resp)
+	}
+}
+
+func TestSlashCommandsObeySlowMode(t *testing.T) {
+	r := newTestRouter(t)
+	room := newTestRoom(t, r)
+	if _, err := r.DB.UpsertUser("bob", "key2", "Bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DB.AddParticipant(room.ID, "bob", db.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DB.SetRoomSlowMode(room.ID, 60); err != nil {
+		t.Fatal(err)
+	}
+	client, out := wstest.NewClient(t, r.Hub, "bob", "Bob")
+
+	if resp := call(t, r, client, out, "rooms.send", map[string]interface{}{"roomId": room.ID, "content": "/help"}); !resp.OK {
+		t.Fatalf("/help = %+v", resp.Error)
+	}
+	if resp := call(t, r, client, out, "rooms.send", map[string]interface{}{"roomId": room.ID, "content": "/help"}); resp.OK || resp.Error.Code != "RATE_LIMITED" {
+		t.Errorf("second /help within the interval = %+v, want RATE_LIMITED", resp)
+	}
+}
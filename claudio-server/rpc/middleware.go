@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// middleware wraps the handler for method with a check or measurement that
+// every handler, or every handler in one of the tables below, shares.
+// Returning next unchanged opts the method out.
+type middleware func(method string, next handlerFunc) handlerFunc
+
+// gates run for every request, known method or not, outermost first.
+func (r *Router) gates() []middleware {
+	return []middleware{r.logRequests, r.guestGate, r.scopeGate, r.serverAdminGate}
+}
+
+// methodMiddleware runs inside the gates for registered methods only.
+func (r *Router) methodMiddleware() []middleware {
+	return []middleware{r.rateLimit, r.timeCalls, r.roomRoleGate}
+}
+
+// chain wraps h in mws, the first outermost.
+func chain(method string, h handlerFunc, mws ...middleware) handlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](method, h)
+	}
+	return h
+}
+
+// wrapMethods puts every registered handler behind the middleware.
+func (r *Router) wrapMethods(methods map[string]handlerFunc) map[string]handlerFunc {
+	mws := append(r.gates(), r.methodMiddleware()...)
+	for method, h := range methods {
+		methods[method] = chain(method, h, mws...)
+	}
+	return methods
+}
+
+func unknownMethod(client *ws.Client, req ws.RPCRequest) {
+	client.SendJSON(ws.NewErrorResponse(req.ID, "UNKNOWN_METHOD", "Unknown method: "+req.Method))
+}
+
+func (r *Router) logRequests(method string, next handlerFunc) handlerFunc {
+	return func(client *ws.Client, req ws.RPCRequest) {
+		client.Log().Info("RPC", "method", method)
+		next(client, req)
+	}
+}
+
+// guestMethods are the methods guest connections may call.
+var guestMethods = map[string]bool{
+	"rooms.listPublic":   true,
+	"rooms.directory":    true,
+	"rooms.join":         true,
+	"rooms.send":         true,
+	"rooms.history":      true,
+	"rooms.info":         true,
+	"rooms.createInvite": true,
+	"rooms.create":       true,
+}
+
+func (r *Router) guestGate(method string, next handlerFunc) handlerFunc {
+	if guestMethods[method] {
+		return next
+	}
+	return func(client *ws.Client, req ws.RPCRequest) {
+		if client.IsGuest() {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "GUEST_FORBIDDEN", "Guests cannot use "+method))
+			return
+		}
+		next(client, req)
+	}
+}
+
+func (r *Router) scopeGate(method string, next handlerFunc) handlerFunc {
+	scope := methodScope(method)
+	return func(client *ws.Client, req ws.RPCRequest) {
+		if !client.HasScope(scope) {
+			client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "FORBIDDEN", "This connection lacks the "+scope+" scope", map[string]interface{}{
+				"requiredScope": scope,
+			}))
+			return
+		}
+		next(client, req)
+	}
+}
+
+func (r *Router) serverAdminGate(method string, next handlerFunc) handlerFunc {
+	if !strings.HasPrefix(method, "admin.") {
+		return next
+	}
+	return func(client *ws.Client, req ws.RPCRequest) {
+		if !r.isServerAdmin(client) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Server admin only"))
+			return
+		}
+		next(client, req)
+	}
+}
+
+// methodLimits are per-user limits for methods that are costly or easy to
+// abuse, on top of the hub's overall request limits. rooms.send has its
+// own MessageLimiter, which duplicates retried with an idempotency key
+// don't spend.
+var methodLimits = map[string]ws.RateLimit{
+	"rooms.create":           {PerSecond: 0.1, Burst: 10},
+	"rooms.translateMessage": {PerSecond: 1, Burst: 10},
+	"messages.report":        {PerSecond: 0.05, Burst: 5},
+	"users.report":           {PerSecond: 0.05, Burst: 5},
+	"devices.createLinkCode": {PerSecond: 0.1, Burst: 3},
+}
+
+func (r *Router) rateLimit(method string, next handlerFunc) handlerFunc {
+	limit, ok := methodLimits[method]
+	if !ok {
+		return next
+	}
+	limiter := ws.NewRateLimiter(limit)
+	return func(client *ws.Client, req ws.RPCRequest) {
+		if wait, first := limiter.Allow(client.UserID()); wait > 0 {
+			if first {
+				client.Log().Warn("method rate limited", "method", method)
+			}
+			client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "RATE_LIMITED", "Too many "+method+" requests", map[string]interface{}{
+				"retryAfterMs": wait.Milliseconds(),
+			}))
+			return
+		}
+		next(client, req)
+	}
+}
+
+// roomRole is the role a method needs in the room named by its roomId.
+type roomRole struct {
+	min       string
+	forbidden string // the error for participants below min
+}
+
+// roomRoles are the methods roomRoleGate checks before their handler runs.
+// Methods that look the room up from another ID, or let some members
+// through, check for themselves.
+var roomRoles = map[string]roomRole{
+	"rooms.update":           {db.RoleAdmin, "Only owners and admins can update the room"},
+	"rooms.updateSettings":   {db.RoleOwner, "Only owners can change room settings"},
+	"rooms.addMembers":       {db.RoleAdmin, "Only owners and admins can add members"},
+	"rooms.auditLog":         {db.RoleAdmin, "Only owners and admins can view the audit log"},
+	"rooms.listJoinRequests": {db.RoleAdmin, "Only owners and admins can review join requests"},
+	"rooms.setAvatar":        {db.RoleAdmin, "Only owners and admins can change the room avatar"},
+	"rooms.exportArchive":    {db.RoleAdmin, "Only owners and admins can export the room"},
+}
+
+func (r *Router) roomRoleGate(method string, next handlerFunc) handlerFunc {
+	rule, ok := roomRoles[method]
+	if !ok {
+		return next
+	}
+	return func(client *ws.Client, req ws.RPCRequest) {
+		roomID := jsonString(req.Params["roomId"])
+		if roomID == "" {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+			return
+		}
+		role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+			return
+		}
+		if !db.RoleAtLeast(role, rule.min) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", rule.forbidden))
+			return
+		}
+		next(client, req)
+	}
+}
+
+// methodTiming is how long one method's handler has taken, for admin.stats.
+type methodTiming struct {
+	mu    sync.Mutex
+	calls int64
+	total time.Duration
+	max   time.Duration
+}
+
+func (t *methodTiming) add(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls++
+	t.total += d
+	t.max = max(t.max, d)
+}
+
+// MethodStats summarizes one method's calls since startup.
+type MethodStats struct {
+	Method string  `json:"method"`
+	Calls  int64   `json:"calls"`
+	AvgMs  float64 `json:"avgMs"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+func (r *Router) timeCalls(method string, next handlerFunc) handlerFunc {
+	t := &methodTiming{}
+	r.timingsMu.Lock()
+	if r.timings == nil {
+		r.timings = make(map[string]*methodTiming)
+	}
+	r.timings[method] = t
+	r.timingsMu.Unlock()
+	return func(client *ws.Client, req ws.RPCRequest) {
+		start := time.Now()
+		defer func() { t.add(time.Since(start)) }()
+		next(client, req)
+	}
+}
+
+// MethodStats returns timings for the methods called at least once, the
+// most time spent first. Handlers that answer from another goroutine are
+// timed until they hand off.
+func (r *Router) MethodStats() []MethodStats {
+	r.timingsMu.Lock()
+	defer r.timingsMu.Unlock()
+	stats := make([]MethodStats, 0, len(r.timings))
+	for method, t := range r.timings {
+		t.mu.Lock()
+		if t.calls > 0 {
+			stats = append(stats, MethodStats{
+				Method: method,
+				Calls:  t.calls,
+				AvgMs:  float64(t.total.Microseconds()) / float64(t.calls) / 1000,
+				MaxMs:  float64(t.max.Microseconds()) / 1000,
+			})
+		}
+		t.mu.Unlock()
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].AvgMs*float64(stats[i].Calls) > stats[j].AvgMs*float64(stats[j].Calls)
+	})
+	return stats
+}
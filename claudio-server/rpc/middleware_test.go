@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) middleware {
+		return func(method string, next handlerFunc) handlerFunc {
+			return func(client *ws.Client, req ws.RPCRequest) {
+				calls = append(calls, name+":"+method)
+				next(client, req)
+			}
+		}
+	}
+	h := chain("rooms.list", func(*ws.Client, ws.RPCRequest) {
+		calls = append(calls, "handler")
+	}, mark("outer"), mark("inner"))
+	h(nil, ws.RPCRequest{})
+
+	want := []string{"outer:rooms.list", "inner:rooms.list", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestTimeCalls(t *testing.T) {
+	r := &Router{}
+	noop := func(*ws.Client, ws.RPCRequest) {}
+	list := r.timeCalls("rooms.list", noop)
+	r.timeCalls("rooms.info", noop)
+
+	list(nil, ws.RPCRequest{})
+	list(nil, ws.RPCRequest{})
+
+	stats := r.MethodStats()
+	if len(stats) != 1 {
+		t.Fatalf("stats = %+v, want only rooms.list", stats)
+	}
+	if stats[0].Method != "rooms.list" || stats[0].Calls != 2 {
+		t.Errorf("stats[0] = %+v, want 2 calls to rooms.list", stats[0])
+	}
+}
+
+func TestEveryMiddlewareTableMethodIsRegistered(t *testing.T) {
+	methods := (&Router{}).registerMethods()
+	for method := range methodLimits {
+		if methods[method] == nil {
+			t.Errorf("rate limited method %s has no handler", method)
+		}
+	}
+	for method := range roomRoles {
+		if methods[method] == nil {
+			t.Errorf("room role method %s has no handler", method)
+		}
+	}
+	for method := range guestMethods {
+		if methods[method] == nil {
+			t.Errorf("guest method %s has no handler", method)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+func (r *Router) handleRoomsSetPreferences(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	level := jsonString(req.Params["notifications"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if !db.ValidNotifyLevel(level) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "notifications must be all, mentions, or mute"))
+		return
+	}
+
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+
+	if err := r.dbFor(req).SetRoomNotifications(roomID, client.UserID(), level); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId":        roomID,
+		"notifications": level,
+	}))
+}
+
+func (r *Router) handleRoomsPin(client *ws.Client, req ws.RPCRequest, pinned bool) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+
+	if err := r.dbFor(req).SetRoomPinned(roomID, client.UserID(), pinned); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId": roomID,
+		"pinned": pinned,
+	}))
+}
@@ -0,0 +1,303 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// handlerFunc answers one RPC method.
+type handlerFunc func(client *ws.Client, req ws.RPCRequest)
+
+// registerMethods returns the RPC methods Handle dispatches to. Handlers
+// with a params struct are wrapped with typed.
+func (r *Router) registerMethods() map[string]handlerFunc {
+	return map[string]handlerFunc{
+		"rooms.list":               r.handleRoomsList,
+		"rooms.listPublic":         r.handleRoomsListPublic,
+		"rooms.directory":          r.handleRoomsDirectory,
+		"rooms.create":             r.handleRoomsCreate,
+		"rooms.join":               r.handleRoomsJoin,
+		"rooms.leave":              r.handleRoomsLeave,
+		"rooms.subscribe":          typed(r.handleRoomsSubscribe),
+		"rooms.unsubscribe":        typed(r.handleRoomsUnsubscribe),
+		"rooms.info":               r.handleRoomsInfo,
+		"rooms.history":            r.handleRoomsHistory,
+		"rooms.send":               r.handleRoomsSend,
+		"rooms.addAgent":           r.handleRoomsAddAgent,
+		"rooms.removeAgent":        r.handleRoomsRemoveAgent,
+		"rooms.updateAgent":        r.handleRoomsUpdateAgent,
+		"rooms.resetAgentBreaker":  r.handleRoomsResetAgentBreaker,
+		"agents.discover":          r.handleAgentsDiscover,
+		"agents.status":            r.handleAgentsStatus,
+		"agents.usage":             r.handleAgentsUsage,
+		"agents.createSchedule":    r.handleAgentsCreateSchedule,
+		"agents.listSchedules":     r.handleAgentsListSchedules,
+		"agents.deleteSchedule":    r.handleAgentsDeleteSchedule,
+		"agents.getMemory":         r.handleAgentsGetMemory,
+		"agents.setMemory":         r.handleAgentsSetMemory,
+		"rooms.abortAgent":         r.handleRoomsAbortAgent,
+		"rooms.resetAgentSession":  r.handleRoomsResetAgentSession,
+		"rooms.createInvite":       r.handleRoomsCreateInvite,
+		"rooms.delete":             r.handleRoomsDelete,
+		"rooms.update":             r.handleRoomsUpdate,
+		"rooms.addMembers":         r.handleRoomsAddMembers,
+		"rooms.activity":           r.handleRoomsActivity,
+		"rooms.auditLog":           r.handleRoomsAuditLog,
+		"rooms.listJoinRequests":   r.handleRoomsListJoinRequests,
+		"rooms.approveJoin":        func(client *ws.Client, req ws.RPCRequest) { r.handleRoomsResolveJoin(client, req, true) },
+		"rooms.denyJoin":           func(client *ws.Client, req ws.RPCRequest) { r.handleRoomsResolveJoin(client, req, false) },
+		"rooms.setRole":            r.handleRoomsSetRole,
+		"rooms.transferOwnership":  r.handleRoomsTransferOwnership,
+		"rooms.setTopic":           r.handleRoomsSetTopic,
+		"rooms.topicHistory":       r.handleRoomsTopicHistory,
+		"rooms.pin":                func(client *ws.Client, req ws.RPCRequest) { r.handleRoomsPin(client, req, true) },
+		"rooms.unpin":              func(client *ws.Client, req ws.RPCRequest) { r.handleRoomsPin(client, req, false) },
+		"rooms.setPreferences":     r.handleRoomsSetPreferences,
+		"rooms.updateSettings":     r.handleRoomsUpdateSettings,
+		"rooms.setAvatar":          r.handleRoomsSetAvatar,
+		"rooms.createUpload":       r.handleRoomsCreateUpload,
+		"rooms.exportArchive":      typed(r.handleRoomsExportArchive),
+		"rooms.importArchive":      typed(r.handleRoomsImportArchive),
+		"rooms.translateMessage":   r.handleRoomsTranslateMessage,
+		"templates.list":           r.handleTemplatesList,
+		"templates.create":         r.handleTemplatesCreate,
+		"templates.delete":         r.handleTemplatesDelete,
+		"dm.open":                  r.handleDMOpen,
+		"dm.openAgent":             r.handleDMOpenAgent,
+		"messages.star":            func(client *ws.Client, req ws.RPCRequest) { r.handleMessagesStar(client, req, true) },
+		"messages.unstar":          func(client *ws.Client, req ws.RPCRequest) { r.handleMessagesStar(client, req, false) },
+		"messages.listStarred":     r.handleMessagesListStarred,
+		"messages.report":          r.handleMessagesReport,
+		"auth.revokeResumeTokens":  r.handleAuthRevokeResumeTokens,
+		"devices.list":             r.handleDevicesList,
+		"devices.activity":         r.handleDevicesActivity,
+		"devices.createLinkCode":   r.handleDevicesCreateLinkCode,
+		"devices.link":             r.handleDevicesLink,
+		"devices.revoke":           r.handleDevicesRevoke,
+		"e2ee.publishKeys":         r.handleE2EEPublishKeys,
+		"e2ee.getKeyBundles":       r.handleE2EEGetKeyBundles,
+		"e2ee.distributeRoomKey":   r.handleE2EEDistributeRoomKey,
+		"e2ee.fetchRoomKeys":       r.handleE2EEFetchRoomKeys,
+		"admin.listRooms":          typed(r.handleAdminListRooms),
+		"admin.deleteRoom":         typed(r.handleAdminDeleteRoom),
+		"admin.banUser":            typed(r.handleAdminBanUser),
+		"admin.unbanUser":          typed(r.handleAdminUnbanUser),
+		"admin.deleteUser":         typed(r.handleAdminDeleteUser),
+		"admin.listBans":           r.handleAdminListBans,
+		"admin.banIP":              typed(r.handleAdminBanIP),
+		"admin.unbanIP":            typed(r.handleAdminUnbanIP),
+		"admin.listIPBans":         r.handleAdminListIPBans,
+		"admin.stats":              r.handleAdminStats,
+		"admin.connections":        typed(r.handleAdminConnections),
+		"admin.authLog":            typed(r.handleAdminAuthLog),
+		"admin.reports":            typed(r.handleAdminReports),
+		"admin.resolveReport":      typed(r.handleAdminResolveReport),
+		"admin.createServerInvite": typed(r.handleAdminCreateServerInvite),
+		"admin.grant":              typed(r.handleAdminGrant),
+		"admin.revoke":             typed(r.handleAdminRevoke),
+		"admin.backup":             r.handleAdminBackup,
+		"presence.set":             r.handlePresenceSet,
+		"users.rotateKey":          r.handleUsersRotateKey,
+		"users.get":                r.handleUsersGet,
+		"users.report":             r.handleUsersReport,
+		"users.deleteAccount":      typed(r.handleUsersDeleteAccount),
+		"user.update":              r.handleUserUpdate,
+	}
+}
+
+// typed adapts a handler that takes its params as a struct. Params are
+// decoded by their json tags and checked against their rpc tags before fn
+// runs; problems are answered with INVALID_PARAMS:
+//
+//	rpc:"required"          not missing or zero
+//	rpc:"max=100"           at most 100 characters, items or as a number
+//	rpc:"oneof=full lite"   one of the listed strings (empty passes unless required)
+//
+// A tag typed can't apply panics here, when methods are registered, rather
+// than on the first request.
+func typed[P any](fn func(client *ws.Client, req ws.RPCRequest, params P)) handlerFunc {
+	checkRules(reflect.TypeFor[P]())
+	return func(client *ws.Client, req ws.RPCRequest) {
+		var params P
+		if err := decodeParams(req.Params, &params); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", err.Error()))
+			return
+		}
+		fn(client, req, params)
+	}
+}
+
+// decodeParams fills the struct dst points to from params and validates
+// it, see typed.
+func decodeParams(params map[string]json.RawMessage, dst interface{}) error {
+	return decodeFields(params, reflect.ValueOf(dst).Elem())
+}
+
+// decodeFields is decodeParams for the struct v, with the fields of
+// embedded structs decoded as its own.
+func decodeFields(params map[string]json.RawMessage, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if embedded(f) {
+			if err := decodeFields(params, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		name := paramName(f)
+		if name == "" {
+			continue
+		}
+		raw, present := params[name]
+		if present && string(raw) != "null" {
+			if err := json.Unmarshal(raw, v.Field(i).Addr().Interface()); err != nil {
+				return fmt.Errorf("%s must be %s", name, describeType(f.Type))
+			}
+		}
+		if err := checkParam(name, f.Tag.Get("rpc"), v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// embedded reports whether f is a struct whose fields are params of the
+// struct embedding it, as encoding/json would treat it.
+func embedded(f reflect.StructField) bool {
+	return f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == ""
+}
+
+func paramName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// checkRules panics if t isn't a struct or has an rpc tag checkParam can't
+// apply to its field.
+func checkRules(t reflect.Type) {
+	if t.Kind() != reflect.Struct {
+		panic("rpc: params type " + t.String() + " is not a struct")
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if embedded(f) {
+			checkRules(f.Type)
+			continue
+		}
+		name := paramName(f)
+		rules := f.Tag.Get("rpc")
+		if name == "" || rules == "" {
+			continue
+		}
+		for _, rule := range strings.Split(rules, ",") {
+			key, arg, _ := strings.Cut(rule, "=")
+			switch key {
+			case "required":
+			case "max":
+				if _, err := strconv.Atoi(arg); err != nil {
+					panic("rpc: bad max rule on " + name)
+				}
+				switch f.Type.Kind() {
+				case reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Int64:
+				default:
+					panic("rpc: max rule on unsupported field " + name)
+				}
+			case "oneof":
+				if f.Type.Kind() != reflect.String {
+					panic("rpc: oneof rule on non-string field " + name)
+				}
+			default:
+				panic("rpc: unknown rule " + key + " on " + name)
+			}
+		}
+	}
+}
+
+// checkParam applies a field's rpc tag rules, which checkRules has vetted.
+func checkParam(name, rules string, v reflect.Value) error {
+	if rules == "" {
+		return nil
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			if v.IsZero() {
+				return fmt.Errorf("%s is required", name)
+			}
+		case "max":
+			limit, _ := strconv.Atoi(arg)
+			if err := checkMax(name, limit, v); err != nil {
+				return err
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			if s := v.String(); s != "" && !slices.Contains(options, s) {
+				return fmt.Errorf("%s must be %s", name, orList(options))
+			}
+		}
+	}
+	return nil
+}
+
+func checkMax(name string, limit int, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		if len([]rune(v.String())) > limit {
+			return fmt.Errorf("%s can be at most %d characters", name, limit)
+		}
+	case reflect.Slice, reflect.Map:
+		if v.Len() > limit {
+			return fmt.Errorf("%s can have at most %d items", name, limit)
+		}
+	case reflect.Int, reflect.Int64:
+		if v.Int() > int64(limit) {
+			return fmt.Errorf("%s can be at most %d", name, limit)
+		}
+	}
+	return nil
+}
+
+// describeType names a param type for error messages, e.g. "an array of
+// strings".
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(describeType(t.Elem()), "a "), "an ") + "s"
+	case reflect.Map:
+		return "an object of " + strings.TrimPrefix(strings.TrimPrefix(describeType(t.Elem()), "a "), "an ") + "s"
+	case reflect.Pointer:
+		return describeType(t.Elem())
+	default:
+		return "an object"
+	}
+}
+
+func orList(options []string) string {
+	if len(options) == 1 {
+		return options[0]
+	}
+	return strings.Join(options[:len(options)-1], ", ") + " or " + options[len(options)-1]
+}
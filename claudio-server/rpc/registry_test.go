@@ -0,0 +1,87 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+func TestDecodeParams(t *testing.T) {
+	type paging struct {
+		Limit int `json:"limit" rpc:"max=50"`
+	}
+	type params struct {
+		RoomIDs []string `json:"roomIds" rpc:"required,max=2"`
+		Level   string   `json:"level" rpc:"oneof=full lite"`
+		paging
+	}
+	tests := []struct {
+		in      string
+		wantErr string
+	}{
+		{`{"roomIds":["a"]}`, ""},
+		{`{"roomIds":["a"],"level":"lite","limit":50}`, ""},
+		{`{}`, "roomIds is required"},
+		{`{"roomIds":null}`, "roomIds is required"},
+		{`{"roomIds":"a"}`, "roomIds must be an array of strings"},
+		{`{"roomIds":["a","b","c"]}`, "roomIds can have at most 2 items"},
+		{`{"roomIds":["a"],"level":"loud"}`, "level must be full or lite"},
+		{`{"roomIds":["a"],"limit":"ten"}`, "limit must be a number"},
+		{`{"roomIds":["a"],"limit":51}`, "limit can be at most 50"},
+	}
+	for _, tt := range tests {
+		var raw map[string]json.RawMessage
+		json.Unmarshal([]byte(tt.in), &raw)
+		var p params
+		err := decodeParams(raw, &p)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.wantErr {
+			t.Errorf("%s: err = %q, want %q", tt.in, got, tt.wantErr)
+		}
+	}
+}
+
+func TestTypedRejectsBadTags(t *testing.T) {
+	type unknownRule struct {
+		Name string `json:"name" rpc:"requried"`
+	}
+	type badMax struct {
+		Name string `json:"name" rpc:"max=ten"`
+	}
+	type maxOnBool struct {
+		On bool `json:"on" rpc:"max=1"`
+	}
+	type oneofOnInt struct {
+		Level int `json:"level" rpc:"oneof=1 2"`
+	}
+	tests := map[string]func(){
+		"unknown rule": func() { typed(func(*ws.Client, ws.RPCRequest, unknownRule) {}) },
+		"bad max":      func() { typed(func(*ws.Client, ws.RPCRequest, badMax) {}) },
+		"max on bool":  func() { typed(func(*ws.Client, ws.RPCRequest, maxOnBool) {}) },
+		"oneof on int": func() { typed(func(*ws.Client, ws.RPCRequest, oneofOnInt) {}) },
+	}
+	for name, register := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: typed accepted the tag", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestEveryScopedMethodIsRegistered(t *testing.T) {
+	r := &Router{}
+	methods := r.registerMethods()
+	for method := range readMethods {
+		if methods[method] == nil {
+			t.Errorf("read method %s has no handler", method)
+		}
+	}
+}
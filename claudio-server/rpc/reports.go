@@ -0,0 +1,252 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const maxReportDetailsLen = 2000
+
+// reportParams reads the reason and details shared by messages.report and
+// users.report, or returns an error message.
+func reportParams(req ws.RPCRequest) (reason, details, errMsg string) {
+	reason = jsonString(req.Params["reason"])
+	details = jsonString(req.Params["details"])
+	if !db.ValidReportReason(reason) {
+		return "", "", "reason must be one of spam, harassment, hate, violence, sexual, impersonation, other"
+	}
+	if utf8.RuneCountInString(details) > maxReportDetailsLen {
+		return "", "", "details must be at most 2000 characters"
+	}
+	return reason, details, ""
+}
+
+// handleMessagesReport reports a message the caller can see. The report
+// keeps a copy of the message, so it survives edits and deletion.
+func (r *Router) handleMessagesReport(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	messageID := jsonString(req.Params["messageId"])
+	if roomID == "" || messageID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and messageId are required"))
+		return
+	}
+	reason, details, errMsg := reportParams(req)
+	if errMsg != "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", errMsg))
+		return
+	}
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Message not found"))
+		return
+	}
+
+	msg, err := r.dbFor(req).GetMessage(roomID, messageID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if msg == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Message not found"))
+		return
+	}
+	report := &db.Report{
+		Kind:       db.ReportMessage,
+		ReporterID: client.UserID(),
+		RoomID:     roomID,
+		MessageID:  messageID,
+		Reason:     reason,
+		Details:    details,
+	}
+	if msg.SenderUserID != nil {
+		report.TargetUserID = *msg.SenderUserID
+	}
+	r.fileReport(client, req, report, msg)
+}
+
+// handleUsersReport reports an account, optionally naming the room where
+// the behaviour happened.
+func (r *Router) handleUsersReport(client *ws.Client, req ws.RPCRequest) {
+	userID := jsonString(req.Params["userId"])
+	roomID := jsonString(req.Params["roomId"])
+	if userID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "userId is required"))
+		return
+	}
+	if userID == client.UserID() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "You cannot report yourself"))
+		return
+	}
+	reason, details, errMsg := reportParams(req)
+	if errMsg != "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", errMsg))
+		return
+	}
+	if user, _ := r.dbFor(req).GetUser(userID); user == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User not found"))
+		return
+	}
+	if roomID != "" {
+		if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+			return
+		}
+	}
+
+	r.fileReport(client, req, &db.Report{
+		Kind:         db.ReportUser,
+		ReporterID:   client.UserID(),
+		TargetUserID: userID,
+		RoomID:       roomID,
+		Reason:       reason,
+		Details:      details,
+	}, nil)
+}
+
+// fileReport stores report and tells the server admins about new ones.
+func (r *Router) fileReport(client *ws.Client, req ws.RPCRequest, report *db.Report, msg *db.Message) {
+	created, err := r.dbFor(req).CreateReport(report, msg)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"reportId":  report.ID,
+		"duplicate": !created,
+	}))
+	if !created {
+		return
+	}
+
+	slog.Info("report filed", "reportId", report.ID, "kind", report.Kind, "reason", report.Reason, "target", report.TargetUserID)
+	r.notifyServerAdmins(ws.NewEvent("admin.report", map[string]interface{}{
+		"report": report,
+	}))
+	if r.ReportWebhookURL != "" {
+		go r.postReportWebhook(report)
+	}
+}
+
+// notifyServerAdmins sends event to every online server admin, from
+// config or granted.
+func (r *Router) notifyServerAdmins(event ws.RPCEvent) {
+	ids := make([]string, 0, len(r.ServerAdmins))
+	for id := range r.ServerAdmins {
+		ids = append(ids, id)
+	}
+	// Configured IDs may be device IDs; deliver to their accounts
+	owners, err := r.DB.DeviceUserIDs(ids)
+	if err != nil {
+		slog.Error("resolve admin devices failed", "err", err)
+	}
+	granted, err := r.DB.ListServerAdmins()
+	if err != nil {
+		slog.Error("list server admins failed", "err", err)
+	}
+
+	sent := map[string]bool{}
+	for _, id := range append(ids, granted...) {
+		userID := id
+		if owner, ok := owners[id]; ok {
+			userID = owner
+		}
+		if !sent[userID] {
+			sent[userID] = true
+			r.Hub.SendToUser(userID, event)
+		}
+	}
+}
+
+// postReportWebhook forwards a new report to ReportWebhookURL, e.g. a chat
+// channel the moderators watch.
+func (r *Router) postReportWebhook(report *db.Report) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"event":  "report.created",
+		"report": report,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", r.ReportWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("report webhook failed", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Error("report webhook failed", "err", err, "reportId", report.ID)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Error("report webhook failed", "status", resp.StatusCode, "reportId", report.ID)
+	}
+}
+
+type adminReportsParams struct {
+	Status string `json:"status" rpc:"oneof=open resolved dismissed"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+func (r *Router) handleAdminReports(client *ws.Client, req ws.RPCRequest, p adminReportsParams) {
+	status, limit, offset := p.Status, p.Limit, p.Offset
+	if limit <= 0 {
+		limit = defaultDirectoryLimit
+	}
+	if limit > maxDirectoryLimit {
+		limit = maxDirectoryLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	reports, err := r.dbFor(req).ListReports(status, limit+1, offset)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	hasMore := len(reports) > limit
+	if hasMore {
+		reports = reports[:limit]
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"reports": reports,
+		"hasMore": hasMore,
+	}))
+}
+
+type adminResolveReportParams struct {
+	ReportID string `json:"reportId" rpc:"required"`
+	Status   string `json:"status" rpc:"oneof=resolved dismissed"` // default resolved
+	Note     string `json:"note" rpc:"max=2000"`                   // maxReportDetailsLen, as for reports
+}
+
+func (r *Router) handleAdminResolveReport(client *ws.Client, req ws.RPCRequest, p adminResolveReportParams) {
+	status := p.Status
+	if status == "" {
+		status = db.ReportResolved
+	}
+
+	report, err := r.dbFor(req).ResolveReport(p.ReportID, status, client.UserID(), p.Note)
+	if errors.Is(err, sql.ErrNoRows) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Report not found"))
+		return
+	}
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"report": report,
+	}))
+}
@@ -0,0 +1,104 @@
+package rpc
+
+import (
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// handleRoomsSetRole promotes or demotes a participant. Granting or revoking
+// admin is owner-only; ownership itself moves via rooms.transferOwnership.
+func (r *Router) handleRoomsSetRole(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	userID := jsonString(req.Params["userId"])
+	role := jsonString(req.Params["role"])
+	if roomID == "" || userID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and userId are required"))
+		return
+	}
+	if role != db.RoleAdmin && role != db.RoleMember && role != db.RoleGuest {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "role must be admin, member, or guest"))
+		return
+	}
+
+	callerRole, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+	if err != nil || !db.RoleAtLeast(callerRole, db.RoleAdmin) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can change roles"))
+		return
+	}
+	targetRole, err := r.dbFor(req).GetParticipantRole(roomID, userID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User is not a participant"))
+		return
+	}
+	if targetRole == db.RoleOwner {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Use rooms.transferOwnership to change the owner"))
+		return
+	}
+	if (role == db.RoleAdmin || targetRole == db.RoleAdmin) && callerRole != db.RoleOwner {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only the owner can grant or revoke admin"))
+		return
+	}
+
+	if role != targetRole {
+		if err := r.dbFor(req).SetParticipantRole(roomID, userID, role); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		r.broadcastRoleChanged(roomID, userID, targetRole, role, client.UserID())
+		r.audit(roomID, client.UserID(), db.AuditRoleChanged, userID, map[string]interface{}{
+			"oldRole": targetRole,
+			"role":    role,
+		})
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"userId": userID,
+		"role":   role,
+	}))
+}
+
+func (r *Router) handleRoomsTransferOwnership(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	userID := jsonString(req.Params["userId"])
+	if roomID == "" || userID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and userId are required"))
+		return
+	}
+	if userID == client.UserID() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "You already own this room"))
+		return
+	}
+
+	callerRole, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+	if err != nil || callerRole != db.RoleOwner {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only the owner can transfer ownership"))
+		return
+	}
+	targetRole, err := r.dbFor(req).GetParticipantRole(roomID, userID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User is not a participant"))
+		return
+	}
+
+	if err := r.dbFor(req).TransferOwnership(roomID, client.UserID(), userID); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	r.broadcastRoleChanged(roomID, userID, targetRole, db.RoleOwner, client.UserID())
+	r.broadcastRoleChanged(roomID, client.UserID(), db.RoleOwner, db.RoleAdmin, client.UserID())
+	r.audit(roomID, client.UserID(), db.AuditOwnershipTransfer, userID, nil)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ownerId": userID,
+	}))
+}
+
+func (r *Router) broadcastRoleChanged(roomID, userID, oldRole, newRole, changedBy string) {
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.role.changed", map[string]interface{}{
+		"roomId":    roomID,
+		"userId":    userID,
+		"oldRole":   oldRole,
+		"role":      newRole,
+		"changedBy": changedBy,
+	}), nil)
+}
@@ -1,17 +1,22 @@
 package rpc
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/nicebartender/claudio-server/backend"
 	"github.com/nicebartender/claudio-server/db"
 	"github.com/nicebartender/claudio-server/joincode"
 	"github.com/nicebartender/claudio-server/ws"
 )
 
 func (r *Router) handleRoomsList(client *ws.Client, req ws.RPCRequest) {
-	rooms, err := r.DB.ListRoomsForUser(client.UserID())
+	rooms, err := r.dbFor(req).ListRoomsForUser(client.UserID())
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
@@ -25,7 +30,7 @@ func (r *Router) handleRoomsList(client *ws.Client, req ws.RPCRequest) {
 }
 
 func (r *Router) handleRoomsListPublic(client *ws.Client, req ws.RPCRequest) {
-	rooms, err := r.DB.ListPublicRooms()
+	rooms, err := r.dbFor(req).ListPublicRooms()
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
@@ -38,30 +43,134 @@ func (r *Router) handleRoomsListPublic(client *ws.Client, req ws.RPCRequest) {
 	}))
 }
 
+const (
+	defaultDirectoryLimit = 50
+	maxDirectoryLimit     = 100
+)
+
+// handleRoomsDirectory lists rooms with public visibility. Any of them can be
+// joined with rooms.join by roomId, no invite code needed.
+func (r *Router) handleRoomsDirectory(client *ws.Client, req ws.RPCRequest) {
+	query := strings.TrimSpace(jsonString(req.Params["query"]))
+	limit := jsonInt(req.Params["limit"])
+	offset := jsonInt(req.Params["offset"])
+	if limit <= 0 {
+		limit = defaultDirectoryLimit
+	}
+	if limit > maxDirectoryLimit {
+		limit = maxDirectoryLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rooms, err := r.dbFor(req).ListDirectory(query, limit+1, offset)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	hasMore := len(rooms) > limit
+	if hasMore {
+		rooms = rooms[:limit]
+	}
+
+	entries := make([]map[string]interface{}, len(rooms))
+	for i, room := range rooms {
+		entries[i] = map[string]interface{}{
+			"id":               room.ID,
+			"name":             room.Name,
+			"emoji":            room.Emoji,
+			"description":      room.Description,
+			"topic":            room.Topic,
+			"participantCount": room.ParticipantCount,
+			"lastActivityAt":   room.UpdatedAt,
+			"lastMessage":      room.LastMessage,
+		}
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"rooms":   entries,
+		"hasMore": hasMore,
+	}))
+}
+
 func (r *Router) handleRoomsCreate(client *ws.Client, req ws.RPCRequest) {
 	name := jsonString(req.Params["name"])
 	emoji := jsonString(req.Params["emoji"])
-	isPublic := jsonBool(req.Params["public"])
+	visibility := db.VisibilityPrivate
+
+	var template *db.RoomTemplate
+	if templateID := jsonString(req.Params["templateId"]); templateID != "" {
+		if template = r.templateFor(client, templateID); template == nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Template not found"))
+			return
+		}
+		if emoji == "" {
+			emoji = template.Emoji
+		}
+		if template.Visibility != "" {
+			visibility = template.Visibility
+		}
+	}
+
+	// Explicit params override the template
+	if raw, ok := req.Params["public"]; ok {
+		visibility = db.VisibilityPrivate
+		if jsonBool(raw) {
+			visibility = db.VisibilityPublic
+		}
+	}
+	if raw, ok := req.Params["visibility"]; ok {
+		visibility = jsonString(raw)
+	}
 
 	if name == "" {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "name is required"))
 		return
 	}
+	if !db.ValidVisibility(visibility) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "visibility must be private, unlisted, or public"))
+		return
+	}
+
+	// Encryption is chosen at creation and can't be changed later
+	encrypted := jsonBool(req.Params["encrypted"])
+	if encrypted && client.IsGuest() {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Guests cannot create encrypted rooms"))
+		return
+	}
+	if encrypted && template != nil && len(template.Agents) > 0 {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Encrypted rooms can't use a template with agents"))
+		return
+	}
 
 	// Ensure guest users exist in the users table (needed for foreign key on created_by)
 	if client.IsGuest() {
-		r.DB.UpsertUser(client.UserID(), "guest", client.DisplayName(), "")
+		r.dbFor(req).UpsertUser(client.UserID(), "guest", client.DisplayName(), "")
 	}
 
-	room, err := r.DB.CreateRoom(name, emoji, client.UserID(), isPublic)
+	room, err := r.dbFor(req).CreateRoomWithVisibility(name, emoji, client.UserID(), visibility)
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
+	if encrypted {
+		if err := r.dbFor(req).SetRoomEncrypted(room.ID); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		room.Encrypted = true
+	}
+
+	if template != nil {
+		r.applyTemplate(room.ID, client.UserID(), template)
+		if full, err := r.dbFor(req).GetRoom(room.ID); err == nil {
+			room = full
+		}
+	}
 
 	// Create initial invite code
 	dur := 7 * 24 * time.Hour
-	invite, err := r.DB.CreateInvite(room.ID, client.UserID(), &dur, 0)
+	invite, err := r.dbFor(req).CreateInvite(room.ID, client.UserID(), &dur, 0, db.RoleMember)
 	if err != nil {
 		slog.Error("create invite failed", "err", err)
 	}
@@ -87,7 +196,7 @@ func (r *Router) handleRoomsJoin(client *ws.Client, req ws.RPCRequest) {
 
 	if roomID != "" {
 		// Join by roomId — must be a public room
-		isPublic, err := r.DB.IsRoomPublic(roomID)
+		isPublic, err := r.dbFor(req).IsRoomPublic(roomID)
 		if err != nil {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Room not found"))
 			return
@@ -99,16 +208,16 @@ func (r *Router) handleRoomsJoin(client *ws.Client, req ws.RPCRequest) {
 
 		if client.IsGuest() {
 			// Guests just subscribe, no participant record
-			r.Hub.SubscribeRoom(roomID, client)
+			r.Hub.SubscribeRoomRecent(roomID, client)
 		} else {
 			// Authenticated user: add as participant
-			already, _ := r.DB.IsParticipant(roomID, client.UserID())
+			already, _ := r.dbFor(req).IsParticipant(roomID, client.UserID())
 			if !already {
-				if err := r.DB.AddParticipant(roomID, client.UserID(), "member"); err != nil {
+				if err := r.dbFor(req).AddParticipant(roomID, client.UserID(), db.RoleMember); err != nil {
 					client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 					return
 				}
-				user, _ := r.DB.GetUser(client.UserID())
+				user, _ := r.dbFor(req).GetUser(client.UserID())
 				if user != nil {
 					r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.join", map[string]interface{}{
 						"roomId":      roomID,
@@ -116,12 +225,13 @@ func (r *Router) handleRoomsJoin(client *ws.Client, req ws.RPCRequest) {
 						"emoji":       user.AvatarEmoji,
 						"userId":      user.ID,
 					}), nil)
+					r.PostSystemMessage(roomID, user.DisplayName+" joined")
 				}
 			}
-			r.Hub.SubscribeRoom(roomID, client)
+			r.Hub.SubscribeRoomRecent(roomID, client)
 		}
 
-		room, err := r.DB.GetRoom(roomID)
+		room, err := r.dbFor(req).GetRoom(roomID)
 		if err != nil {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 			return
@@ -138,15 +248,44 @@ func (r *Router) handleRoomsJoin(client *ws.Client, req ws.RPCRequest) {
 		return
 	}
 
-	roomID, err := r.DB.RedeemInvite(code)
+	// Rooms that require approval get a join request instead, unless the
+	// user is already in. Checked before redeeming so a refused guest
+	// doesn't use up the invite.
+	if pending, err := r.dbFor(req).LookupInvite(code); err == nil {
+		if require, _ := r.dbFor(req).RoomRequiresApproval(pending.RoomID); require {
+			if client.IsGuest() {
+				client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "This room requires approval to join; sign in to request access"))
+				return
+			}
+			if ok, _ := r.dbFor(req).IsParticipant(pending.RoomID, client.UserID()); !ok {
+				r.requestToJoin(client, req, code)
+				return
+			}
+		}
+	}
+
+	// Guests aren't recorded as participants; everyone else is added in
+	// the same transaction that counts the use
+	var invite *db.InviteCode
+	var added bool
+	var err error
+	if client.IsGuest() {
+		invite, err = r.dbFor(req).RedeemInvite(code)
+	} else {
+		invite, added, err = r.dbFor(req).JoinWithInvite(code, client.UserID())
+	}
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_INVITE", err.Error()))
 		return
 	}
+	roomID = invite.RoomID
 
 	if client.IsGuest() {
 		// Guests just subscribe, no participant record
-		r.Hub.SubscribeRoom(roomID, client)
+		if invite.Role == db.RoleGuest {
+			client.SetReadOnly(roomID)
+		}
+		r.Hub.SubscribeRoomRecent(roomID, client)
 
 		// Broadcast join event for guest
 		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.join", map[string]interface{}{
@@ -155,17 +294,12 @@ func (r *Router) handleRoomsJoin(client *ws.Client, req ws.RPCRequest) {
 			"userId":      client.UserID(),
 			"isAgent":     false,
 		}), nil)
+		r.PostSystemMessage(roomID, client.DisplayName()+" joined")
 	} else {
-		// Check if already a participant
-		already, _ := r.DB.IsParticipant(roomID, client.UserID())
-		if !already {
-			if err := r.DB.AddParticipant(roomID, client.UserID(), "member"); err != nil {
-				client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
-				return
-			}
-
+		// Existing participants keep their role
+		if added {
 			// Broadcast join event
-			user, _ := r.DB.GetUser(client.UserID())
+			user, _ := r.dbFor(req).GetUser(client.UserID())
 			if user != nil {
 				r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.join", map[string]interface{}{
 					"roomId":      roomID,
@@ -173,14 +307,15 @@ func (r *Router) handleRoomsJoin(client *ws.Client, req ws.RPCRequest) {
 					"emoji":       user.AvatarEmoji,
 					"userId":      user.ID,
 				}), nil)
+				r.PostSystemMessage(roomID, user.DisplayName+" joined")
 			}
 		}
 
 		// Subscribe to room events
-		r.Hub.SubscribeRoom(roomID, client)
+		r.Hub.SubscribeRoomRecent(roomID, client)
 	}
 
-	room, err := r.DB.GetRoom(roomID)
+	room, err := r.dbFor(req).GetRoom(roomID)
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
@@ -198,7 +333,37 @@ func (r *Router) handleRoomsLeave(client *ws.Client, req ws.RPCRequest) {
 		return
 	}
 
-	if err := r.DB.RemoveParticipant(roomID, client.UserID()); err != nil {
+	// An owner can't just walk away: ownership goes to transferTo, or to the
+	// longest-standing admin
+	if role, _ := r.dbFor(req).GetParticipantRole(roomID, client.UserID()); role == db.RoleOwner {
+		newOwner := jsonString(req.Params["transferTo"])
+		if newOwner == "" {
+			var err error
+			if newOwner, err = r.dbFor(req).LongestStandingAdmin(roomID); err != nil {
+				client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+				return
+			}
+		}
+		if newOwner == "" || newOwner == client.UserID() {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "Transfer ownership or promote an admin before leaving"))
+			return
+		}
+		oldRole, err := r.dbFor(req).GetParticipantRole(roomID, newOwner)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "transferTo is not a participant"))
+			return
+		}
+		if err := r.dbFor(req).TransferOwnership(roomID, client.UserID(), newOwner); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		r.broadcastRoleChanged(roomID, newOwner, oldRole, db.RoleOwner, client.UserID())
+		r.audit(roomID, client.UserID(), db.AuditOwnershipTransfer, newOwner, map[string]interface{}{
+			"reason": "owner left",
+		})
+	}
+
+	if err := r.dbFor(req).RemoveParticipant(roomID, client.UserID()); err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
@@ -206,13 +371,45 @@ func (r *Router) handleRoomsLeave(client *ws.Client, req ws.RPCRequest) {
 	r.Hub.UnsubscribeRoom(roomID, client)
 
 	// Broadcast leave event
-	user, _ := r.DB.GetUser(client.UserID())
+	user, _ := r.dbFor(req).GetUser(client.UserID())
 	if user != nil {
 		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.leave", map[string]interface{}{
 			"roomId":      roomID,
 			"displayName": user.DisplayName,
 			"userId":      user.ID,
 		}), nil)
+		r.PostSystemMessage(roomID, user.DisplayName+" left")
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+func (r *Router) handleRoomsDelete(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if roomID == db.LobbyRoomID {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "The lobby cannot be deleted"))
+		return
+	}
+
+	role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+	if err != nil || role != db.RoleOwner {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only the room owner can delete the room"))
+		return
+	}
+
+	if err := r.removeRoom(roomID, client.UserID()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Room not found"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
 	}
 
 	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
@@ -220,6 +417,24 @@ func (r *Router) handleRoomsLeave(client *ws.Client, req ws.RPCRequest) {
 	}))
 }
 
+// removeRoom deletes a room and tells its subscribers.
+func (r *Router) removeRoom(roomID, deletedBy string) error {
+	if err := r.DB.DeleteRoom(roomID); err != nil {
+		return err
+	}
+	r.roomRemoved(roomID, deletedBy)
+	return nil
+}
+
+// roomRemoved tells a deleted room's subscribers it's gone.
+func (r *Router) roomRemoved(roomID, deletedBy string) {
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.deleted", map[string]interface{}{
+		"roomId":    roomID,
+		"deletedBy": deletedBy,
+	}), nil)
+	r.Hub.UnsubscribeAll(roomID)
+}
+
 func (r *Router) handleRoomsInfo(client *ws.Client, req ws.RPCRequest) {
 	roomID := jsonString(req.Params["roomId"])
 	if roomID == "" {
@@ -229,20 +444,21 @@ func (r *Router) handleRoomsInfo(client *ws.Client, req ws.RPCRequest) {
 
 	// Verify access
 	if client.IsGuest() {
-		isPublic, _ := r.DB.IsRoomPublic(roomID)
+		isPublic, _ := r.dbFor(req).IsRoomPublic(roomID)
 		if !isPublic && !r.Hub.IsClientSubscribed(roomID, client) {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Guests can only access rooms they have joined"))
 			return
 		}
 	} else {
-		ok, _ := r.DB.IsParticipant(roomID, client.UserID())
+		ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID())
 		if !ok {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
 			return
 		}
+		r.dbFor(req).TouchParticipant(roomID, client.UserID())
 	}
 
-	room, err := r.DB.GetRoom(roomID)
+	room, err := r.dbFor(req).GetRoom(roomID)
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
@@ -262,37 +478,101 @@ func (r *Router) handleRoomsAddAgent(client *ws.Client, req ws.RPCRequest) {
 	agentID := jsonString(req.Params["agentId"])
 	agentName := jsonString(req.Params["agentName"])
 	agentEmoji := jsonString(req.Params["agentEmoji"])
+	persona := strings.TrimSpace(jsonString(req.Params["persona"]))
+	// Agents may use an LLM provider's API directly instead of OpenClaw
+	backendKind := jsonString(req.Params["backend"])
+	model := jsonString(req.Params["model"])
+	if !backend.ValidKind(backendKind) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "backend must be openclaw, openai, or anthropic"))
+		return
+	}
+	if !backend.IsOpenClaw(backendKind) {
+		if apiKey := jsonString(req.Params["apiKey"]); apiKey != "" {
+			openclawToken = apiKey
+		}
+		if openclawURL == "" {
+			openclawURL = backend.DefaultBaseURL(backendKind)
+		}
+		if openclawToken == "" {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "apiKey is required for "+backendKind+" agents"))
+			return
+		}
+	}
 
 	if roomID == "" || openclawURL == "" || agentID == "" {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId, openclawUrl, and agentId are required"))
 		return
 	}
+	if utf8.RuneCountInString(persona) > maxPersonaLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "persona must be at most 4000 characters"))
+		return
+	}
+	trigger, keywords, hasTrigger, errMsg := agentTriggerParams(req)
+	if errMsg != "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", errMsg))
+		return
+	}
 
 	// Verify participant with admin+ role
-	role, err := r.DB.GetParticipantRole(roomID, client.UserID())
+	role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
 		return
 	}
-	if role != "owner" && role != "admin" {
+	if !db.RoleAtLeast(role, db.RoleAdmin) {
 		// In public rooms, members can also add agents
-		isPublic, _ := r.DB.IsRoomPublic(roomID)
-		if !isPublic {
+		isPublic, _ := r.dbFor(req).IsRoomPublic(roomID)
+		if !isPublic || !db.RoleAtLeast(role, db.RoleMember) {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can add agents"))
 			return
 		}
 	}
 
+	if roomType, _ := r.dbFor(req).GetRoomType(roomID); db.IsDirectRoomType(roomType) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Direct messages cannot have more agents"))
+		return
+	}
+	if encrypted, _ := r.dbFor(req).IsRoomEncrypted(roomID); encrypted {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Agents can't read end-to-end encrypted rooms"))
+		return
+	}
+
 	if agentName == "" {
 		agentName = agentID
 	}
 
-	if err := r.DB.AddAgentParticipant(roomID, agentID, openclawURL, openclawToken, "", agentName, agentEmoji); err != nil {
+	// Catch a bad URL or token now rather than on the agent's first mention
+	if backend.IsOpenClaw(backendKind) {
+		if rpcErr := r.validateAgent(openclawURL, openclawToken, agentID); rpcErr != nil {
+			client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, rpcErr.Code, rpcErr.Message, rpcErr.Details))
+			return
+		}
+	}
+
+	if err := r.dbFor(req).AddAgentParticipant(roomID, agentID, openclawURL, openclawToken, "", agentName, agentEmoji); err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
+	if persona != "" {
+		if err := r.dbFor(req).UpdateAgentParticipant(roomID, agentID, openclawURL, nil, nil, &persona); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+	}
+	if backendKind != "" {
+		if err := r.dbFor(req).SetAgentBackend(roomID, agentID, openclawURL, backendKind, model); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+	}
+	if hasTrigger {
+		if err := r.dbFor(req).SetAgentTrigger(roomID, agentID, openclawURL, trigger, keywords); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+	}
 
-	participant, _ := r.DB.GetAgentParticipant(roomID, agentID, openclawURL)
+	participant, _ := r.dbFor(req).GetAgentParticipant(roomID, agentID, openclawURL)
 
 	// Broadcast join
 	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.join", map[string]interface{}{
@@ -301,6 +581,12 @@ func (r *Router) handleRoomsAddAgent(client *ws.Client, req ws.RPCRequest) {
 		"emoji":       agentEmoji,
 		"isAgent":     true,
 	}), nil)
+	r.PostSystemMessage(roomID, r.displayNameFor(client)+" added "+agentName)
+	r.audit(roomID, client.UserID(), db.AuditAgentAdded, agentID, map[string]interface{}{
+		"agentName":   agentName,
+		"openclawUrl": openclawURL,
+		"backend":     backendKind,
+	})
 
 	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
 		"participant": participant,
@@ -317,16 +603,116 @@ func (r *Router) handleRoomsRemoveAgent(client *ws.Client, req ws.RPCRequest) {
 		return
 	}
 
-	if err := r.DB.RemoveAgentParticipant(roomID, agentID, openclawURL); err != nil {
+	agent, _ := r.dbFor(req).GetAgentParticipant(roomID, agentID, openclawURL)
+
+	if err := r.dbFor(req).RemoveAgentParticipant(roomID, agentID, openclawURL); err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
 
+	if agent != nil {
+		r.PostSystemMessage(roomID, r.displayNameFor(client)+" removed "+agent.DisplayName)
+		r.audit(roomID, client.UserID(), db.AuditAgentRemoved, agentID, map[string]interface{}{
+			"agentName":   agent.DisplayName,
+			"openclawUrl": openclawURL,
+		})
+	}
+
 	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
 		"ok": true,
 	}))
 }
 
+// maxPersonaLen caps an agent's persona prompt, in characters.
+const maxPersonaLen = 4000
+
+// handleRoomsUpdateAgent changes an agent's name, emoji, or persona.
+func (r *Router) handleRoomsUpdateAgent(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	agentID := jsonString(req.Params["agentId"])
+	openclawURL := jsonString(req.Params["openclawUrl"])
+	if roomID == "" || agentID == "" || openclawURL == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId, agentId, and openclawUrl are required"))
+		return
+	}
+
+	name := jsonOptionalString(req.Params, "agentName")
+	emoji := jsonOptionalString(req.Params, "agentEmoji")
+	persona := jsonOptionalString(req.Params, "persona")
+	if name != nil {
+		*name = strings.TrimSpace(*name)
+		if *name == "" {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "agentName cannot be empty"))
+			return
+		}
+	}
+	if persona != nil {
+		*persona = strings.TrimSpace(*persona)
+		if utf8.RuneCountInString(*persona) > maxPersonaLen {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "persona must be at most 4000 characters"))
+			return
+		}
+	}
+	trigger, keywords, hasTrigger, errMsg := agentTriggerParams(req)
+	if errMsg != "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", errMsg))
+		return
+	}
+	if name == nil && emoji == nil && persona == nil && !hasTrigger {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Nothing to update"))
+		return
+	}
+
+	role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+	// Direct rooms have no admins, so the human may manage their agent
+	if roomType, _ := r.dbFor(req).GetRoomType(roomID); !db.IsDirectRoomType(roomType) && !db.RoleAtLeast(role, db.RoleAdmin) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can update agents"))
+		return
+	}
+
+	err = r.dbFor(req).UpdateAgentParticipant(roomID, agentID, openclawURL, name, emoji, persona)
+	if err == nil && hasTrigger {
+		err = r.dbFor(req).SetAgentTrigger(roomID, agentID, openclawURL, trigger, keywords)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Agent not in this room"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	participant, _ := r.dbFor(req).GetAgentParticipant(roomID, agentID, openclawURL)
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.agent.updated", map[string]interface{}{
+		"roomId":      roomID,
+		"participant": participant,
+	}), nil)
+
+	details := map[string]interface{}{"openclawUrl": openclawURL}
+	if name != nil {
+		details["agentName"] = *name
+	}
+	if emoji != nil {
+		details["agentEmoji"] = *emoji
+	}
+	if persona != nil {
+		details["personaChanged"] = true
+	}
+	if hasTrigger {
+		details["trigger"] = trigger
+	}
+	r.audit(roomID, client.UserID(), db.AuditAgentUpdated, agentID, details)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"participant": participant,
+	}))
+}
+
 func (r *Router) handleRoomsCreateInvite(client *ws.Client, req ws.RPCRequest) {
 	roomID := jsonString(req.Params["roomId"])
 	if roomID == "" {
@@ -341,13 +727,38 @@ func (r *Router) handleRoomsCreateInvite(client *ws.Client, req ws.RPCRequest) {
 			return
 		}
 	} else {
-		ok, _ := r.DB.IsParticipant(roomID, client.UserID())
+		ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID())
 		if !ok {
 			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
 			return
 		}
 	}
 
+	// Invites grant member access, or guest for read-only links
+	inviteRole := db.RoleMember
+	if raw, ok := req.Params["role"]; ok {
+		inviteRole = jsonString(raw)
+	}
+	if inviteRole != db.RoleMember && inviteRole != db.RoleGuest {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "role must be member or guest"))
+		return
+	}
+	// Read-only participants can't hand out more access than they have
+	if !client.IsGuest() && inviteRole == db.RoleMember {
+		if role, _ := r.dbFor(req).GetParticipantRole(roomID, client.UserID()); role == db.RoleGuest {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Read-only participants can only create read-only invites"))
+			return
+		}
+	}
+	if client.IsGuest() && client.IsReadOnly(roomID) {
+		inviteRole = db.RoleGuest
+	}
+
+	if roomType, _ := r.dbFor(req).GetRoomType(roomID); db.IsDirectRoomType(roomType) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Direct messages cannot have invites"))
+		return
+	}
+
 	maxUses := jsonInt(req.Params["maxUses"])
 	var expiresIn *time.Duration
 	if seconds := jsonInt(req.Params["expiresIn"]); seconds > 0 {
@@ -362,15 +773,22 @@ func (r *Router) handleRoomsCreateInvite(client *ws.Client, req ws.RPCRequest) {
 	if client.IsGuest() {
 		createdBy = "system"
 	}
-	invite, err := r.DB.CreateInvite(roomID, createdBy, expiresIn, maxUses)
+	invite, err := r.dbFor(req).CreateInvite(roomID, createdBy, expiresIn, maxUses, inviteRole)
 	if err != nil {
 		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
 		return
 	}
 
+	r.audit(roomID, client.UserID(), db.AuditInviteCreated, invite.Code, map[string]interface{}{
+		"role":      invite.Role,
+		"maxUses":   invite.MaxUses,
+		"expiresAt": invite.ExpiresAt,
+	})
+
 	resp := map[string]interface{}{
 		"code":      invite.Code,
 		"expiresAt": invite.ExpiresAt,
+		"role":      invite.Role,
 	}
 	if r.ExternalURL != "" {
 		resp["universalCode"] = joincode.Encode(r.ExternalURL, invite.Code)
@@ -378,6 +796,179 @@ func (r *Router) handleRoomsCreateInvite(client *ws.Client, req ws.RPCRequest) {
 	client.SendJSON(ws.NewResponse(req.ID, resp))
 }
 
+const (
+	maxRoomNameLen        = 100
+	maxRoomDescriptionLen = 1000
+)
+
+// handleRoomsUpdate changes a room's name, emoji or description; the
+// caller is an owner or admin, see roomRoles.
+func (r *Router) handleRoomsUpdate(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	name := jsonOptionalString(req.Params, "name")
+	emoji := jsonOptionalString(req.Params, "emoji")
+	description := jsonOptionalString(req.Params, "description")
+
+	if name == nil && emoji == nil && description == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "name, emoji, or description is required"))
+		return
+	}
+	if name != nil {
+		trimmed := strings.TrimSpace(*name)
+		name = &trimmed
+		if trimmed == "" || utf8.RuneCountInString(trimmed) > maxRoomNameLen {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "name must be 1-100 characters"))
+			return
+		}
+	}
+	if description != nil && utf8.RuneCountInString(*description) > maxRoomDescriptionLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "description must be at most 1000 characters"))
+		return
+	}
+
+	before, err := r.dbFor(req).GetRoom(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Room not found"))
+		return
+	}
+	if err := r.dbFor(req).UpdateRoom(roomID, name, emoji, description); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	room, err := r.dbFor(req).GetRoom(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.updated", map[string]interface{}{
+		"roomId":      roomID,
+		"name":        room.Name,
+		"emoji":       room.Emoji,
+		"description": room.Description,
+		"updatedAt":   room.UpdatedAt,
+		"updatedBy":   client.UserID(),
+	}), nil)
+	if name != nil && *name != before.Name {
+		r.PostSystemMessage(roomID, r.displayNameFor(client)+" renamed the room to "+room.Name)
+	}
+	changes := map[string]interface{}{}
+	if name != nil {
+		changes["name"] = *name
+	}
+	if emoji != nil {
+		changes["emoji"] = *emoji
+	}
+	if description != nil {
+		changes["description"] = *description
+	}
+	r.audit(roomID, client.UserID(), db.AuditRoomUpdated, "", changes)
+
+	r.mergeOnlineGuests(room)
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"room": room,
+	}))
+}
+
+// handleRoomsUpdateSettings changes owner-only room settings, see roomRoles.
+func (r *Router) handleRoomsUpdateSettings(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	settings := map[string]interface{}{"roomId": roomID}
+
+	if raw, ok := req.Params["slowModeSeconds"]; ok {
+		seconds := jsonInt(raw)
+		if seconds < 0 || seconds > maxSlowModeSeconds {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "slowModeSeconds must be between 0 and 21600"))
+			return
+		}
+		if err := r.dbFor(req).SetRoomSlowMode(roomID, seconds); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		settings["slowModeSeconds"] = seconds
+	}
+
+	if raw, ok := req.Params["messageRetentionDays"]; ok {
+		days := jsonInt(raw)
+		if days < 0 || days > db.MaxMessageRetentionDays {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "messageRetentionDays must be between 0 and 3650"))
+			return
+		}
+		if err := r.dbFor(req).SetRoomMessageRetention(roomID, days); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		settings["messageRetentionDays"] = days
+	}
+
+	if raw, ok := req.Params["requireApproval"]; ok {
+		require := jsonBool(raw)
+		if err := r.dbFor(req).SetRoomRequireApproval(roomID, require); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		settings["requireApproval"] = require
+	}
+
+	// allowAgentChaining lets agents @mention each other; agentChains is its older name
+	raw, ok := req.Params["allowAgentChaining"]
+	if !ok {
+		raw, ok = req.Params["agentChains"]
+	}
+	if ok {
+		enabled := jsonBool(raw)
+		if err := r.dbFor(req).SetRoomAgentChains(roomID, enabled); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		settings["allowAgentChaining"] = enabled
+		settings["agentChains"] = enabled
+	}
+
+	if raw, ok := req.Params["contentFilter"]; ok {
+		mode := jsonString(raw)
+		if !db.ValidContentFilter(mode) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "contentFilter must be \"\", reject, or redact"))
+			return
+		}
+		if mode != db.ContentFilterOff && r.MessageFilter == nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FILTER_UNAVAILABLE", "Content filtering is not configured on this server"))
+			return
+		}
+		if encrypted, _ := r.dbFor(req).IsRoomEncrypted(roomID); encrypted && mode != db.ContentFilterOff {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "End-to-end encrypted rooms can't be filtered by the server"))
+			return
+		}
+		if err := r.dbFor(req).SetRoomContentFilter(roomID, mode); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		settings["contentFilter"] = mode
+	}
+
+	if raw, ok := req.Params["visibility"]; ok {
+		visibility := jsonString(raw)
+		if !db.ValidVisibility(visibility) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "visibility must be private, unlisted, or public"))
+			return
+		}
+		if roomType, _ := r.dbFor(req).GetRoomType(roomID); db.IsDirectRoomType(roomType) && visibility != db.VisibilityPrivate {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Direct messages are always private"))
+			return
+		}
+		if err := r.dbFor(req).SetRoomVisibility(roomID, visibility); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		settings["visibility"] = visibility
+	}
+
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.settings.updated", settings), nil)
+	r.audit(roomID, client.UserID(), db.AuditSettingsUpdated, "", settings)
+
+	client.SendJSON(ws.NewResponse(req.ID, settings))
+}
+
 // helpers
 
 func jsonString(raw json.RawMessage) string {
@@ -388,6 +979,17 @@ func jsonString(raw json.RawMessage) string {
 	return s
 }
 
+// jsonOptionalString returns nil when key is absent, so callers can tell
+// "not provided" apart from an explicit empty string.
+func jsonOptionalString(params map[string]json.RawMessage, key string) *string {
+	raw, ok := params[key]
+	if !ok {
+		return nil
+	}
+	s := jsonString(raw)
+	return &s
+}
+
 func jsonInt(raw json.RawMessage) int {
 	var i int
 	if raw != nil {
@@ -404,21 +1006,59 @@ func jsonBool(raw json.RawMessage) bool {
 	return b
 }
 
+// checkRoomAccess verifies the client may read the room: participants, or guests
+// in public rooms / rooms they joined. Sends a FORBIDDEN error and returns false otherwise.
+func (r *Router) checkRoomAccess(client *ws.Client, req ws.RPCRequest, roomID string) bool {
+	if client.IsGuest() {
+		isPublic, _ := r.dbFor(req).IsRoomPublic(roomID)
+		if !isPublic && !r.Hub.IsClientSubscribed(roomID, client) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Guests can only access rooms they have joined"))
+			return false
+		}
+		return true
+	}
+	ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID())
+	if !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return false
+	}
+	return true
+}
+
+// displayNameFor returns the client's stored display name, falling back to the
+// name it connected with.
+func (r *Router) displayNameFor(client *ws.Client) string {
+	if !client.IsGuest() {
+		if user, _ := r.DB.GetUser(client.UserID()); user != nil && user.DisplayName != "" {
+			return user.DisplayName
+		}
+	}
+	return client.DisplayName()
+}
+
 // mergeOnlineGuests adds connected guests (not already in the DB participant list) to the room.
 func (r *Router) mergeOnlineGuests(room *db.Room) {
 	online := r.Hub.GetRoomOnlineClients(room.ID)
 	existing := make(map[string]bool)
 	for i, p := range room.Participants {
 		existing[p.ID] = true
+		if p.IsAgent {
+			room.Participants[i].IsOnline = r.agentOnline(p)
+			continue
+		}
 		// While we're here, mark DB participants online if they have a connection
-		if !p.IsAgent {
-			for _, o := range online {
-				if o.UserID == p.ID {
-					room.Participants[i].IsOnline = true
-					break
-				}
+		for _, o := range online {
+			if o.UserID == p.ID {
+				room.Participants[i].IsOnline = true
+				break
 			}
 		}
+		if status, ok := r.Hub.Status(p.ID); ok {
+			room.Participants[i].IsOnline = true
+			room.Participants[i].Status = status.Status
+			room.Participants[i].StatusText = status.Text
+			room.Participants[i].StatusEmoji = status.Emoji
+		}
 	}
 	for _, o := range online {
 		if !existing[o.UserID] && o.IsGuest {
@@ -432,3 +1072,21 @@ func (r *Router) mergeOnlineGuests(room *db.Room) {
 	}
 	room.ParticipantCount = len(room.Participants)
 }
+
+// agentOnline reports whether an agent's OpenClaw server is connected. An
+// agent the pool isn't tracking yet starts being tracked, so its presence is
+// known (and broadcast as room.presence) from then on.
+func (r *Router) agentOnline(p db.Participant) bool {
+	if !backend.IsOpenClaw(p.Backend) {
+		// API backends have no connection to watch
+		return p.OpenclawURL != ""
+	}
+	if p.OpenclawURL == "" {
+		return false
+	}
+	health, ok := r.OpenClawPool.Status(p.OpenclawURL, p.OpenclawToken)
+	if !ok {
+		r.OpenClawPool.Track(p.OpenclawURL, p.OpenclawToken)
+	}
+	return health.Connected
+}
@@ -1,66 +1,112 @@
 package rpc
 
 import (
-	"log/slog"
+	"sync"
 
+	"github.com/nicebartender/claudio-server/cron"
 	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/media"
 	"github.com/nicebartender/claudio-server/openclaw"
 	"github.com/nicebartender/claudio-server/ws"
 )
 
 type Router struct {
-	Hub          *ws.Hub
-	DB           *db.DB
-	ExternalURL  string
-	OpenClawPool *openclaw.Pool
+	Hub           *ws.Hub
+	DB            db.Store
+	ExternalURL   string
+	OpenClawPool  *openclaw.Pool
+	AgentChains   *openclaw.ChainGuard // anti-loop protection for agent @mentions
+	AgentThrottle *openclaw.Throttle   // per-agent per-room rate limit and circuit breaker
+	Translator    Translator           // optional; nil disables rooms.translateMessage
+	MessageFilter MessageFilter        // optional; nil disables per-room content filtering
+	Media         *media.Store
+
+	// Largest attachment accepted, and so the largest in an imported
+	// archive (0 = maxArchiveBytes)
+	MaxUploadBytes int64
+
+	// Message length limit in characters (0 = unlimited). Over-long messages are
+	// rejected with MESSAGE_TOO_LONG, or turned into a text attachment when
+	// LongMessageAsAttachment is set.
+	MaxMessageLength        int
+	LongMessageAsAttachment bool
+
+	// Responses each agent works on at once (0 = unlimited); further calls
+	// wait in a queue of at most AgentQueueLimit, merged per room unless
+	// AgentQueueStrategy is QueueDrop.
+	AgentConcurrency   int
+	AgentQueueLimit    int
+	AgentQueueStrategy string
+
+	// How agents mentioned together are dispatched: MentionGrouped (all at
+	// once) or MentionOrdered (one after another, in mention order).
+	AgentMentionOrder string
+
+	// Messages each user may send across all rooms, on top of the hub's
+	// request limits and per-room slow mode.
+	MessageLimiter *ws.RateLimiter
+
+	// Device or user IDs with server admin rights from config, on top of
+	// grants stored in the database. See isServerAdmin.
+	ServerAdmins map[string]bool
+
+	// Optional URL that receives a POST for every new abuse report
+	ReportWebhookURL string
+
+	// Directory admin.backup writes snapshots to; empty disables it
+	BackupDir string
+	backupMu  sync.Mutex // held while a backup runs
+
+	maintenance         maintenanceStats // see StartMaintenance
+	maintenanceSchedule *cron.Schedule
+
+	methods    map[string]handlerFunc // see registerMethods and wrapMethods
+	timings    map[string]*methodTiming
+	timingsMu  sync.Mutex
+	slowMode   *slowModeTracker
+	uploads    *uploadTokens
+	sends      *idempotencyCache
+	agentCalls *agentCalls
+	agentQueue *agentQueue
 }
 
-func NewRouter(hub *ws.Hub, database *db.DB, keyDir string) *Router {
-	r := &Router{Hub: hub, DB: database, OpenClawPool: openclaw.NewPool(keyDir)}
+func NewRouter(hub *ws.Hub, database db.Store, keyDir string) *Router {
+	r := &Router{
+		Hub:           hub,
+		DB:            database,
+		OpenClawPool:  openclaw.NewPool(keyDir),
+		AgentChains:   openclaw.NewChainGuard(openclaw.DefaultMaxChainDepth),
+		AgentThrottle: openclaw.NewThrottle(openclaw.DefaultMinInterval, openclaw.DefaultBreakerLimit, openclaw.DefaultBreakerWindow),
+		slowMode:      newSlowModeTracker(),
+		uploads:       newUploadTokens(),
+		sends:         newIdempotencyCache(),
+		agentCalls:    newAgentCalls(),
+		agentQueue:    newAgentQueue(),
+
+		AgentConcurrency:   DefaultAgentConcurrency,
+		AgentQueueLimit:    DefaultAgentQueueLimit,
+		AgentQueueStrategy: QueueMerge,
+		AgentMentionOrder:  MentionGrouped,
+		MessageLimiter:     ws.NewRateLimiter(ws.DefaultUserMessageLimit),
+	}
+	r.methods = r.wrapMethods(r.registerMethods())
+	r.OpenClawPool.OnChange = r.agentHealthChanged
 	hub.RPCRouter = r.Handle
 	return r
 }
 
+// Handle dispatches a request to its method's handler, which the
+// middleware in middleware.go wraps.
 func (r *Router) Handle(client *ws.Client, req ws.RPCRequest) {
-	slog.Info("RPC", "method", req.Method, "userID", client.UserID())
-
-	// Guest permission gate
-	if client.IsGuest() {
-		switch req.Method {
-		case "rooms.listPublic", "rooms.join", "rooms.send", "rooms.history", "rooms.info", "rooms.createInvite", "rooms.create":
-			// allowed — fall through
-		default:
-			client.SendJSON(ws.NewErrorResponse(req.ID, "GUEST_FORBIDDEN", "Guests cannot use "+req.Method))
-			return
-		}
+	handler, ok := r.methods[req.Method]
+	if !ok {
+		handler = chain(req.Method, unknownMethod, r.gates()...)
 	}
+	handler(client, req)
+}
 
-	switch req.Method {
-	case "rooms.list":
-		r.handleRoomsList(client, req)
-	case "rooms.listPublic":
-		r.handleRoomsListPublic(client, req)
-	case "rooms.create":
-		r.handleRoomsCreate(client, req)
-	case "rooms.join":
-		r.handleRoomsJoin(client, req)
-	case "rooms.leave":
-		r.handleRoomsLeave(client, req)
-	case "rooms.info":
-		r.handleRoomsInfo(client, req)
-	case "rooms.history":
-		r.handleRoomsHistory(client, req)
-	case "rooms.send":
-		r.handleRoomsSend(client, req)
-	case "rooms.addAgent":
-		r.handleRoomsAddAgent(client, req)
-	case "rooms.removeAgent":
-		r.handleRoomsRemoveAgent(client, req)
-	case "rooms.createInvite":
-		r.handleRoomsCreateInvite(client, req)
-	case "user.update":
-		r.handleUserUpdate(client, req)
-	default:
-		client.SendJSON(ws.NewErrorResponse(req.ID, "UNKNOWN_METHOD", "Unknown method: "+req.Method))
-	}
+// dbFor is r.DB with queries tied to req's connection, so they stop when
+// the client that would get the answer is gone.
+func (r *Router) dbFor(req ws.RPCRequest) db.Store {
+	return r.DB.WithContext(req.Context())
 }
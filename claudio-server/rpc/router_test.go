@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/media"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// newTestRouter returns a router over a fresh database and media store.
+func newTestRouter(t *testing.T) *Router {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	store, err := media.NewStore(filepath.Join(dir, "media"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter(ws.NewHub(database), database, filepath.Join(dir, "keys"))
+	r.Media = store
+	return r
+}
+
+// newTestRoom signs up alice and creates a room she owns.
+func newTestRoom(t *testing.T, r *Router) *db.Room {
+	t.Helper()
+	if _, err := r.DB.UpsertUser("alice", "key", "Alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	room, err := r.DB.CreateRoomWithVisibility("Test", "", "alice", db.VisibilityPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return room
+}
+
+// call sends method with params as client and returns the response.
+func call(t *testing.T, r *Router, client *ws.Client, out <-chan []byte, method string, params map[string]interface{}) ws.RPCResponse {
+	t.Helper()
+	req := ws.RPCRequest{ID: "1", Method: method, Params: make(map[string]json.RawMessage)}
+	for k, v := range params {
+		req.Params[k], _ = json.Marshal(v)
+	}
+	r.Handle(client, req)
+	timeout := time.After(10 * time.Second) // some methods answer from a goroutine
+	for {
+		select {
+		case data := <-out:
+			var resp ws.RPCResponse
+			if json.Unmarshal(data, &resp) == nil && resp.Type == "res" && resp.ID == req.ID {
+				return resp
+			}
+		case <-timeout:
+			t.Fatalf("%s: no response", method)
+		}
+	}
+}
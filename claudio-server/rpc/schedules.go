@@ -0,0 +1,226 @@
+package rpc
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nicebartender/claudio-server/cron"
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	maxSchedulesPerRoom     = 20
+	maxSchedulePromptLen    = 4000
+	scheduledSenderName     = "Scheduled task"
+	defaultScheduleTimezone = "UTC"
+)
+
+// nextScheduleRun parses spec and returns its next run after now in timezone.
+func nextScheduleRun(spec, timezone string, now time.Time) (time.Time, error) {
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, errors.New("unknown timezone")
+	}
+	next := schedule.Next(now.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("schedule never runs")
+	}
+	return next, nil
+}
+
+// roomAgent returns the OpenClaw agent agentID in roomID, or nil.
+func (r *Router) roomAgent(roomID, agentID string) (*db.Participant, error) {
+	participants, err := r.DB.GetParticipants(roomID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range participants {
+		if participants[i].IsAgent && participants[i].AgentID == agentID {
+			return &participants[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// canManageAgents reports whether the client may configure agents in roomID.
+// Direct rooms have no admins, so the human may manage their agent.
+func (r *Router) canManageAgents(client *ws.Client, roomID string) bool {
+	role, err := r.DB.GetParticipantRole(roomID, client.UserID())
+	if err != nil {
+		return false
+	}
+	roomType, _ := r.DB.GetRoomType(roomID)
+	return db.IsDirectRoomType(roomType) || db.RoleAtLeast(role, db.RoleAdmin)
+}
+
+func (r *Router) handleAgentsCreateSchedule(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	agentID := jsonString(req.Params["agentId"])
+	spec := strings.TrimSpace(jsonString(req.Params["cron"]))
+	prompt := strings.TrimSpace(jsonString(req.Params["prompt"]))
+	timezone := jsonString(req.Params["timezone"])
+	if timezone == "" {
+		timezone = defaultScheduleTimezone
+	}
+	if roomID == "" || agentID == "" || spec == "" || prompt == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId, agentId, cron and prompt are required"))
+		return
+	}
+	if utf8.RuneCountInString(prompt) > maxSchedulePromptLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "prompt is too long"))
+		return
+	}
+	next, err := nextScheduleRun(spec, timezone, time.Now())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", err.Error()))
+		return
+	}
+
+	if !r.canManageAgents(client, roomID) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can schedule agent tasks"))
+		return
+	}
+	agent, err := r.roomAgent(roomID, agentID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if agent == nil || agent.OpenclawURL == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Agent not in this room"))
+		return
+	}
+
+	existing, err := r.dbFor(req).ListSchedules(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if len(existing) >= maxSchedulesPerRoom {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "CONFLICT", "This room already has the maximum number of schedules"))
+		return
+	}
+
+	schedule, err := r.dbFor(req).CreateSchedule(roomID, agentID, spec, timezone, prompt, client.UserID(), next)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	r.audit(roomID, client.UserID(), db.AuditScheduleCreated, agentID, map[string]interface{}{
+		"scheduleId": schedule.ID,
+		"cron":       spec,
+		"timezone":   timezone,
+	})
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"schedule": schedule,
+	}))
+}
+
+func (r *Router) handleAgentsListSchedules(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+
+	schedules, err := r.dbFor(req).ListSchedules(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId":    roomID,
+		"schedules": schedules,
+	}))
+}
+
+func (r *Router) handleAgentsDeleteSchedule(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	scheduleID := jsonString(req.Params["scheduleId"])
+	if roomID == "" || scheduleID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and scheduleId are required"))
+		return
+	}
+	if !r.canManageAgents(client, roomID) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can delete agent schedules"))
+		return
+	}
+
+	if err := r.dbFor(req).DeleteSchedule(scheduleID, roomID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Schedule not found"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	r.audit(roomID, client.UserID(), db.AuditScheduleDeleted, "", map[string]interface{}{
+		"scheduleId": scheduleID,
+	})
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+// StartAgentScheduler checks for due schedules every interval and sends
+// their prompts to the agents.
+func (r *Router) StartAgentScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			r.runDueSchedules(now)
+		}
+	}()
+}
+
+func (r *Router) runDueSchedules(now time.Time) {
+	due, err := r.DB.DueSchedules(now)
+	if err != nil {
+		slog.Error("scheduler: list due schedules failed", "err", err)
+		return
+	}
+	for _, s := range due {
+		// Advance first so a slow or failing agent can't make a schedule fire twice
+		next, err := nextScheduleRun(s.Cron, s.Timezone, now)
+		if err != nil {
+			slog.Warn("scheduler: dropping unusable schedule", "id", s.ID, "err", err)
+			r.DB.DeleteSchedule(s.ID, s.RoomID)
+			continue
+		}
+		if err := r.DB.MarkScheduleRun(s.ID, now, next); err != nil {
+			slog.Error("scheduler: mark run failed", "id", s.ID, "err", err)
+			continue
+		}
+
+		agent, err := r.roomAgent(s.RoomID, s.AgentID)
+		if err != nil || agent == nil || agent.OpenclawURL == "" {
+			slog.Warn("scheduler: agent no longer in room", "id", s.ID, "room", s.RoomID, "agent", s.AgentID)
+			continue
+		}
+		if !r.AllowAgentDispatch(s.RoomID, agent.AgentID, agent.DisplayName) {
+			continue
+		}
+
+		slog.Info("running scheduled task", "id", s.ID, "agent", agent.DisplayName, "roomId", s.RoomID)
+		r.Hub.BroadcastToRoom(s.RoomID, ws.NewEvent("room.typing", map[string]interface{}{
+			"roomId":      s.RoomID,
+			"displayName": agent.DisplayName,
+		}), nil)
+		r.dispatchAgent(s.RoomID, &db.Message{SenderDisplayName: scheduledSenderName, Content: s.Prompt}, *agent, 1)
+	}
+}
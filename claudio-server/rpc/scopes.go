@@ -0,0 +1,47 @@
+package rpc
+
+import (
+	"strings"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// readMethods only read state, so a connection with just the read scope
+// (a dashboard, a bot that watches rooms) may call them.
+var readMethods = map[string]bool{
+	"rooms.list":             true,
+	"rooms.listPublic":       true,
+	"rooms.directory":        true,
+	"rooms.info":             true,
+	"rooms.history":          true,
+	"rooms.activity":         true,
+	"rooms.auditLog":         true,
+	"rooms.listJoinRequests": true,
+	"rooms.topicHistory":     true,
+	"rooms.subscribe":        true, // only changes what this connection receives
+	"rooms.unsubscribe":      true,
+	"agents.discover":        true,
+	"agents.status":          true,
+	"agents.usage":           true,
+	"agents.listSchedules":   true,
+	"agents.getMemory":       true,
+	"templates.list":         true,
+	"messages.listStarred":   true,
+	"devices.list":           true,
+	"devices.activity":       true,
+	"e2ee.fetchRoomKeys":     true,
+	"users.get":              true,
+}
+
+// methodScope returns the connect scope needed to call method: admin for
+// the admin.* namespace, read for readMethods and write for the rest.
+func methodScope(method string) string {
+	switch {
+	case strings.HasPrefix(method, "admin."):
+		return ws.ScopeAdmin
+	case readMethods[method]:
+		return ws.ScopeRead
+	default:
+		return ws.ScopeWrite
+	}
+}
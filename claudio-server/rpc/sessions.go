@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// handleRoomsResetAgentSession starts a fresh OpenClaw conversation for an
+// agent in a room, so it forgets everything said there so far.
+func (r *Router) handleRoomsResetAgentSession(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	agentID := jsonString(req.Params["agentId"])
+	if roomID == "" || agentID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and agentId are required"))
+		return
+	}
+
+	if !r.canManageAgents(client, roomID) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can reset agent sessions"))
+		return
+	}
+
+	agent, err := r.roomAgent(roomID, agentID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if agent == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Agent not in this room"))
+		return
+	}
+
+	if err := r.resetAgentSession(client, roomID, *agent); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId":  roomID,
+		"agentId": agentID,
+	}))
+}
+
+// resetAgentSession rotates agent's session key in roomID and tells the room.
+func (r *Router) resetAgentSession(client *ws.Client, roomID string, agent db.Participant) error {
+	ocAgentID := agent.OpenclawAgentID
+	if ocAgentID == "" {
+		ocAgentID = agent.AgentID
+	}
+	if _, err := r.DB.ResetAgentSession(roomID, agent.AgentID, ocAgentID); err != nil {
+		return err
+	}
+	r.PostSystemMessage(roomID, r.displayNameFor(client)+" started a new conversation with "+agent.DisplayName)
+	return nil
+}
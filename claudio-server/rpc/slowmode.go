@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSlowModeSeconds caps the per-room slow mode interval (6 hours).
+const maxSlowModeSeconds = 6 * 60 * 60
+
+// slowModeTracker remembers when each user last sent a message in each room.
+type slowModeTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time // key: roomID|userID
+}
+
+func newSlowModeTracker() *slowModeTracker {
+	return &slowModeTracker{last: make(map[string]time.Time)}
+}
+
+// allow records a send and returns 0 if the user may send now, or how long
+// they must wait otherwise.
+func (t *slowModeTracker) allow(roomID, userID string, interval time.Duration) time.Duration {
+	key := roomID + "|" + userID
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.last[key]; ok {
+		if wait := interval - now.Sub(last); wait > 0 {
+			return wait
+		}
+	}
+	t.last[key] = now
+
+	// Entries older than the largest possible interval can never block again
+	if len(t.last) > 10000 {
+		for k, ts := range t.last {
+			if now.Sub(ts) > maxSlowModeSeconds*time.Second {
+				delete(t.last, k)
+			}
+		}
+	}
+	return 0
+}
+
+// release forgets the send that allow just recorded, when it then failed.
+// Any earlier send was already past the interval, or allow would have
+// refused, so forgetting it changes nothing.
+func (t *slowModeTracker) release(roomID, userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.last, roomID+"|"+userID)
+}
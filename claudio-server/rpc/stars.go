@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	defaultStarredPageSize = 50
+	maxStarredPageSize     = 100
+)
+
+// handleMessagesStar serves messages.star and messages.unstar.
+func (r *Router) handleMessagesStar(client *ws.Client, req ws.RPCRequest, star bool) {
+	messageID := jsonString(req.Params["messageId"])
+	if messageID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "messageId is required"))
+		return
+	}
+
+	if star {
+		roomID, err := r.dbFor(req).GetMessageRoom(messageID)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Message not found"))
+			return
+		}
+		if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Message not found"))
+			return
+		}
+		if err := r.dbFor(req).StarMessage(client.UserID(), messageID); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+	} else if err := r.dbFor(req).UnstarMessage(client.UserID(), messageID); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"messageId": messageID,
+		"starred":   star,
+	}))
+}
+
+func (r *Router) handleMessagesListStarred(client *ws.Client, req ws.RPCRequest) {
+	limit := jsonInt(req.Params["limit"])
+	if limit <= 0 {
+		limit = defaultStarredPageSize
+	}
+	if limit > maxStarredPageSize {
+		limit = maxStarredPageSize
+	}
+
+	page, err := r.dbFor(req).ListStarred(client.UserID(), int64(jsonInt(req.Params["before"])), limit)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	resp := map[string]interface{}{
+		"stars":   page.Stars,
+		"hasMore": page.HasMore,
+	}
+	if n := len(page.Stars); n > 0 {
+		resp["beforeCursor"] = page.Stars[n-1].Cursor
+	}
+	client.SendJSON(ws.NewResponse(req.ID, resp))
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import "github.com/nicebartender/claudio-server/ws"
+
+type roomsSubscribeParams struct {
+	RoomIDs []string         `json:"roomIds" rpc:"required,max=100"`
+	Level   string           `json:"level" rpc:"oneof=full lite"`
+	LastSeq map[string]int64 `json:"lastSeq"`
+	SeqNode string           `json:"seqNode"`
+}
+
+// handleRoomsSubscribe subscribes this connection to rooms the user is in,
+// for clients that only follow the rooms on screen. At level lite the
+// rooms send just room.summary; at full (the default) every event, and
+// rooms listed in lastSeq get the events missed since, or room.resync, as
+// on connect.
+func (r *Router) handleRoomsSubscribe(client *ws.Client, req ws.RPCRequest, p roomsSubscribeParams) {
+	level := p.Level
+	if level == "" {
+		level = ws.SubscribeFull
+	}
+	for _, roomID := range p.RoomIDs {
+		if ok, _ := r.dbFor(req).IsParticipant(roomID, client.UserID()); !ok {
+			client.SendJSON(ws.NewErrorResponseWithDetails(req.ID, "FORBIDDEN", "Not a participant", map[string]interface{}{
+				"roomId": roomID,
+			}))
+			return
+		}
+	}
+
+	if level == ws.SubscribeLite {
+		for _, roomID := range p.RoomIDs {
+			r.Hub.SubscribeRoomLite(roomID, client)
+		}
+		client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+			"roomIds": p.RoomIDs,
+			"level":   level,
+		}))
+		return
+	}
+
+	var resume []string
+	for _, roomID := range p.RoomIDs {
+		if _, ok := p.LastSeq[roomID]; ok && !r.Hub.IsClientSubscribed(roomID, client) {
+			// Subscribed by ResumeRoom once the response is out
+			resume = append(resume, roomID)
+			continue
+		}
+		r.Hub.SubscribeRoomRecent(roomID, client)
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomIds": p.RoomIDs,
+		"level":   level,
+	}))
+
+	for _, roomID := range resume {
+		seq := p.LastSeq[roomID]
+		if p.SeqNode != "" && p.SeqNode != r.Hub.NodeID {
+			seq = -1 // numbered by another instance, so always a resync
+		}
+		r.Hub.ResumeRoom(roomID, client, seq)
+	}
+}
+
+type roomsUnsubscribeParams struct {
+	RoomIDs []string `json:"roomIds" rpc:"required,max=100"`
+}
+
+// handleRoomsUnsubscribe stops this connection's events from rooms; the
+// user stays a participant. Without autoSubscribe off, the next connect
+// subscribes them again.
+func (r *Router) handleRoomsUnsubscribe(client *ws.Client, req ws.RPCRequest, p roomsUnsubscribeParams) {
+	for _, roomID := range p.RoomIDs {
+		r.Hub.UnsubscribeRoom(roomID, client)
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomIds":    p.RoomIDs,
+		"subscribed": client.Rooms(),
+	}))
+}
@@ -0,0 +1,137 @@
+package rpc
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const maxTemplateAgents = 10
+
+// ValidateTemplate returns a description of what's wrong with t, or "" if it's usable.
+func ValidateTemplate(t db.RoomTemplate) string {
+	if strings.TrimSpace(t.Name) == "" {
+		return "name is required"
+	}
+	if t.Visibility != "" && !db.ValidVisibility(t.Visibility) {
+		return "visibility must be private, unlisted, or public"
+	}
+	if t.SlowModeSeconds < 0 || t.SlowModeSeconds > maxSlowModeSeconds {
+		return "slowModeSeconds must be between 0 and 21600"
+	}
+	if len(t.Agents) > maxTemplateAgents {
+		return "templates can include at most 10 agents"
+	}
+	for _, a := range t.Agents {
+		if a.AgentID == "" || a.OpenclawURL == "" {
+			return "agents need agentId and openclawUrl"
+		}
+	}
+	return ""
+}
+
+func (r *Router) handleTemplatesList(client *ws.Client, req ws.RPCRequest) {
+	templates, err := r.dbFor(req).ListTemplates(client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	for i := range templates {
+		templates[i] = templates[i].Redacted()
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"templates": templates,
+	}))
+}
+
+func (r *Router) handleTemplatesCreate(client *ws.Client, req ws.RPCRequest) {
+	t := db.RoomTemplate{Name: strings.TrimSpace(jsonString(req.Params["name"]))}
+	if raw, ok := req.Params["config"]; ok {
+		if err := json.Unmarshal(raw, &t.TemplateConfig); err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "config must be an object"))
+			return
+		}
+	}
+	if msg := ValidateTemplate(t); msg != "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", msg))
+		return
+	}
+
+	created, err := r.dbFor(req).CreateTemplate(client.UserID(), t.Name, t.TemplateConfig)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"template": created.Redacted(),
+	}))
+}
+
+func (r *Router) handleTemplatesDelete(client *ws.Client, req ws.RPCRequest) {
+	id := jsonString(req.Params["templateId"])
+	if id == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "templateId is required"))
+		return
+	}
+	if err := r.dbFor(req).DeleteTemplate(id, client.UserID()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Template not found"))
+			return
+		}
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"ok": true,
+	}))
+}
+
+// templateFor loads a template the client may use: server-wide or their own.
+func (r *Router) templateFor(client *ws.Client, id string) *db.RoomTemplate {
+	t, err := r.DB.GetTemplate(id)
+	if err != nil || t == nil {
+		return nil
+	}
+	if t.OwnerID != nil && *t.OwnerID != client.UserID() {
+		return nil
+	}
+	return t
+}
+
+// applyTemplate sets up a freshly created room from t. Failures are logged
+// rather than returned: the room already exists and is usable without them.
+func (r *Router) applyTemplate(roomID, createdBy string, t *db.RoomTemplate) {
+	if t.Description != "" {
+		description := t.Description
+		if err := r.DB.UpdateRoom(roomID, nil, nil, &description); err != nil {
+			slog.Error("template: set description failed", "room", roomID, "err", err)
+		}
+	}
+	if t.Topic != "" {
+		if err := r.DB.SetRoomTopic(roomID, t.Topic, createdBy); err != nil {
+			slog.Error("template: set topic failed", "room", roomID, "err", err)
+		}
+	}
+	if t.SlowModeSeconds > 0 {
+		if err := r.DB.SetRoomSlowMode(roomID, t.SlowModeSeconds); err != nil {
+			slog.Error("template: set slow mode failed", "room", roomID, "err", err)
+		}
+	}
+	for _, a := range t.Agents {
+		name := a.AgentName
+		if name == "" {
+			name = a.AgentID
+		}
+		if err := r.DB.AddAgentParticipant(roomID, a.AgentID, a.OpenclawURL, a.OpenclawToken, a.OpenclawAgentID, name, a.AgentEmoji); err != nil {
+			slog.Error("template: add agent failed", "room", roomID, "agent", a.AgentID, "err", err)
+		}
+	}
+	if t.WelcomeMessage != "" {
+		r.PostSystemMessage(roomID, t.WelcomeMessage)
+	}
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"log/slog"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/openclaw"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// AllowAgentDispatch applies the per-agent rate limit and circuit breaker
+// before an agent is asked to respond in a room. When the breaker trips, a
+// system message tells the room the agent was paused.
+func (r *Router) AllowAgentDispatch(roomID, agentID, agentName string) bool {
+	switch r.AgentThrottle.Check(roomID, agentID) {
+	case openclaw.Allowed:
+		return true
+	case openclaw.Tripped:
+		slog.Warn("agent circuit breaker tripped", "agent", agentName, "agentId", agentID, "roomId", roomID)
+		r.PostSystemMessage(roomID, agentName+" was paused after responding too often. An owner or admin can resume it.")
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.agent.paused", map[string]interface{}{
+			"roomId":  roomID,
+			"agentId": agentID,
+		}), nil)
+	default:
+		slog.Info("agent dispatch throttled", "agent", agentName, "agentId", agentID, "roomId", roomID)
+	}
+	return false
+}
+
+// handleRoomsResetAgentBreaker resumes an agent paused by its circuit breaker.
+func (r *Router) handleRoomsResetAgentBreaker(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	agentID := jsonString(req.Params["agentId"])
+	if roomID == "" || agentID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId and agentId are required"))
+		return
+	}
+
+	role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+	// Direct rooms have no admins, so the human may manage their agent
+	if roomType, _ := r.dbFor(req).GetRoomType(roomID); !db.IsDirectRoomType(roomType) && !db.RoleAtLeast(role, db.RoleAdmin) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can resume agents"))
+		return
+	}
+
+	wasPaused := r.AgentThrottle.Reset(roomID, agentID)
+	if wasPaused {
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.agent.resumed", map[string]interface{}{
+			"roomId":  roomID,
+			"agentId": agentID,
+		}), nil)
+		r.audit(roomID, client.UserID(), db.AuditAgentResumed, agentID, nil)
+		name := agentID
+		participants, _ := r.dbFor(req).GetParticipants(roomID)
+		for _, p := range participants {
+			if p.IsAgent && p.AgentID == agentID {
+				name = p.DisplayName
+				break
+			}
+		}
+		r.PostSystemMessage(roomID, r.displayNameFor(client)+" resumed "+name)
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId":    roomID,
+		"agentId":   agentID,
+		"wasPaused": wasPaused,
+	}))
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	maxTopicLen          = 250
+	topicHistoryPageSize = 50
+)
+
+func (r *Router) handleRoomsSetTopic(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	topic := strings.TrimSpace(jsonString(req.Params["topic"]))
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if utf8.RuneCountInString(topic) > maxTopicLen {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "topic must be at most 250 characters"))
+		return
+	}
+
+	role, err := r.dbFor(req).GetParticipantRole(roomID, client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Not a participant"))
+		return
+	}
+	// DMs have no admins, so either side may set the topic
+	if roomType, _ := r.dbFor(req).GetRoomType(roomID); !db.IsDirectRoomType(roomType) && !db.RoleAtLeast(role, db.RoleAdmin) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can set the topic"))
+		return
+	}
+
+	if err := r.dbFor(req).SetRoomTopic(roomID, topic, client.UserID()); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.topic.changed", map[string]interface{}{
+		"roomId": roomID,
+		"topic":  topic,
+		"setBy":  client.UserID(),
+	}), nil)
+	r.audit(roomID, client.UserID(), db.AuditTopicChanged, "", map[string]interface{}{
+		"topic": topic,
+	})
+	name := r.displayNameFor(client)
+	if topic == "" {
+		r.PostSystemMessage(roomID, name+" cleared the topic")
+	} else {
+		r.PostSystemMessage(roomID, name+" set the topic to "+topic)
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"topic": topic,
+	}))
+}
+
+func (r *Router) handleRoomsTopicHistory(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if !r.checkRoomAccess(client, req, roomID) {
+		return
+	}
+
+	changes, err := r.dbFor(req).GetTopicHistory(roomID, topicHistoryPageSize)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"changes": changes,
+	}))
+}
@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// Translator translates message content into a target language.
+// Set Router.Translator to enable rooms.translateMessage.
+type Translator interface {
+	Translate(ctx context.Context, text, targetLang string) (string, error)
+}
+
+// LibreTranslator calls a LibreTranslate-compatible HTTP API.
+type LibreTranslator struct {
+	URL    string // base URL, e.g. https://libretranslate.example.com
+	APIKey string // optional
+	HTTP   *http.Client
+}
+
+func NewLibreTranslator(url, apiKey string) *LibreTranslator {
+	return &LibreTranslator{
+		URL:    strings.TrimSuffix(url, "/"),
+		APIKey: apiKey,
+		HTTP:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (t *LibreTranslator) Translate(ctx context.Context, text, targetLang string) (string, error) {
+	body, _ := json.Marshal(map[string]string{
+		"q":       text,
+		"source":  "auto",
+		"target":  targetLang,
+		"format":  "text",
+		"api_key": t.APIKey,
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", t.URL+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("translate returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("parse translate response: %w", err)
+	}
+	return result.TranslatedText, nil
+}
+
+var languageTagRe = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+func (r *Router) handleRoomsTranslateMessage(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	messageID := jsonString(req.Params["messageId"])
+	language := strings.ToLower(jsonString(req.Params["language"]))
+
+	if roomID == "" || messageID == "" || language == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId, messageId, and language are required"))
+		return
+	}
+	if !languageTagRe.MatchString(language) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "language must be a language tag like \"en\" or \"pt-br\""))
+		return
+	}
+	if r.Translator == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "TRANSLATION_UNAVAILABLE", "Translation is not configured on this server"))
+		return
+	}
+	if !r.checkRoomAccess(client, req, roomID) {
+		return
+	}
+	if encrypted, _ := r.dbFor(req).IsRoomEncrypted(roomID); encrypted {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Messages in end-to-end encrypted rooms can't be translated by the server"))
+		return
+	}
+
+	msg, err := r.dbFor(req).GetMessage(roomID, messageID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if msg == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "Message not found"))
+		return
+	}
+
+	cached, err := r.dbFor(req).GetTranslation(messageID, language)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if cached != "" {
+		client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+			"messageId": messageID,
+			"language":  language,
+			"content":   cached,
+			"cached":    true,
+		}))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 20*time.Second)
+	defer cancel()
+	translated, err := r.Translator.Translate(ctx, msg.Content, language)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "TRANSLATION_FAILED", err.Error()))
+		return
+	}
+
+	if err := r.dbFor(req).SaveTranslation(messageID, language, translated); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"messageId": messageID,
+		"language":  language,
+		"content":   translated,
+		"cached":    false,
+	}))
+}
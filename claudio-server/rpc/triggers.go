@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	maxTriggerKeywords   = 20
+	maxTriggerKeywordLen = 200
+)
+
+// agentTriggerParams reads the optional trigger and keywords params of
+// rooms.addAgent and rooms.updateAgent. ok is false if neither was given; a
+// non-empty errMsg means they were invalid.
+func agentTriggerParams(req ws.RPCRequest) (trigger string, keywords []string, ok bool, errMsg string) {
+	rawTrigger, hasTrigger := req.Params["trigger"]
+	rawKeywords, hasKeywords := req.Params["keywords"]
+	if !hasTrigger && !hasKeywords {
+		return "", nil, false, ""
+	}
+	trigger = jsonString(rawTrigger)
+	if !db.ValidTrigger(trigger) {
+		return "", nil, true, "trigger must be mention, all, keywords, or replies"
+	}
+	if hasKeywords && string(rawKeywords) != "null" {
+		if err := json.Unmarshal(rawKeywords, &keywords); err != nil {
+			return "", nil, true, "keywords must be an array of strings"
+		}
+	}
+	if trigger != db.TriggerKeywords {
+		return trigger, nil, true, ""
+	}
+	if len(keywords) == 0 {
+		return "", nil, true, "keywords are required for the keywords trigger"
+	}
+	if len(keywords) > maxTriggerKeywords {
+		return "", nil, true, "At most 20 keywords are allowed"
+	}
+	for _, k := range keywords {
+		if k == "" || len(k) > maxTriggerKeywordLen {
+			return "", nil, true, "keywords must be 1 to 200 characters"
+		}
+		if _, err := regexp.Compile("(?i)" + k); err != nil {
+			return "", nil, true, "Invalid keyword pattern " + k + ": " + err.Error()
+		}
+	}
+	return trigger, keywords, true, ""
+}
+
+// triggeredWithoutMention reports whether agent's trigger mode makes it
+// answer msg even though msg doesn't mention it. Only human messages trigger
+// agents this way, so agents can't set each other off. repliedTo returns the
+// message msg replies to, or nil.
+func triggeredWithoutMention(agent db.Participant, msg *db.Message, repliedTo func() *db.Message) bool {
+	if msg.SenderAgentID != nil {
+		return false
+	}
+	switch agent.Trigger {
+	case db.TriggerAll:
+		return true
+	case db.TriggerKeywords:
+		for _, k := range agent.TriggerKeywords {
+			if re, err := regexp.Compile("(?i)" + k); err == nil && re.MatchString(msg.Content) {
+				return true
+			}
+		}
+	case db.TriggerReplies:
+		if orig := repliedTo(); orig != nil && orig.SenderAgentID != nil && *orig.SenderAgentID == agent.AgentID {
+			return true
+		}
+	}
+	return false
+}
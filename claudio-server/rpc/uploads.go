@@ -0,0 +1,215 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/openclaw"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+const (
+	uploadTokenTTL           = 10 * time.Minute
+	maxAttachmentsPerMessage = 10
+	longMessagePreviewLen    = 500 // characters kept inline when a long message becomes an attachment
+	maxAgentImageBytes       = 10 << 20
+)
+
+// PendingUpload is an upload authorized over RPC and completed over HTTP.
+type PendingUpload struct {
+	RoomID      string
+	UserID      string
+	Filename    string
+	ContentType string
+	ExpiresAt   time.Time
+}
+
+type uploadTokens struct {
+	mu      sync.Mutex
+	pending map[string]PendingUpload
+}
+
+func newUploadTokens() *uploadTokens {
+	return &uploadTokens{pending: make(map[string]PendingUpload)}
+}
+
+func (u *uploadTokens) issue(p PendingUpload) string {
+	token := GenerateMsgID() + GenerateMsgID()
+	now := time.Now()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for t, existing := range u.pending {
+		if now.After(existing.ExpiresAt) {
+			delete(u.pending, t)
+		}
+	}
+	u.pending[token] = p
+	return token
+}
+
+func (u *uploadTokens) consume(token string) (*PendingUpload, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p, ok := u.pending[token]
+	if !ok {
+		return nil, false
+	}
+	delete(u.pending, token)
+	if time.Now().After(p.ExpiresAt) {
+		return nil, false
+	}
+	return &p, true
+}
+
+// storeTextAttachment saves text as a pending attachment and returns its ID.
+func (r *Router) storeTextAttachment(roomID, userID, filename, text string) (string, error) {
+	r.Media.RLock()
+	defer r.Media.RUnlock()
+
+	hash, size, err := r.Media.Put(strings.NewReader(text), int64(len(text)))
+	if err != nil {
+		return "", err
+	}
+	attachment, err := r.DB.CreateAttachment(GenerateMsgID(), roomID, userID, hash, size, filename, "text/plain; charset=utf-8")
+	if err != nil {
+		return "", err
+	}
+	return attachment.ID, nil
+}
+
+// storeImageAttachment decodes an agent's inline image into a pending upload.
+func (r *Router) storeImageAttachment(roomID, uploaderID string, block openclaw.ContentBlock) (string, error) {
+	if r.Media == nil {
+		return "", errors.New("no media store")
+	}
+	data, err := base64.StdEncoding.DecodeString(block.Data)
+	if err != nil {
+		return "", fmt.Errorf("decode image: %w", err)
+	}
+	if len(data) > maxAgentImageBytes {
+		return "", errors.New("image too large")
+	}
+
+	r.Media.RLock()
+	defer r.Media.RUnlock()
+
+	hash, size, err := r.Media.Put(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	filename := "image"
+	if exts, _ := mime.ExtensionsByType(block.MimeType); len(exts) > 0 {
+		filename += exts[0]
+	}
+	attachment, err := r.DB.CreateAttachment(GenerateMsgID(), roomID, uploaderID, hash, size, filename, block.MimeType)
+	if err != nil {
+		return "", err
+	}
+	return attachment.ID, nil
+}
+
+// ConsumeUpload redeems a single-use upload token issued by rooms.createUpload.
+func (r *Router) ConsumeUpload(token string) (*PendingUpload, bool) {
+	return r.uploads.consume(token)
+}
+
+func (r *Router) handleRoomsCreateUpload(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	filename := jsonString(req.Params["filename"])
+	contentType := jsonString(req.Params["contentType"])
+
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if !r.checkRoomAccess(client, req, roomID) {
+		return
+	}
+
+	expiresAt := time.Now().Add(uploadTokenTTL)
+	token := r.uploads.issue(PendingUpload{
+		RoomID:      roomID,
+		UserID:      client.UserID(),
+		Filename:    filename,
+		ContentType: contentType,
+		ExpiresAt:   expiresAt,
+	})
+
+	resp := map[string]interface{}{
+		"uploadPath": "/media/upload/" + token,
+		"expiresAt":  expiresAt.UTC(),
+	}
+	if r.ExternalURL != "" {
+		resp["uploadUrl"] = "https://" + r.ExternalURL + "/media/upload/" + token
+	}
+	client.SendJSON(ws.NewResponse(req.ID, resp))
+}
+
+// avatarContentTypes are the image types accepted as room avatars.
+var avatarContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// handleRoomsSetAvatar uses an upload from rooms.createUpload as the room's
+// avatar. An empty attachmentId clears it.
+func (r *Router) handleRoomsSetAvatar(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	attachmentID := jsonString(req.Params["attachmentId"])
+	var avatar *string
+	if attachmentID != "" {
+		// Must be the caller's own unsent upload to this room
+		n, err := r.dbFor(req).CountPendingAttachments(roomID, client.UserID(), []string{attachmentID})
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		attachment, _ := r.dbFor(req).GetAttachment(attachmentID)
+		if n != 1 || attachment == nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Unknown attachment"))
+			return
+		}
+		if !avatarContentTypes[attachment.ContentType] {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "Avatar must be a PNG, JPEG, GIF, or WebP image"))
+			return
+		}
+		avatar = &attachmentID
+	}
+
+	if err := r.dbFor(req).SetRoomAvatar(roomID, avatar); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	room, err := r.dbFor(req).GetRoom(roomID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.updated", map[string]interface{}{
+		"roomId":    roomID,
+		"avatarUrl": room.AvatarURL,
+		"updatedAt": room.UpdatedAt,
+		"updatedBy": client.UserID(),
+	}), nil)
+	r.audit(roomID, client.UserID(), db.AuditRoomUpdated, "", map[string]interface{}{
+		"avatarUrl": room.AvatarURL,
+	})
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"avatarUrl": room.AvatarURL,
+	}))
+}
@@ -0,0 +1,38 @@
+package rpc
+
+import (
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// handleAgentsUsage returns per-agent call counts, latencies and response
+// sizes in a room for the inclusive UTC date range [from, to].
+func (r *Router) handleAgentsUsage(client *ws.Client, req ws.RPCRequest) {
+	roomID := jsonString(req.Params["roomId"])
+	if roomID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "roomId is required"))
+		return
+	}
+
+	from, to, ok := parseDateRange(client, req)
+	if !ok {
+		return
+	}
+
+	if !r.canManageAgents(client, roomID) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "FORBIDDEN", "Only owners and admins can view agent usage"))
+		return
+	}
+
+	usage, err := r.dbFor(req).GetAgentUsage(roomID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"roomId": roomID,
+		"from":   from.Format(activityDateLayout),
+		"to":     to.Format(activityDateLayout),
+		"agents": usage,
+	}))
+}
@@ -0,0 +1,182 @@
+package rpc
+
+import (
+	"log/slog"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nicebartender/claudio-server/db"
+	"github.com/nicebartender/claudio-server/ws"
+)
+
+// handleUsersGet returns the public profile and presence of a user the
+// caller shares a room with.
+func (r *Router) handleUsersGet(client *ws.Client, req ws.RPCRequest) {
+	userID := jsonString(req.Params["userId"])
+	if userID == "" {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "userId is required"))
+		return
+	}
+
+	if userID != client.UserID() {
+		shared, err := r.dbFor(req).SharesRoom(client.UserID(), userID)
+		if err != nil {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+			return
+		}
+		// Same error as a missing user, so IDs can't be probed
+		if !shared {
+			client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User not found"))
+			return
+		}
+	}
+
+	user, err := r.dbFor(req).GetUser(userID)
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	if user == nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "NOT_FOUND", "User not found"))
+		return
+	}
+	lastSeen, _ := r.dbFor(req).LastSeen(userID)
+	status, online := r.Hub.Status(userID)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":          user.ID,
+			"displayName": user.DisplayName,
+			"emoji":       user.AvatarEmoji,
+			"bio":         user.Bio,
+			"pronouns":    user.Pronouns,
+			"timezone":    user.Timezone,
+			"online":      online,
+			"status":      status.Status,
+			"statusText":  status.Text,
+			"statusEmoji": status.Emoji,
+			"lastSeenAt":  lastSeen,
+		},
+	}))
+}
+
+const (
+	maxBioLength      = 300
+	maxPronounsLength = 40
+)
+
+// validateProfile checks the optional profile fields of user.update and
+// returns a message for the first bad one.
+func validateProfile(bio, pronouns, timezone *string) string {
+	if bio != nil && utf8.RuneCountInString(*bio) > maxBioLength {
+		return "bio is too long"
+	}
+	if pronouns != nil && utf8.RuneCountInString(*pronouns) > maxPronounsLength {
+		return "pronouns are too long"
+	}
+	if timezone != nil && *timezone != "" {
+		if _, err := time.LoadLocation(*timezone); err != nil || *timezone == "Local" {
+			return "timezone must be an IANA time zone name"
+		}
+	}
+	return ""
+}
+
+const (
+	maxStatusTextLength  = 100
+	maxStatusEmojiLength = 16
+)
+
+// handlePresenceSet sets the caller's presence status (online, away, dnd)
+// and optional custom status text and emoji. With persist the status
+// survives disconnects; otherwise it ends with the last connection.
+func (r *Router) handlePresenceSet(client *ws.Client, req ws.RPCRequest) {
+	status := db.UserStatus{
+		Status: jsonString(req.Params["status"]),
+		Text:   jsonString(req.Params["text"]),
+		Emoji:  jsonString(req.Params["emoji"]),
+	}
+	if status.Status == "" {
+		status.Status = db.StatusOnline
+	}
+	if !db.ValidStatus(status.Status) {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "status must be online, away or dnd"))
+		return
+	}
+	if utf8.RuneCountInString(status.Text) > maxStatusTextLength {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "text is too long"))
+		return
+	}
+	if utf8.RuneCountInString(status.Emoji) > maxStatusEmojiLength {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "INVALID_PARAMS", "emoji is too long"))
+		return
+	}
+
+	if err := r.Hub.SetStatus(client.UserID(), status, jsonBool(req.Params["persist"])); err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"status": status,
+	}))
+}
+
+// deleteAccountParams are the params users.deleteAccount and
+// admin.deleteUser share.
+type deleteAccountParams struct {
+	Messages string `json:"messages" rpc:"oneof=anonymize erase"`
+}
+
+// policy is what becomes of the account's messages, anonymize by default.
+func (p deleteAccountParams) policy() string {
+	if p.Messages == "" {
+		return db.MessagesAnonymize
+	}
+	return p.Messages
+}
+
+// handleUsersDeleteAccount deletes the caller's account, see deleteAccount.
+func (r *Router) handleUsersDeleteAccount(client *ws.Client, req ws.RPCRequest, p deleteAccountParams) {
+	policy := p.policy()
+
+	del, err := r.deleteAccount(req, client.UserID(), policy, client.UserID())
+	if err != nil {
+		client.SendJSON(ws.NewErrorResponse(req.ID, "DB_ERROR", err.Error()))
+		return
+	}
+	slog.Info("account deleted", "userID", client.UserID(), "messages", policy)
+
+	client.SendJSON(ws.NewResponse(req.ID, map[string]interface{}{
+		"deleted":  true,
+		"messages": del.Messages,
+	}))
+	r.Hub.DisconnectUser(client.UserID())
+}
+
+// deleteAccount erases userID's account (db.DeleteAccount) and tells the
+// rooms it was in. Its connections are left to the caller to drop.
+func (r *Router) deleteAccount(req ws.RPCRequest, userID, policy, deletedBy string) (*db.AccountDeletion, error) {
+	del, err := r.dbFor(req).DeleteAccount(userID, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, roomID := range del.DeletedRooms {
+		r.roomRemoved(roomID, deletedBy)
+	}
+	for _, h := range del.Handovers {
+		r.broadcastRoleChanged(h.RoomID, h.UserID, h.OldRole, db.RoleOwner, userID)
+		r.audit(h.RoomID, userID, db.AuditOwnershipTransfer, h.UserID, map[string]interface{}{
+			"reason": "owner deleted their account",
+		})
+	}
+	// No "left" system message: it would keep the erased name in history
+	for _, roomID := range del.Rooms {
+		r.Hub.BroadcastToRoom(roomID, ws.NewEvent("room.leave", map[string]interface{}{
+			"roomId":      roomID,
+			"displayName": db.DeletedUserName,
+			"userId":      userID,
+		}), nil)
+	}
+	return del, nil
+}
@@ -20,6 +20,7 @@ type ConnectParams struct {
 	Device      *ConnectDevice   `json:"device"`
 	Auth        *ConnectAuth     `json:"auth"`
 	Role        string           `json:"role"`
+	Scopes      []string         `json:"scopes,omitempty"` // signed; default DefaultScopes
 }
 
 type ConnectClient struct {
@@ -39,7 +40,9 @@ type ConnectDevice struct {
 }
 
 type ConnectAuth struct {
-	Token string `json:"token"`
+	Token       string `json:"token"`
+	ResumeToken string `json:"resumeToken,omitempty"` // from a previous connect; skips the device signature
+	JWT         string `json:"jwt,omitempty"`         // identity provider token, see Hub.JWT; replaces the device signature
 }
 
 // VerifyConnect validates the connect handshake and returns the user ID (device ID)
@@ -90,7 +93,7 @@ func VerifyConnect(paramsRaw json.RawMessage, challengeNonce string) (userID str
 		safeClientID(params.Client),
 		safeClientMode(params.Client),
 		params.Role,
-		"operator.read,operator.write",
+		joinScopes(connectScopes(params.Scopes)),
 		dev.SignedAt,
 		token,
 		dev.Nonce,
@@ -140,3 +143,14 @@ func safeClientMode(c *ConnectClient) string {
 	}
 	return c.Mode
 }
+
+// DeviceIDForKey returns the device ID for a base64url-encoded Ed25519
+// public key: the hex SHA256 of the key.
+func DeviceIDForKey(publicKey string) (string, error) {
+	pubKeyBytes, err := base64URLDecode(publicKey)
+	if err != nil || len(pubKeyBytes) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid public key")
+	}
+	hash := sha256.Sum256(pubKeyBytes)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/nicebartender/claudio-server/db"
+)
+
+// RequestIP returns the client address of r. Behind trustedProxies reverse
+// proxies, each appending the address it was reached from to
+// X-Forwarded-For, it's the entry that many from the end: anything before
+// that came from the client and can't be trusted.
+func RequestIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(v, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, hop)
+				}
+			}
+		}
+		if len(hops) > 0 {
+			return hops[max(len(hops)-trustedProxies, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newAuthEvent starts the audit record of a connect attempt.
+func newAuthEvent(client *Client, method string, c *ConnectClient) db.AuthEvent {
+	ev := db.AuthEvent{Method: method, IP: client.RemoteIP()}
+	if c != nil {
+		ev.Platform = c.Platform
+		ev.ClientVersion = c.Version
+	}
+	return ev
+}
+
+// recordAuth stores a connect attempt; code is "" for a successful one.
+func (h *Hub) recordAuth(ev db.AuthEvent, code, reason string) {
+	ev.Success = code == ""
+	ev.ErrorCode = code
+	ev.Reason = reason
+	if err := h.DB.RecordAuthEvent(ev); err != nil {
+		slog.Error("record auth event failed", "err", err)
+	}
+}
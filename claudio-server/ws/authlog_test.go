@@ -0,0 +1,28 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:51234"
+	// The client forged the first entry; two proxies appended the rest
+	r.Header.Add("X-Forwarded-For", "198.51.100.9, 203.0.113.7")
+	r.Header.Add("X-Forwarded-For", "10.0.0.1")
+
+	for _, tt := range []struct {
+		proxies int
+		want    string
+	}{
+		{0, "192.0.2.1"},
+		{1, "10.0.0.1"},
+		{2, "203.0.113.7"},
+		{5, "198.51.100.9"},
+	} {
+		if got := RequestIP(r, tt.proxies); got != tt.want {
+			t.Errorf("RequestIP behind %d proxies = %q, want %q", tt.proxies, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package ws
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// What happens to a connection whose overflow queue fills up, see
+// Hub.SlowConsumer.
+const (
+	// Drop the queue and send sync.required so the client refetches; a
+	// second overflow before it catches up disconnects it
+	SlowConsumerResync = "resync"
+	// Disconnect with CloseSlowConsumer straight away
+	SlowConsumerDisconnect = "disconnect"
+)
+
+// DefaultSendQueueLimit is how many messages may queue up behind a full
+// send buffer before the slow consumer policy kicks in.
+const DefaultSendQueueLimit = 1024
+
+// ValidSlowConsumer reports whether policy is a known slow consumer policy.
+func ValidSlowConsumer(policy string) bool {
+	return policy == SlowConsumerResync || policy == SlowConsumerDisconnect
+}
+
+// SendStats counts what slow connections have cost since startup.
+type SendStats struct {
+	Dropped     int64 `json:"dropped"`     // messages discarded from full queues
+	Resyncs     int64 `json:"resyncs"`     // sync.required sent
+	Disconnects int64 `json:"disconnects"` // connections closed with CloseSlowConsumer
+}
+
+type sendCounters struct {
+	dropped, resyncs, disconnects atomic.Int64
+}
+
+// SendStats returns the slow consumer counters.
+func (h *Hub) SendStats() SendStats {
+	return SendStats{
+		Dropped:     h.sends.dropped.Load(),
+		Resyncs:     h.sends.resyncs.Load(),
+		Disconnects: h.sends.disconnects.Load(),
+	}
+}
+
+// enqueue hands data to WritePump, after any pending batch.
+func (c *Client) enqueue(data []byte) {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	c.flushBatchLocked()
+	c.push(data)
+}
+
+// push queues data for WritePump. Once the send buffer is full, messages
+// wait in an overflow queue of up to Hub.SendQueueLimit, and everything
+// after them queues too so order is kept. Past the limit, Hub.SlowConsumer
+// decides.
+func (c *Client) push(data []byte) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.slowClosed {
+		return
+	}
+	if len(c.overflow) == 0 {
+		select {
+		case c.send <- data:
+			return
+		default:
+		}
+	}
+	if len(c.overflow) < c.hub.SendQueueLimit {
+		c.overflow = append(c.overflow, data)
+		c.wakeWriter()
+		return
+	}
+
+	dropped := int64(len(c.overflow) + 1)
+	c.hub.sends.dropped.Add(dropped)
+	if c.hub.SlowConsumer == SlowConsumerDisconnect || c.lagging {
+		c.Log().Warn("disconnecting slow consumer", "dropped", dropped)
+		c.hub.sends.disconnects.Add(1)
+		c.slowClosed = true
+		c.overflow = nil
+		go c.closeWith(CloseSlowConsumer)
+		return
+	}
+	c.Log().Warn("slow consumer must resync", "dropped", dropped)
+	c.hub.sends.resyncs.Add(1)
+	c.lagging = true
+	c.overflow = [][]byte{mustJSON(NewEvent("sync.required", map[string]interface{}{
+		"reason":  "slow_consumer",
+		"dropped": dropped,
+	}))}
+	c.wakeWriter()
+}
+
+// wakeWriter tells WritePump the overflow queue has messages. c.outMu is
+// held.
+func (c *Client) wakeWriter() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeOverflow empties the overflow queue for WritePump, which writes it
+// after whatever is still in the send buffer. Taking it all means the
+// connection has caught up.
+func (c *Client) takeOverflow() [][]byte {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	queued := c.overflow
+	c.overflow = nil
+	c.lagging = false
+	return queued
+}
+
+// writeMessage writes one queued message; only WritePump calls it.
+func (c *Client) writeMessage(message []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	c.conn.EnableWriteCompression(len(message) >= compressThreshold)
+	if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		return err
+	}
+	c.markWritten(time.Now())
+	return nil
+}
+
+// flushOverflow writes the send buffer's backlog and then the overflow
+// queue, oldest first.
+func (c *Client) flushOverflow() error {
+backlog:
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.writeMessage(message); err != nil {
+				return err
+			}
+		default:
+			break backlog
+		}
+	}
+	for _, message := range c.takeOverflow() {
+		if err := c.writeMessage(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func newSlowClient(h *Hub) *Client {
+	return &Client{hub: h, send: make(chan []byte, 2), wake: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+func TestSlowConsumerResyncThenDisconnect(t *testing.T) {
+	h := NewHub(nil)
+	h.SendQueueLimit = 3
+	c := newSlowClient(h)
+
+	for i := 0; i < 5; i++ {
+		c.SendJSON(NewEvent("tick", nil))
+	}
+	if len(c.send) != 2 || len(c.overflow) != 3 {
+		t.Fatalf("send has %d, overflow %d; want 2 and 3", len(c.send), len(c.overflow))
+	}
+
+	// The sixth overflows: the queue becomes a single sync.required
+	c.SendJSON(NewEvent("tick", nil))
+	if len(c.overflow) != 1 {
+		t.Fatalf("overflow has %d after resync, want 1", len(c.overflow))
+	}
+	var ev RPCEvent
+	json.Unmarshal(c.overflow[0], &ev)
+	if ev.Event != "sync.required" {
+		t.Errorf("queued %q, want sync.required", ev.Event)
+	}
+	if got := h.SendStats(); got.Dropped != 4 || got.Resyncs != 1 {
+		t.Errorf("stats = %+v, want 4 dropped and 1 resync", got)
+	}
+
+	// Still lagging: overflowing again disconnects
+	c.SendJSON(NewEvent("tick", nil))
+	c.SendJSON(NewEvent("tick", nil))
+	c.SendJSON(NewEvent("tick", nil))
+	if !c.slowClosed || h.SendStats().Disconnects != 1 {
+		t.Errorf("lagging client not disconnected: %+v", h.SendStats())
+	}
+	c.SendJSON(NewEvent("tick", nil))
+	if len(c.overflow) != 0 {
+		t.Error("queued for a closed connection")
+	}
+}
+
+func TestSlowConsumerCatchesUp(t *testing.T) {
+	h := NewHub(nil)
+	h.SendQueueLimit = 1
+	c := newSlowClient(h)
+
+	for i := 0; i < 4; i++ {
+		c.SendJSON(NewEvent("tick", nil))
+	}
+	if !c.lagging {
+		t.Fatal("not lagging after overflowing")
+	}
+	// WritePump drains the buffer and the queue
+	<-c.send
+	<-c.send
+	c.takeOverflow()
+	for i := 0; i < 4; i++ {
+		c.SendJSON(NewEvent("tick", nil))
+	}
+	if c.slowClosed || h.SendStats().Resyncs != 2 {
+		t.Errorf("caught-up client should resync again, not disconnect: %+v", h.SendStats())
+	}
+}
+
+func TestSlowConsumerDisconnectPolicy(t *testing.T) {
+	h := NewHub(nil)
+	h.SendQueueLimit = 0
+	h.SlowConsumer = SlowConsumerDisconnect
+	c := newSlowClient(h)
+
+	for i := 0; i < 3; i++ {
+		c.SendJSON(NewEvent("tick", nil))
+	}
+	if !c.slowClosed || h.SendStats().Resyncs != 0 {
+		t.Errorf("disconnect policy: closed %v, stats %+v", c.slowClosed, h.SendStats())
+	}
+}
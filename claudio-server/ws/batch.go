@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"bytes"
+	"time"
+)
+
+// DefaultBatchInterval is how long room.message events in a busy room wait
+// to share a frame, for clients that connect with batch.
+const DefaultBatchInterval = 50 * time.Millisecond
+
+// maxBatch flushes a batch early so frames stay a sensible size.
+const maxBatch = 100
+
+// coalesce reports whether event should be batched: room.message events
+// closer together than interval, i.e. a room busy enough that batching
+// saves frames. The caller holds rl.mu.
+func (rl *roomLog) coalesce(event RPCEvent, interval time.Duration, now time.Time) bool {
+	if event.Event != "room.message" || interval <= 0 {
+		return false
+	}
+	busy := now.Sub(rl.lastMessage) < interval
+	rl.lastMessage = now
+	return busy
+}
+
+func (c *Client) setBatching(on bool) {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	c.batching = on
+}
+
+// enqueueCoalesced holds an encoded room.message for up to interval and
+// sends it with any others that arrive meanwhile as one "batch" event.
+// Clients that didn't ask for batches get it straight away.
+func (c *Client) enqueueCoalesced(data []byte, interval time.Duration) {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	if !c.batching {
+		c.flushBatchLocked()
+		c.push(data)
+		return
+	}
+	c.batch = append(c.batch, data)
+	if len(c.batch) >= maxBatch {
+		c.flushBatchLocked()
+		return
+	}
+	if c.batchTimer == nil {
+		c.batchTimer = time.AfterFunc(interval, c.flushBatch)
+	}
+}
+
+func (c *Client) flushBatch() {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	c.flushBatchLocked()
+}
+
+// flushBatchLocked sends the pending batch, so nothing queued after it can
+// overtake it. The caller holds c.batchMu.
+func (c *Client) flushBatchLocked() {
+	if c.batchTimer != nil {
+		c.batchTimer.Stop()
+		c.batchTimer = nil
+	}
+	switch len(c.batch) {
+	case 0:
+		return
+	case 1:
+		c.push(c.batch[0])
+	default:
+		c.push(batchFrame(c.batch))
+	}
+	c.batch = nil
+}
+
+// batchFrame wraps already encoded events in one event:
+// {"type":"event","event":"batch","payload":{"events":[...]}}.
+func batchFrame(events [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":"event","event":"batch","payload":{"events":[`)
+	for i, e := range events {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(e)
+	}
+	buf.WriteString(`]}}`)
+	return buf.Bytes()
+}
@@ -0,0 +1,65 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBusyRoomMessagesShareAFrame(t *testing.T) {
+	h := NewHub(nil)
+	h.BatchInterval = 20 * time.Millisecond
+	batched := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{})}
+	batched.setBatching(true)
+	plain := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{})}
+	h.SubscribeRoom("r1", batched)
+	h.SubscribeRoom("r1", plain)
+
+	for i := 0; i < 3; i++ {
+		h.BroadcastToRoom("r1", NewEvent("room.message", map[string]int{"n": i}), nil)
+	}
+	// A different event flushes the pending batch ahead of itself
+	h.BroadcastToRoom("r1", NewEvent("room.typing", nil), nil)
+
+	if len(plain.send) != 4 {
+		t.Errorf("plain client got %d frames, want 4", len(plain.send))
+	}
+	// The first message of a burst goes out alone, the rest are batched
+	var frames []RPCEvent
+	for len(batched.send) > 0 {
+		var ev RPCEvent
+		json.Unmarshal(<-batched.send, &ev)
+		frames = append(frames, ev)
+	}
+	if len(frames) != 3 || frames[0].Event != "room.message" || frames[1].Event != "batch" || frames[2].Event != "room.typing" {
+		t.Fatalf("batched client got %+v", frames)
+	}
+	events := frames[1].Payload.(map[string]interface{})["events"].([]interface{})
+	if len(events) != 2 {
+		t.Errorf("batch has %d events, want 2", len(events))
+	}
+}
+
+func TestBatchFlushesAfterInterval(t *testing.T) {
+	h := NewHub(nil)
+	h.BatchInterval = 10 * time.Millisecond
+	c := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{})}
+	c.setBatching(true)
+	h.SubscribeRoom("r1", c)
+
+	h.BroadcastToRoom("r1", NewEvent("room.message", nil), nil)
+	h.BroadcastToRoom("r1", NewEvent("room.message", nil), nil)
+	h.BroadcastToRoom("r1", NewEvent("room.message", nil), nil)
+	<-c.send // the first, unbatched
+
+	select {
+	case data := <-c.send:
+		var ev RPCEvent
+		json.Unmarshal(data, &ev)
+		if ev.Event != "batch" {
+			t.Errorf("got %q, want batch", ev.Event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("batch never flushed")
+	}
+}
@@ -1,9 +1,11 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,6 +16,9 @@ const (
 	pongWait   = 60 * time.Second
 	pingPeriod = (pongWait * 9) / 10
 	maxMsgSize = 1 << 20 // 1MB
+
+	// Messages shorter than this aren't worth deflating
+	compressThreshold = 512
 )
 
 type Client struct {
@@ -21,6 +26,8 @@ type Client struct {
 	conn   *websocket.Conn
 	send   chan []byte
 	done   chan struct{} // closed on unregister
+	ctx    context.Context // cancelled on unregister, see Context
+	cancel context.CancelFunc
 	userID string       // set after auth
 	mu     sync.RWMutex
 
@@ -29,17 +36,86 @@ type Client struct {
 	authenticated  bool
 	isGuest        bool
 	displayName    string
+	deviceID       string // signing key's device; differs from userID on linked devices
+
+	// Rooms a guest joined through a read-only invite
+	readOnlyRooms map[string]bool
+
+	rpcBucket *tokenBucket // per-connection request limit, see Hub.ConnRPCLimit
+
+	scopes map[string]bool // granted connect scopes; nil = not scope-limited
+
+	remoteIP string // set before the read loop starts, see RequestIP
+
+	sessionStart time.Time // last successful connect, see Hub.MaxSessionAge
+	expiryWarned bool      // auth.expiring sent for this session
+	protocol     int       // negotiated at connect; 0 until then, see Protocol
+	manualRooms  bool      // connected with autoSubscribe off
+	autoLite     bool      // connected with subscribeLevel lite
+
+	liteRooms map[string]int // lite subscriptions and their unread counts, see SubscribeRoomLite
+
+	tick      time.Duration // tick event interval, see tickLoop
+	lastWrite atomic.Int64  // unix nanos of the last message written
+
+	// Connection metadata, see Info
+	connID        string
+	connectedAt   time.Time
+	userAgent     string
+	platform      string // from connect's client info
+	clientVersion string
+
+	// Server requests awaiting the client's answer, see Request
+	caps        map[string]bool // connect caps
+	reqMu       sync.Mutex
+	pending     map[string]chan RPCMessage
+	nextRequest atomic.Uint64
+
+	rooms     map[string]bool // subscribed rooms, see Hub.SubscribeRoom
+	closeOnce sync.Once       // guards unregistering
+	connShard int             // see connIndex
+
+	// Messages waiting behind a full send buffer, see enqueue
+	outMu      sync.Mutex
+	overflow   [][]byte
+	wake       chan struct{} // overflow has messages
+	lagging    bool          // sync.required sent and not caught up yet
+	slowClosed bool          // closed for falling behind; further sends are dropped
+
+	// room.message events held for a batch frame, see enqueueCoalesced;
+	// batchMu is taken before outMu
+	batchMu    sync.Mutex
+	batching   bool // asked for batches at connect
+	batch      [][]byte
+	batchTimer *time.Timer
 }
 
 func NewClient(hub *Hub, conn *websocket.Conn) *Client {
+	// Only matters if the upgrade negotiated permessage-deflate
+	conn.SetCompressionLevel(hub.CompressionLevel)
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		hub:  hub,
-		conn: conn,
-		send: make(chan []byte, 256),
-		done: make(chan struct{}),
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan []byte, 256),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+		connID:      generateNonce()[:12],
+		connectedAt: time.Now(),
 	}
 }
 
+// Context is cancelled once the client is unregistered, for work done on
+// its behalf.
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 func (c *Client) UserID() string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -64,6 +140,23 @@ func (c *Client) IsGuest() bool {
 	return c.isGuest
 }
 
+// SetReadOnly marks the room read-only for this connection. Used for guests,
+// who have no participant row to carry a role.
+func (c *Client) SetReadOnly(roomID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.readOnlyRooms == nil {
+		c.readOnlyRooms = make(map[string]bool)
+	}
+	c.readOnlyRooms[roomID] = true
+}
+
+func (c *Client) IsReadOnly(roomID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.readOnlyRooms[roomID]
+}
+
 func (c *Client) SetAuth(userID, displayName string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -72,6 +165,32 @@ func (c *Client) SetAuth(userID, displayName string) {
 	c.displayName = displayName
 }
 
+// SetDevice records which of the account's devices this connection is.
+func (c *Client) SetDevice(deviceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deviceID = deviceID
+}
+
+func (c *Client) DeviceID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.deviceID
+}
+
+// SetRemoteIP records the address the connection came from.
+func (c *Client) SetRemoteIP(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remoteIP = ip
+}
+
+func (c *Client) RemoteIP() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.remoteIP
+}
+
 func (c *Client) SetGuestAuth(guestID, displayName string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -81,22 +200,23 @@ func (c *Client) SetGuestAuth(guestID, displayName string) {
 	c.displayName = displayName
 }
 
+// Close drops the connection; ReadPump then unregisters the client.
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
 func (c *Client) SendJSON(v interface{}) {
-	data, err := json.Marshal(v)
+	data, err := json.Marshal(c.shim(v))
 	if err != nil {
 		slog.Error("marshal error", "err", err)
 		return
 	}
-	select {
-	case c.send <- data:
-	default:
-		slog.Warn("client send buffer full, dropping message")
-	}
+	c.enqueue(data)
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unregister(c)
 		c.conn.Close()
 	}()
 
@@ -111,7 +231,7 @@ func (c *Client) ReadPump() {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				slog.Info("client disconnected", "err", err)
+				c.Log().Info("client disconnected", "err", err)
 			}
 			return
 		}
@@ -128,16 +248,22 @@ func (c *Client) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case message := <-c.send:
+			if err := c.writeMessage(message); err != nil {
 				return
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+
+		case <-c.wake:
+			if err := c.flushOverflow(); err != nil {
 				return
 			}
 
+		case <-c.done:
+			// The send channel stays open for late broadcasts; done ends us
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
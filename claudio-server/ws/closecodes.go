@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Close codes the server sends before dropping a connection, with what the
+// client should do next. The close reason is the matching token from
+// closeReasons.
+const (
+	// Shutting down or restarting: reconnect after a short random delay
+	CloseServerShutdown = 4000
+	// Session reached Hub.MaxSessionAge: reconnect now with a fresh handshake
+	CloseAuthExpired = 4001
+	// Malformed frames: a client bug, so back off hard rather than loop
+	CloseProtocolError = 4002
+	// Account banned, device revoked or address banned: don't reconnect
+	CloseRevoked = 4003
+	// Kept sending while rate limited: reconnect with exponential backoff
+	CloseRateLimited = 4004
+	// Couldn't keep up with its events: reconnect now, with lastSeq
+	CloseSlowConsumer = 4008
+)
+
+var closeReasons = map[int]string{
+	CloseServerShutdown: "server_shutdown",
+	CloseAuthExpired:    "auth_expired",
+	CloseProtocolError:  "protocol_error",
+	CloseRevoked:        "revoked",
+	CloseRateLimited:    "rate_limited",
+	CloseSlowConsumer:   "slow_consumer",
+}
+
+// closeWith sends a close frame with code before dropping the connection,
+// so the client knows why and how to reconnect.
+func (c *Client) closeWith(code int) {
+	if c.conn == nil {
+		return
+	}
+	msg := websocket.FormatCloseMessage(code, closeReasons[code])
+	c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	c.conn.Close()
+}
+
+// Shutdown closes every connection with CloseServerShutdown.
+func (h *Hub) Shutdown() int {
+	conns := h.conns.all()
+	for _, c := range conns {
+		c.closeWith(CloseServerShutdown)
+	}
+	return len(conns)
+}
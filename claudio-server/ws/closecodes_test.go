@@ -0,0 +1,43 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketCountsDenials(t *testing.T) {
+	limit := RateLimit{PerSecond: 1, Burst: 1}
+	b := &tokenBucket{}
+	now := time.Now()
+	b.take(limit, now)
+	for i := 0; i < 5; i++ {
+		b.take(limit, now)
+	}
+	if b.denials != 5 {
+		t.Fatalf("denials = %d, want 5", b.denials)
+	}
+	if wait := b.take(limit, now.Add(2*time.Second)); wait != 0 {
+		t.Fatalf("bucket did not refill (wait %v)", wait)
+	}
+	if b.denials != 0 {
+		t.Errorf("denials = %d after an allowed call, want 0", b.denials)
+	}
+}
+
+func TestShutdownClosesEveryConnection(t *testing.T) {
+	h := NewHub(nil)
+	clients := make([]*Client, 10)
+	for i := range clients {
+		clients[i] = newTestClient(h)
+		h.Register(clients[i])
+	}
+	h.unregister(clients[0])
+	if n := h.Shutdown(); n != 9 {
+		t.Errorf("Shutdown closed %d connections, want 9", n)
+	}
+	for _, code := range []int{CloseServerShutdown, CloseAuthExpired, CloseProtocolError, CloseRevoked, CloseRateLimited, CloseSlowConsumer} {
+		if closeReasons[code] == "" {
+			t.Errorf("close code %d has no reason", code)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package ws
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nicebartender/claudio-server/db"
+)
+
+// How often each instance announces its online users, and how long an
+// instance that went quiet still counts. A crashed instance's users go
+// offline after clusterNodeTimeout.
+const (
+	clusterSyncInterval = 15 * time.Second
+	clusterNodeTimeout  = 3 * clusterSyncInterval
+)
+
+// ClusterBroker carries hub traffic between instances; see package cluster
+// for Redis and NATS. Every instance receives every message, its own too.
+type ClusterBroker interface {
+	Publish(data []byte) error
+	Subscribe(handler func(data []byte)) error
+	Close() error
+}
+
+// Kinds of clusterMessage.
+const (
+	clusterRoomEvent        = "room"             // BroadcastToRoom
+	clusterUserEvent        = "user"             // SendToUser
+	clusterDeviceEvent      = "device"           // SendToDevice
+	clusterSubscribeUser    = "subscribeUser"    // SubscribeUser
+	clusterUnsubscribeAll   = "unsubscribeAll"   // UnsubscribeAll
+	clusterDisconnectUser   = "disconnectUser"   // DisconnectUser
+	clusterDisconnectDevice = "disconnectDevice" // DisconnectDevice
+	clusterDisconnectIP     = "disconnectIP"     // DisconnectIP
+	clusterPresence         = "presence"         // a user's first or last connection here, or a status change
+	clusterSync             = "sync"             // all of an instance's online users, every clusterSyncInterval
+)
+
+type clusterMessage struct {
+	Node     string          `json:"node"`
+	Kind     string          `json:"kind"`
+	RoomID   string          `json:"roomId,omitempty"`
+	UserID   string          `json:"userId,omitempty"`
+	DeviceID string          `json:"deviceId,omitempty"`
+	CIDR     string          `json:"cidr,omitempty"`
+	Event    json.RawMessage `json:"event,omitempty"`
+	// The event's ExcludeUser, which isn't part of its JSON
+	ExcludeUser string `json:"excludeUser,omitempty"`
+
+	Online bool                     `json:"online,omitempty"`
+	Status *db.UserStatus           `json:"status,omitempty"`
+	Users  map[string]db.UserStatus `json:"users,omitempty"`
+}
+
+// remotePresence is who is online on the other instances.
+type remotePresence struct {
+	mu    sync.RWMutex
+	nodes map[string]*remoteNode
+}
+
+type remoteNode struct {
+	seen  time.Time
+	users map[string]db.UserStatus
+}
+
+func (p *remotePresence) node(nodeID string) *remoteNode {
+	n := p.nodes[nodeID]
+	if n == nil {
+		n = &remoteNode{users: make(map[string]db.UserStatus)}
+		p.nodes[nodeID] = n
+	}
+	n.seen = time.Now()
+	return n
+}
+
+// status returns the user's status on whichever live instance has them.
+func (p *remotePresence) status(userID string) (db.UserStatus, bool) {
+	if p == nil {
+		return db.UserStatus{}, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	cutoff := time.Now().Add(-clusterNodeTimeout)
+	for _, n := range p.nodes {
+		if s, ok := n.users[userID]; ok && n.seen.After(cutoff) {
+			return s, true
+		}
+	}
+	return db.UserStatus{}, false
+}
+
+// StartCluster joins the instances sharing broker: room broadcasts, direct
+// events, subscription changes, disconnects and presence all reach their
+// connections too. Instances must share the database.
+func (h *Hub) StartCluster(broker ClusterBroker) error {
+	h.NodeID = generateNonce()[:12]
+	h.remote = &remotePresence{nodes: make(map[string]*remoteNode)}
+	h.cluster = broker
+	h.clusterStop = make(chan struct{})
+	if err := broker.Subscribe(h.handleCluster); err != nil {
+		return err
+	}
+	go h.clusterSyncLoop()
+	slog.Info("cluster mode on", "node", h.NodeID)
+	return nil
+}
+
+// StopCluster stops announcing this instance and closes the broker. Other
+// instances forget it after clusterNodeTimeout.
+func (h *Hub) StopCluster() error {
+	if h.cluster == nil {
+		return nil
+	}
+	h.stopOnce.Do(func() { close(h.clusterStop) })
+	return h.cluster.Close()
+}
+
+func (h *Hub) publish(msg clusterMessage) {
+	if h.cluster == nil {
+		return
+	}
+	msg.Node = h.NodeID
+	data, _ := json.Marshal(msg)
+	if err := h.cluster.Publish(data); err != nil {
+		slog.Error("cluster publish failed", "kind", msg.Kind, "err", err)
+	}
+}
+
+func (h *Hub) handleCluster(data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		slog.Warn("bad cluster message", "err", err)
+		return
+	}
+	if msg.Node == h.NodeID {
+		return
+	}
+	var event RPCEvent
+	if len(msg.Event) > 0 {
+		json.Unmarshal(msg.Event, &event)
+	}
+	event.ExcludeUser = msg.ExcludeUser
+
+	switch msg.Kind {
+	case clusterRoomEvent:
+		h.broadcastLocal(msg.RoomID, event, nil)
+	case clusterUserEvent:
+		for _, c := range h.userConns(msg.UserID) {
+			c.SendJSON(event)
+		}
+	case clusterDeviceEvent:
+		h.sendToDeviceLocal(msg.UserID, msg.DeviceID, event)
+	case clusterSubscribeUser:
+		h.subscribeUserLocal(msg.RoomID, msg.UserID)
+	case clusterUnsubscribeAll:
+		h.unsubscribeAllLocal(msg.RoomID)
+	case clusterDisconnectUser:
+		for _, c := range h.userConns(msg.UserID) {
+			c.closeWith(CloseRevoked)
+		}
+	case clusterDisconnectDevice:
+		h.disconnectDeviceLocal(msg.UserID, msg.DeviceID)
+	case clusterDisconnectIP:
+		if ipNet, err := ParseIPBan(msg.CIDR); err == nil {
+			h.disconnectIPLocal(ipNet)
+		}
+	case clusterPresence:
+		h.remote.mu.Lock()
+		n := h.remote.node(msg.Node)
+		if msg.Online && msg.Status != nil {
+			n.users[msg.UserID] = *msg.Status
+		} else {
+			delete(n.users, msg.UserID)
+		}
+		h.remote.mu.Unlock()
+	case clusterSync:
+		h.remote.mu.Lock()
+		n := h.remote.node(msg.Node)
+		n.users = msg.Users
+		if n.users == nil {
+			n.users = make(map[string]db.UserStatus)
+		}
+		h.remote.mu.Unlock()
+	}
+}
+
+// publishPresence tells the other instances about a local user coming,
+// going or changing status.
+func (h *Hub) publishPresence(userID string, online bool) {
+	if h.cluster == nil {
+		return
+	}
+	msg := clusterMessage{Kind: clusterPresence, UserID: userID, Online: online}
+	if online {
+		status := h.localStatus(userID)
+		msg.Status = &status
+	}
+	h.publish(msg)
+}
+
+// clusterSyncLoop announces this instance's online users, which also keeps
+// it alive in the others' eyes, and forgets instances that went quiet,
+// until StopCluster.
+func (h *Hub) clusterSyncLoop() {
+	ticker := time.NewTicker(clusterSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.clusterStop:
+			return
+		case <-ticker.C:
+		}
+		users := make(map[string]db.UserStatus)
+		h.users.each(func(userID string, _ map[*Client]bool) {
+			users[userID] = db.UserStatus{Status: db.StatusOnline}
+		})
+		for userID := range users {
+			users[userID] = h.localStatus(userID)
+		}
+		h.publish(clusterMessage{Kind: clusterSync, Users: users})
+
+		cutoff := time.Now().Add(-clusterNodeTimeout)
+		h.remote.mu.Lock()
+		for id, n := range h.remote.nodes {
+			if n.seen.Before(cutoff) {
+				slog.Warn("cluster node gone quiet", "node", id)
+				delete(h.remote.nodes, id)
+			}
+		}
+		h.remote.mu.Unlock()
+	}
+}
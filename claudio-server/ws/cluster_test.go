@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicebartender/claudio-server/db"
+)
+
+// memBroker links hubs in one process, delivering to every subscriber
+// synchronously like a broker that echoes our own messages.
+type memBroker struct {
+	mu       sync.Mutex
+	handlers []func([]byte)
+	closed   bool
+}
+
+func (b *memBroker) Publish(data []byte) error {
+	b.mu.Lock()
+	handlers := append([]func([]byte){}, b.handlers...)
+	b.mu.Unlock()
+	for _, h := range handlers {
+		h(data)
+	}
+	return nil
+}
+
+func (b *memBroker) Subscribe(handler func([]byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+func (b *memBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func TestStopClusterEndsSyncLoop(t *testing.T) {
+	broker := &memBroker{}
+	h := NewHub(nil)
+	h.StartCluster(broker)
+
+	done := make(chan struct{})
+	go func() {
+		h.clusterSyncLoop()
+		close(done)
+	}()
+	h.StopCluster()
+	h.StopCluster()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("clusterSyncLoop still running after StopCluster")
+	}
+	if !broker.closed {
+		t.Error("broker not closed")
+	}
+}
+
+func TestClusterBroadcastReachesOtherNodes(t *testing.T) {
+	broker := &memBroker{}
+	a, b := NewHub(nil), NewHub(nil)
+	a.StartCluster(broker)
+	b.StartCluster(broker)
+
+	onA := &Client{hub: a, send: make(chan []byte, 8), done: make(chan struct{})}
+	onB := &Client{hub: b, send: make(chan []byte, 8), done: make(chan struct{})}
+	a.SubscribeRoom("r1", onA)
+	b.SubscribeRoom("r1", onB)
+
+	a.BroadcastToRoom("r1", NewEvent("message.new", map[string]string{"content": "hi"}), nil)
+	for name, c := range map[string]*Client{"sender's node": onA, "other node": onB} {
+		select {
+		case data := <-c.send:
+			var ev RPCEvent
+			json.Unmarshal(data, &ev)
+			if ev.Event != "message.new" || ev.RoomID != "r1" || ev.Seq == 0 {
+				t.Errorf("%s got %s", name, data)
+			}
+		default:
+			t.Errorf("%s got nothing", name)
+		}
+		if len(c.send) != 0 {
+			t.Errorf("%s got the event more than once", name)
+		}
+	}
+}
+
+func TestClusterPresence(t *testing.T) {
+	broker := &memBroker{}
+	a, b := NewHub(nil), NewHub(nil)
+	a.StartCluster(broker)
+	b.StartCluster(broker)
+
+	// alice connects to a, without the DB work of userConnected
+	s := a.users.shard("alice")
+	s.mu.Lock()
+	s.clients["alice"] = map[*Client]bool{{}: true}
+	s.statuses["alice"] = db.UserStatus{Status: "away", Text: "lunch"}
+	s.mu.Unlock()
+	a.publishPresence("alice", true)
+
+	if !b.IsUserOnline("alice") {
+		t.Fatal("alice not online on the other node")
+	}
+	if status, ok := b.Status("alice"); !ok || status.Text != "lunch" {
+		t.Errorf("Status on the other node = %+v, %v", status, ok)
+	}
+
+	a.publishPresence("alice", false)
+	if b.IsUserOnline("alice") {
+		t.Error("alice still online after leaving")
+	}
+
+	// A node that stops syncing takes its users with it
+	a.publishPresence("alice", true)
+	b.remote.mu.Lock()
+	b.remote.nodes[a.NodeID].seen = time.Now().Add(-2 * clusterNodeTimeout)
+	b.remote.mu.Unlock()
+	if b.IsUserOnline("alice") {
+		t.Error("alice online through a node that went quiet")
+	}
+}
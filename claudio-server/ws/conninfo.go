@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ConnInfo describes an open connection, for devices.list and
+// admin.connections.
+type ConnInfo struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"userId,omitempty"`
+	DeviceID      string    `json:"deviceId,omitempty"`
+	Guest         bool      `json:"guest,omitempty"`
+	RemoteIP      string    `json:"remoteIp"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	Platform      string    `json:"platform,omitempty"`
+	ClientVersion string    `json:"clientVersion,omitempty"`
+	Protocol      int       `json:"protocol"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+}
+
+// SetUserAgent records the User-Agent of the upgrade request.
+func (c *Client) SetUserAgent(ua string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.userAgent = ua
+}
+
+// setClientInfo records what the client said about itself at connect.
+func (c *Client) setClientInfo(info *ConnectClient) {
+	if info == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.platform = info.Platform
+	c.clientVersion = info.Version
+}
+
+// Info returns the connection's metadata.
+func (c *Client) Info() ConnInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	protocol := c.protocol
+	if protocol == 0 {
+		protocol = CurrentProtocol
+	}
+	return ConnInfo{
+		ID:            c.connID,
+		UserID:        c.userID,
+		DeviceID:      c.deviceID,
+		Guest:         c.isGuest,
+		RemoteIP:      c.remoteIP,
+		UserAgent:     c.userAgent,
+		Platform:      c.platform,
+		ClientVersion: c.clientVersion,
+		Protocol:      protocol,
+		ConnectedAt:   c.connectedAt,
+	}
+}
+
+// Log returns a logger carrying the connection's ID, address and, once
+// known, user and platform, so its log lines can be told apart.
+func (c *Client) Log() *slog.Logger {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	args := []any{"conn", c.connID, "ip", c.remoteIP}
+	if c.userID != "" {
+		args = append(args, "userID", c.userID)
+	}
+	if c.platform != "" {
+		args = append(args, "platform", c.platform)
+	}
+	return slog.With(args...)
+}
+
+// Connections returns this instance's open connections, or just userID's
+// if it isn't empty, oldest first.
+func (h *Hub) Connections(userID string) []ConnInfo {
+	var conns []*Client
+	if userID != "" {
+		conns = h.userConns(userID)
+	} else {
+		conns = h.conns.all()
+	}
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		infos = append(infos, c.Info())
+	}
+	slices.SortFunc(infos, func(a, b ConnInfo) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+	return infos
+}
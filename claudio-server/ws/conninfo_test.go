@@ -0,0 +1,28 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnections(t *testing.T) {
+	h := NewHub(nil)
+	now := time.Now()
+	newer := &Client{hub: h, done: make(chan struct{}), connID: "b", connectedAt: now}
+	older := &Client{hub: h, done: make(chan struct{}), connID: "a", connectedAt: now.Add(-time.Minute)}
+	h.Register(newer)
+	h.Register(older)
+	older.SetUserAgent("Claudio/1.0")
+	older.setClientInfo(&ConnectClient{Platform: "ios", Version: "1.0"})
+
+	conns := h.Connections("")
+	if len(conns) != 2 || conns[0].ID != "a" || conns[1].ID != "b" {
+		t.Fatalf("Connections = %+v, want a then b", conns)
+	}
+	if c := conns[0]; c.UserAgent != "Claudio/1.0" || c.Platform != "ios" || c.Protocol != CurrentProtocol {
+		t.Errorf("metadata = %+v", c)
+	}
+	if conns := h.Connections("alice"); len(conns) != 0 {
+		t.Errorf("alice has %d connections, want 0", len(conns))
+	}
+}
@@ -0,0 +1,184 @@
+package ws
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+// newTestClient is a connection without a socket; its drainer stands in
+// for WritePump.
+func newTestClient(h *Hub) *Client {
+	c := &Client{hub: h, send: make(chan []byte, 256), wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case <-c.send:
+			case <-c.wake:
+				c.takeOverflow()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func TestHubConcurrentConnects(t *testing.T) {
+	h := NewHub(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			roomID := fmt.Sprintf("room-%d", i%5)
+			for j := 0; j < 20; j++ {
+				c := newTestClient(h)
+				h.Register(c)
+				h.SubscribeRoom(roomID, c)
+				h.BroadcastToRoom(roomID, NewEvent("tick", nil), nil)
+				h.IsUserOnline("alice")
+				h.OnlineCounts()
+				h.unregister(c)
+				h.unregister(c) // the second is a no-op
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 5; i++ {
+		if subs := h.rooms.snapshot(fmt.Sprintf("room-%d", i)); len(subs) != 0 {
+			t.Errorf("room-%d still has %d subscribers", i, len(subs))
+		}
+	}
+}
+
+func TestSubscribeAfterUnregister(t *testing.T) {
+	h := NewHub(nil)
+	c := newTestClient(h)
+	h.unregister(c)
+	h.SubscribeRoom("r1", c)
+	if h.IsClientSubscribed("r1", c) {
+		t.Error("closed connection stayed subscribed")
+	}
+}
+
+func TestSubscribeUserSkipsManualConnections(t *testing.T) {
+	h := NewHub(nil)
+	auto, manual := newTestClient(h), newTestClient(h)
+	manual.setManualSubscribe(true)
+	s := h.users.shard("alice")
+	s.mu.Lock()
+	s.clients["alice"] = map[*Client]bool{auto: true, manual: true}
+	s.mu.Unlock()
+
+	h.SubscribeUser("r1", "alice")
+	if !h.IsClientSubscribed("r1", auto) {
+		t.Error("auto-subscribing connection wasn't subscribed")
+	}
+	if h.IsClientSubscribed("r1", manual) {
+		t.Error("connection with autoSubscribe off was subscribed")
+	}
+
+	h.SubscribeRoom("r2", manual)
+	h.UnsubscribeRoom("r2", manual)
+	if rooms := manual.Rooms(); len(rooms) != 0 {
+		t.Errorf("Rooms() = %v after unsubscribing", rooms)
+	}
+}
+
+func TestBroadcastExclusion(t *testing.T) {
+	h := NewHub(nil)
+	h.BatchInterval = 0
+	phone := &Client{hub: h, send: make(chan []byte, 8), done: make(chan struct{}), userID: "alice"}
+	laptop := &Client{hub: h, send: make(chan []byte, 8), done: make(chan struct{}), userID: "alice"}
+	bob := &Client{hub: h, send: make(chan []byte, 8), done: make(chan struct{}), userID: "bob"}
+	for _, c := range []*Client{phone, laptop, bob} {
+		h.SubscribeRoom("r1", c)
+	}
+
+	// The sending connection alone is left out
+	h.BroadcastToRoom("r1", NewEvent("room.message", nil), phone)
+	if len(phone.send) != 0 || len(laptop.send) != 1 || len(bob.send) != 1 {
+		t.Errorf("exclude: phone %d, laptop %d, bob %d", len(phone.send), len(laptop.send), len(bob.send))
+	}
+
+	// All of alice's connections are, and stay so on replay
+	event := NewEvent("room.typing", nil)
+	event.ExcludeUser = "alice"
+	h.BroadcastToRoom("r1", event, nil)
+	if len(phone.send) != 0 || len(laptop.send) != 1 || len(bob.send) != 2 {
+		t.Errorf("ExcludeUser: phone %d, laptop %d, bob %d", len(phone.send), len(laptop.send), len(bob.send))
+	}
+	seq := h.replay.room("r1").seq
+	h.UnsubscribeRoom("r1", phone)
+	h.ResumeRoom("r1", phone, seq-1)
+	if len(phone.send) != 0 {
+		t.Error("ExcludeUser event replayed to the excluded user")
+	}
+}
+
+// subscribeClients connects n clients spread over rooms, with the
+// per-connection logging silenced.
+func subscribeClients(b *testing.B, h *Hub, n, rooms int) {
+	b.Helper()
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(prev) })
+	for i := 0; i < n; i++ {
+		c := newTestClient(h)
+		h.SubscribeRoom(fmt.Sprintf("room-%d", i%rooms), c)
+		b.Cleanup(func() { h.unregister(c) })
+	}
+}
+
+func BenchmarkBroadcastToRoom10k(b *testing.B) {
+	h := NewHub(nil)
+	subscribeClients(b, h, 10000, 1)
+	event := NewEvent("message.new", map[string]string{"content": "hello"})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.BroadcastToRoom("room-0", event, nil)
+	}
+	b.ReportMetric(float64(b.N)*10000/b.Elapsed().Seconds(), "deliveries/s")
+}
+
+func BenchmarkBroadcastParallel10k(b *testing.B) {
+	h := NewHub(nil)
+	subscribeClients(b, h, 10000, 100)
+	event := NewEvent("message.new", map[string]string{"content": "hello"})
+
+	b.ResetTimer()
+	var next sync.Mutex
+	room := 0
+	b.RunParallel(func(pb *testing.PB) {
+		next.Lock()
+		roomID := fmt.Sprintf("room-%d", room%100)
+		room++
+		next.Unlock()
+		for pb.Next() {
+			h.BroadcastToRoom(roomID, event, nil)
+		}
+	})
+	b.ReportMetric(float64(b.N)*100/b.Elapsed().Seconds(), "deliveries/s")
+}
+
+func BenchmarkConnectChurn(b *testing.B) {
+	h := NewHub(nil)
+	subscribeClients(b, h, 10000, 100)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c := newTestClient(h)
+			h.Register(c)
+			h.SubscribeRoom(fmt.Sprintf("room-%d", i%100), c)
+			h.unregister(c)
+			i++
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package ws
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nicebartender/claudio-server/db"
+)
+
+// indexShards splits the hub's room and user indexes so connects,
+// disconnects and broadcasts in different rooms don't queue on one lock.
+const indexShards = 64
+
+func shardOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % indexShards)
+}
+
+// roomIndex is roomID -> subscribed clients.
+type roomIndex struct {
+	shards [indexShards]roomShard
+}
+
+type roomShard struct {
+	mu   sync.RWMutex
+	subs map[string]map[*Client]bool
+}
+
+func newRoomIndex() *roomIndex {
+	idx := &roomIndex{}
+	for i := range idx.shards {
+		idx.shards[i].subs = make(map[string]map[*Client]bool)
+	}
+	return idx
+}
+
+func (idx *roomIndex) add(roomID string, client *Client) {
+	s := &idx.shards[shardOf(roomID)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs[roomID] == nil {
+		s.subs[roomID] = make(map[*Client]bool)
+	}
+	s.subs[roomID][client] = true
+}
+
+func (idx *roomIndex) remove(roomID string, client *Client) {
+	s := &idx.shards[shardOf(roomID)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subs, ok := s.subs[roomID]; ok {
+		delete(subs, client)
+		if len(subs) == 0 {
+			delete(s.subs, roomID)
+		}
+	}
+}
+
+func (idx *roomIndex) drop(roomID string) {
+	s := &idx.shards[shardOf(roomID)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, roomID)
+}
+
+func (idx *roomIndex) has(roomID string, client *Client) bool {
+	s := &idx.shards[shardOf(roomID)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subs[roomID][client]
+}
+
+// count returns how many rooms have subscribers.
+func (idx *roomIndex) count() int {
+	n := 0
+	for i := range idx.shards {
+		s := &idx.shards[i]
+		s.mu.RLock()
+		n += len(s.subs)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// snapshot returns the room's subscribers at this moment.
+func (idx *roomIndex) snapshot(roomID string) []*Client {
+	s := &idx.shards[shardOf(roomID)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := make([]*Client, 0, len(s.subs[roomID]))
+	for c := range s.subs[roomID] {
+		subs = append(subs, c)
+	}
+	return subs
+}
+
+// userIndex is userID -> authenticated connections, plus the presence
+// status of online users. Callers lock the user's shard themselves when
+// they need both at once.
+type userIndex struct {
+	shards [indexShards]userShard
+}
+
+type userShard struct {
+	mu       sync.RWMutex
+	clients  map[string]map[*Client]bool
+	statuses map[string]db.UserStatus // non-default presence of online users
+}
+
+func newUserIndex() *userIndex {
+	idx := &userIndex{}
+	for i := range idx.shards {
+		idx.shards[i].clients = make(map[string]map[*Client]bool)
+		idx.shards[i].statuses = make(map[string]db.UserStatus)
+	}
+	return idx
+}
+
+func (idx *userIndex) shard(userID string) *userShard {
+	return &idx.shards[shardOf(userID)]
+}
+
+// each calls fn with every user's connections, one shard locked at a time.
+func (idx *userIndex) each(fn func(userID string, clients map[*Client]bool)) {
+	for i := range idx.shards {
+		s := &idx.shards[i]
+		s.mu.RLock()
+		for userID, clients := range s.clients {
+			fn(userID, clients)
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// connIndex is every open connection, authenticated or not, spread over
+// shards round-robin.
+type connIndex struct {
+	next   atomic.Uint64
+	shards [indexShards]connShard
+}
+
+type connShard struct {
+	mu    sync.Mutex
+	conns map[*Client]bool
+}
+
+func newConnIndex() *connIndex {
+	idx := &connIndex{}
+	for i := range idx.shards {
+		idx.shards[i].conns = make(map[*Client]bool)
+	}
+	return idx
+}
+
+func (idx *connIndex) add(c *Client) {
+	c.connShard = int(idx.next.Add(1) % indexShards)
+	s := &idx.shards[c.connShard]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[c] = true
+}
+
+func (idx *connIndex) remove(c *Client) {
+	s := &idx.shards[c.connShard]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+func (idx *connIndex) count() int {
+	n := 0
+	for i := range idx.shards {
+		s := &idx.shards[i]
+		s.mu.Lock()
+		n += len(s.conns)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func (idx *connIndex) all() []*Client {
+	var conns []*Client
+	for i := range idx.shards {
+		s := &idx.shards[i]
+		s.mu.Lock()
+		for c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+	}
+	return conns
+}
+
+// joinRoom and leaveRoom track the client's own subscriptions, so closing
+// it touches only the shards of its rooms.
+func (c *Client) joinRoom(roomID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rooms == nil {
+		c.rooms = make(map[string]bool)
+	}
+	c.rooms[roomID] = true
+}
+
+func (c *Client) leaveRoom(roomID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rooms, roomID)
+}
+
+// takeRooms clears and returns the client's subscriptions.
+func (c *Client) takeRooms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		rooms = append(rooms, roomID)
+	}
+	c.rooms = nil
+	return rooms
+}
+
+func (c *Client) setManualSubscribe(manual bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.manualRooms = manual
+}
+
+// ManualSubscribe reports whether the connection picks its rooms with
+// rooms.subscribe instead of following every room the user is in.
+func (c *Client) ManualSubscribe() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.manualRooms
+}
+
+// Rooms returns the rooms the connection is subscribed to.
+func (c *Client) Rooms() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		rooms = append(rooms, roomID)
+	}
+	return rooms
+}
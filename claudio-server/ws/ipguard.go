@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultIPConnectLimit limits WebSocket upgrades per remote IP, enough for
+// a household of clients reconnecting but not for a reconnect storm.
+var DefaultIPConnectLimit = RateLimit{PerSecond: 0.5, Burst: 20}
+
+// ParseIPBan turns an address or CIDR range into a network; a single
+// address becomes a /32 (or /128 for IPv6).
+func ParseIPBan(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// IPBanList is the in-memory copy of the banned ranges that every upgrade
+// is checked against. The database is the source of truth, see
+// Hub.ReloadIPBans.
+type IPBanList struct {
+	mu   sync.RWMutex
+	nets []*net.IPNet
+}
+
+func (l *IPBanList) set(nets []*net.IPNet) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nets = nets
+}
+
+// Banned reports whether ip falls in any banned range.
+func (l *IPBanList) Banned(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if l == nil || parsed == nil {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, n := range l.nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReloadIPBans refreshes IPBans from the database.
+func (h *Hub) ReloadIPBans() error {
+	bans, err := h.DB.ListIPBans()
+	if err != nil {
+		return err
+	}
+	nets := make([]*net.IPNet, 0, len(bans))
+	for _, b := range bans {
+		n, err := ParseIPBan(b.CIDR)
+		if err != nil {
+			slog.Warn("skipping bad ip ban", "cidr", b.CIDR, "err", err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	h.IPBans.set(nets)
+	return nil
+}
+
+// AdmitIP decides whether a WebSocket upgrade from ip may go ahead: banned
+// addresses are refused, and each address gets IPConnects upgrades.
+func (h *Hub) AdmitIP(ip string) (retryAfter time.Duration, banned bool) {
+	if h.IPBans.Banned(ip) {
+		return 0, true
+	}
+	retryAfter, first := h.IPConnects.Allow(ip)
+	if retryAfter > 0 && first {
+		slog.Warn("connects throttled", "ip", ip)
+	}
+	return retryAfter, false
+}
+
+// DisconnectIP closes the authenticated connections coming from ipNet and
+// returns how many there were on this instance.
+func (h *Hub) DisconnectIP(ipNet *net.IPNet) int {
+	h.publish(clusterMessage{Kind: clusterDisconnectIP, CIDR: ipNet.String()})
+	return h.disconnectIPLocal(ipNet)
+}
+
+func (h *Hub) disconnectIPLocal(ipNet *net.IPNet) int {
+	var conns []*Client
+	h.users.each(func(_ string, clients map[*Client]bool) {
+		for c := range clients {
+			if ip := net.ParseIP(c.RemoteIP()); ip != nil && ipNet.Contains(ip) {
+				conns = append(conns, c)
+			}
+		}
+	})
+
+	for _, c := range conns {
+		c.closeWith(CloseRevoked)
+	}
+	return len(conns)
+}
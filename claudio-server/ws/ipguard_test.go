@@ -0,0 +1,53 @@
+package ws
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPBanList(t *testing.T) {
+	var nets []*net.IPNet
+	for _, s := range []string{"203.0.113.7", "198.51.100.0/24", "2001:db8::/32"} {
+		n, err := ParseIPBan(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	if nets[0].String() != "203.0.113.7/32" {
+		t.Errorf("single address normalized to %s, want 203.0.113.7/32", nets[0])
+	}
+	if _, err := ParseIPBan("not-an-ip"); err == nil {
+		t.Error("ParseIPBan accepted garbage")
+	}
+
+	list := &IPBanList{}
+	list.set(nets)
+	for ip, want := range map[string]bool{
+		"203.0.113.7":   true,
+		"203.0.113.8":   false,
+		"198.51.100.99": true,
+		"2001:db8::1":   true,
+		"2001:db9::1":   false,
+		"":              false,
+	} {
+		if got := list.Banned(ip); got != want {
+			t.Errorf("Banned(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestAdmitIPThrottlesReconnects(t *testing.T) {
+	h := &Hub{IPBans: &IPBanList{}, IPConnects: NewRateLimiter(RateLimit{PerSecond: 1, Burst: 2})}
+	for i := 0; i < 2; i++ {
+		if wait, banned := h.AdmitIP("192.0.2.1"); wait > 0 || banned {
+			t.Fatalf("connect %d refused", i+1)
+		}
+	}
+	if wait, _ := h.AdmitIP("192.0.2.1"); wait == 0 {
+		t.Error("third connect in a row was not throttled")
+	}
+	if wait, _ := h.AdmitIP("192.0.2.2"); wait > 0 {
+		t.Error("another address was throttled")
+	}
+}
@@ -0,0 +1,198 @@
+package ws
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtLeeway absorbs clock skew between the identity provider and us.
+const jwtLeeway = time.Minute
+
+// JWTVerifier checks tokens from an existing identity provider, for the
+// connect mode where auth.jwt replaces the device signature. Tokens are
+// signed with HMACSecret (HS256) or one of PublicKeys (RS256, ES256, EdDSA).
+type JWTVerifier struct {
+	Issuer     string // required iss, if set
+	Audience   string // required in aud, if set
+	HMACSecret []byte
+	PublicKeys []crypto.PublicKey
+	UserClaim  string // claim with the stable user identifier; default "sub"
+	NameClaim  string // claim with the display name; default "name"
+}
+
+// JWTIdentity is who a verified token speaks for.
+type JWTIdentity struct {
+	UserID      string // stable account ID derived from issuer and subject
+	Subject     string
+	DisplayName string
+}
+
+// LoadJWTPublicKeys reads every PEM public key (PKIX) in path.
+func LoadJWTPublicKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse JWT public key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM public keys in %s", path)
+	}
+	return keys, nil
+}
+
+// JWTUserID maps an identity provider's subject to an account ID. It is
+// stable across tokens and can't collide with device-derived IDs.
+func JWTUserID(issuer, subject string) string {
+	sum := sha256.Sum256([]byte(issuer + "\x00" + subject))
+	return "jwt-" + hex.EncodeToString(sum[:16])
+}
+
+// Verify checks token's signature and claims at now.
+func (v *JWTVerifier) Verify(token string, now time.Time) (*JWTIdentity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if !v.verifySignature(header.Alg, parts[0]+"."+parts[1], sig) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	iss, _ := claims["iss"].(string)
+	if v.Issuer != "" && iss != v.Issuer {
+		return nil, errors.New("wrong token issuer")
+	}
+	if v.Audience != "" && !jwtAudienceHas(claims["aud"], v.Audience) {
+		return nil, errors.New("wrong token audience")
+	}
+
+	userClaim := v.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	nameClaim := v.NameClaim
+	if nameClaim == "" {
+		nameClaim = "name"
+	}
+	subject, _ := claims[userClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("token has no %s claim", userClaim)
+	}
+	name, _ := claims[nameClaim].(string)
+	return &JWTIdentity{
+		UserID:      JWTUserID(iss, subject),
+		Subject:     subject,
+		DisplayName: name,
+	}, nil
+}
+
+func (v *JWTVerifier) verifySignature(alg, signed string, sig []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "HS256":
+		if len(v.HMACSecret) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, v.HMACSecret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(sig, mac.Sum(nil))
+	case "RS256":
+		for _, k := range v.PublicKeys {
+			if rk, ok := k.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(rk, crypto.SHA256, digest[:], sig) == nil {
+				return true
+			}
+		}
+	case "ES256":
+		if len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		for _, k := range v.PublicKeys {
+			if ek, ok := k.(*ecdsa.PublicKey); ok && ecdsa.Verify(ek, digest[:], r, s) {
+				return true
+			}
+		}
+	case "EdDSA":
+		for _, k := range v.PublicKeys {
+			if ek, ok := k.(ed25519.PublicKey); ok && ed25519.Verify(ek, []byte(signed), sig) {
+				return true
+			}
+		}
+	}
+	// Anything else, "none" included, is refused
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwtAudienceHas reports whether aud, a string or a list of them,
+// contains want.
+func jwtAudienceHas(aud interface{}, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []interface{}:
+		for _, v := range a {
+			if s, _ := v.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
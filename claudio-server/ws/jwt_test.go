@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(string) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func TestJWTVerifier(t *testing.T) {
+	secret := []byte("s3cret")
+	hs256 := func(s string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(s))
+		return mac.Sum(nil)
+	}
+	now := time.Now()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": "https://id.example", "aud": []string{"claudio"}, "sub": "u-42", "name": "Ada", "exp": now.Add(time.Hour).Unix()}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	v := &JWTVerifier{Issuer: "https://id.example", Audience: "claudio", HMACSecret: secret}
+
+	id, err := v.Verify(signTestJWT(t, "HS256", claims(nil), hs256), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "u-42" || id.DisplayName != "Ada" || id.UserID != JWTUserID("https://id.example", "u-42") {
+		t.Errorf("identity = %+v", id)
+	}
+
+	for name, token := range map[string]string{
+		"expired":        signTestJWT(t, "HS256", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}), hs256),
+		"wrong issuer":   signTestJWT(t, "HS256", claims(map[string]interface{}{"iss": "https://evil.example"}), hs256),
+		"wrong audience": signTestJWT(t, "HS256", claims(map[string]interface{}{"aud": "other"}), hs256),
+		"no subject":     signTestJWT(t, "HS256", claims(map[string]interface{}{"sub": ""}), hs256),
+		"alg none":       signTestJWT(t, "none", claims(nil), func(string) []byte { return nil }),
+		"bad signature":  signTestJWT(t, "HS256", claims(nil), func(s string) []byte { return hs256(s + "x") }),
+	} {
+		if _, err := v.Verify(token, now); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// Asymmetric keys; an HMAC token can't pass as a public-key one
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	v = &JWTVerifier{PublicKeys: []crypto.PublicKey{pub}}
+	token := signTestJWT(t, "EdDSA", claims(nil), func(s string) []byte { return ed25519.Sign(priv, []byte(s)) })
+	if _, err := v.Verify(token, now); err != nil {
+		t.Errorf("EdDSA token: %v", err)
+	}
+	if _, err := v.Verify(signTestJWT(t, "HS256", claims(nil), hs256), now); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("HS256 token without a secret: err = %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package ws
+
+import "time"
+
+// DefaultTickInterval is how often an idle connection gets a tick event.
+const DefaultTickInterval = 15 * time.Second
+
+// maxTickInterval caps what a client may ask for with connect's
+// tickIntervalMs, so its liveness check still notices a dead server.
+const maxTickInterval = time.Minute
+
+// tickInterval is the connection's tick interval: Hub.TickInterval, or
+// longer if the client asked for it at connect.
+func (h *Hub) tickInterval(requestedMs int64) time.Duration {
+	interval := h.TickInterval
+	if requested := time.Duration(requestedMs) * time.Millisecond; requested > interval {
+		interval = min(requested, maxTickInterval)
+	}
+	return interval
+}
+
+func (c *Client) setTickInterval(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tick = d
+}
+
+func (c *Client) tickEvery() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tick
+}
+
+// markWritten records that WritePump just put a message on the wire.
+func (c *Client) markWritten(now time.Time) {
+	c.lastWrite.Store(now.UnixNano())
+}
+
+// needsTick reports whether the connection has been quiet for interval: no
+// message written and no batch about to go out. Anything else already
+// shows the client the server is alive.
+func (c *Client) needsTick(interval time.Duration, now time.Time) bool {
+	if now.Sub(time.Unix(0, c.lastWrite.Load())) < interval {
+		return false
+	}
+	c.batchMu.Lock()
+	pending := len(c.batch) > 0
+	c.batchMu.Unlock()
+	return !pending
+}
@@ -0,0 +1,45 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNeedsTick(t *testing.T) {
+	h := NewHub(nil)
+	c := newTestClient(h)
+	now := time.Now()
+	if !c.needsTick(time.Second, now) {
+		t.Error("connection that never wrote doesn't need a tick")
+	}
+	c.markWritten(now.Add(-500 * time.Millisecond))
+	if c.needsTick(time.Second, now) {
+		t.Error("tick sent despite recent traffic")
+	}
+	if !c.needsTick(time.Second, now.Add(time.Second)) {
+		t.Error("quiet connection skipped its tick")
+	}
+
+	c.batch = [][]byte{[]byte(`{}`)}
+	if c.needsTick(time.Second, now.Add(time.Minute)) {
+		t.Error("tick sent with a batch about to go out")
+	}
+}
+
+func TestTickIntervalRequest(t *testing.T) {
+	h := NewHub(nil)
+	tests := []struct {
+		requestedMs int64
+		want        time.Duration
+	}{
+		{0, DefaultTickInterval},
+		{1000, DefaultTickInterval}, // faster than the server's isn't allowed
+		{30000, 30 * time.Second},
+		{600000, maxTickInterval},
+	}
+	for _, tt := range tests {
+		if got := h.tickInterval(tt.requestedMs); got != tt.want {
+			t.Errorf("tickInterval(%d) = %v, want %v", tt.requestedMs, got, tt.want)
+		}
+	}
+}
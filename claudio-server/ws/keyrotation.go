@@ -0,0 +1,44 @@
+package ws
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// KeyRotationPayload is what the old key signs to hand a device over to a
+// new key: "v1|rotate|<old device ID>|<new public key>|<signedAt ms>".
+func KeyRotationPayload(oldDeviceID, newPublicKey string, signedAt int64) string {
+	return "v1|rotate|" + oldDeviceID + "|" + newPublicKey + "|" + strconv.FormatInt(signedAt, 10)
+}
+
+// VerifyKeyRotation checks that oldPublicKey belongs to oldDeviceID and
+// signed the switch to newPublicKey within the last five minutes. It returns
+// the new key's device ID.
+func VerifyKeyRotation(oldDeviceID, oldPublicKey, newPublicKey, signature string, signedAt int64) (string, error) {
+	if id, err := DeviceIDForKey(oldPublicKey); err != nil || id != oldDeviceID {
+		return "", fmt.Errorf("old public key does not match this device")
+	}
+	newDeviceID, err := DeviceIDForKey(newPublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid new public key")
+	}
+	if newDeviceID == oldDeviceID {
+		return "", fmt.Errorf("new key is the current key")
+	}
+	if math.Abs(time.Since(time.UnixMilli(signedAt)).Seconds()) > 300 {
+		return "", fmt.Errorf("signature expired")
+	}
+
+	oldKey, _ := base64URLDecode(oldPublicKey)
+	sig, err := base64URLDecode(signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature encoding")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(oldKey), []byte(KeyRotationPayload(oldDeviceID, newPublicKey, signedAt)), sig) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	return newDeviceID, nil
+}
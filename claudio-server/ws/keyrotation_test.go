@@ -0,0 +1,38 @@
+package ws
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestVerifyKeyRotation(t *testing.T) {
+	oldPub, oldPriv, _ := ed25519.GenerateKey(rand.Reader)
+	newPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	oldKey := base64.RawURLEncoding.EncodeToString(oldPub)
+	newKey := base64.RawURLEncoding.EncodeToString(newPub)
+	oldID, _ := DeviceIDForKey(oldKey)
+	wantID, _ := DeviceIDForKey(newKey)
+
+	signedAt := time.Now().UnixMilli()
+	sign := func(payload string) string {
+		return base64.RawURLEncoding.EncodeToString(ed25519.Sign(oldPriv, []byte(payload)))
+	}
+	sig := sign(KeyRotationPayload(oldID, newKey, signedAt))
+
+	if got, err := VerifyKeyRotation(oldID, oldKey, newKey, sig, signedAt); err != nil || got != wantID {
+		t.Fatalf("VerifyKeyRotation = %q, %v; want %q", got, err, wantID)
+	}
+	if _, err := VerifyKeyRotation(wantID, oldKey, newKey, sig, signedAt); err == nil {
+		t.Errorf("accepted an old key that isn't the connection's device")
+	}
+	if _, err := VerifyKeyRotation(oldID, oldKey, newKey, sig, signedAt+1); err == nil {
+		t.Errorf("accepted a signature over a different timestamp")
+	}
+	stale := time.Now().Add(-time.Hour).UnixMilli()
+	if _, err := VerifyKeyRotation(oldID, oldKey, newKey, sign(KeyRotationPayload(oldID, newKey, stale)), stale); err == nil {
+		t.Errorf("accepted a stale signature")
+	}
+}
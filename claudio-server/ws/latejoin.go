@@ -0,0 +1,54 @@
+package ws
+
+import "time"
+
+// DefaultLateJoinEvents is how many of a room's latest events a client that
+// just joined it gets, see SubscribeRoomRecent.
+const DefaultLateJoinEvents = 20
+
+// lateJoinWindow is how recent those events must be: a late joiner catches
+// the burst in progress, and rooms.history covers anything older.
+const lateJoinWindow = time.Minute
+
+// recent returns up to n of the events logged since cutoff, oldest first.
+// The caller holds rl.mu.
+func (rl *roomLog) recent(n int, cutoff time.Time) []loggedEvent {
+	i := len(rl.events)
+	for i > 0 && len(rl.events)-i < n && rl.events[i-1].at.After(cutoff) {
+		i--
+	}
+	return rl.events[i:]
+}
+
+// SubscribeRoomRecent is SubscribeRoom for a client that has just joined a
+// room: it also gets the room's last Hub.LateJoinEvents events from the
+// past minute, so it doesn't come in mid-conversation. Already subscribed
+// clients get nothing again.
+func (h *Hub) SubscribeRoomRecent(roomID string, client *Client) {
+	if h.LateJoinEvents <= 0 || h.IsClientSubscribed(roomID, client) {
+		h.SubscribeRoom(roomID, client)
+		return
+	}
+	rl := h.replay.room(roomID)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Under the log lock no broadcast can slip between the two
+	h.SubscribeRoom(roomID, client)
+	for _, e := range rl.recent(h.LateJoinEvents, time.Now().Add(-lateJoinWindow)) {
+		client.sendLogged(e)
+	}
+}
+
+// sendLogged sends a logged room event in the client's protocol version,
+// unless it was meant to skip the client.
+func (c *Client) sendLogged(e loggedEvent) {
+	if e.event.skips(c) {
+		return
+	}
+	if version := c.Protocol(); version != CurrentProtocol {
+		c.sendRaw(newEventEncodings(e.event, e.data).forVersion(version))
+		return
+	}
+	c.sendRaw(e.data)
+}
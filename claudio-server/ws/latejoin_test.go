@@ -0,0 +1,37 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeRoomRecent(t *testing.T) {
+	h := NewHub(nil)
+	h.BatchInterval = 0
+	h.LateJoinEvents = 3
+	for i := 0; i < 5; i++ {
+		h.BroadcastToRoom("r1", NewEvent("room.message", nil), nil)
+	}
+	rl := h.replay.room("r1")
+	rl.events[0].at = time.Now().Add(-time.Hour)
+
+	late := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{})}
+	h.SubscribeRoomRecent("r1", late)
+	if len(late.send) != 3 {
+		t.Fatalf("late joiner got %d events, want 3", len(late.send))
+	}
+	h.SubscribeRoomRecent("r1", late)
+	if len(late.send) != 3 {
+		t.Errorf("subscribing again resent events")
+	}
+
+	// Events older than the window aren't a burst in progress
+	for i := range rl.events {
+		rl.events[i].at = time.Now().Add(-time.Hour)
+	}
+	quiet := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{})}
+	h.SubscribeRoomRecent("r1", quiet)
+	if len(quiet.send) != 0 {
+		t.Errorf("got %d stale events", len(quiet.send))
+	}
+}
@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsCollector receives the hub's metrics from CollectMetrics. A
+// metrics exporter implements it and calls CollectMetrics when scraped.
+type MetricsCollector interface {
+	// Counter reports a value that only grows while the server runs.
+	Counter(name, help string, value int64)
+	// Gauge reports a value at this moment.
+	Gauge(name, help string, value float64)
+}
+
+// hubCounters are the hub's own counters; delivery problems live in
+// sendCounters.
+type hubCounters struct {
+	broadcasts atomic.Int64 // BroadcastToRoom calls delivered here
+	deliveries atomic.Int64 // events queued to subscribers by them
+	panics     atomic.Int64 // messages whose handler panicked, see recoverRequest
+
+	// The last CollectMetrics, for the broadcast rate
+	mu          sync.Mutex
+	lastCollect time.Time
+	lastTotal   int64
+}
+
+// broadcastRate returns broadcasts per second since the previous call.
+func (m *hubCounters) broadcastRate(now time.Time) float64 {
+	total := m.broadcasts.Load()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rate float64
+	if elapsed := now.Sub(m.lastCollect).Seconds(); !m.lastCollect.IsZero() && elapsed > 0 {
+		rate = float64(total-m.lastTotal) / elapsed
+	}
+	m.lastCollect, m.lastTotal = now, total
+	return rate
+}
+
+// CollectMetrics reports the hub's counters and gauges to c. The broadcast
+// rate covers the time since the previous call, so one exporter should
+// own it.
+func (h *Hub) CollectMetrics(c MetricsCollector) {
+	users, authenticated := h.OnlineCounts()
+	c.Gauge("ws_connections", "Open WebSocket connections", float64(h.conns.count()))
+	c.Gauge("ws_authenticated_connections", "Connections that completed connect", float64(authenticated))
+	c.Gauge("ws_online_users", "Users with at least one connection here", float64(users))
+	c.Gauge("ws_active_rooms", "Rooms with at least one subscriber here", float64(h.rooms.count()))
+	c.Gauge("ws_broadcast_rate", "Room broadcasts per second since the last collection", h.metrics.broadcastRate(time.Now()))
+
+	c.Counter("ws_broadcasts_total", "Room broadcasts delivered", h.metrics.broadcasts.Load())
+	c.Counter("ws_deliveries_total", "Broadcast events queued to subscribers", h.metrics.deliveries.Load())
+	c.Counter("ws_handler_panics_total", "Messages whose handler panicked", h.metrics.panics.Load())
+	sends := h.SendStats()
+	c.Counter("ws_send_dropped_total", "Messages dropped from full send queues", sends.Dropped)
+	c.Counter("ws_send_resyncs_total", "sync.required sent to slow consumers", sends.Resyncs)
+	c.Counter("ws_slow_disconnects_total", "Connections closed for not keeping up", sends.Disconnects)
+}
@@ -0,0 +1,43 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeCollector map[string]float64
+
+func (f fakeCollector) Counter(name, _ string, value int64) { f[name] = float64(value) }
+func (f fakeCollector) Gauge(name, _ string, value float64) { f[name] = value }
+
+func TestCollectMetrics(t *testing.T) {
+	h := NewHub(nil)
+	h.BatchInterval = 0
+	for i := 0; i < 3; i++ {
+		c := newTestClient(h)
+		h.Register(c)
+		h.SubscribeRoom("r1", c)
+	}
+	h.CollectMetrics(fakeCollector{}) // starts the rate window
+
+	h.BroadcastToRoom("r1", NewEvent("tick", nil), nil)
+	h.BroadcastToRoom("r2", NewEvent("tick", nil), nil)
+	time.Sleep(10 * time.Millisecond)
+
+	m := fakeCollector{}
+	h.CollectMetrics(m)
+	want := map[string]float64{
+		"ws_connections":      3,
+		"ws_active_rooms":     1,
+		"ws_broadcasts_total": 2,
+		"ws_deliveries_total": 3,
+	}
+	for name, v := range want {
+		if m[name] != v {
+			t.Errorf("%s = %v, want %v", name, m[name], v)
+		}
+	}
+	if m["ws_broadcast_rate"] <= 0 {
+		t.Errorf("ws_broadcast_rate = %v, want > 0", m["ws_broadcast_rate"])
+	}
+}
@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultNonceTTL matches how long VerifyConnect accepts a signature.
+const DefaultNonceTTL = 5 * time.Minute
+
+// nonceStore tracks issued connect challenges. Each nonce can be consumed
+// once, before it expires; after that it is forgotten, so replaying a signed
+// connect fails even on the socket it was issued to.
+type nonceStore struct {
+	mu     sync.Mutex
+	issued map[string]time.Time // nonce -> expiry
+}
+
+func newNonceStore() *nonceStore {
+	return &nonceStore{issued: make(map[string]time.Time)}
+}
+
+func (s *nonceStore) issue(nonce string, ttl time.Duration) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issued[nonce] = now.Add(ttl)
+
+	if len(s.issued) > 1000 {
+		for n, exp := range s.issued {
+			if now.After(exp) {
+				delete(s.issued, n)
+			}
+		}
+	}
+}
+
+// consume reports whether nonce was issued, is unexpired and unused, and
+// marks it used.
+func (s *nonceStore) consume(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.issued[nonce]
+	if !ok {
+		return false
+	}
+	delete(s.issued, nonce)
+	return time.Now().Before(exp)
+}
+
+// forget drops a nonce that can no longer be used, e.g. when its socket
+// closes.
+func (s *nonceStore) forget(nonce string) {
+	s.mu.Lock()
+	delete(s.issued, nonce)
+	s.mu.Unlock()
+}
@@ -0,0 +1,31 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceSingleUse(t *testing.T) {
+	s := newNonceStore()
+	s.issue("a", time.Minute)
+	s.issue("old", -time.Second)
+
+	if !s.consume("a") {
+		t.Fatal("fresh nonce was rejected")
+	}
+	if s.consume("a") {
+		t.Error("nonce was accepted twice")
+	}
+	if s.consume("old") {
+		t.Error("expired nonce was accepted")
+	}
+	if s.consume("never-issued") {
+		t.Error("unknown nonce was accepted")
+	}
+
+	s.issue("b", time.Minute)
+	s.forget("b")
+	if s.consume("b") {
+		t.Error("forgotten nonce was accepted")
+	}
+}
@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// OriginChecker returns a CheckOrigin function for the WebSocket upgrader.
+// Requests without an Origin header come from native clients rather than
+// browsers and are always allowed, as are same-origin requests. Otherwise
+// the origin must match one of allowed: a full origin such as
+// "https://app.example.com" or a bare host, either of which may use *
+// wildcards ("https://*.example.com"). allowAny turns the check off.
+func OriginChecker(allowed []string, allowAny bool) func(r *http.Request) bool {
+	patterns := make([]string, 0, len(allowed))
+	for _, p := range allowed {
+		if p = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(p), "/")); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return func(r *http.Request) bool {
+		if allowAny {
+			return true
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		origin = strings.ToLower(u.Scheme + "://" + u.Host)
+		host := strings.ToLower(u.Host)
+		for _, p := range patterns {
+			target := host
+			if strings.Contains(p, "://") {
+				target = origin
+			}
+			if ok, _ := path.Match(p, target); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,41 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginChecker(t *testing.T) {
+	check := OriginChecker([]string{"https://app.example.com", "https://*.claudio.dev", "localhost:*"}, false)
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},                    // native client
+		{"https://server.test", true}, // same origin
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"http://app.example.com", false},
+		{"https://web.claudio.dev", true},
+		{"https://claudio.dev", false},
+		{"http://localhost:3000", true},
+		{"https://evil.test", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "https://server.test/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := check(r); got != tt.want {
+			t.Errorf("origin %q: allowed = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "https://server.test/ws", nil)
+	r.Header.Set("Origin", "https://evil.test")
+	if !OriginChecker(nil, true)(r) {
+		t.Errorf("allowAny did not allow a cross-site origin")
+	}
+}
@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"log/slog"
+
+	"github.com/nicebartender/claudio-server/db"
+)
+
+// SetStatus changes a connected user's presence status and tells their
+// rooms with room.presence. Unless persist is set, the status lasts until
+// the user's last connection closes.
+func (h *Hub) SetStatus(userID string, status db.UserStatus, persist bool) error {
+	var stored *db.UserStatus
+	if persist && status != (db.UserStatus{Status: db.StatusOnline}) {
+		stored = &status
+	}
+	if err := h.DB.SetUserStatus(userID, stored); err != nil {
+		return err
+	}
+
+	s := h.users.shard(userID)
+	s.mu.Lock()
+	if status == (db.UserStatus{Status: db.StatusOnline}) {
+		delete(s.statuses, userID)
+	} else {
+		s.statuses[userID] = status
+	}
+	online := len(s.clients[userID]) > 0
+	s.mu.Unlock()
+
+	if online {
+		h.publishPresence(userID, true)
+	}
+
+	h.broadcastStatus(userID, online, status)
+	return nil
+}
+
+// Status returns a user's presence status; offline users have none.
+func (h *Hub) Status(userID string) (status db.UserStatus, online bool) {
+	if h.isLocalUser(userID) {
+		return h.localStatus(userID), true
+	}
+	return h.remote.status(userID)
+}
+
+// localStatus is the status of a user connected to this instance.
+func (h *Hub) localStatus(userID string) db.UserStatus {
+	s := h.users.shard(userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if st, ok := s.statuses[userID]; ok {
+		return st
+	}
+	return db.UserStatus{Status: db.StatusOnline}
+}
+
+// restoreStatus loads a persisted status on a user's first connection.
+func (h *Hub) restoreStatus(userID string) {
+	status, err := h.DB.GetUserStatus(userID)
+	if err != nil {
+		slog.Error("load user status failed", "userID", userID, "err", err)
+		return
+	}
+	if status == nil {
+		return
+	}
+	s := h.users.shard(userID)
+	s.mu.Lock()
+	if _, ok := s.statuses[userID]; !ok {
+		s.statuses[userID] = *status
+	}
+	s.mu.Unlock()
+}
+
+func (h *Hub) broadcastStatus(userID string, online bool, status db.UserStatus) {
+	roomIDs, err := h.DB.UserRoomIDs(userID)
+	if err != nil {
+		slog.Error("list user rooms failed", "userID", userID, "err", err)
+		return
+	}
+	for _, roomID := range roomIDs {
+		h.BroadcastToRoom(roomID, NewEvent("room.presence", map[string]interface{}{
+			"roomId":        roomID,
+			"participantId": userID,
+			"userId":        userID,
+			"online":        online,
+			"status":        status.Status,
+			"statusText":    status.Text,
+			"statusEmoji":   status.Emoji,
+		}), nil)
+	}
+}
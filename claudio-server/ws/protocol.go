@@ -1,6 +1,9 @@
 package ws
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+)
 
 // RPCMessage is the type-peek for incoming messages
 type RPCMessage struct {
@@ -9,6 +12,11 @@ type RPCMessage struct {
 	Method string          `json:"method,omitempty"`
 	Params json.RawMessage `json:"params,omitempty"`
 	Event  string          `json:"event,omitempty"`
+
+	// A "res" answering a server request, see Client.Request
+	OK      bool            `json:"ok,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
 }
 
 // RPCRequest is a parsed incoming request
@@ -16,6 +24,17 @@ type RPCRequest struct {
 	ID     string
 	Method string
 	Params map[string]json.RawMessage
+
+	ctx context.Context
+}
+
+// Context is done once the connection the request came on closes; work
+// done only to answer it can stop then.
+func (r RPCRequest) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
 // RPCResponse is an outgoing response
@@ -28,8 +47,9 @@ type RPCResponse struct {
 }
 
 type RPCError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"` // machine-readable context, e.g. retryAfterMs
 }
 
 // RPCEvent is an outgoing event
@@ -37,6 +57,20 @@ type RPCEvent struct {
 	Type    string      `json:"type"`
 	Event   string      `json:"event"`
 	Payload interface{} `json:"payload,omitempty"`
+
+	// Set on room broadcasts: the room and the event's place in it, for
+	// connect's lastSeq
+	RoomID string `json:"roomId,omitempty"`
+	Seq    int64  `json:"seq,omitempty"`
+
+	// Not sent: none of this user's connections get the event from
+	// BroadcastToRoom, on any instance, e.g. their own typing indicator
+	ExcludeUser string `json:"-"`
+}
+
+// skips reports whether BroadcastToRoom leaves client out of the event.
+func (e RPCEvent) skips(client *Client) bool {
+	return e.ExcludeUser != "" && client.UserID() == e.ExcludeUser
 }
 
 func NewResponse(id string, payload interface{}) RPCResponse {
@@ -52,6 +86,14 @@ func NewErrorResponse(id, code, message string) RPCResponse {
 	}
 }
 
+// NewErrorResponseWithDetails is NewErrorResponse with structured details for
+// clients that need more than a code (limits, retry hints).
+func NewErrorResponseWithDetails(id, code, message string, details map[string]interface{}) RPCResponse {
+	resp := NewErrorResponse(id, code, message)
+	resp.Error.Details = details
+	return resp
+}
+
 func NewEvent(event string, payload interface{}) RPCEvent {
 	return RPCEvent{Type: "event", Event: event, Payload: payload}
 }
@@ -0,0 +1,125 @@
+package ws
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Default request and message limits, see RateLimit.
+var (
+	DefaultConnRPCLimit     = RateLimit{PerSecond: 20, Burst: 50}
+	DefaultUserRPCLimit     = RateLimit{PerSecond: 40, Burst: 100}
+	DefaultUserMessageLimit = RateLimit{PerSecond: 1, Burst: 10}
+)
+
+// RateLimit is a token bucket: Burst calls at once, refilled at PerSecond.
+// A zero PerSecond disables the limit.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.PerSecond > 0
+}
+
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	warned  bool // logged since the bucket last allowed a call
+	denials int  // refusals since the bucket last allowed a call
+}
+
+// maxConnDenials is how many requests in a row a connection may send into
+// its rate limit before it is closed with CloseRateLimited.
+const maxConnDenials = 100
+
+// take spends a token, or returns how long until one is available.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) time.Duration {
+	burst := float64(max(limit.Burst, 1))
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.PerSecond)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.warned = false
+		b.denials = 0
+		return 0
+	}
+	b.denials++
+	return time.Duration((1 - b.tokens) / limit.PerSecond * float64(time.Second))
+}
+
+// RateLimiter keeps a token bucket per key, e.g. per user.
+type RateLimiter struct {
+	Limit RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{Limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow spends a token from key's bucket. It returns 0 if the call may go
+// ahead, or how long to wait otherwise; firstDenial is set on the first
+// refusal since key was last allowed, so callers can warn once per burst.
+func (l *RateLimiter) Allow(key string) (retryAfter time.Duration, firstDenial bool) {
+	if l == nil || !l.Limit.enabled() {
+		return 0, false
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{}
+		l.buckets[key] = b
+	}
+	retryAfter = b.take(l.Limit, now)
+	if retryAfter > 0 {
+		firstDenial = !b.warned
+		b.warned = true
+	}
+
+	// A bucket idle long enough to refill is the same as no bucket
+	if len(l.buckets) > 10000 {
+		full := time.Duration(float64(max(l.Limit.Burst, 1)) / l.Limit.PerSecond * float64(time.Second))
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	return retryAfter, firstDenial
+}
+
+// allowConn applies limit to a single connection's bucket. Only the
+// client's read loop calls it, so no locking is needed.
+func (c *Client) allowConn(limit RateLimit) (retryAfter time.Duration, firstDenial bool) {
+	if !limit.enabled() {
+		return 0, false
+	}
+	if c.rpcBucket == nil {
+		c.rpcBucket = &tokenBucket{}
+	}
+	retryAfter = c.rpcBucket.take(limit, time.Now())
+	if retryAfter > 0 {
+		firstDenial = !c.rpcBucket.warned
+		c.rpcBucket.warned = true
+	}
+	return retryAfter, firstDenial
+}
+
+func rateLimitedResponse(id string, retryAfter time.Duration) RPCResponse {
+	return NewErrorResponseWithDetails(id, "RATE_LIMITED", "Too many requests", map[string]interface{}{
+		"retryAfterMs": retryAfter.Milliseconds(),
+	})
+}
@@ -0,0 +1,44 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	l := NewRateLimiter(RateLimit{PerSecond: 10, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if wait, _ := l.Allow("alice"); wait != 0 {
+			t.Fatalf("call %d within burst was limited (wait %v)", i, wait)
+		}
+	}
+	wait, first := l.Allow("alice")
+	if wait <= 0 || wait > 100*time.Millisecond || !first {
+		t.Fatalf("over burst: wait %v, first %v; want up to 100ms, first denial", wait, first)
+	}
+	if _, first := l.Allow("alice"); first {
+		t.Errorf("second denial in a row reported as first")
+	}
+	if wait, _ := l.Allow("bob"); wait != 0 {
+		t.Errorf("bob shares alice's bucket")
+	}
+
+	time.Sleep(wait + 10*time.Millisecond)
+	if wait, _ := l.Allow("alice"); wait != 0 {
+		t.Errorf("bucket did not refill (wait %v)", wait)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	var nilLimiter *RateLimiter
+	if wait, _ := nilLimiter.Allow("alice"); wait != 0 {
+		t.Errorf("nil limiter limited a call")
+	}
+	l := NewRateLimiter(RateLimit{})
+	for i := 0; i < 100; i++ {
+		if wait, _ := l.Allow("alice"); wait != 0 {
+			t.Fatalf("zero limit limited call %d", i)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package ws
+
+import "runtime/debug"
+
+// recoverRequest, deferred by handleMessage, stops a panicking handler from
+// taking the read loop and the connection down with it: the panic is logged
+// with its stack and the request, if it was one, answered with
+// INTERNAL_ERROR. Panics in goroutines a handler starts are not covered.
+func (h *Hub) recoverRequest(client *Client, msg *RPCMessage) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	h.metrics.panics.Add(1)
+	client.Log().Error("panic handling message", "type", msg.Type, "method", msg.Method, "id", msg.ID,
+		"panic", v, "stack", string(debug.Stack()))
+	if msg.Type == "req" {
+		client.SendJSON(NewErrorResponse(msg.ID, "INTERNAL_ERROR", "Internal server error"))
+	}
+}
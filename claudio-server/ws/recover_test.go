@@ -0,0 +1,40 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHandlerPanicAnswersRequest(t *testing.T) {
+	h := NewHub(nil)
+	calls := 0
+	h.RPCRouter = func(client *Client, req RPCRequest) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		client.SendJSON(NewResponse(req.ID, nil))
+	}
+	c := &Client{hub: h, send: make(chan []byte, 8), done: make(chan struct{})}
+
+	h.handleMessage(c, []byte(`{"type":"req","id":"1","method":"rooms.listPublic"}`))
+	var resp RPCResponse
+	json.Unmarshal(<-c.send, &resp)
+	if resp.ID != "1" || resp.OK || resp.Error == nil || resp.Error.Code != "INTERNAL_ERROR" {
+		t.Fatalf("after panic: %+v", resp)
+	}
+
+	// The connection keeps serving requests
+	h.handleMessage(c, []byte(`{"type":"req","id":"2","method":"rooms.listPublic"}`))
+	resp = RPCResponse{}
+	json.Unmarshal(<-c.send, &resp)
+	if resp.ID != "2" || !resp.OK {
+		t.Fatalf("next request: %+v", resp)
+	}
+
+	m := fakeCollector{}
+	h.CollectMetrics(m)
+	if m["ws_handler_panics_total"] != 1 {
+		t.Errorf("ws_handler_panics_total = %v, want 1", m["ws_handler_panics_total"])
+	}
+}
@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+// Registration modes: who may create an account by connecting a new device.
+const (
+	RegistrationOpen   = "open"   // anyone who completes the handshake
+	RegistrationSecret = "secret" // new devices must send Hub.JoinSecret as auth.token
+	RegistrationInvite = "invite" // new devices must send a server invite code as auth.token
+)
+
+// admitDevice applies the registration mode to a signed connect. Devices
+// that already belong to an account are always let in.
+func (h *Hub) admitDevice(deviceID string, auth *ConnectAuth) error {
+	if h.Registration == "" || h.Registration == RegistrationOpen {
+		return nil
+	}
+	if known, err := h.DB.DeviceExists(deviceID); err != nil || known {
+		return err
+	}
+	token := ""
+	if auth != nil {
+		token = auth.Token
+	}
+
+	switch h.Registration {
+	case RegistrationSecret:
+		if h.JoinSecret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.JoinSecret)) == 1 {
+			return nil
+		}
+		return fmt.Errorf("this server requires a join secret")
+	case RegistrationInvite:
+		if token != "" && h.DB.RedeemServerInvite(token) == nil {
+			return nil
+		}
+		return fmt.Errorf("this server is invite-only; a valid server invite code is required")
+	}
+	return fmt.Errorf("unknown registration mode %q", h.Registration)
+}
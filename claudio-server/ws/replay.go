@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultReplayBuffer is how many recent events per room are kept for
+// clients resuming with lastSeq.
+const DefaultReplayBuffer = 100
+
+// roomLog numbers a room's broadcasts and keeps the latest of them. Its lock
+// is held while an event is fanned out, so subscribers see events in
+// sequence order and a resuming client can join between two of them.
+type roomLog struct {
+	mu     sync.Mutex
+	seq    int64
+	events []loggedEvent // oldest first, at most Hub.ReplayBuffer
+
+	lastMessage time.Time // last room.message, see coalesce
+}
+
+type loggedEvent struct {
+	seq   int64
+	at    time.Time
+	event RPCEvent // as numbered, for older protocol versions
+	data  []byte   // encoded for CurrentProtocol
+}
+
+// replayLogs holds a roomLog per room that has broadcast since startup.
+type replayLogs struct {
+	mu    sync.Mutex
+	rooms map[string]*roomLog
+	// Sequence numbers start from the startup time in milliseconds, so
+	// they keep growing across restarts: a lastSeq from before a restart is
+	// older than anything buffered and gets a resync, never wrong events.
+	base int64
+}
+
+func newReplayLogs() *replayLogs {
+	return &replayLogs{rooms: make(map[string]*roomLog), base: time.Now().UnixMilli()}
+}
+
+func (l *replayLogs) room(roomID string) *roomLog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rl := l.rooms[roomID]
+	if rl == nil {
+		rl = &roomLog{seq: l.base}
+		l.rooms[roomID] = rl
+	}
+	return rl
+}
+
+func (l *replayLogs) drop(roomID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.rooms, roomID)
+}
+
+// record stamps event with the room's next sequence number, keeps it for
+// replay and returns it, encoded too. The caller holds rl.mu.
+func (rl *roomLog) record(roomID string, event RPCEvent, keep int) loggedEvent {
+	rl.seq++
+	event.RoomID = roomID
+	event.Seq = rl.seq
+	data, _ := json.Marshal(event)
+	logged := loggedEvent{seq: rl.seq, at: time.Now(), event: event, data: data}
+	if keep > 0 {
+		if len(rl.events) >= keep {
+			rl.events = append(rl.events[:0], rl.events[len(rl.events)-keep+1:]...)
+		}
+		rl.events = append(rl.events, logged)
+	}
+	return logged
+}
+
+// since returns the events after lastSeq, or false if some of them are no
+// longer buffered. The caller holds rl.mu.
+func (rl *roomLog) since(lastSeq int64) ([]loggedEvent, bool) {
+	if lastSeq > rl.seq {
+		// From another server run, or made up
+		return nil, false
+	}
+	if lastSeq == rl.seq {
+		return nil, true
+	}
+	if len(rl.events) == 0 || rl.events[0].seq > lastSeq+1 {
+		return nil, false
+	}
+	i := len(rl.events) - int(rl.seq-lastSeq)
+	return rl.events[i:], true
+}
+
+// ResumeRoom subscribes client to roomID and sends it the room's events
+// after lastSeq, from connect's or rooms.subscribe's lastSeq map. If they can't all be
+// replayed it sends room.resync instead, and the client refetches the
+// room's history.
+func (h *Hub) ResumeRoom(roomID string, client *Client, lastSeq int64) {
+	rl := h.replay.room(roomID)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	h.SubscribeRoom(roomID, client)
+	missed, ok := rl.since(lastSeq)
+	if ok && len(missed) > cap(client.send)-len(client.send) {
+		// Replaying would overflow the send buffer and drop events anyway
+		ok = false
+	}
+	if !ok {
+		client.SendJSON(NewEvent("room.resync", map[string]interface{}{
+			"roomId": roomID,
+			"seq":    rl.seq,
+		}))
+		return
+	}
+	for _, e := range missed {
+		client.sendLogged(e)
+	}
+}
+
+// sendRaw queues an already encoded message, like SendJSON.
+func (c *Client) sendRaw(data []byte) {
+	c.enqueue(data)
+}
@@ -0,0 +1,40 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRoomLogSince(t *testing.T) {
+	rl := &roomLog{seq: 1000}
+	for i := 0; i < 5; i++ {
+		rl.record("r1", NewEvent("message.new", nil), 3)
+	}
+	if rl.seq != 1005 || len(rl.events) != 3 {
+		t.Fatalf("seq = %d with %d buffered, want 1005 with 3", rl.seq, len(rl.events))
+	}
+
+	var ev RPCEvent
+	json.Unmarshal(rl.events[2].data, &ev)
+	if ev.RoomID != "r1" || ev.Seq != 1005 {
+		t.Errorf("recorded event has roomId %q seq %d", ev.RoomID, ev.Seq)
+	}
+
+	missed, ok := rl.since(1003)
+	if !ok || len(missed) != 2 || missed[0].seq != 1004 {
+		t.Errorf("since(1003) = %d events from %v, ok %v; want 1004 and 1005", len(missed), missed, ok)
+	}
+	if missed, ok := rl.since(1005); !ok || len(missed) != 0 {
+		t.Errorf("since(latest) = %d events, ok %v", len(missed), ok)
+	}
+	// 1003 is still buffered, 1002 isn't
+	if _, ok := rl.since(1002); !ok {
+		t.Error("since(1002) should replay 1003-1005")
+	}
+	if _, ok := rl.since(1001); ok {
+		t.Error("since(1001) should need a resync, 1002 is gone")
+	}
+	if _, ok := rl.since(2000); ok {
+		t.Error("since a seq from the future should need a resync")
+	}
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CapRequests is the connect cap a client sends to say it answers server
+// requests, see Client.Request.
+const CapRequests = "requests"
+
+// DefaultRequestTimeout is how long Request waits for an answer when the
+// caller doesn't say.
+const DefaultRequestTimeout = 10 * time.Second
+
+// maxPendingRequests caps unanswered server requests per connection.
+const maxPendingRequests = 32
+
+var (
+	ErrRequestsUnsupported = errors.New("client does not answer server requests")
+	ErrRequestTimeout      = errors.New("client did not answer in time")
+	ErrConnectionClosed    = errors.New("connection closed")
+	ErrTooManyRequests     = errors.New("too many unanswered requests")
+)
+
+// ClientError is an error a client answered a server request with.
+type ClientError struct {
+	Code    string
+	Message string
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("client error %s: %s", e.Code, e.Message)
+}
+
+// serverRequest is a request to the client, shaped like the client's own:
+// {"type":"req","id":"s1","method":...,"params":...}. IDs start with "s" so
+// they can't be mistaken for the client's.
+type serverRequest struct {
+	Type   string      `json:"type"`
+	ID     string      `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+func (c *Client) setCaps(caps []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.caps = make(map[string]bool, len(caps))
+	for _, name := range caps {
+		c.caps[name] = true
+	}
+}
+
+// HasCap reports whether the client sent the cap at connect.
+func (c *Client) HasCap(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.caps[name]
+}
+
+// Request sends method to the client and waits up to timeout (0 means
+// DefaultRequestTimeout) for its answer, which comes back as the payload
+// or a *ClientError. Clients that didn't connect with CapRequests get
+// ErrRequestsUnsupported. Call it from its own goroutine, not the
+// connection's read loop, which delivers the answer.
+func (c *Client) Request(method string, params interface{}, timeout time.Duration) (json.RawMessage, error) {
+	if !c.HasCap(CapRequests) {
+		return nil, ErrRequestsUnsupported
+	}
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+
+	id := "s" + strconv.FormatUint(c.nextRequest.Add(1), 10)
+	reply := make(chan RPCMessage, 1)
+	c.reqMu.Lock()
+	if len(c.pending) >= maxPendingRequests {
+		c.reqMu.Unlock()
+		return nil, ErrTooManyRequests
+	}
+	if c.pending == nil {
+		c.pending = make(map[string]chan RPCMessage)
+	}
+	c.pending[id] = reply
+	c.reqMu.Unlock()
+	defer func() {
+		c.reqMu.Lock()
+		delete(c.pending, id)
+		c.reqMu.Unlock()
+	}()
+
+	c.SendJSON(serverRequest{Type: "req", ID: id, Method: method, Params: params})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-reply:
+		if !msg.OK {
+			if msg.Error == nil {
+				return nil, &ClientError{Code: "UNKNOWN", Message: "request failed"}
+			}
+			return nil, &ClientError{Code: msg.Error.Code, Message: msg.Error.Message}
+		}
+		return msg.Payload, nil
+	case <-timer.C:
+		return nil, ErrRequestTimeout
+	case <-c.done:
+		return nil, ErrConnectionClosed
+	}
+}
+
+// resolve hands a client's "res" to the Request waiting for it.
+func (c *Client) resolve(msg RPCMessage) {
+	c.reqMu.Lock()
+	reply, ok := c.pending[msg.ID]
+	delete(c.pending, msg.ID)
+	c.reqMu.Unlock()
+	if !ok {
+		c.Log().Debug("response to no pending request", "id", msg.ID)
+		return
+	}
+	reply <- msg
+}
@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// answer replies to the next server request on c with reply. It runs in
+// its own goroutine, so it reports with t.Error.
+func answer(t *testing.T, h *Hub, c *Client, reply string) {
+	var req serverRequest
+	select {
+	case data := <-c.send:
+		json.Unmarshal(data, &req)
+	case <-time.After(time.Second):
+		t.Error("no request sent")
+		return
+	}
+	if req.Type != "req" || req.Method != "e2ee.keyBundle" {
+		t.Errorf("sent %+v", req)
+		return
+	}
+	h.handleMessage(c, []byte(`{"type":"res","id":"`+req.ID+`",`+reply+`}`))
+}
+
+func TestClientRequest(t *testing.T) {
+	h := NewHub(nil)
+	c := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{})}
+	if _, err := c.Request("e2ee.keyBundle", nil, time.Second); err != ErrRequestsUnsupported {
+		t.Fatalf("without the cap: err = %v", err)
+	}
+	c.setCaps([]string{CapRequests})
+
+	go answer(t, h, c, `"ok":true,"payload":{"key":"k1"}`)
+	payload, err := c.Request("e2ee.keyBundle", nil, time.Second)
+	if err != nil || string(payload) != `{"key":"k1"}` {
+		t.Fatalf("Request = %s, %v", payload, err)
+	}
+
+	go answer(t, h, c, `"ok":false,"error":{"code":"NO_KEYS","message":"none yet"}`)
+	_, err = c.Request("e2ee.keyBundle", nil, time.Second)
+	var clientErr *ClientError
+	if !errors.As(err, &clientErr) || clientErr.Code != "NO_KEYS" {
+		t.Fatalf("err = %v, want NO_KEYS", err)
+	}
+
+	if _, err := c.Request("e2ee.keyBundle", nil, 10*time.Millisecond); err != ErrRequestTimeout {
+		t.Errorf("unanswered: err = %v", err)
+	}
+	close(c.done)
+	if _, err := c.Request("e2ee.keyBundle", nil, time.Second); err != ErrConnectionClosed {
+		t.Errorf("closed: err = %v", err)
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("%d requests left pending", len(c.pending))
+	}
+}
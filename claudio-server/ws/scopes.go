@@ -0,0 +1,52 @@
+package ws
+
+import "strings"
+
+// Connect scopes. A connection can only call RPC methods whose scope it was
+// granted; see rpc's methodScope.
+const (
+	ScopeRead  = "operator.read"
+	ScopeWrite = "operator.write"
+	ScopeAdmin = "operator.admin" // admin.* methods, for server admins
+)
+
+// DefaultScopes are granted when connect params carry no scopes, and are
+// what older clients sign.
+var DefaultScopes = []string{ScopeRead, ScopeWrite}
+
+// ValidScope reports whether s is a scope the server knows.
+func ValidScope(s string) bool {
+	return s == ScopeRead || s == ScopeWrite || s == ScopeAdmin
+}
+
+// connectScopes returns the scopes a connect asks for, defaulting to
+// DefaultScopes when none are given.
+func connectScopes(scopes []string) []string {
+	if len(scopes) == 0 {
+		return DefaultScopes
+	}
+	return scopes
+}
+
+// SetScopes restricts the connection to scopes.
+func (c *Client) SetScopes(scopes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scopes = make(map[string]bool, len(scopes))
+	for _, s := range scopes {
+		c.scopes[s] = true
+	}
+}
+
+// HasScope reports whether the connection was granted scope. Connections
+// that never set scopes (guests, unauthenticated) are limited by other
+// checks instead.
+func (c *Client) HasScope(scope string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.scopes == nil || c.scopes[scope]
+}
+
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
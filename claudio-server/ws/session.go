@@ -0,0 +1,70 @@
+package ws
+
+import "time"
+
+// DefaultSessionExpiryWarning is how long before MaxSessionAge runs out
+// clients get auth.expiring.
+const DefaultSessionExpiryWarning = 5 * time.Minute
+
+func (c *Client) challenge() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.challengeNonce
+}
+
+func (c *Client) setChallenge(nonce string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.challengeNonce = nonce
+}
+
+// startSession restarts the session clock after a successful connect.
+func (c *Client) startSession(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionStart = now
+	c.expiryWarned = false
+}
+
+// sessionExpiry returns when the connection's session runs out under
+// maxAge, and claims the expiry warning: warn is true the first time it's
+// asked once the warning window has started.
+func (c *Client) sessionExpiry(maxAge, warning time.Duration, now time.Time) (expiresAt time.Time, warn bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt = c.sessionStart.Add(maxAge)
+	if !c.expiryWarned && !now.Before(expiresAt.Add(-warning)) {
+		c.expiryWarned = true
+		warn = true
+	}
+	return expiresAt, warn
+}
+
+// checkSession enforces MaxSessionAge on an authenticated connection. Ahead
+// of expiry the client gets auth.expiring with a fresh challenge, so it can
+// re-run connect on the same socket (signed, resume token or JWT) without
+// dropping; once expired the connection is closed with CloseAuthExpired.
+// It returns false then.
+func (h *Hub) checkSession(client *Client) bool {
+	if h.MaxSessionAge <= 0 || client.IsGuest() {
+		return true
+	}
+	now := time.Now()
+	expiresAt, warn := client.sessionExpiry(h.MaxSessionAge, h.SessionExpiryWarning, now)
+	if !now.Before(expiresAt) {
+		client.Log().Info("session expired")
+		client.closeWith(CloseAuthExpired)
+		return false
+	}
+	if warn {
+		nonce := generateNonce()
+		h.nonces.forget(client.challenge())
+		h.nonces.issue(nonce, h.NonceTTL)
+		client.setChallenge(nonce)
+		client.SendJSON(NewEvent("auth.expiring", map[string]interface{}{
+			"expiresAt": expiresAt.UTC().Format(time.RFC3339),
+			"nonce":     nonce,
+		}))
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionExpiryWarnsOnce(t *testing.T) {
+	c := &Client{}
+	start := time.Now()
+	c.startSession(start)
+
+	expiresAt, warn := c.sessionExpiry(time.Hour, 5*time.Minute, start.Add(30*time.Minute))
+	if warn {
+		t.Error("warned before the warning window")
+	}
+	if !expiresAt.Equal(start.Add(time.Hour)) {
+		t.Errorf("expiresAt = %v, want %v", expiresAt, start.Add(time.Hour))
+	}
+	if _, warn := c.sessionExpiry(time.Hour, 5*time.Minute, start.Add(56*time.Minute)); !warn {
+		t.Error("not warned inside the warning window")
+	}
+	if _, warn := c.sessionExpiry(time.Hour, 5*time.Minute, start.Add(57*time.Minute)); warn {
+		t.Error("warned twice for one session")
+	}
+
+	// Re-auth starts a new session, which gets its own warning
+	c.startSession(start.Add(58 * time.Minute))
+	if _, warn := c.sessionExpiry(time.Hour, 5*time.Minute, start.Add(60*time.Minute)); warn {
+		t.Error("renewed session warned early")
+	}
+	if _, warn := c.sessionExpiry(time.Hour, 5*time.Minute, start.Add(114*time.Minute)); !warn {
+		t.Error("renewed session not warned")
+	}
+}
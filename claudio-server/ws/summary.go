@@ -0,0 +1,193 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Subscription levels for rooms.subscribe and connect's subscribeLevel.
+const (
+	SubscribeFull = "full" // every event
+	SubscribeLite = "lite" // room.summary for new messages only
+)
+
+// summaryPreviewLen is how many characters of a message room.summary
+// carries.
+const summaryPreviewLen = 140
+
+// SubscribeRoomLite subscribes client to a room at the lite level: instead
+// of the room's events it gets room.summary, with the latest message's
+// preview and how many arrived since, for rooms the client isn't showing.
+// A full SubscribeRoom later replaces it.
+func (h *Hub) SubscribeRoomLite(roomID string, client *Client) {
+	h.UnsubscribeRoom(roomID, client)
+	h.lite.add(roomID, client)
+	client.joinLite(roomID)
+	select {
+	case <-client.done:
+		h.dropLite(roomID, client)
+	default:
+	}
+}
+
+// autoSubscribe subscribes a connection to a room it didn't ask for, at
+// the level it picked at connect.
+func (h *Hub) autoSubscribe(roomID string, client *Client) {
+	if client.autoSubscribeLite() {
+		h.SubscribeRoomLite(roomID, client)
+		return
+	}
+	h.SubscribeRoom(roomID, client)
+}
+
+func (c *Client) setAutoLite(lite bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.autoLite = lite
+}
+
+func (c *Client) autoSubscribeLite() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.autoLite
+}
+
+// IsClientLite reports whether client follows the room at the lite level.
+func (h *Hub) IsClientLite(roomID string, client *Client) bool {
+	return h.lite.has(roomID, client)
+}
+
+func (h *Hub) dropLite(roomID string, client *Client) {
+	h.lite.remove(roomID, client)
+	client.leaveLite(roomID)
+}
+
+func (c *Client) joinLite(roomID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.liteRooms == nil {
+		c.liteRooms = make(map[string]int)
+	}
+	c.liteRooms[roomID] = 0
+}
+
+func (c *Client) leaveLite(roomID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.liteRooms, roomID)
+}
+
+// takeLiteRooms clears and returns the client's lite subscriptions.
+func (c *Client) takeLiteRooms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make([]string, 0, len(c.liteRooms))
+	for roomID := range c.liteRooms {
+		rooms = append(rooms, roomID)
+	}
+	c.liteRooms = nil
+	return rooms
+}
+
+// countUnread adds a message to the room's unread count, unless it is the
+// user's own, and returns the count. ok is false if the client left the
+// lite level meanwhile.
+func (c *Client) countUnread(roomID, senderID string) (unread int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	unread, ok = c.liteRooms[roomID]
+	if !ok {
+		return 0, false
+	}
+	if senderID == "" || senderID != c.userID {
+		unread++
+		c.liteRooms[roomID] = unread
+	}
+	return unread, true
+}
+
+// messageSummary is the part of a room.message that room.summary repeats.
+type messageSummary struct {
+	ID          string    `json:"id"`
+	SenderID    string    `json:"senderId,omitempty"`
+	SenderName  string    `json:"senderName"`
+	SenderEmoji string    `json:"senderEmoji,omitempty"`
+	Preview     string    `json:"preview,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// summarize pulls the message out of an encoded room.message. The preview
+// is left out for end-to-end encrypted rooms, where the content is
+// ciphertext and cutting it short would leave nothing to decrypt.
+func (h *Hub) summarize(roomID string, data []byte) (messageSummary, bool) {
+	var event struct {
+		Payload struct {
+			Message *struct {
+				ID                string    `json:"id"`
+				SenderUserID      *string   `json:"senderUserId"`
+				SenderAgentID     *string   `json:"senderAgentId"`
+				SenderDisplayName string    `json:"senderDisplayName"`
+				SenderEmoji       string    `json:"senderEmoji"`
+				Content           string    `json:"content"`
+				CreatedAt         time.Time `json:"createdAt"`
+			} `json:"message"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Payload.Message == nil {
+		return messageSummary{}, false
+	}
+	msg := event.Payload.Message
+	s := messageSummary{
+		ID:          msg.ID,
+		SenderName:  msg.SenderDisplayName,
+		SenderEmoji: msg.SenderEmoji,
+		CreatedAt:   msg.CreatedAt,
+	}
+	if msg.SenderUserID != nil {
+		s.SenderID = *msg.SenderUserID
+	} else if msg.SenderAgentID != nil {
+		s.SenderID = *msg.SenderAgentID
+	}
+	encrypted := false
+	if h.DB != nil {
+		encrypted, _ = h.DB.IsRoomEncrypted(roomID)
+	}
+	if !encrypted {
+		s.Preview = msg.Content
+		if r := []rune(s.Preview); len(r) > summaryPreviewLen {
+			s.Preview = string(r[:summaryPreviewLen]) + "…"
+		}
+	}
+	return s, true
+}
+
+// sendSummaries tells the room's lite subscribers about a new message.
+// The caller holds the room's log lock, so summaries keep message order.
+func (h *Hub) sendSummaries(roomID string, event RPCEvent, data []byte, seq int64, exclude *Client) {
+	if event.Event != "room.message" {
+		return
+	}
+	subs := h.lite.snapshot(roomID)
+	if len(subs) == 0 {
+		return
+	}
+	summary, ok := h.summarize(roomID, data)
+	if !ok {
+		return
+	}
+	for _, client := range subs {
+		if client == exclude || event.skips(client) {
+			continue
+		}
+		unread, ok := client.countUnread(roomID, summary.SenderID)
+		if !ok {
+			continue
+		}
+		client.SendJSON(NewEvent("room.summary", map[string]interface{}{
+			"roomId":      roomID,
+			"seq":         seq,
+			"unreadCount": unread,
+			"lastMessage": summary,
+		}))
+	}
+}
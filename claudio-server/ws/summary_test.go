@@ -0,0 +1,68 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiteSubscribersGetSummaries(t *testing.T) {
+	h := NewHub(nil)
+	h.BatchInterval = 0
+	full := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{})}
+	lite := &Client{hub: h, send: make(chan []byte, 16), done: make(chan struct{}), userID: "bob"}
+	h.SubscribeRoom("r1", full)
+	h.SubscribeRoom("r1", lite)
+	h.SubscribeRoomLite("r1", lite)
+	if h.IsClientSubscribed("r1", lite) || !h.IsClientLite("r1", lite) {
+		t.Fatal("lite subscription didn't replace the full one")
+	}
+
+	alice := "alice"
+	message := func(sender *string, content string) RPCEvent {
+		return NewEvent("room.message", map[string]interface{}{
+			"roomId": "r1",
+			"message": map[string]interface{}{
+				"id": "m1", "senderUserId": sender, "senderDisplayName": "Alice",
+				"content": content, "createdAt": time.Now(),
+			},
+		})
+	}
+	h.BroadcastToRoom("r1", NewEvent("room.typing", nil), nil)
+	h.BroadcastToRoom("r1", message(&alice, strings.Repeat("x", 300)), nil)
+	bob := "bob"
+	h.BroadcastToRoom("r1", message(&bob, "mine"), nil)
+
+	if len(full.send) != 3 {
+		t.Errorf("full subscriber got %d events, want 3", len(full.send))
+	}
+	type summary struct {
+		Event   string `json:"event"`
+		Payload struct {
+			UnreadCount int            `json:"unreadCount"`
+			LastMessage messageSummary `json:"lastMessage"`
+		} `json:"payload"`
+	}
+	var summaries []summary
+	for len(lite.send) > 0 {
+		var s summary
+		json.Unmarshal(<-lite.send, &s)
+		summaries = append(summaries, s)
+	}
+	if len(summaries) != 2 || summaries[0].Event != "room.summary" {
+		t.Fatalf("lite subscriber got %+v, want two room.summary", summaries)
+	}
+	if got := summaries[0].Payload; got.UnreadCount != 1 || len([]rune(got.LastMessage.Preview)) != summaryPreviewLen+1 {
+		t.Errorf("first summary = %+v", got)
+	}
+	if got := summaries[1].Payload; got.UnreadCount != 1 || got.LastMessage.Preview != "mine" {
+		t.Errorf("own message changed the unread count: %+v", got)
+	}
+
+	// Focusing the room again resets it to the full stream
+	h.SubscribeRoom("r1", lite)
+	if h.IsClientLite("r1", lite) || !h.IsClientSubscribed("r1", lite) {
+		t.Error("full subscription didn't replace the lite one")
+	}
+}
@@ -0,0 +1,141 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Protocol versions this server speaks. Connect picks the newest one inside
+// both the client's minProtocol..maxProtocol and these; messages to clients
+// on an older version go through its shim.
+const (
+	OldestProtocol  = 2
+	CurrentProtocol = 3
+)
+
+// protocolShim rewrites outgoing messages for clients on an older protocol.
+type protocolShim struct {
+	event    func(RPCEvent) RPCEvent
+	response func(RPCResponse) RPCResponse
+}
+
+// protocolShims has an entry for every version from OldestProtocol up to,
+// not including, CurrentProtocol.
+var protocolShims = map[int]protocolShim{
+	// Protocol 2 predates room sequence numbers and structured error
+	// details, and has no batch events (connect doesn't offer them).
+	2: {
+		event: func(e RPCEvent) RPCEvent {
+			e.RoomID, e.Seq = "", 0
+			return e
+		},
+		response: func(r RPCResponse) RPCResponse {
+			if r.Error != nil && r.Error.Details != nil {
+				stripped := *r.Error
+				stripped.Details = nil
+				r.Error = &stripped
+			}
+			return r
+		},
+	},
+}
+
+// protocolError is why connect can't agree on a version.
+type protocolError struct {
+	code, message string
+}
+
+// negotiateProtocol picks the version for a client speaking min..max.
+// Clients that send neither are taken to speak CurrentProtocol; one bound
+// alone means just that version.
+func negotiateProtocol(min, max int) (int, *protocolError) {
+	switch {
+	case min == 0 && max == 0:
+		return CurrentProtocol, nil
+	case max == 0:
+		max = min
+	case min == 0:
+		min = max
+	}
+	if min > max {
+		return 0, &protocolError{"INVALID_PARAMS", fmt.Sprintf("minProtocol %d is above maxProtocol %d", min, max)}
+	}
+	if max < OldestProtocol {
+		return 0, &protocolError{"UPGRADE_REQUIRED", fmt.Sprintf("Protocol %d is no longer supported; update the app", max)}
+	}
+	if min > CurrentProtocol {
+		return 0, &protocolError{"PROTOCOL_UNSUPPORTED", fmt.Sprintf("This server speaks up to protocol %d", CurrentProtocol)}
+	}
+	if max > CurrentProtocol {
+		return CurrentProtocol, nil
+	}
+	return max, nil
+}
+
+// response is the connect error for a failed negotiation; its details tell
+// the client which versions would work.
+func (e *protocolError) response(id string) RPCResponse {
+	return NewErrorResponseWithDetails(id, e.code, e.message, map[string]interface{}{
+		"minProtocol": OldestProtocol,
+		"maxProtocol": CurrentProtocol,
+	})
+}
+
+// Protocol returns the connection's negotiated protocol version.
+func (c *Client) Protocol() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.protocol == 0 {
+		return CurrentProtocol
+	}
+	return c.protocol
+}
+
+func (c *Client) setProtocol(version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.protocol = version
+}
+
+// shim rewrites v for the client's protocol version, if it needs it.
+func (c *Client) shim(v interface{}) interface{} {
+	s, ok := protocolShims[c.Protocol()]
+	if !ok {
+		return v
+	}
+	switch m := v.(type) {
+	case RPCEvent:
+		if s.event != nil {
+			return s.event(m)
+		}
+	case RPCResponse:
+		if s.response != nil {
+			return s.response(m)
+		}
+	}
+	return v
+}
+
+// eventEncodings is one broadcast encoded per protocol version, each at most
+// once however many subscribers share it.
+type eventEncodings struct {
+	event RPCEvent
+	data  map[int][]byte
+}
+
+func newEventEncodings(event RPCEvent, current []byte) *eventEncodings {
+	return &eventEncodings{event: event, data: map[int][]byte{CurrentProtocol: current}}
+}
+
+func (e *eventEncodings) forVersion(version int) []byte {
+	if data, ok := e.data[version]; ok {
+		return data
+	}
+	event := e.event
+	if s, ok := protocolShims[version]; ok && s.event != nil {
+		event = s.event(event)
+	}
+	data, _ := json.Marshal(event)
+	e.data[version] = data
+	return data
+}
@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	tests := []struct {
+		min, max int
+		want     int
+		code     string
+	}{
+		{0, 0, CurrentProtocol, ""},
+		{3, 3, 3, ""},
+		{2, 2, 2, ""},
+		{2, 9, CurrentProtocol, ""},
+		{2, 0, 2, ""},
+		{0, 2, 2, ""},
+		{1, 1, 0, "UPGRADE_REQUIRED"},
+		{9, 9, 0, "PROTOCOL_UNSUPPORTED"},
+		{3, 2, 0, "INVALID_PARAMS"},
+	}
+	for _, tt := range tests {
+		got, err := negotiateProtocol(tt.min, tt.max)
+		code := ""
+		if err != nil {
+			code = err.code
+		}
+		if got != tt.want || code != tt.code {
+			t.Errorf("negotiateProtocol(%d, %d) = %d, %q; want %d, %q", tt.min, tt.max, got, code, tt.want, tt.code)
+		}
+	}
+}
+
+func TestUpgradeRequiredDetails(t *testing.T) {
+	_, perr := negotiateProtocol(1, 1)
+	data, _ := json.Marshal(perr.response("c1"))
+	if !strings.Contains(string(data), `"details":{"maxProtocol":3,"minProtocol":2}`) {
+		t.Errorf("response lacks supported versions: %s", data)
+	}
+}
+
+func TestProtocol2Shim(t *testing.T) {
+	h := NewHub(nil)
+	old, cur := newTestClient(h), newTestClient(h)
+	old.setProtocol(2)
+
+	rl := h.replay.room("r1")
+	logged := rl.record("r1", NewEvent("room.message", map[string]string{"text": "hi"}), 0)
+	encoded := newEventEncodings(logged.event, logged.data)
+	if s := string(encoded.forVersion(cur.Protocol())); !strings.Contains(s, `"seq"`) {
+		t.Errorf("current protocol lost seq: %s", s)
+	}
+	if s := string(encoded.forVersion(old.Protocol())); strings.Contains(s, `"seq"`) || strings.Contains(s, `"roomId"`) {
+		t.Errorf("protocol 2 got seq or roomId: %s", s)
+	}
+
+	resp := NewErrorResponseWithDetails("1", "RATE_LIMITED", "slow down", map[string]interface{}{"retryAfterMs": 100})
+	if r := old.shim(resp).(RPCResponse); r.Error.Details != nil {
+		t.Errorf("protocol 2 got error details")
+	}
+	if resp.Error.Details == nil {
+		t.Errorf("shim modified the caller's error")
+	}
+	if r := cur.shim(resp).(RPCResponse); r.Error.Details == nil {
+		t.Errorf("current protocol lost error details")
+	}
+}